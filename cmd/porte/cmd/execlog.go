@@ -0,0 +1,155 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph/execlog"
+)
+
+// execlogCmd represents the execlog command
+var execlogCmd = &cobra.Command{
+	Use:   "execlog",
+	Short: "Queries and replays the execution logs written by the proxy",
+}
+
+func init() {
+	rootCmd.AddCommand(execlogCmd)
+}
+
+// addEntryFilterFlags adds the flags selecting execution log entries to the
+// command, bound to the viper keys under key.
+func addEntryFilterFlags(cmd *cobra.Command, key string) {
+	cmd.Flags().String("since", "", "Select the executions started since this time (RFC 3339) or duration ago")
+	cmd.Flags().String("until", "", "Select the executions started before this time (RFC 3339) or duration ago")
+	cmd.Flags().String("graph", "", "Select the executions of the graph with this ID")
+	cmd.Flags().String("client-name", "", "Select the executions of the client with this name")
+	cmd.Flags().String("client-version", "", "Select the executions of the client with this version")
+	cmd.Flags().String("operation", "", "Select the executions of the operation with this name")
+	cmd.Flags().String("errors", "", "Select only the executions with errors (only) or without errors (none)")
+	cmd.Flags().Duration("min-duration", 0, "Select the executions lasting at least this long")
+
+	for _, name := range []string{"since", "until", "graph", "client-name", "client-version", "operation", "errors", "min-duration"} {
+		viper.BindPFlag(key+"."+name, cmd.Flags().Lookup(name))
+	}
+}
+
+// entryFilter returns the execution log entry filter configured by the flags
+// added with addEntryFilterFlags.
+func entryFilter(key string) (*execlog.Filter, error) {
+	f := &execlog.Filter{
+		GraphID:       viper.GetString(key + ".graph"),
+		ClientName:    viper.GetString(key + ".client-name"),
+		ClientVersion: viper.GetString(key + ".client-version"),
+		OperationName: viper.GetString(key + ".operation"),
+		Errors:        execlog.ErrorFilter(viper.GetString(key + ".errors")),
+		MinDuration:   viper.GetDuration(key + ".min-duration"),
+	}
+	switch f.Errors {
+	case execlog.ErrorFilterAny, execlog.ErrorFilterOnly, execlog.ErrorFilterNone:
+	default:
+		return nil, fmt.Errorf("invalid errors filter %q, expected only or none", f.Errors)
+	}
+	var err error
+	if f.Since, err = parseTime(viper.GetString(key + ".since")); err != nil {
+		return nil, err
+	}
+	if f.Until, err = parseTime(viper.GetString(key + ".until")); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseTime parses a time written in RFC 3339 or as a duration before now.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected an RFC 3339 time or a duration", s)
+	}
+	return t, nil
+}
+
+// errStopReading stops readEntries without error.
+var errStopReading = errors.New("stop reading")
+
+// readEntries calls fn with the entries of the execution log files selected
+// by the filter, in order, until fn returns errStopReading. The entries are
+// read from the standard input when no file is given.
+func readEntries(paths []string, filter *execlog.Filter, fn func(*execlog.Entry) error) error {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		var r io.ReadCloser = os.Stdin
+		if path != "-" {
+			f, err := execlog.OpenEntryFile(path)
+			if err != nil {
+				return err
+			}
+			r = f
+		}
+		err := readEntryFile(path, r, filter, fn)
+		r.Close()
+		if err == errStopReading {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readEntryFile(path string, r io.Reader, filter *execlog.Filter, fn func(*execlog.Entry) error) error {
+	er := execlog.NewEntryReader(r)
+	for {
+		entry, err := er.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read execution log %s: %s", path, err)
+		}
+		if !filter.Match(entry) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// operationName returns the name of the operation of the entry for display.
+func operationName(entry *execlog.Entry) string {
+	if name := entry.OperationName(); name != "" {
+		return name
+	}
+	return "(anonymous)"
+}
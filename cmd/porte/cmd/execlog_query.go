@@ -0,0 +1,94 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph/execlog"
+)
+
+// execlogQueryCmd represents the execlog query command
+var execlogQueryCmd = &cobra.Command{
+	Use:   "query [file...]",
+	Short: "Prints the execution log entries matching filters",
+	Long: `Reads execution log files, gzipped or not, or the standard input when no file
+is given, and prints the entries matching the filters. Entries are printed as
+JSON lines, in the format of the execution log, or as text.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter, err := entryFilter("execlog.query")
+		if err != nil {
+			return err
+		}
+		format := viper.GetString("execlog.query.format")
+		if format != "json" && format != "text" {
+			return fmt.Errorf("unknown format %q", format)
+		}
+		limit := viper.GetInt("execlog.query.limit")
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		n := 0
+		return readEntries(args, filter, func(entry *execlog.Entry) error {
+			if format == "json" {
+				if err := enc.Encode(entry); err != nil {
+					return err
+				}
+			} else {
+				status := "ok"
+				if entry.Error != "" {
+					status = "error: " + entry.Error
+				} else if entry.HasErrors() {
+					status = fmt.Sprintf("%d errors", len(entry.Response.Errors))
+				}
+				client := strings.TrimSpace(entry.ClientName + " " + entry.ClientVersion)
+				if client == "" {
+					client = "-"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s  %s  %s  %s  %s  %s\n",
+					entry.StartTime.Format(time.RFC3339),
+					entry.ID,
+					client,
+					operationName(entry),
+					entry.Duration,
+					status,
+				)
+			}
+			n++
+			if limit > 0 && n >= limit {
+				return errStopReading
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	execlogCmd.AddCommand(execlogQueryCmd)
+
+	addEntryFilterFlags(execlogQueryCmd, "execlog.query")
+	execlogQueryCmd.Flags().StringP("format", "f", "json", "Format to print the entries in (json or text)")
+	execlogQueryCmd.Flags().Int("limit", 0, "Maximum number of entries printed (0 means no limit)")
+
+	viper.BindPFlag("execlog.query.format", execlogQueryCmd.Flags().Lookup("format"))
+	viper.BindPFlag("execlog.query.limit", execlogQueryCmd.Flags().Lookup("limit"))
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/execlog"
+)
+
+// errReplayDifferences makes the replay command exit with a non-zero status,
+// without writing anything after its report.
+var errReplayDifferences = errors.New("replayed responses differ from the recorded ones")
+
+// execlogReplayCmd represents the execlog replay command
+var execlogReplayCmd = &cobra.Command{
+	Use:   "replay [file...]",
+	Short: "Replays the recorded requests against a graph and compares the responses",
+	Long: `Reads execution log files, gzipped or not, or the standard input when no file
+is given, sends the requests of the entries matching the filters to the target
+graph and compares its responses with the recorded ones.
+
+Only query operations are replayed unless mutations are enabled, subscriptions
+are never replayed. The command exits with a non-zero status when responses
+differ or requests fail.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter, err := entryFilter("execlog.replay")
+		if err != nil {
+			return err
+		}
+		format := viper.GetString("execlog.replay.format")
+		if format != "json" && format != "text" {
+			return fmt.Errorf("unknown format %q", format)
+		}
+		target := viper.GetString("execlog.replay.target")
+		if target == "" {
+			return errors.New("missing target graph URL")
+		}
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: targetURL})
+		if err != nil {
+			return err
+		}
+		mutations := viper.GetBool("execlog.replay.mutations")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		entries := make(chan *execlog.Entry)
+		readErr := make(chan error, 1)
+		skipped := 0
+		go func() {
+			defer close(entries)
+			readErr <- readEntries(args, filter, func(entry *execlog.Entry) error {
+				if !replayable(entry, mutations) {
+					skipped++
+					return nil
+				}
+				select {
+				case entries <- entry:
+					return nil
+				case <-ctx.Done():
+					return errStopReading
+				}
+			})
+		}()
+		results, err := execlog.Replay(ctx, execlog.ReplayConfig{
+			Graph:       g,
+			Concurrency: viper.GetInt("execlog.replay.concurrency"),
+			Rate:        viper.GetFloat64("execlog.replay.rate"),
+			Ignore:      viper.GetStringSlice("execlog.replay.ignore"),
+		}, entries)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		replayed, different, failed := 0, 0, 0
+		for res := range results {
+			replayed++
+			switch {
+			case res.Error != nil:
+				failed++
+			case len(res.Differences) > 0:
+				different++
+			}
+			if err := writeReplayResult(cmd, enc, res, format); err != nil {
+				return err
+			}
+		}
+		if err := <-readErr; err != nil {
+			return err
+		}
+		if format == "text" {
+			fmt.Fprintf(cmd.OutOrStdout(), "%d replayed, %d identical, %d different, %d failed, %d skipped\n",
+				replayed, replayed-different-failed, different, failed, skipped)
+		}
+		if different > 0 || failed > 0 {
+			return errReplayDifferences
+		}
+		return nil
+	},
+}
+
+// replayable reports whether the request of the entry can be replayed.
+func replayable(entry *execlog.Entry, mutations bool) bool {
+	if entry.Request == nil {
+		return false
+	}
+	opType, err := entry.Request.OperationType()
+	if err != nil {
+		return false
+	}
+	return opType == graph.OperationTypeQuery || mutations && opType == graph.OperationTypeMutation
+}
+
+// writeReplayResult writes the result of a replayed entry to the command
+// output in the given format, text or json. Identical responses are only
+// written in json.
+func writeReplayResult(cmd *cobra.Command, enc *json.Encoder, res *execlog.ReplayResult, format string) error {
+	if format == "json" {
+		out := struct {
+			ID            string              `json:"id"`
+			OperationName string              `json:"operationName"`
+			Error         string              `json:"error,omitempty"`
+			Differences   []*graph.Difference `json:"differences,omitempty"`
+		}{
+			ID:            res.Entry.ID,
+			OperationName: res.Entry.OperationName(),
+			Differences:   res.Differences,
+		}
+		if res.Error != nil {
+			out.Error = res.Error.Error()
+		}
+		return enc.Encode(out)
+	}
+	w := cmd.OutOrStdout()
+	switch {
+	case res.Error != nil:
+		fmt.Fprintf(w, "FAILED     %s  %s  %s\n", res.Entry.ID, operationName(res.Entry), res.Error)
+	case len(res.Differences) > 0:
+		fmt.Fprintf(w, "DIFFERENT  %s  %s\n", res.Entry.ID, operationName(res.Entry))
+		for _, d := range res.Differences {
+			fmt.Fprintf(w, "           %s\n", d)
+		}
+	}
+	return nil
+}
+
+func init() {
+	execlogCmd.AddCommand(execlogReplayCmd)
+
+	addEntryFilterFlags(execlogReplayCmd, "execlog.replay")
+	execlogReplayCmd.Flags().String("target", "", "URL of the graph to replay the requests against")
+	execlogReplayCmd.Flags().Int("concurrency", 1, "Number of requests sent concurrently")
+	execlogReplayCmd.Flags().Float64("rate", 0, "Maximum number of requests sent per second (0 means no limit)")
+	execlogReplayCmd.Flags().StringSlice("ignore", nil, "Path of response values not compared, like extensions or data.reviews.*.createdAt, can be repeated")
+	execlogReplayCmd.Flags().Bool("mutations", false, "Replay mutations too")
+	execlogReplayCmd.Flags().StringP("format", "f", "text", "Format to write the results in (text or json)")
+
+	viper.BindPFlag("execlog.replay.target", execlogReplayCmd.Flags().Lookup("target"))
+	viper.BindPFlag("execlog.replay.concurrency", execlogReplayCmd.Flags().Lookup("concurrency"))
+	viper.BindPFlag("execlog.replay.rate", execlogReplayCmd.Flags().Lookup("rate"))
+	viper.BindPFlag("execlog.replay.ignore", execlogReplayCmd.Flags().Lookup("ignore"))
+	viper.BindPFlag("execlog.replay.mutations", execlogReplayCmd.Flags().Lookup("mutations"))
+	viper.BindPFlag("execlog.replay.format", execlogReplayCmd.Flags().Lookup("format"))
+}
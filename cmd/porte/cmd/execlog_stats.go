@@ -0,0 +1,90 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph/execlog"
+)
+
+// execlogStatsCmd represents the execlog stats command
+var execlogStatsCmd = &cobra.Command{
+	Use:   "stats [file...]",
+	Short: "Aggregates the execution log entries by operation",
+	Long: `Reads execution log files, gzipped or not, or the standard input when no file
+is given, and prints for each operation the number of executions, the 50th,
+95th and 99th percentiles of their duration and their error rate.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter, err := entryFilter("execlog.stats")
+		if err != nil {
+			return err
+		}
+		stats := execlog.NewStats()
+		err = readEntries(args, filter, func(entry *execlog.Entry) error {
+			stats.Add(entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return writeOperationStats(cmd, stats.Operations(), viper.GetString("execlog.stats.format"))
+	},
+}
+
+// writeOperationStats writes the operation statistics to the command output
+// in the given format, text or json.
+func writeOperationStats(cmd *cobra.Command, ops []*execlog.OperationStats, format string) error {
+	out := cmd.OutOrStdout()
+	switch format {
+	case "text":
+		if len(ops) == 0 {
+			fmt.Fprintln(out, "No executions")
+			return nil
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "OPERATION\tCOUNT\tP50\tP95\tP99\tERRORS\t")
+		for _, op := range ops {
+			name := op.OperationName
+			if name == "" {
+				name = "(anonymous)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.2f%%\t\n", name, op.Count, op.P50, op.P95, op.P99, op.ErrorRate*100)
+		}
+		return w.Flush()
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ops)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	execlogCmd.AddCommand(execlogStatsCmd)
+
+	addEntryFilterFlags(execlogStatsCmd, "execlog.stats")
+	execlogStatsCmd.Flags().StringP("format", "f", "text", "Format to write the statistics in (text or json)")
+
+	viper.BindPFlag("execlog.stats.format", execlogStatsCmd.Flags().Lookup("format"))
+}
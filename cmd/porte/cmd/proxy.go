@@ -16,15 +16,34 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/herzult/porte/internal/graph/prometheus"
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/herzult/porte/internal/graph/debug"
+	"github.com/herzult/porte/internal/graph/execlog"
+	"github.com/herzult/porte/internal/graph/federation"
+	"github.com/herzult/porte/internal/graph/headers"
 	"github.com/herzult/porte/internal/graph/playground"
+	"github.com/herzult/porte/internal/graph/shadow"
+	"github.com/herzult/porte/internal/graph/stitching"
+	"github.com/herzult/porte/internal/graph/upstream"
+	"github.com/herzult/porte/internal/graph/validation"
 
 	"github.com/spf13/viper"
 
@@ -38,57 +57,169 @@ import (
 var proxyCmd = &cobra.Command{
 	Use:   "proxy",
 	Short: "Starts a GraphQL proxy",
-	// 	Long: `A longer description that spans multiple lines and likely contains examples
-	// and usage of using your command. For example:
+	Long: `Starts a GraphQL proxy.
 
-	// Cobra is a CLI library for Go that empowers applications.
-	// This application is a tool to generate the needed files
-	// to quickly create a Cobra application.`,
+The proxy serves the graph configured by the flags, or the graphs declared by
+the proxy.graphs list of the config file. Each graph entry takes the keys of
+the flags, the proxy.* ones being their defaults, plus:
+
+  graph-name          name of the graph, required and unique
+  route-host          host of the requests sent to the graph
+  route-header        header selecting the requests sent to the graph...
+  route-header-value  ...when it has this value
+  stitch              graphs whose schemas are merged into the one of the
+                      graph, instead of proxying graph-url
+  federate            federated graphs composed into the graph, instead of
+                      proxying graph-url
+
+A stitched graph entry has a graph-name and a graph-url, and may have:
+
+  schema        schema file of the graph, introspected when there is none
+  field-prefix  prefix of the root field names of the graph
+  type-prefix   prefix of the type names of the graph
+  namespace     root field the root fields of the graph are nested under
+
+A federated graph entry has a graph-name and a graph-url, and may have an sdl
+file holding the SDL of the graph, fetched from its _service field when there
+is none.
+
+A graph with graph-endpoints spreads its requests over several instances of
+its GraphQL service, graph-url defaulting to the first one. The endpoints are
+given weights by a #weight=N URL fragment. For example:
+
+  proxy:
+    upstreams-admin: true
+    graph-endpoints:
+      - http://users-1:8080/graphql#weight=2
+      - http://users-2:8080/graphql
+    balancing-strategy: weighted
+    health-check-interval: 10s
+
+The client headers sent to the graph go through the request-headers-* and
+forwarded-* rules, and the execution ID is sent in the request-id-header. For
+example:
+
+  proxy:
+    request-headers-deny: [Cookie, X-Internal-*]
+    request-headers-set:
+      - "X-Client-Ip: {{.ClientIP}}"
+    forwarded-for-header: append
+
+A request is sent to the first graph whose path or subscriptions path, host
+and header match it. For example:
+
+  proxy:
+    prometheus: true
+    graphs:
+      - graph-name: users
+        graph-url: http://users:8080/graphql
+        path: /users/graphql
+      - graph-name: products
+        graph-url: http://products:8080/graphql
+        execlog: true
+        execlog-file: /var/log/porte/products.log
+      - graph-name: gateway
+        path: /gateway/graphql
+        stitch:
+          - graph-name: users
+            graph-url: http://users:8080/graphql
+          - graph-name: reviews
+            graph-url: http://reviews:8080/graphql
+            type-prefix: Reviews
+            namespace: reviews
+      - graph-name: supergraph
+        path: /supergraph/graphql
+        federate:
+          - graph-name: accounts
+            graph-url: http://accounts:8080/graphql
+          - graph-name: inventory
+            graph-url: http://inventory:8080/graphql
+            sdl: /etc/porte/inventory.graphql`,
 	Run: func(cmd *cobra.Command, args []string) {
-		graphURL, err := url.Parse(viper.GetString("proxy.graph-url"))
+		settings, err := graphSettings()
 		if err != nil {
 			panic(err)
 		}
-		g, err := graph.NewGraph(&graph.GraphConfig{
-			ServiceURL: graphURL,
-		})
+		// metrics of several graphs are told apart by a graph label
+		multi := viper.IsSet("proxy.graphs")
+		sinks := &execLogSinks{labelled: multi, writers: make(map[string]execlog.EntryWriter)}
+
+		routes := make([]*proxy.Route, 0, len(settings))
+		pools := make(map[string]*upstream.Pool)
+		metrics := false
+		admin := false
+		for _, s := range settings {
+			registerer := promclient.DefaultRegisterer
+			if multi {
+				registerer = promclient.WrapRegistererWith(promclient.Labels{"graph": s.GetString("graph-name")}, registerer)
+			}
+			p, err := newGraphProxy(s, sinks, pools, registerer)
+			if err != nil {
+				panic(err)
+			}
+			paths := []string{s.GetString("path")}
+			if path := s.GetString("subscriptions-path"); path != paths[0] {
+				paths = append(paths, path)
+			}
+			routes = append(routes, &proxy.Route{
+				Paths:       paths,
+				Host:        s.GetString("route-host"),
+				Header:      s.GetString("route-header"),
+				HeaderValue: s.GetString("route-header-value"),
+				Proxy:       p,
+			})
+			metrics = metrics || s.GetBool("prometheus")
+			admin = admin || s.GetBool("upstreams-admin")
+		}
+		router, err := proxy.NewRouter(routes)
 		if err != nil {
 			panic(err)
 		}
 
-		plugs := make([]*proxy.Plugin, 0)
-		if viper.GetBool("proxy.debug") {
-			plug, _ := debug.NewProxyPlugin()
-			plugs = append(plugs, plug)
-		}
-		if viper.GetBool("proxy.playground") {
-			plug, _ := playground.NewProxyPlugin()
-			plugs = append(plugs, plug)
+		mux := http.NewServeMux()
+		if metrics {
+			mux.Handle("/metrics", promhttp.Handler())
 		}
-		if viper.GetBool("proxy.prometheus") {
-			plug, _ := prometheus.NewProxyPlugin(prometheus.ProxyPluginConfig{
-				Namespace: "porte",
-				Subsystem: "proxy",
-			})
-			plugs = append(plugs, plug)
-			http.Handle("/metrics", promhttp.Handler())
+		if admin {
+			mux.Handle("/admin/upstreams", upstream.NewAdminHandler(pools))
 		}
+		mux.Handle("/", router)
 
-		p, err := proxy.New(&proxy.Config{
-			Graph:   g,
-			Plugins: plugs,
-		})
-		if err != nil {
-			panic(err)
+		server := &http.Server{
+			Addr:    fmt.Sprint(":", viper.GetString("proxy.port")),
+			Handler: mux,
 		}
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			<-signals
+			signal.Stop(signals)
 
-		http.Handle(viper.GetString("proxy.path"), p)
+			cmd.Println("Shutting down")
+			ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("proxy.shutdown-timeout"))
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				cmd.PrintErrln("failed to shut down proxy:", err.Error())
+			}
+			// the execution log writers flush what the plugins buffered
+			for _, fn := range sinks.shutdown {
+				if err := fn(ctx); err != nil {
+					cmd.PrintErrln("failed to shut down proxy:", err.Error())
+				}
+			}
+			for _, p := range pools {
+				p.Close()
+			}
+		}()
 
-		address := fmt.Sprint(":", viper.GetString("proxy.port"))
-		cmd.Printf("Listening and serving HTTP on %s\n", address)
-		if err := http.ListenAndServe(address, nil); err != nil {
+		cmd.Printf("Listening and serving HTTP on %s\n", server.Addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
 			cmd.PrintErr("failed to run proxy: ", err.Error())
+			return
 		}
+		<-stopped
 	},
 }
 
@@ -98,14 +229,775 @@ func init() {
 	proxyCmd.Flags().String("port", "8080", "Port to run proxy on")
 	proxyCmd.Flags().String("path", "/graphql", "Path to handle GraphQL requests on")
 	proxyCmd.Flags().String("graph-url", "", "URL of the GraphQL service")
+	proxyCmd.Flags().String("graph-name", "", "Name identifying the graph in the execution log and metrics (defaults to the graph URL)")
+	proxyCmd.Flags().String("graph-subscription-url", "", "WebSocket URL of the GraphQL service subscriptions (defaults to the graph URL with a ws scheme)")
+	proxyCmd.Flags().String("subscriptions-path", "/subscriptions", "Path to handle GraphQL subscriptions over WebSocket on")
+	proxyCmd.Flags().StringSlice("subscriptions-origins", nil, "Origins of the web pages allowed to open subscriptions besides the proxy one, like https://app.example.org or * for any, can be repeated")
 	proxyCmd.Flags().Bool("playground", false, "Enable the GraphQL playground")
 	proxyCmd.Flags().Bool("debug", false, "Enable debug mode")
 	proxyCmd.Flags().Bool("prometheus", false, "Enable prometheus on /metrics")
+	proxyCmd.Flags().String("batch-mode", string(proxy.BatchModeFanOut), "How batched requests are sent to the graph (fanout or upstream)")
+	proxyCmd.Flags().Int("batch-concurrency", 0, "Maximum number of batched operations executed concurrently in fanout mode (0 means no limit)")
+	proxyCmd.Flags().Bool("execlog", false, "Enable the execution log")
+	proxyCmd.Flags().String("execlog-file", "-", "File to write the execution log to (- for stdout)")
+	proxyCmd.Flags().Int64("execlog-max-size", 0, "Size in megabytes above which the execution log file is rotated (0 means no limit)")
+	proxyCmd.Flags().Duration("execlog-max-age", 0, "Time after which the execution log file is rotated (0 means no limit)")
+	proxyCmd.Flags().Bool("execlog-compress", false, "Gzip the rotated execution log files")
+	proxyCmd.Flags().Int("execlog-max-backups", 0, "Number of rotated execution log files kept (0 keeps them all)")
+	proxyCmd.Flags().Duration("execlog-max-backup-age", 0, "Time rotated execution log files are kept for (0 keeps them forever)")
+	proxyCmd.Flags().String("execlog-amqp-url", "", "URL of the AMQP broker to publish the execution log to, instead of writing it to a file")
+	proxyCmd.Flags().String("execlog-amqp-exchange", "porte.execlog", "AMQP exchange to publish the execution log to")
+	proxyCmd.Flags().String("execlog-amqp-routing-key", "", "AMQP routing key of the published execution log entries")
+	proxyCmd.Flags().Int("execlog-amqp-buffer-size", 1000, "Number of execution log entries kept in memory while the AMQP broker is unreachable")
+	proxyCmd.Flags().String("execlog-amqp-overflow", string(execlog.OverflowDropOldest), "What to do with new execution log entries when the AMQP buffer is full (drop-oldest or block)")
+	proxyCmd.Flags().Int("execlog-queue-size", 10000, "Number of execution log entries waiting to be written above which new entries are dropped")
+	proxyCmd.Flags().Int("execlog-batch-size", 100, "Maximum number of execution log entries written at once")
+	proxyCmd.Flags().Duration("execlog-flush-interval", time.Second, "Maximum time an execution log entry waits for its batch to fill up before being written")
+	proxyCmd.Flags().Int("execlog-workers", 1, "Number of execution log batches written concurrently")
+	proxyCmd.Flags().Float64("execlog-sample-rate", 1, "Fraction of the executions written to the execution log, between 0 and 1")
+	proxyCmd.Flags().StringSlice("execlog-redact", nil, "Keys whose values are redacted from the variables, data and error extensions of the execution log and shadow execution log, can be repeated (arguments written inline in queries and error messages are not redacted)")
+	proxyCmd.Flags().Duration("shutdown-timeout", 10*time.Second, "Time given to in-flight requests and buffered execution log entries on shutdown")
+	proxyCmd.Flags().String("shadow-graph-url", "", "URL of a graph to mirror the requests to, comparing its responses with the primary graph ones")
+	proxyCmd.Flags().Float64("shadow-sample-rate", 1, "Fraction of the requests mirrored to the shadow graph, between 0 and 1")
+	proxyCmd.Flags().Bool("shadow-mutations", false, "Mirror mutations to the shadow graph too")
+	proxyCmd.Flags().StringSlice("shadow-ignore", nil, "Path of response data and errors not compared with the shadow graph ones, like data.reviews.*.createdAt, can be repeated")
+	proxyCmd.Flags().Int("shadow-concurrency", 10, "Maximum number of requests to the shadow graph in flight, above which requests are not mirrored")
+	proxyCmd.Flags().Duration("shadow-timeout", 10*time.Second, "Timeout of the requests to the shadow graph")
+	proxyCmd.Flags().String("shadow-execlog-file", "", "File to write the shadow executions differing from the primary ones to (- for stdout), other than the execution log one")
+	proxyCmd.Flags().Duration("transport-response-header-timeout", 0, "Time to wait for the graph response headers (0 means no limit)")
+	proxyCmd.Flags().Int("transport-max-idle-conns-per-host", 0, "Maximum number of idle connections kept to the graph (0 means the Go default)")
+	proxyCmd.Flags().Duration("transport-idle-conn-timeout", 90*time.Second, "Time an idle connection to the graph is kept for")
+	proxyCmd.Flags().Bool("transport-insecure-skip-verify", false, "Do not verify the graph TLS certificate")
+	proxyCmd.Flags().String("validation-schema", "", "Schema file (SDL or introspection JSON) to validate graph requests against before sending them")
+	proxyCmd.Flags().StringSlice("graph-endpoints", nil, "URLs of the instances of the GraphQL service to spread the requests over, with an optional #weight=N fragment, can be repeated")
+	proxyCmd.Flags().String("balancing-strategy", string(upstream.StrategyRoundRobin), "How requests are spread over the graph endpoints (round-robin, least-in-flight, weighted or consistent-hash)")
+	proxyCmd.Flags().String("balancing-hash-header", "", "Header whose value picks the graph endpoint with the consistent-hash strategy")
+	proxyCmd.Flags().Duration("health-check-interval", 0, "Time between the health checks of the graph endpoints (0 disables them)")
+	proxyCmd.Flags().String("health-check-query", upstream.DefaultHealthCheckQuery, "GraphQL query probing the health of the graph endpoints")
+	proxyCmd.Flags().Duration("health-check-timeout", 5*time.Second, "Timeout of the health checks of the graph endpoints")
+	proxyCmd.Flags().Int("health-check-unhealthy-threshold", 3, "Number of consecutive failed health checks after which a graph endpoint is unhealthy")
+	proxyCmd.Flags().Int("health-check-healthy-threshold", 2, "Number of consecutive successful health checks after which a graph endpoint is healthy again")
+	proxyCmd.Flags().Int("ejection-failures", 5, "Number of consecutive failed requests after which a graph endpoint is ejected (0 never ejects them)")
+	proxyCmd.Flags().Duration("ejection-time", 30*time.Second, "Time a graph endpoint is ejected for")
+	proxyCmd.Flags().Duration("slow-start", 0, "Time a graph endpoint back in the pool takes to get its full share of the requests")
+	proxyCmd.Flags().Bool("upstreams-admin", false, "Serve the state of the graph endpoints on /admin/upstreams")
+	proxyCmd.Flags().Duration("graph-timeout", 0, "Time a graph request may take, retries and the read of the response included (0 means no limit)")
+	proxyCmd.Flags().Duration("graph-read-timeout", 0, "Time each attempt of a graph request may take, from sending it to reading the response (0 means no limit)")
+	proxyCmd.Flags().Duration("graph-connect-timeout", 30*time.Second, "Time each attempt of a graph request waits for a connection to the graph (0 means no limit)")
+	proxyCmd.Flags().Int("retry-max-attempts", 1, "Number of times a graph query is sent when the graph is unreachable or unavailable (1 means no retries)")
+	proxyCmd.Flags().Duration("retry-backoff", 100*time.Millisecond, "Maximum wait before retrying a graph request, doubling on each retry")
+	proxyCmd.Flags().Duration("retry-max-backoff", 2*time.Second, "Maximum wait between retries of a graph request")
+	proxyCmd.Flags().Bool("retry-mutations", false, "Retry graph mutations too, which the graph may execute more than once")
+	proxyCmd.Flags().Bool("circuit-breaker", false, "Stop sending requests to the graph for a while after consecutive failures")
+	proxyCmd.Flags().Int("circuit-breaker-failures", 5, "Number of consecutive failed graph requests opening the circuit breaker")
+	proxyCmd.Flags().Duration("circuit-breaker-open-timeout", 30*time.Second, "Time the circuit breaker stays open before letting trial requests through")
+	proxyCmd.Flags().Int("circuit-breaker-half-open-requests", 1, "Number of trial requests that must succeed to close the circuit breaker")
+	proxyCmd.Flags().StringSlice("response-headers-allow", nil, "Headers of the graph responses written back to the client, like Set-Cookie or X-RateLimit-*, can be repeated")
+	proxyCmd.Flags().StringSlice("response-headers-deny", nil, "Headers of the graph responses not written back to the client even when allowed, can be repeated")
+	proxyCmd.Flags().StringSlice("request-headers-allow", nil, "Client headers sent to the graph, like Authorization or X-Trace-* (all of them by default), can be repeated")
+	proxyCmd.Flags().StringSlice("request-headers-deny", nil, "Client headers not sent to the graph even when allowed, can be repeated")
+	proxyCmd.Flags().StringSlice("request-headers-rename", nil, "Client header sent to the graph under another name, as \"From: To\", can be repeated")
+	proxyCmd.Flags().StringSlice("request-headers-set", nil, "Header to send to the graph, as \"Name: value\", the value being a template like {{.ClientIP}}, {{.ExecID}} or {{.Claims.sub}}, can be repeated")
+	proxyCmd.Flags().Bool("request-headers-unverified-claims", false, "Expose the claims of the JWT bearer token to the request-headers-set templates as {{.Claims}}, WITHOUT verifying the token: only enable it when something in front of the proxy verifies it")
+	proxyCmd.Flags().String("forwarded-for-header", string(headers.ForwardedKeep), "What to do with the X-Forwarded-For header sent to the graph (keep, strip or append the client IP)")
+	proxyCmd.Flags().String("forwarded-header", string(headers.ForwardedKeep), "What to do with the Forwarded header sent to the graph (keep, strip or append the client)")
+	proxyCmd.Flags().String("request-id-header", headers.DefaultRequestIDHeader, "Header the execution ID is sent to the graph in (empty disables it)")
+	proxyCmd.Flags().Duration("schema-load-timeout", 30*time.Second, "Time to load the schemas of the stitched and federated graphs on startup")
 
 	viper.BindPFlag("proxy.port", proxyCmd.Flags().Lookup("port"))
 	viper.BindPFlag("proxy.path", proxyCmd.Flags().Lookup("path"))
 	viper.BindPFlag("proxy.graph-url", proxyCmd.Flags().Lookup("graph-url"))
+	viper.BindPFlag("proxy.graph-name", proxyCmd.Flags().Lookup("graph-name"))
+	viper.BindPFlag("proxy.graph-subscription-url", proxyCmd.Flags().Lookup("graph-subscription-url"))
+	viper.BindPFlag("proxy.subscriptions-path", proxyCmd.Flags().Lookup("subscriptions-path"))
+	viper.BindPFlag("proxy.subscriptions-origins", proxyCmd.Flags().Lookup("subscriptions-origins"))
 	viper.BindPFlag("proxy.playground", proxyCmd.Flags().Lookup("playground"))
 	viper.BindPFlag("proxy.debug", proxyCmd.Flags().Lookup("debug"))
 	viper.BindPFlag("proxy.prometheus", proxyCmd.Flags().Lookup("prometheus"))
+	viper.BindPFlag("proxy.batch-mode", proxyCmd.Flags().Lookup("batch-mode"))
+	viper.BindPFlag("proxy.batch-concurrency", proxyCmd.Flags().Lookup("batch-concurrency"))
+	viper.BindPFlag("proxy.execlog", proxyCmd.Flags().Lookup("execlog"))
+	viper.BindPFlag("proxy.execlog-file", proxyCmd.Flags().Lookup("execlog-file"))
+	viper.BindPFlag("proxy.execlog-max-size", proxyCmd.Flags().Lookup("execlog-max-size"))
+	viper.BindPFlag("proxy.execlog-max-age", proxyCmd.Flags().Lookup("execlog-max-age"))
+	viper.BindPFlag("proxy.execlog-compress", proxyCmd.Flags().Lookup("execlog-compress"))
+	viper.BindPFlag("proxy.execlog-max-backups", proxyCmd.Flags().Lookup("execlog-max-backups"))
+	viper.BindPFlag("proxy.execlog-max-backup-age", proxyCmd.Flags().Lookup("execlog-max-backup-age"))
+	viper.BindPFlag("proxy.execlog-amqp-url", proxyCmd.Flags().Lookup("execlog-amqp-url"))
+	viper.BindPFlag("proxy.execlog-amqp-exchange", proxyCmd.Flags().Lookup("execlog-amqp-exchange"))
+	viper.BindPFlag("proxy.execlog-amqp-routing-key", proxyCmd.Flags().Lookup("execlog-amqp-routing-key"))
+	viper.BindPFlag("proxy.execlog-amqp-buffer-size", proxyCmd.Flags().Lookup("execlog-amqp-buffer-size"))
+	viper.BindPFlag("proxy.execlog-amqp-overflow", proxyCmd.Flags().Lookup("execlog-amqp-overflow"))
+	viper.BindPFlag("proxy.execlog-queue-size", proxyCmd.Flags().Lookup("execlog-queue-size"))
+	viper.BindPFlag("proxy.execlog-batch-size", proxyCmd.Flags().Lookup("execlog-batch-size"))
+	viper.BindPFlag("proxy.execlog-flush-interval", proxyCmd.Flags().Lookup("execlog-flush-interval"))
+	viper.BindPFlag("proxy.execlog-workers", proxyCmd.Flags().Lookup("execlog-workers"))
+	viper.BindPFlag("proxy.execlog-sample-rate", proxyCmd.Flags().Lookup("execlog-sample-rate"))
+	viper.BindPFlag("proxy.execlog-redact", proxyCmd.Flags().Lookup("execlog-redact"))
+	viper.BindPFlag("proxy.shutdown-timeout", proxyCmd.Flags().Lookup("shutdown-timeout"))
+	viper.BindPFlag("proxy.shadow-graph-url", proxyCmd.Flags().Lookup("shadow-graph-url"))
+	viper.BindPFlag("proxy.shadow-sample-rate", proxyCmd.Flags().Lookup("shadow-sample-rate"))
+	viper.BindPFlag("proxy.shadow-mutations", proxyCmd.Flags().Lookup("shadow-mutations"))
+	viper.BindPFlag("proxy.shadow-ignore", proxyCmd.Flags().Lookup("shadow-ignore"))
+	viper.BindPFlag("proxy.shadow-concurrency", proxyCmd.Flags().Lookup("shadow-concurrency"))
+	viper.BindPFlag("proxy.shadow-timeout", proxyCmd.Flags().Lookup("shadow-timeout"))
+	viper.BindPFlag("proxy.shadow-execlog-file", proxyCmd.Flags().Lookup("shadow-execlog-file"))
+	viper.BindPFlag("proxy.transport-response-header-timeout", proxyCmd.Flags().Lookup("transport-response-header-timeout"))
+	viper.BindPFlag("proxy.transport-max-idle-conns-per-host", proxyCmd.Flags().Lookup("transport-max-idle-conns-per-host"))
+	viper.BindPFlag("proxy.transport-idle-conn-timeout", proxyCmd.Flags().Lookup("transport-idle-conn-timeout"))
+	viper.BindPFlag("proxy.transport-insecure-skip-verify", proxyCmd.Flags().Lookup("transport-insecure-skip-verify"))
+	viper.BindPFlag("proxy.validation-schema", proxyCmd.Flags().Lookup("validation-schema"))
+	viper.BindPFlag("proxy.graph-endpoints", proxyCmd.Flags().Lookup("graph-endpoints"))
+	viper.BindPFlag("proxy.balancing-strategy", proxyCmd.Flags().Lookup("balancing-strategy"))
+	viper.BindPFlag("proxy.balancing-hash-header", proxyCmd.Flags().Lookup("balancing-hash-header"))
+	viper.BindPFlag("proxy.health-check-interval", proxyCmd.Flags().Lookup("health-check-interval"))
+	viper.BindPFlag("proxy.health-check-query", proxyCmd.Flags().Lookup("health-check-query"))
+	viper.BindPFlag("proxy.health-check-timeout", proxyCmd.Flags().Lookup("health-check-timeout"))
+	viper.BindPFlag("proxy.health-check-unhealthy-threshold", proxyCmd.Flags().Lookup("health-check-unhealthy-threshold"))
+	viper.BindPFlag("proxy.health-check-healthy-threshold", proxyCmd.Flags().Lookup("health-check-healthy-threshold"))
+	viper.BindPFlag("proxy.ejection-failures", proxyCmd.Flags().Lookup("ejection-failures"))
+	viper.BindPFlag("proxy.ejection-time", proxyCmd.Flags().Lookup("ejection-time"))
+	viper.BindPFlag("proxy.slow-start", proxyCmd.Flags().Lookup("slow-start"))
+	viper.BindPFlag("proxy.upstreams-admin", proxyCmd.Flags().Lookup("upstreams-admin"))
+	viper.BindPFlag("proxy.graph-timeout", proxyCmd.Flags().Lookup("graph-timeout"))
+	viper.BindPFlag("proxy.graph-read-timeout", proxyCmd.Flags().Lookup("graph-read-timeout"))
+	viper.BindPFlag("proxy.graph-connect-timeout", proxyCmd.Flags().Lookup("graph-connect-timeout"))
+	viper.BindPFlag("proxy.retry-max-attempts", proxyCmd.Flags().Lookup("retry-max-attempts"))
+	viper.BindPFlag("proxy.retry-backoff", proxyCmd.Flags().Lookup("retry-backoff"))
+	viper.BindPFlag("proxy.retry-max-backoff", proxyCmd.Flags().Lookup("retry-max-backoff"))
+	viper.BindPFlag("proxy.retry-mutations", proxyCmd.Flags().Lookup("retry-mutations"))
+	viper.BindPFlag("proxy.circuit-breaker", proxyCmd.Flags().Lookup("circuit-breaker"))
+	viper.BindPFlag("proxy.circuit-breaker-failures", proxyCmd.Flags().Lookup("circuit-breaker-failures"))
+	viper.BindPFlag("proxy.circuit-breaker-open-timeout", proxyCmd.Flags().Lookup("circuit-breaker-open-timeout"))
+	viper.BindPFlag("proxy.circuit-breaker-half-open-requests", proxyCmd.Flags().Lookup("circuit-breaker-half-open-requests"))
+	viper.BindPFlag("proxy.response-headers-allow", proxyCmd.Flags().Lookup("response-headers-allow"))
+	viper.BindPFlag("proxy.response-headers-deny", proxyCmd.Flags().Lookup("response-headers-deny"))
+	viper.BindPFlag("proxy.request-headers-allow", proxyCmd.Flags().Lookup("request-headers-allow"))
+	viper.BindPFlag("proxy.request-headers-deny", proxyCmd.Flags().Lookup("request-headers-deny"))
+	viper.BindPFlag("proxy.request-headers-rename", proxyCmd.Flags().Lookup("request-headers-rename"))
+	viper.BindPFlag("proxy.request-headers-set", proxyCmd.Flags().Lookup("request-headers-set"))
+	viper.BindPFlag("proxy.request-headers-unverified-claims", proxyCmd.Flags().Lookup("request-headers-unverified-claims"))
+	viper.BindPFlag("proxy.forwarded-for-header", proxyCmd.Flags().Lookup("forwarded-for-header"))
+	viper.BindPFlag("proxy.forwarded-header", proxyCmd.Flags().Lookup("forwarded-header"))
+	viper.BindPFlag("proxy.request-id-header", proxyCmd.Flags().Lookup("request-id-header"))
+	viper.BindPFlag("proxy.schema-load-timeout", proxyCmd.Flags().Lookup("schema-load-timeout"))
+}
+
+// graphSettings returns the settings of each graph served by the proxy: the
+// entries of the proxy.graphs list, or the proxy.* keys when there is no
+// such list. The proxy.* keys are the defaults of the entries.
+func graphSettings() ([]*viper.Viper, error) {
+	defaults := make(map[string]interface{})
+	for _, key := range viper.AllKeys() {
+		if strings.HasPrefix(key, "proxy.") && key != "proxy.graphs" {
+			defaults[strings.TrimPrefix(key, "proxy.")] = viper.Get(key)
+		}
+	}
+	newSettings := func() *viper.Viper {
+		s := viper.New()
+		for key, value := range defaults {
+			s.SetDefault(key, value)
+		}
+		return s
+	}
+	if !viper.IsSet("proxy.graphs") {
+		return []*viper.Viper{newSettings()}, nil
+	}
+
+	var entries []map[string]interface{}
+	if err := viper.UnmarshalKey("proxy.graphs", &entries); err != nil {
+		return nil, fmt.Errorf("invalid proxy.graphs: %s", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("proxy.graphs declares no graph")
+	}
+	settings := make([]*viper.Viper, 0, len(entries))
+	names := make(map[string]bool)
+	for i, entry := range entries {
+		s := newSettings()
+		for key, value := range entry {
+			s.Set(key, value)
+		}
+		name := s.GetString("graph-name")
+		if name == "" {
+			return nil, fmt.Errorf("graph %d of proxy.graphs has no graph-name", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("graph name %s is declared more than once in proxy.graphs", name)
+		}
+		names[name] = true
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// newGraphProxy returns the proxy of the graph configured by the settings,
+// whose plugins register their metrics with the registerer. The pool of its
+// endpoints, if any, is added to the pools.
+func newGraphProxy(s *viper.Viper, sinks *execLogSinks, pools map[string]*upstream.Pool, registerer promclient.Registerer) (proxy.Proxy, error) {
+	transport := newGraphTransport(s)
+	var g graph.Graph
+	var err error
+	switch {
+	case s.IsSet("stitch"):
+		g, err = newStitchingGateway(s, transport, registerer)
+	case s.IsSet("federate"):
+		g, err = newFederationGateway(s, transport, registerer)
+	default:
+		g, err = newGraph(s, transport, pools, registerer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	headerRules, err := newHeaderRules(s)
+	if err != nil {
+		return nil, err
+	}
+
+	plugs := make([]*proxy.Plugin, 0)
+	if path := s.GetString("validation-schema"); path != "" {
+		schema, err := readSchemaFile(path)
+		if err != nil {
+			return nil, err
+		}
+		plug, err := validation.NewProxyPlugin(schema)
+		if err != nil {
+			return nil, err
+		}
+		plugs = append(plugs, plug)
+	}
+	// only the requests passing validation are mirrored
+	if shadowURL := s.GetString("shadow-graph-url"); shadowURL != "" {
+		u, err := url.Parse(shadowURL)
+		if err != nil {
+			return nil, err
+		}
+		sg, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+		if err != nil {
+			return nil, err
+		}
+		var entryWriter execlog.EntryWriter
+		if path := s.GetString("shadow-execlog-file"); path != "" {
+			entryWriter, err = sinks.shadowEntryWriter(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		plug, err := shadow.NewProxyPlugin(shadow.ProxyPluginConfig{
+			Graph:       sg,
+			Transport:   headerRules.SendGraphRequest(transport),
+			SampleRate:  s.GetFloat64("shadow-sample-rate"),
+			Mutations:   s.GetBool("shadow-mutations"),
+			Ignore:      s.GetStringSlice("shadow-ignore"),
+			Concurrency: s.GetInt("shadow-concurrency"),
+			Timeout:     s.GetDuration("shadow-timeout"),
+			EntryWriter: entryWriter,
+			Redact:      s.GetStringSlice("execlog-redact"),
+			Namespace:   "porte",
+			Subsystem:   "proxy",
+			Registerer:  registerer,
+		})
+		if err != nil {
+			return nil, err
+		}
+		plugs = append(plugs, plug)
+	}
+	// plugins wrap the ones registered before them: debug sees the graph
+	// requests as sent, execlog logs what prometheus measures, and the
+	// playground pages are neither logged nor measured
+	if s.GetBool("debug") {
+		plug, _ := debug.NewProxyPlugin()
+		plugs = append(plugs, plug)
+	}
+	if s.GetBool("execlog") {
+		entryWriter, err := sinks.entryWriter(s)
+		if err != nil {
+			return nil, err
+		}
+		plug, err := execlog.NewProxyPlugin(execlog.ProxyPluginConfig{
+			EntryWriter: entryWriter,
+			SampleRate:  s.GetFloat64("execlog-sample-rate"),
+			Redact:      s.GetStringSlice("execlog-redact"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		plugs = append(plugs, plug)
+	}
+	if s.GetBool("prometheus") {
+		plug, err := prometheus.NewProxyPlugin(prometheus.ProxyPluginConfig{
+			Namespace:  "porte",
+			Subsystem:  "proxy",
+			Registerer: registerer,
+		})
+		if err != nil {
+			return nil, err
+		}
+		plugs = append(plugs, plug)
+	}
+	if s.GetBool("playground") {
+		plug, _ := playground.NewProxyPlugin()
+		plugs = append(plugs, plug)
+	}
+	// the header rules apply first, the other plugins see the graph
+	// requests with their headers as sent
+	plugs = append(plugs, headerRules)
+
+	return proxy.New(&proxy.Config{
+		Graph:     g,
+		Plugins:   plugs,
+		Transport: transport,
+		Batch: proxy.BatchConfig{
+			Mode:        proxy.BatchMode(s.GetString("batch-mode")),
+			Concurrency: s.GetInt("batch-concurrency"),
+		},
+		ResponseHeaders: proxy.ResponseHeaderPolicy{
+			Allow: s.GetStringSlice("response-headers-allow"),
+			Deny:  s.GetStringSlice("response-headers-deny"),
+		},
+		SubscriptionOrigins: s.GetStringSlice("subscriptions-origins"),
+	})
+}
+
+// newHeaderRules returns the proxy plugin applying the rules configured by
+// the request-headers-*, forwarded-* and request-id-header settings to the
+// headers of the graph requests.
+func newHeaderRules(s *viper.Viper) (*proxy.Plugin, error) {
+	rename := make(map[string]string)
+	for _, h := range s.GetStringSlice("request-headers-rename") {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header rename %q, expected \"From: To\"", h)
+		}
+		rename[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	set := make(map[string]string)
+	for _, h := range s.GetStringSlice("request-headers-set") {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+		set[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return headers.NewProxyPlugin(headers.ProxyPluginConfig{
+		Allow:            s.GetStringSlice("request-headers-allow"),
+		Deny:             s.GetStringSlice("request-headers-deny"),
+		Rename:           rename,
+		ForwardedFor:     headers.ForwardedMode(s.GetString("forwarded-for-header")),
+		Forwarded:        headers.ForwardedMode(s.GetString("forwarded-header")),
+		Set:              set,
+		UnverifiedClaims: s.GetBool("request-headers-unverified-claims"),
+		RequestIDHeader:  s.GetString("request-id-header"),
+	})
+}
+
+// newGraph returns the graph configured by the graph-*, retry-* and
+// circuit-breaker-* settings, balancing its requests over its endpoints with
+// the pool configured by the balancing-*, health-check-*, ejection-* and
+// slow-start settings.
+func newGraph(s *viper.Viper, transport http.RoundTripper, pools map[string]*upstream.Pool, registerer promclient.Registerer) (graph.Graph, error) {
+	var pool *upstream.Pool
+	rawurl := s.GetString("graph-url")
+	if rawurls := s.GetStringSlice("graph-endpoints"); len(rawurls) != 0 {
+		var err error
+		pool, err = newUpstreamPool(s, rawurls, transport, registerer)
+		if err != nil {
+			return nil, err
+		}
+		if rawurl == "" {
+			// the endpoints are valid once in the pool
+			e, _ := upstream.ParseEndpoint(rawurls[0])
+			rawurl = e.URL.String()
+		}
+	}
+	graphURL, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptionURL *url.URL
+	if u := s.GetString("graph-subscription-url"); u != "" {
+		subscriptionURL, err = url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := newGraphConfig(s, s.GetString("graph-name"), graphURL, "proxy", registerer)
+	if err != nil {
+		return nil, err
+	}
+	cfg.SubscriptionURL = subscriptionURL
+	if pool != nil {
+		cfg.Balancer = pool
+	}
+	g, err := graph.NewGraph(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if pool != nil {
+		pools[g.ID()] = pool
+	}
+	return g, nil
+}
+
+// newGraphConfig returns the configuration of the graph of the given name
+// and service URL, with the timeouts, retry policy and circuit breaker of the
+// settings. The metrics of the circuit breaker are registered with the
+// registerer in the given subsystem when prometheus is enabled.
+func newGraphConfig(s *viper.Viper, name string, serviceURL *url.URL, subsystem string, registerer promclient.Registerer) (*graph.GraphConfig, error) {
+	cfg := &graph.GraphConfig{
+		Name:           name,
+		ServiceURL:     serviceURL,
+		Timeout:        s.GetDuration("graph-timeout"),
+		ReadTimeout:    s.GetDuration("graph-read-timeout"),
+		ConnectTimeout: s.GetDuration("graph-connect-timeout"),
+		Retry: graph.RetryPolicy{
+			MaxAttempts: s.GetInt("retry-max-attempts"),
+			Backoff:     s.GetDuration("retry-backoff"),
+			MaxBackoff:  s.GetDuration("retry-max-backoff"),
+			Mutations:   s.GetBool("retry-mutations"),
+		},
+	}
+	if s.GetBool("circuit-breaker") {
+		cfg.CircuitBreaker = graph.NewCircuitBreaker(graph.CircuitBreakerConfig{
+			FailureThreshold: s.GetInt("circuit-breaker-failures"),
+			OpenTimeout:      s.GetDuration("circuit-breaker-open-timeout"),
+			HalfOpenRequests: s.GetInt("circuit-breaker-half-open-requests"),
+		})
+		if s.GetBool("prometheus") {
+			err := prometheus.RegisterCircuitBreaker(cfg.CircuitBreaker, prometheus.CircuitBreakerConfig{
+				Namespace:  "porte",
+				Subsystem:  subsystem,
+				Registerer: registerer,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// newUpstreamPool returns the pool of the graph endpoints, recording its
+// metrics when prometheus is enabled.
+func newUpstreamPool(s *viper.Viper, rawurls []string, transport http.RoundTripper, registerer promclient.Registerer) (*upstream.Pool, error) {
+	endpoints := make([]*upstream.Endpoint, 0, len(rawurls))
+	for _, rawurl := range rawurls {
+		e, err := upstream.ParseEndpoint(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	cfg := &upstream.Config{
+		Endpoints:            endpoints,
+		Strategy:             upstream.Strategy(s.GetString("balancing-strategy")),
+		HashHeader:           s.GetString("balancing-hash-header"),
+		HealthCheckInterval:  s.GetDuration("health-check-interval"),
+		HealthCheckQuery:     s.GetString("health-check-query"),
+		HealthCheckTimeout:   s.GetDuration("health-check-timeout"),
+		UnhealthyThreshold:   s.GetInt("health-check-unhealthy-threshold"),
+		HealthyThreshold:     s.GetInt("health-check-healthy-threshold"),
+		HealthCheckTransport: transport,
+		MaxFailures:          s.GetInt("ejection-failures"),
+		EjectionTime:         s.GetDuration("ejection-time"),
+		SlowStart:            s.GetDuration("slow-start"),
+		Namespace:            "porte",
+		Subsystem:            "proxy",
+	}
+	if s.GetBool("prometheus") {
+		cfg.Registerer = registerer
+	}
+	return upstream.NewPool(cfg)
+}
+
+// stitchedGraph is an entry of the stitch list of a graph.
+type stitchedGraph struct {
+	Name        string `mapstructure:"graph-name"`
+	URL         string `mapstructure:"graph-url"`
+	Schema      string `mapstructure:"schema"`
+	FieldPrefix string `mapstructure:"field-prefix"`
+	TypePrefix  string `mapstructure:"type-prefix"`
+	Namespace   string `mapstructure:"namespace"`
+}
+
+// newStitchingGateway returns the gateway merging the schemas of the graphs
+// of the stitch list, introspecting them with the transport within the
+// schema-load-timeout. The graphs have the timeouts, retry policy and circuit
+// breaker of the settings.
+func newStitchingGateway(s *viper.Viper, transport http.RoundTripper, registerer promclient.Registerer) (graph.Graph, error) {
+	var entries []*stitchedGraph
+	if err := s.UnmarshalKey("stitch", &entries); err != nil {
+		return nil, fmt.Errorf("invalid stitch list: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.GetDuration("schema-load-timeout"))
+	defer cancel()
+	subs := make([]*stitching.Subschema, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "" {
+			return nil, errors.New("stitched graph must have a graph-name")
+		}
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return nil, err
+		}
+		// the subgraphs have their own circuit breaker, whose metrics are
+		// labeled with their name: they have other names than the metrics of
+		// the breakers of plain graphs, which have no such label
+		cfg, err := newGraphConfig(s, entry.Name, u, "gateway", promclient.WrapRegistererWith(promclient.Labels{"subgraph": entry.Name}, registerer))
+		if err != nil {
+			return nil, err
+		}
+		g, err := graph.NewGraph(cfg)
+		if err != nil {
+			return nil, err
+		}
+		source := entry.Schema
+		if source == "" {
+			source = entry.URL
+		}
+		gs, err := loadSchema(ctx, source, transport)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the schema of stitched graph %s: %s", entry.Name, err)
+		}
+		subs = append(subs, &stitching.Subschema{
+			Graph:       g,
+			Schema:      gs,
+			FieldPrefix: entry.FieldPrefix,
+			TypePrefix:  entry.TypePrefix,
+			Namespace:   entry.Namespace,
+		})
+	}
+	name := s.GetString("graph-name")
+	if name == "" {
+		name = "gateway"
+	}
+	return stitching.NewGateway(&stitching.GatewayConfig{Name: name, Subschemas: subs})
+}
+
+// federatedGraph is an entry of the federate list of a graph.
+type federatedGraph struct {
+	Name string `mapstructure:"graph-name"`
+	URL  string `mapstructure:"graph-url"`
+	SDL  string `mapstructure:"sdl"`
+}
+
+// newFederationGateway returns the gateway composing the graphs of the
+// federate list, fetching their SDL with the transport within the
+// schema-load-timeout. The graphs have the timeouts, retry policy and circuit
+// breaker of the settings.
+func newFederationGateway(s *viper.Viper, transport http.RoundTripper, registerer promclient.Registerer) (graph.Graph, error) {
+	var entries []*federatedGraph
+	if err := s.UnmarshalKey("federate", &entries); err != nil {
+		return nil, fmt.Errorf("invalid federate list: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.GetDuration("schema-load-timeout"))
+	defer cancel()
+	subs := make([]*federation.Subgraph, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "" {
+			return nil, errors.New("federated graph must have a graph-name")
+		}
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return nil, err
+		}
+		// the subgraphs have their own circuit breaker, whose metrics are
+		// labeled with their name: they have other names than the metrics of
+		// the breakers of plain graphs, which have no such label
+		cfg, err := newGraphConfig(s, entry.Name, u, "gateway", promclient.WrapRegistererWith(promclient.Labels{"subgraph": entry.Name}, registerer))
+		if err != nil {
+			return nil, err
+		}
+		g, err := graph.NewGraph(cfg)
+		if err != nil {
+			return nil, err
+		}
+		var sdl string
+		if entry.SDL != "" {
+			var data []byte
+			data, err = ioutil.ReadFile(entry.SDL)
+			sdl = string(data)
+		} else {
+			sdl, err = federation.FetchSDL(ctx, g, transport)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the SDL of federated graph %s: %s", entry.Name, err)
+		}
+		subs = append(subs, &federation.Subgraph{Graph: g, SDL: sdl})
+	}
+	name := s.GetString("graph-name")
+	if name == "" {
+		name = "gateway"
+	}
+	return federation.NewGateway(&federation.GatewayConfig{Name: name, Subgraphs: subs})
+}
+
+// newGraphTransport returns the transport of the graph requests configured
+// by the transport-* settings.
+func newGraphTransport(s *viper.Viper) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = s.GetDuration("transport-response-header-timeout")
+	if n := s.GetInt("transport-max-idle-conns-per-host"); n > 0 {
+		t.MaxIdleConnsPerHost = n
+	}
+	if d := s.GetDuration("transport-idle-conn-timeout"); d > 0 {
+		t.IdleConnTimeout = d
+	}
+	if s.GetBool("transport-insecure-skip-verify") {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return t
+}
+
+// execLogSinks shares the execution log writers between the graphs writing
+// their entries to the same destination. A writer is configured by the
+// settings of the first graph using it.
+type execLogSinks struct {
+	// labelled sinks register their metrics with a sink label, so that
+	// several of them can be registered
+	labelled bool
+	writers  map[string]execlog.EntryWriter
+	// files are the kinds of the entries written to each file, stdout
+	// being -: a file is written by a single writer
+	files map[string]string
+	// shutdown flushes and closes the writers once the server stopped
+	shutdown []func(context.Context) error
+}
+
+// entryWriter returns the asynchronous execution log entry writer configured
+// by the execlog-* settings.
+func (sinks *execLogSinks) entryWriter(s *viper.Viper) (execlog.EntryWriter, error) {
+	key := s.GetString("execlog-amqp-url")
+	label := key
+	if u, err := url.Parse(key); err == nil && key != "" {
+		// credentials do not belong in metrics
+		u.User = nil
+		label = u.String()
+	}
+	if key == "" {
+		key = s.GetString("execlog-file")
+		if key == "" {
+			key = "-"
+		}
+		label = key
+		if err := sinks.claimFile(key, "execution log"); err != nil {
+			return nil, err
+		}
+	}
+	if w, ok := sinks.writers[key]; ok {
+		return w, nil
+	}
+
+	entryWriter, err := newExecLogEntryWriter(s)
+	if err != nil {
+		return nil, err
+	}
+	registerer := promclient.DefaultRegisterer
+	if sinks.labelled {
+		registerer = promclient.WrapRegistererWith(promclient.Labels{"sink": label}, registerer)
+	}
+	asyncWriter, err := execlog.NewAsyncEntryWriter(execlog.AsyncEntryWriterConfig{
+		EntryWriter:   entryWriter,
+		QueueSize:     s.GetInt("execlog-queue-size"),
+		BatchSize:     s.GetInt("execlog-batch-size"),
+		FlushInterval: s.GetDuration("execlog-flush-interval"),
+		Workers:       s.GetInt("execlog-workers"),
+		Namespace:     "porte",
+		Subsystem:     "proxy",
+		Registerer:    registerer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sinks.writers[key] = asyncWriter
+	sinks.shutdown = append(sinks.shutdown, asyncWriter.Shutdown)
+	return asyncWriter, nil
+}
+
+// shadowEntryWriter returns the entry writer of the shadow executions
+// differing from the primary ones, writing to the given file.
+func (sinks *execLogSinks) shadowEntryWriter(path string) (execlog.EntryWriter, error) {
+	if err := sinks.claimFile(path, "shadow execution log"); err != nil {
+		return nil, err
+	}
+	key := "shadow:" + path
+	if w, ok := sinks.writers[key]; ok {
+		return w, nil
+	}
+	if path == "-" {
+		w := &execlog.FileEntryWriter{File: os.Stdout}
+		sinks.writers[key] = w
+		return w, nil
+	}
+	w, err := execlog.NewRotatingFileEntryWriter(execlog.RotatingFileEntryWriterConfig{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	sinks.writers[key] = w
+	sinks.shutdown = append(sinks.shutdown, func(context.Context) error { return w.Close() })
+	return w, nil
+}
+
+// claimFile records that the entries of the given kind are written to the
+// file at path, - being stdout. It returns an error when entries of another
+// kind are already written to it: the writers of the file would each rotate
+// it and interleave their lines.
+func (sinks *execLogSinks) claimFile(path, kind string) error {
+	if sinks.files == nil {
+		sinks.files = make(map[string]string)
+	}
+	if path != "-" {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	if claimed, ok := sinks.files[path]; ok && claimed != kind {
+		if path == "-" {
+			return fmt.Errorf("%s and %s can not both be written to stdout", claimed, kind)
+		}
+		return fmt.Errorf("%s and %s can not both be written to %s", claimed, kind, path)
+	}
+	sinks.files[path] = kind
+	return nil
+}
+
+// newExecLogEntryWriter returns the execution log entry writer configured
+// by the execlog-* settings.
+func newExecLogEntryWriter(s *viper.Viper) (execlog.EntryWriter, error) {
+	if url := s.GetString("execlog-amqp-url"); url != "" {
+		return execlog.NewAMQPEntryWriter(execlog.AMQPEntryWriterConfig{
+			URL:            url,
+			Exchange:       s.GetString("execlog-amqp-exchange"),
+			RoutingKey:     s.GetString("execlog-amqp-routing-key"),
+			BufferSize:     s.GetInt("execlog-amqp-buffer-size"),
+			OverflowPolicy: execlog.OverflowPolicy(s.GetString("execlog-amqp-overflow")),
+		})
+	}
+	path := s.GetString("execlog-file")
+	if path == "" || path == "-" {
+		return &execlog.FileEntryWriter{File: os.Stdout}, nil
+	}
+	w, err := execlog.NewRotatingFileEntryWriter(execlog.RotatingFileEntryWriterConfig{
+		Path:         path,
+		MaxSize:      s.GetInt64("execlog-max-size") * 1024 * 1024,
+		MaxAge:       s.GetDuration("execlog-max-age"),
+		Compress:     s.GetBool("execlog-compress"),
+		MaxBackups:   s.GetInt("execlog-max-backups"),
+		MaxBackupAge: s.GetDuration("execlog-max-backup-age"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// logrotate moves the file away and sends SIGHUP
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		for range signals {
+			if err := w.Reopen(); err != nil {
+				log.Println("Failed to reopen execution log file:", err.Error())
+			}
+		}
+	}()
+	return w, nil
 }
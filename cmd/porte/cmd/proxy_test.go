@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph/execlog"
+	"github.com/herzult/porte/internal/graph/upstream"
+)
+
+func TestNewGraphProxy_circuit_breaker_metrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "porte")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sdl := filepath.Join(dir, "accounts.graphql")
+	err = ioutil.WriteFile(sdl, []byte(`
+extend type Query {
+  me: User
+}
+
+type User @key(fields: "id") {
+  id: ID!
+  name: String
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSettings := func(name string) *viper.Viper {
+		s := viper.New()
+		s.Set("graph-name", name)
+		s.Set("circuit-breaker", true)
+		s.Set("prometheus", true)
+		return s
+	}
+	plain := newSettings("starwars")
+	plain.Set("graph-url", "http://starwars:8080/graphql")
+	gateway := newSettings("supergraph")
+	gateway.Set("federate", []interface{}{
+		map[string]interface{}{
+			"graph-name": "accounts",
+			"graph-url":  "http://accounts:8080/graphql",
+			"sdl":        sdl,
+		},
+	})
+
+	// the graphs of a proxy register their metrics with the same registry,
+	// told apart by a graph label
+	reg := promclient.NewRegistry()
+	sinks := &execLogSinks{labelled: true, writers: make(map[string]execlog.EntryWriter)}
+	pools := make(map[string]*upstream.Pool)
+	for _, s := range []*viper.Viper{plain, gateway} {
+		registerer := promclient.WrapRegistererWith(promclient.Labels{"graph": s.GetString("graph-name")}, reg)
+		if _, err := newGraphProxy(s, sinks, pools, registerer); err != nil {
+			t.Fatalf("newGraphProxy() of graph %s returned error: %s", s.GetString("graph-name"), err)
+		}
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	for _, name := range []string{"porte_proxy_graph_circuit_breaker_state", "porte_gateway_graph_circuit_breaker_state"} {
+		if !names[name] {
+			t.Errorf("metric %s is not registered", name)
+		}
+	}
+}
+
+func TestExecLogSinks_same_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "porte")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		shadowPath  string
+		execLogPath string
+	}{
+		{name: "file", shadowPath: filepath.Join(dir, "execlog.jsonl"), execLogPath: filepath.Join(dir, ".", "execlog.jsonl")},
+		{name: "stdout", shadowPath: "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sinks := &execLogSinks{writers: make(map[string]execlog.EntryWriter)}
+			defer func() {
+				for _, shutdown := range sinks.shutdown {
+					shutdown(context.Background())
+				}
+			}()
+			if _, err := sinks.shadowEntryWriter(tt.shadowPath); err != nil {
+				t.Fatalf("shadowEntryWriter() returned error: %s", err)
+			}
+			s := viper.New()
+			s.Set("execlog-file", tt.execLogPath)
+			if _, err := sinks.entryWriter(s); err == nil {
+				t.Error("entryWriter() returned no error for the file of the shadow execution log")
+			}
+		})
+	}
+}
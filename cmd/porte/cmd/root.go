@@ -48,7 +48,11 @@ var rootCmd = &cobra.Command{
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		// the changes and differences were reported, the output must remain
+		// readable
+		if err != errBreakingChanges && err != errReplayDifferences {
+			fmt.Println(err)
+		}
 		os.Exit(1)
 	}
 }
@@ -90,6 +94,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
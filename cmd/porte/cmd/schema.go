@@ -0,0 +1,78 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Manages GraphQL schemas",
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
+
+// readSchemaFile reads a schema from a file, either written in SDL when its
+// extension is .graphql or .gql, or as an introspection result otherwise.
+func readSchemaFile(path string) (schema.Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg *schema.SchemaConfig
+	switch filepath.Ext(path) {
+	case ".graphql", ".gql":
+		cfg, err = schema.ParseSDL(string(data))
+	default:
+		cfg, err = schema.DecodeIntrospection(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema from %s: %s", path, err)
+	}
+	return schema.NewSchema(cfg)
+}
+
+// loadSchema loads a schema from the given source, either the URL of a
+// GraphQL service to introspect with the transport or a schema file.
+func loadSchema(ctx context.Context, source string, transport http.RoundTripper) (schema.Schema, error) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return readSchemaFile(source)
+	}
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+	if err != nil {
+		return nil, err
+	}
+	s, err := schema.Introspect(ctx, g, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect schema from %s: %s", source, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph/execlog"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// schemaCheckCmd represents the schema check command
+var schemaCheckCmd = &cobra.Command{
+	Use:   "check <old> <new>",
+	Short: "Checks which clients are affected by the changes between two schemas",
+	Long: `Compares two schemas like the diff command does, and finds the clients
+affected by each change in the execution logs written by the proxy. Breaking
+and dangerous changes affecting no client over the checked period are
+downgraded to safe.
+
+The command exits with a non-zero status when breaking changes affecting
+clients are found.`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("schema.check.timeout"))
+		defer cancel()
+		oldSchema, err := loadSchema(ctx, args[0], nil)
+		if err != nil {
+			return err
+		}
+		newSchema, err := loadSchema(ctx, args[1], nil)
+		if err != nil {
+			return err
+		}
+
+		paths := viper.GetStringSlice("schema.check.execlog")
+		if len(paths) == 0 {
+			return fmt.Errorf("missing execution log files")
+		}
+		since := time.Now().AddDate(0, 0, -viper.GetInt("schema.check.days"))
+		report := schema.NewUsageReport()
+		for _, path := range paths {
+			if err := readUsage(report, oldSchema, path, since); err != nil {
+				return err
+			}
+		}
+
+		checked := schema.CheckChanges(schema.Diff(oldSchema, newSchema), report)
+		if err := writeCheckedChanges(cmd, checked, viper.GetString("schema.check.format")); err != nil {
+			return err
+		}
+		for _, c := range checked {
+			if c.Criticality == schema.CriticalityBreaking {
+				return errBreakingChanges
+			}
+		}
+		return nil
+	},
+}
+
+// readUsage adds the usage of the schema by the operations of the execution
+// log file executed since the given time to the report.
+func readUsage(report *schema.UsageReport, s schema.Schema, path string, since time.Time) error {
+	f, err := execlog.OpenEntryFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// clients send the same operations over and over, the operations of a
+	// query are told apart by their name
+	usages := map[string][]string{}
+	r := execlog.NewEntryReader(f)
+	for {
+		entry, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read execution log %s: %s", path, err)
+		}
+		if entry.Request == nil || entry.StartTime.Before(since) {
+			continue
+		}
+		key := entry.Request.OperationName + "\x00" + entry.Request.Query
+		coords, ok := usages[key]
+		if !ok || len(entry.Request.Variables) > 0 {
+			doc, err := entry.Request.ParseQuery()
+			if err != nil {
+				continue
+			}
+			coords, err = schema.ExtractUsage(s, doc, entry.Request.OperationName, entry.Request.Variables)
+			if err != nil {
+				continue
+			}
+			if len(entry.Request.Variables) == 0 {
+				usages[key] = coords
+			}
+		}
+		report.Add(coords, entry.ClientName, entry.ClientVersion, entry.StartTime)
+	}
+}
+
+// writeCheckedChanges writes the checked schema changes to the command output
+// in the given format, text or json.
+func writeCheckedChanges(cmd *cobra.Command, checked []*schema.CheckedChange, format string) error {
+	out := cmd.OutOrStdout()
+	switch format {
+	case "text":
+		if len(checked) == 0 {
+			fmt.Fprintln(out, "No changes")
+			return nil
+		}
+		for _, c := range checked {
+			crit := string(c.Criticality)
+			if c.OriginalCriticality != "" {
+				crit = fmt.Sprintf("%s (%s, unused)", c.Criticality, c.OriginalCriticality)
+			}
+			fmt.Fprintf(out, "%-9s  %s  %s\n", crit, c.Path, c.Message)
+			if len(c.Clients) == 0 || c.Change.Criticality == schema.CriticalitySafe {
+				continue
+			}
+			clients := make([]string, len(c.Clients))
+			for i, u := range c.Clients {
+				name := u.ClientName
+				if name == "" {
+					name = "unknown client"
+				}
+				if u.ClientVersion != "" {
+					name += " " + u.ClientVersion
+				}
+				clients[i] = fmt.Sprintf("%s (%d operations, last on %s)", name, u.Count, u.LastSeen.Format("2006-01-02"))
+			}
+			fmt.Fprintf(out, "           used by %s\n", strings.Join(clients, ", "))
+		}
+		return nil
+	case "json":
+		if checked == nil {
+			checked = []*schema.CheckedChange{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(checked)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	schemaCmd.AddCommand(schemaCheckCmd)
+
+	schemaCheckCmd.Flags().StringSlice("execlog", nil, "Execution log file written by the proxy, gzipped or not, can be repeated")
+	schemaCheckCmd.Flags().Int("days", 30, "Number of days of execution logs to check")
+	schemaCheckCmd.Flags().StringP("format", "f", "text", "Format to write the changes in (text or json)")
+	schemaCheckCmd.Flags().Duration("timeout", 30*time.Second, "Timeout of the introspection of schemas fetched from URLs")
+
+	viper.BindPFlag("schema.check.execlog", schemaCheckCmd.Flags().Lookup("execlog"))
+	viper.BindPFlag("schema.check.days", schemaCheckCmd.Flags().Lookup("days"))
+	viper.BindPFlag("schema.check.format", schemaCheckCmd.Flags().Lookup("format"))
+	viper.BindPFlag("schema.check.timeout", schemaCheckCmd.Flags().Lookup("timeout"))
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/schema"
+)
+
+// errBreakingChanges makes the commands reporting schema changes exit with a
+// non-zero status, without writing anything after their report.
+var errBreakingChanges = errors.New("breaking changes found")
+
+// schemaDiffCmd represents the schema diff command
+var schemaDiffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Compares two schemas and reports breaking changes",
+	Long: `Compares two schemas and reports the changes between them, classified as
+breaking, dangerous or safe. Schemas are read from SDL files (.graphql or .gql),
+introspection result files (.json) or fetched from GraphQL service URLs.
+
+The command exits with a non-zero status when breaking changes are found.`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("schema.diff.timeout"))
+		defer cancel()
+		oldSchema, err := loadSchema(ctx, args[0], nil)
+		if err != nil {
+			return err
+		}
+		newSchema, err := loadSchema(ctx, args[1], nil)
+		if err != nil {
+			return err
+		}
+
+		changes := schema.Diff(oldSchema, newSchema)
+		if err := writeChanges(cmd, changes, viper.GetString("schema.diff.format")); err != nil {
+			return err
+		}
+		if schema.HasBreakingChanges(changes) {
+			return errBreakingChanges
+		}
+		return nil
+	},
+}
+
+// writeChanges writes the schema changes to the command output in the given
+// format, text or json.
+func writeChanges(cmd *cobra.Command, changes []*schema.Change, format string) error {
+	out := cmd.OutOrStdout()
+	switch format {
+	case "text":
+		if len(changes) == 0 {
+			fmt.Fprintln(out, "No changes")
+			return nil
+		}
+		for _, c := range changes {
+			fmt.Fprintf(out, "%-9s  %s  %s\n", c.Criticality, c.Path, c.Message)
+		}
+		return nil
+	case "json":
+		if changes == nil {
+			changes = []*schema.Change{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	schemaCmd.AddCommand(schemaDiffCmd)
+
+	schemaDiffCmd.Flags().StringP("format", "f", "text", "Format to write the changes in (text or json)")
+	schemaDiffCmd.Flags().Duration("timeout", 30*time.Second, "Timeout of the introspection of schemas fetched from URLs")
+
+	viper.BindPFlag("schema.diff.format", schemaDiffCmd.Flags().Lookup("format"))
+	viper.BindPFlag("schema.diff.timeout", schemaDiffCmd.Flags().Lookup("timeout"))
+}
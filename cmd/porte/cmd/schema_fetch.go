@@ -0,0 +1,121 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/proxy"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// schemaFetchCmd represents the schema fetch command
+var schemaFetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Fetches the schema of a GraphQL service using introspection",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := viper.GetString("schema.fetch.format")
+		if format != "sdl" && format != "json" {
+			return fmt.Errorf("unknown format %q", format)
+		}
+		graphURL, err := url.Parse(viper.GetString("schema.fetch.graph-url"))
+		if err != nil {
+			return err
+		}
+		if graphURL.String() == "" {
+			return fmt.Errorf("missing graph URL")
+		}
+		g, err := graph.NewGraph(&graph.GraphConfig{
+			ServiceURL: graphURL,
+		})
+		if err != nil {
+			return err
+		}
+
+		head := make(http.Header)
+		for _, h := range viper.GetStringSlice("schema.fetch.header") {
+			kv := strings.SplitN(h, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+			}
+			head.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+		transport := proxy.SendGraphRequest(func(req *http.Request) (*http.Response, error) {
+			for k, v := range head {
+				req.Header[k] = v
+			}
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("schema.fetch.timeout"))
+		defer cancel()
+		cfg, err := schema.IntrospectConfig(ctx, g, transport)
+		if err != nil {
+			return err
+		}
+		// make sure the fetched schema is valid before writing it
+		s, err := schema.NewSchema(cfg)
+		if err != nil {
+			return err
+		}
+
+		out := os.Stdout
+		if o := viper.GetString("schema.fetch.output"); o != "" && o != "-" {
+			f, err := os.Create(o)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		if format == "sdl" {
+			_, err = fmt.Fprint(out, schema.PrintSDL(s))
+			return err
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(&schema.IntrospectionResult{Schema: cfg})
+	},
+}
+
+func init() {
+	schemaCmd.AddCommand(schemaFetchCmd)
+
+	schemaFetchCmd.Flags().String("graph-url", "", "URL of the GraphQL service")
+	schemaFetchCmd.Flags().StringP("output", "o", "-", "File to write the schema to (- for stdout)")
+	schemaFetchCmd.Flags().StringP("format", "f", "json", "Format to write the schema in (json or sdl)")
+	schemaFetchCmd.Flags().StringSlice("header", nil, "Header to send to the GraphQL service, as \"Name: value\"")
+	schemaFetchCmd.Flags().Duration("timeout", 30*time.Second, "Timeout of the introspection query")
+
+	viper.BindPFlag("schema.fetch.graph-url", schemaFetchCmd.Flags().Lookup("graph-url"))
+	viper.BindPFlag("schema.fetch.output", schemaFetchCmd.Flags().Lookup("output"))
+	viper.BindPFlag("schema.fetch.format", schemaFetchCmd.Flags().Lookup("format"))
+	viper.BindPFlag("schema.fetch.header", schemaFetchCmd.Flags().Lookup("header"))
+	viper.BindPFlag("schema.fetch.timeout", schemaFetchCmd.Flags().Lookup("timeout"))
+}
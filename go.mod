@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/google/uuid v1.1.1
+	github.com/gorilla/websocket v1.4.1
 	github.com/graphql-go/graphql v0.7.8
 	github.com/graphql-go/handler v0.2.3
 	github.com/mitchellh/go-homedir v1.1.0
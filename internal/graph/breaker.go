@@ -0,0 +1,168 @@
+package graph
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when a graph request is not sent because the
+// circuit breaker of the graph is open.
+var ErrCircuitOpen = errors.New("graphql service circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets the requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a few trial requests through, closing once they
+	// succeeded.
+	CircuitHalfOpen
+	// CircuitOpen rejects the requests.
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests opening
+	// the breaker, it is 5 by default.
+	FailureThreshold int
+	// OpenTimeout is the time the breaker stays open before letting trial
+	// requests through, it is 30 seconds by default.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests let through while
+	// half-open, all of them must succeed to close the breaker. It is 1 by
+	// default.
+	HalfOpenRequests int
+}
+
+// CircuitBreaker stops sending requests to a failing GraphQL service for a
+// while, rather than making every client wait for it to fail.
+type CircuitBreaker struct {
+	cfg CircuitBreakerConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// trials is the number of trial requests let through while half-open,
+	// successes the number of them that succeeded
+	trials     int
+	successes  int
+	rejections uint64
+}
+
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpenAfterTimeout()
+	return b.state
+}
+
+// Rejections returns the number of requests rejected by the breaker.
+func (b *CircuitBreaker) Rejections() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rejections
+}
+
+func (b *CircuitBreaker) halfOpenAfterTimeout() {
+	if b.state == CircuitOpen && !b.now().Before(b.openedAt.Add(b.cfg.OpenTimeout)) {
+		b.state = CircuitHalfOpen
+		b.trials = 0
+		b.successes = 0
+	}
+}
+
+// allow returns ErrCircuitOpen when the request must not be sent, otherwise
+// the request outcome must be recorded.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpenAfterTimeout()
+	switch b.state {
+	case CircuitOpen:
+		b.rejections++
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.trials >= b.cfg.HalfOpenRequests {
+			b.rejections++
+			return ErrCircuitOpen
+		}
+		b.trials++
+	}
+	return nil
+}
+
+// outcome is the outcome of a request let through by a breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored requests, canceled by their client, say nothing of the
+	// service
+	outcomeIgnored
+)
+
+func (b *CircuitBreaker) record(o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitClosed:
+		switch o {
+		case outcomeSuccess:
+			b.failures = 0
+		case outcomeFailure:
+			b.failures++
+			if b.failures >= b.cfg.FailureThreshold {
+				b.open()
+			}
+		}
+	case CircuitHalfOpen:
+		switch o {
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenRequests {
+				b.state = CircuitClosed
+				b.failures = 0
+			}
+		case outcomeFailure:
+			b.open()
+		case outcomeIgnored:
+			b.trials--
+		}
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = CircuitOpen
+	b.openedAt = b.now()
+	b.failures = 0
+}
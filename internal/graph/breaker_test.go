@@ -0,0 +1,59 @@
+package graph
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		HalfOpenRequests: 2,
+	})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	expect := func(state CircuitState, allowed bool) {
+		t.Helper()
+		if s := b.State(); s != state {
+			t.Fatalf("got state %s, expected %s", s, state)
+		}
+		if err := b.allow(); (err == nil) != allowed {
+			t.Fatalf("got %v while %s", err, state)
+		}
+	}
+
+	// a success resets the consecutive failures
+	expect(CircuitClosed, true)
+	b.record(outcomeFailure)
+	expect(CircuitClosed, true)
+	b.record(outcomeSuccess)
+	expect(CircuitClosed, true)
+	b.record(outcomeFailure)
+	expect(CircuitClosed, true)
+	b.record(outcomeFailure)
+	expect(CircuitOpen, false)
+
+	// a failed trial opens the breaker again
+	now = now.Add(time.Minute)
+	expect(CircuitHalfOpen, true)
+	b.record(outcomeFailure)
+	expect(CircuitOpen, false)
+
+	// canceled trials are let through again, and the breaker closes once
+	// all of them succeeded
+	now = now.Add(time.Minute)
+	expect(CircuitHalfOpen, true)
+	expect(CircuitHalfOpen, true)
+	expect(CircuitHalfOpen, false)
+	b.record(outcomeIgnored)
+	b.record(outcomeSuccess)
+	expect(CircuitHalfOpen, true)
+	b.record(outcomeSuccess)
+	expect(CircuitClosed, true)
+
+	if n := b.Rejections(); n != 3 {
+		t.Errorf("got %d rejections, expected 3", n)
+	}
+}
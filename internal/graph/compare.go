@@ -0,0 +1,137 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Difference is a value differing between two graph responses.
+type Difference struct {
+	// Path is the dotted path of the value in the response, list items are
+	// designated by their index: data.hero.friends.0.name.
+	Path     string      `json:"path"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+}
+
+func (d *Difference) String() string {
+	expected, _ := json.Marshal(d.Expected)
+	actual, _ := json.Marshal(d.Actual)
+	return fmt.Sprintf("%s: expected %s, got %s", d.Path, expected, actual)
+}
+
+// CompareResponses returns the differences between the expected and actual
+// responses, as they are encoded in JSON. The values at or below the ignored
+// paths are not compared, a * in an ignored path matches any key or index:
+// extensions or data.reviews.*.createdAt.
+func CompareResponses(expected, actual *Response, ignore []string) ([]*Difference, error) {
+	e, err := normalizeResponse(expected)
+	if err != nil {
+		return nil, err
+	}
+	a, err := normalizeResponse(actual)
+	if err != nil {
+		return nil, err
+	}
+	c := &comparer{ignore: make([][]string, len(ignore))}
+	for i, path := range ignore {
+		c.ignore[i] = strings.Split(path, ".")
+	}
+	c.compare(nil, e, a)
+	return c.diffs, nil
+}
+
+// normalizeResponse returns the response as decoded from its JSON encoding.
+func normalizeResponse(res *Response) (interface{}, error) {
+	if res == nil {
+		return map[string]interface{}{}, nil
+	}
+	bdy, err := json.Marshal(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode graph response: %s", err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(bdy, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode graph response: %s", err)
+	}
+	return v, nil
+}
+
+type comparer struct {
+	ignore [][]string
+	diffs  []*Difference
+}
+
+func (c *comparer) compare(path []string, expected, actual interface{}) {
+	if c.ignored(path) {
+		return
+	}
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(e)+len(a))
+		for k := range e {
+			keys = append(keys, k)
+		}
+		for k := range a {
+			if _, ok := e[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c.compare(append(path[:len(path):len(path)], k), e[k], a[k])
+		}
+		return
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(e) || i < len(a); i++ {
+			var ev, av interface{}
+			if i < len(e) {
+				ev = e[i]
+			}
+			if i < len(a) {
+				av = a[i]
+			}
+			c.compare(append(path[:len(path):len(path)], strconv.Itoa(i)), ev, av)
+		}
+		return
+	default:
+		if expected == actual {
+			return
+		}
+	}
+	c.diffs = append(c.diffs, &Difference{
+		Path:     strings.Join(path, "."),
+		Expected: expected,
+		Actual:   actual,
+	})
+}
+
+func (c *comparer) ignored(path []string) bool {
+	for _, ignore := range c.ignore {
+		if len(ignore) > len(path) {
+			continue
+		}
+		matched := true
+		for i, seg := range ignore {
+			if seg != "*" && seg != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
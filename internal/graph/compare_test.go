@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompareResponses(t *testing.T) {
+	expected := &Response{
+		Data: map[string]interface{}{
+			"hero": map[string]interface{}{
+				"name":    "R2-D2",
+				"friends": []interface{}{map[string]interface{}{"name": "Luke", "id": "1"}},
+			},
+			"reviews": []interface{}{
+				map[string]interface{}{"stars": 5, "createdAt": "2019-01-02"},
+			},
+		},
+		Extensions: map[string]interface{}{"tracing": 12},
+	}
+	var actual *Response
+	json.Unmarshal([]byte(`{
+  "data": {
+    "hero": {"name": "R2-D2", "friends": [{"name": "Han", "id": "1"}, {"name": "Leia", "id": "2"}]},
+    "reviews": [{"stars": 5, "createdAt": "2020-05-06"}]
+  },
+  "errors": [{"message": "droid not found"}],
+  "extensions": {"tracing": 34}
+}`), &actual)
+
+	tests := []struct {
+		name   string
+		ignore []string
+		want   []string
+	}{
+		{
+			name: "every value",
+			want: []string{
+				`data.hero.friends.0.name: expected "Luke", got "Han"`,
+				`data.hero.friends.1: expected null, got {"id":"2","name":"Leia"}`,
+				`data.reviews.0.createdAt: expected "2019-01-02", got "2020-05-06"`,
+				`errors: expected null, got [{"message":"droid not found"}]`,
+				`extensions.tracing: expected 12, got 34`,
+			},
+		},
+		{
+			name:   "ignored paths",
+			ignore: []string{"extensions", "errors", "data.reviews.*.createdAt", "data.hero.friends.*.name"},
+			want: []string{
+				`data.hero.friends.1: expected null, got {"id":"2","name":"Leia"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := CompareResponses(expected, actual, tt.ignore)
+			if err != nil {
+				t.Fatalf("CompareResponses() returned error: %s", err)
+			}
+			got := make([]string, len(diffs))
+			for i, d := range diffs {
+				got[i] = d.String()
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("CompareResponses() = %q, expected %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("CompareResponses() = %q, expected %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	if diffs, _ := CompareResponses(expected, expected, nil); len(diffs) != 0 {
+		t.Errorf("CompareResponses() of the same response = %v, expected no differences", diffs)
+	}
+}
@@ -2,6 +2,7 @@ package debug
 
 import (
 	"context"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 
@@ -27,16 +28,29 @@ func NewProxyPlugin() (*proxy.Plugin, error) {
 		},
 		SendGraphRequest: func(next http.RoundTripper) http.RoundTripper {
 			return proxy.SendGraphRequest(func(req *http.Request) (*http.Response, error) {
-				ds := req.Context().Value(debugState{}).(*debugState)
+				// a batch of operations shares the same graph request
+				states := make([]*debugState, 0)
+				for _, ctx := range proxy.GetExecContexts(req.Context()) {
+					states = append(states, ctx.Value(debugState{}).(*debugState))
+				}
 				reqDump, _ := httputil.DumpRequest(req, true)
-				ds.GraphReq = string(reqDump)
+				for _, ds := range states {
+					ds.GraphReq = string(reqDump)
+				}
 				res, err := next.RoundTrip(req)
+				var resDump []byte
 				if res != nil {
-					resDump, _ := httputil.DumpResponse(res, true)
-					ds.GraphRes = string(resDump)
+					// dumping the body of an incremental delivery would wait for
+					// the graph to deliver it entirely
+					mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+					streamed := mediaType == graph.MediaTypeEventStream || mediaType == graph.MediaTypeMultipart
+					resDump, _ = httputil.DumpResponse(res, !streamed)
 				}
-				if err != nil {
-					ds.GraphErr = err.Error()
+				for _, ds := range states {
+					ds.GraphRes = string(resDump)
+					if err != nil {
+						ds.GraphErr = err.Error()
+					}
 				}
 				return res, err
 			})
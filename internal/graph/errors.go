@@ -0,0 +1,207 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+// Codes of the errors of the graph requests failing to execute, sent to the
+// clients as the code extension of the errors.
+const (
+	// ErrorCodeServiceUnreachable is the code of the requests that could
+	// not reach the service: its host could not be resolved, or connected
+	// to.
+	ErrorCodeServiceUnreachable = "SERVICE_UNREACHABLE"
+	// ErrorCodeServiceUnavailable is the code of the requests whose
+	// connection to the service failed.
+	ErrorCodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+	// ErrorCodeServiceTimeout is the code of the requests the service did
+	// not respond to in time.
+	ErrorCodeServiceTimeout = "SERVICE_TIMEOUT"
+	// ErrorCodeRequestCanceled is the code of the requests canceled by their
+	// client.
+	ErrorCodeRequestCanceled = "REQUEST_CANCELED"
+	// ErrorCodeServiceHTTPError is the code of the requests the service
+	// responded to with a status other than 200.
+	ErrorCodeServiceHTTPError = "SERVICE_HTTP_ERROR"
+	// ErrorCodeServiceInvalidResponse is the code of the requests the
+	// service responded to with something else than a GraphQL response.
+	ErrorCodeServiceInvalidResponse = "SERVICE_INVALID_RESPONSE"
+	// ErrorCodeCircuitOpen is the code of the requests rejected by the
+	// circuit breaker of the graph.
+	ErrorCodeCircuitOpen = "CIRCUIT_OPEN"
+)
+
+// maxErrorBodySize is the number of bytes of a non-200 response body kept in
+// its ServiceError.
+const maxErrorBodySize = 1 << 20
+
+// ServiceError is returned when a graph request fails to execute on the
+// GraphQL service.
+type ServiceError struct {
+	// Code is one of the ErrorCode* constants.
+	Code string
+	// StatusCode, Header and Body are the status, the header and the
+	// beginning of the body of the response when it has a status other than
+	// 200.
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Err        error
+}
+
+func (e *ServiceError) Error() string {
+	switch e.Code {
+	case ErrorCodeServiceHTTPError:
+		return fmt.Sprintf("graphql service responded with error: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	case ErrorCodeServiceInvalidResponse:
+		return fmt.Sprintf("failed to decode graphql service response: %s", e.Err)
+	case ErrorCodeRequestCanceled:
+		return fmt.Sprintf("graphql service request canceled: %s", e.Err)
+	case ErrorCodeServiceTimeout:
+		return fmt.Sprintf("graphql service timed out: %s", e.Err)
+	default:
+		return fmt.Sprintf("%s: %s", ErrGraphQLServiceNotAvailable, e.Err)
+	}
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes the errors of the requests that did not reach the service, or
+// that it did not respond to in time, match ErrGraphQLServiceNotAvailable.
+func (e *ServiceError) Is(target error) bool {
+	if target != ErrGraphQLServiceNotAvailable {
+		return false
+	}
+	switch e.Code {
+	case ErrorCodeServiceUnreachable, ErrorCodeServiceUnavailable, ErrorCodeServiceTimeout:
+		return true
+	}
+	return false
+}
+
+// ErrorCode returns the code of the error of a graph request, or an empty
+// string when it has none.
+func ErrorCode(err error) string {
+	var serviceErr *ServiceError
+	switch {
+	case errors.As(err, &serviceErr):
+		return serviceErr.Code
+	case errors.Is(err, ErrCircuitOpen):
+		return ErrorCodeCircuitOpen
+	}
+	return ""
+}
+
+// ErrorExtensions returns the extensions of the error sent to the client of
+// a graph request that failed with err: its code, along with the status of
+// the service response for ErrorCodeServiceHTTPError. It returns nil when the
+// error has no code.
+func ErrorExtensions(err error) map[string]interface{} {
+	code := ErrorCode(err)
+	if code == "" {
+		return nil
+	}
+	extensions := map[string]interface{}{"code": code}
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.StatusCode != 0 {
+		extensions["status"] = serviceErr.StatusCode
+	}
+	return extensions
+}
+
+// newTransportError returns the error of a request to the GraphQL service
+// that failed to get a response, sent with the given context.
+func newTransportError(ctx context.Context, err error) *ServiceError {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case ctx.Err() == context.Canceled:
+		return &ServiceError{Code: ErrorCodeRequestCanceled, Err: err}
+	case errors.As(err, &dnsErr):
+		return &ServiceError{Code: ErrorCodeServiceUnreachable, Err: err}
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return &ServiceError{Code: ErrorCodeServiceUnreachable, Err: err}
+	case ctx.Err() == context.DeadlineExceeded,
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return &ServiceError{Code: ErrorCodeServiceTimeout, Err: err}
+	}
+	return &ServiceError{Code: ErrorCodeServiceUnavailable, Err: err}
+}
+
+// newStatusError returns the error of a response of the GraphQL service with
+// a status other than 200, reading the beginning of its body.
+func newStatusError(httpRes *http.Response) *ServiceError {
+	body, _ := ioutil.ReadAll(io.LimitReader(httpRes.Body, maxErrorBodySize))
+	return &ServiceError{
+		Code:       ErrorCodeServiceHTTPError,
+		StatusCode: httpRes.StatusCode,
+		Header:     httpRes.Header,
+		Body:       body,
+		Err:        errors.New(httpRes.Status),
+	}
+}
+
+// statusErrorResponse returns the GraphQL response in the body of the
+// response of the service with a status other than 200 err was returned for,
+// or nil when there is none.
+func statusErrorResponse(err error) *Response {
+	var serviceErr *ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.Code != ErrorCodeServiceHTTPError {
+		return nil
+	}
+	gqlRes := new(Response)
+	if json.Unmarshal(serviceErr.Body, gqlRes) != nil || gqlRes.Data == nil && len(gqlRes.Errors) == 0 {
+		return nil
+	}
+	gqlRes.StatusCode = serviceErr.StatusCode
+	gqlRes.Header = serviceErr.Header
+	return gqlRes
+}
+
+// statusErrorResponses returns the GraphQL responses to a batch of n
+// requests in the body of the response of the service with a status other
+// than 200 err was returned for, or nil when there are none.
+func statusErrorResponses(err error, n int) []*Response {
+	var serviceErr *ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.Code != ErrorCodeServiceHTTPError {
+		return nil
+	}
+	var gqlRes []*Response
+	if json.Unmarshal(serviceErr.Body, &gqlRes) != nil || len(gqlRes) != n {
+		return nil
+	}
+	for _, r := range gqlRes {
+		if r == nil || r.Data == nil && len(r.Errors) == 0 {
+			return nil
+		}
+		r.StatusCode = serviceErr.StatusCode
+		r.Header = serviceErr.Header
+	}
+	return gqlRes
+}
+
+// newDecodeError returns the error of a response of the GraphQL service that
+// failed to decode, read with the given context.
+func newDecodeError(ctx context.Context, err error) *ServiceError {
+	var netErr net.Error
+	switch {
+	case ctx.Err() == context.Canceled:
+		return &ServiceError{Code: ErrorCodeRequestCanceled, Err: err}
+	case ctx.Err() == context.DeadlineExceeded,
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return &ServiceError{Code: ErrorCodeServiceTimeout, Err: err}
+	}
+	return &ServiceError{Code: ErrorCodeServiceInvalidResponse, Err: err}
+}
@@ -0,0 +1,214 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGraph_Execute_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		// handler is the upstream handler, the upstream is closed before the
+		// request when it is nil
+		handler    http.HandlerFunc
+		serviceURL string
+		cfg        GraphConfig
+		transport  http.RoundTripper
+		// cancel cancels the request after the given time
+		cancel           time.Duration
+		wantCode         string
+		wantStatus       int
+		wantBody         string
+		wantNotAvailable bool
+	}{
+		{
+			name:             "connection refused",
+			wantCode:         ErrorCodeServiceUnreachable,
+			wantNotAvailable: true,
+		},
+		{
+			name:             "unknown host",
+			serviceURL:       "http://porte.invalid/graphql",
+			wantCode:         ErrorCodeServiceUnreachable,
+			wantNotAvailable: true,
+		},
+		{
+			name: "read timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				hang(r)
+			},
+			cfg:              GraphConfig{ReadTimeout: 20 * time.Millisecond},
+			wantCode:         ErrorCodeServiceTimeout,
+			wantNotAvailable: true,
+		},
+		{
+			name: "connect timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+			},
+			cfg: GraphConfig{ConnectTimeout: 20 * time.Millisecond},
+			// the connections never complete
+			transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				},
+			},
+			wantCode:         ErrorCodeServiceUnreachable,
+			wantNotAvailable: true,
+		},
+		{
+			name: "timeout while reading the body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"data":`)
+				w.(http.Flusher).Flush()
+				hang(r)
+			},
+			cfg:              GraphConfig{Timeout: 20 * time.Millisecond},
+			wantCode:         ErrorCodeServiceTimeout,
+			wantNotAvailable: true,
+		},
+		{
+			name: "canceled",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				hang(r)
+			},
+			cancel:   20 * time.Millisecond,
+			wantCode: ErrorCodeRequestCanceled,
+		},
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+				io.WriteString(w, "upstream connect error")
+			},
+			wantCode:   ErrorCodeServiceHTTPError,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "upstream connect error",
+		},
+		{
+			name: "malformed JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `<html>Welcome</html>`)
+			},
+			wantCode: ErrorCodeServiceInvalidResponse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			if tt.handler == nil {
+				srv.Close()
+			}
+			cfg := tt.cfg
+			cfg.ServiceURL, _ = url.Parse(srv.URL)
+			if tt.serviceURL != "" {
+				cfg.ServiceURL, _ = url.Parse(tt.serviceURL)
+			}
+			g, err := NewGraph(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if tt.cancel > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			_, err = g.Execute(ctx, &Request{Query: "{ hero }"}, tt.transport)
+
+			var serviceErr *ServiceError
+			if !errors.As(err, &serviceErr) {
+				t.Fatalf("got error %v, expected a service error", err)
+			}
+			if serviceErr.Code != tt.wantCode || ErrorCode(err) != tt.wantCode {
+				t.Errorf("got code %s (%v), expected %s", serviceErr.Code, err, tt.wantCode)
+			}
+			if serviceErr.StatusCode != tt.wantStatus || string(serviceErr.Body) != tt.wantBody {
+				t.Errorf("got status %d and body %q, expected %d and %q", serviceErr.StatusCode, serviceErr.Body, tt.wantStatus, tt.wantBody)
+			}
+			if errors.Is(err, ErrGraphQLServiceNotAvailable) != tt.wantNotAvailable {
+				t.Errorf("got error %v matching ErrGraphQLServiceNotAvailable: %v", err, !tt.wantNotAvailable)
+			}
+		})
+	}
+}
+
+func TestGraph_Execute_connect_timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	g, err := NewGraph(&GraphConfig{ServiceURL: u, ConnectTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the connect timeout does not bound the response
+	if _, err := g.Execute(context.Background(), &Request{Query: "{ hero }"}, nil); err != nil {
+		t.Errorf("Execute() returned error: %s", err)
+	}
+}
+
+// hang waits for the client to give up on the request.
+func hang(r *http.Request) {
+	// the server notices the client is gone once the body is read
+	ioutil.ReadAll(r.Body)
+	select {
+	case <-time.After(time.Second):
+	case <-r.Context().Done():
+	}
+}
+
+func TestErrorExtensions(t *testing.T) {
+	if ext := ErrorExtensions(errors.New("boom")); ext != nil {
+		t.Errorf("got extensions %v for an error without code", ext)
+	}
+	if ext := ErrorExtensions(ErrCircuitOpen); ext["code"] != ErrorCodeCircuitOpen {
+		t.Errorf("got extensions %v for an open circuit", ext)
+	}
+	ext := ErrorExtensions(&ServiceError{Code: ErrorCodeServiceHTTPError, StatusCode: 503})
+	if ext["code"] != ErrorCodeServiceHTTPError || ext["status"] != 503 {
+		t.Errorf("got extensions %v for an HTTP error", ext)
+	}
+}
+
+func TestGraph_Execute_status_response(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MediaTypeGraphQLResponse)
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"errors":[{"message":"Cannot query field \"droid\" on type \"Query\"."}]}`)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	g, err := NewGraph(&GraphConfig{ServiceURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := g.Execute(context.Background(), &Request{Query: "{ droid }"}, nil)
+	if err != nil {
+		t.Fatalf("Execute() returned error: %s", err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, expected %d", res.StatusCode, http.StatusBadRequest)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != MediaTypeGraphQLResponse {
+		t.Errorf("got content type %s, expected %s", ct, MediaTypeGraphQLResponse)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Message != `Cannot query field "droid" on type "Query".` {
+		t.Errorf("got errors %v", res.Errors)
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 type Request struct {
@@ -14,25 +18,141 @@ type Request struct {
 	Extensions    map[string]interface{} `json:"extensions,omitempty"`
 }
 
-var ErrHTTPMethodNotAllowed = errors.New("HTTP method not allowed for graph request")
+var (
+	ErrHTTPMethodNotAllowed = errors.New("HTTP method not allowed for graph request")
+	ErrUnsupportedMediaType = errors.New("unsupported media type for graph request")
+	ErrOperationNotAllowed  = errors.New("only query operations are allowed over HTTP GET")
+)
+
+// maxMultipartMemory is the maximum number of bytes of a multipart body kept
+// in memory while parsing it.
+const maxMultipartMemory = 32 << 20
 
+// NewRequestFromHTTP reads a graph request from the given HTTP request
+// following the GraphQL over HTTP specification.
+//
+// GET requests carry the graph request in their query string and may only
+// select query operations. POST requests carry it in their body, encoded as
+// application/json, application/graphql, application/x-www-form-urlencoded or
+// multipart/form-data.
 func NewRequestFromHTTP(r *http.Request) (*Request, error) {
-	if r.Method != http.MethodPost {
+	switch r.Method {
+	case http.MethodGet:
+		return newRequestFromHTTPGet(r)
+	case http.MethodPost:
+		return newRequestFromHTTPPost(r)
+	default:
 		return nil, ErrHTTPMethodNotAllowed
 	}
+}
+
+func newRequestFromHTTPGet(r *http.Request) (*Request, error) {
+	gr, err := newRequestFromValues(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	opType, err := gr.OperationType()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse graph request's query: %s", err)
+	}
+	if opType != OperationTypeQuery {
+		return nil, ErrOperationNotAllowed
+	}
+
+	return gr, nil
+}
+
+func newRequestFromHTTPPost(r *http.Request) (*Request, error) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, ErrUnsupportedMediaType
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		gr := new(Request)
+		if err := json.NewDecoder(r.Body).Decode(gr); err != nil {
+			return nil, fmt.Errorf("Failed to decode graph request from HTTP request's body: %s", err)
+		}
+		return gr, nil
+	case "application/graphql":
+		bdy, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read graph request from HTTP request's body: %s", err)
+		}
+		values := r.URL.Query()
+		values.Set("query", string(bdy))
+		return newRequestFromValues(values)
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("Failed to decode graph request from HTTP request's body: %s", err)
+		}
+		return newRequestFromValues(r.PostForm)
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+			return nil, fmt.Errorf("Failed to decode graph request from HTTP request's body: %s", err)
+		}
+		return newRequestFromValues(url.Values(r.MultipartForm.Value))
+	default:
+		return nil, ErrUnsupportedMediaType
+	}
+}
 
-	gr := new(Request)
-	if err := json.NewDecoder(r.Body).Decode(gr); err != nil {
-		return nil, fmt.Errorf("Failed to decode graph request from HTTP request's body: %s", err)
+// newRequestFromValues reads a graph request from URL encoded values, where
+// variables and extensions are JSON encoded.
+func newRequestFromValues(values url.Values) (*Request, error) {
+	gr := &Request{
+		Query:         values.Get("query"),
+		OperationName: values.Get("operationName"),
+	}
+	if gr.Query == "" {
+		return nil, errors.New("Missing graph request's query")
+	}
+	if v := values.Get("variables"); v != "" {
+		if err := json.Unmarshal([]byte(v), &gr.Variables); err != nil {
+			return nil, fmt.Errorf("Failed to decode graph request's variables: %s", err)
+		}
+	}
+	if v := values.Get("extensions"); v != "" {
+		if err := json.Unmarshal([]byte(v), &gr.Extensions); err != nil {
+			return nil, fmt.Errorf("Failed to decode graph request's extensions: %s", err)
+		}
 	}
 
 	return gr, nil
 }
 
+// Media types of the graph responses, application/json being the legacy one
+// of the GraphQL over HTTP specification.
+const (
+	MediaTypeJSON            = "application/json"
+	MediaTypeGraphQLResponse = "application/graphql-response+json"
+)
+
 type Response struct {
 	Data       interface{}            `json:"data,omitempty"`
 	Errors     []*Error               `json:"errors,omitempty"`
 	Extensions map[string]interface{} `json:"extensions,omitempty"`
+
+	// StatusCode is the HTTP status the GraphQL service responded with, zero
+	// when the response does not come from a service. Services may respond
+	// with errors along with a status other than 200.
+	StatusCode int `json:"-"`
+	// Header is the header of the response of the GraphQL service, nil when
+	// the response does not come from a service. The responses to a batch
+	// share it.
+	Header http.Header `json:"-"`
+
+	// incremental delivery fields, only set on the parts of a ResponseStream
+	HasNext     *bool         `json:"hasNext,omitempty"`
+	Incremental []*Response   `json:"incremental,omitempty"`
+	Items       []interface{} `json:"items,omitempty"`
+	Path        []interface{} `json:"path,omitempty"`
+	Label       string        `json:"label,omitempty"`
 }
 
 func (r *Response) SetExtension(key string, val interface{}) {
@@ -43,10 +163,28 @@ func (r *Response) SetExtension(key string, val interface{}) {
 }
 
 type Error struct {
-	Message   string `json:"message"`
-	Locations []*struct {
-		Line   int64 `json:"line"`
-		Column int64 `json:"column"`
-	} `json:"locations,omitempty"`
-	Path []interface{} `json:"path,omitempty"`
+	Message    string                 `json:"message"`
+	Locations  []*Location            `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Location points at a position in the query document of a graph request.
+type Location struct {
+	Line   int64 `json:"line"`
+	Column int64 `json:"column"`
+}
+
+// RequestError is returned when a graph request is rejected before its
+// execution. Its errors are sent back to the client in a graph response.
+type RequestError struct {
+	Errors []*Error
+}
+
+func (e *RequestError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Message
+	}
+	return fmt.Sprintf("invalid graph request: %s", strings.Join(msgs, "; "))
 }
@@ -0,0 +1,136 @@
+package graph
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewRequestFromHTTP(t *testing.T) {
+	multipartBody := new(bytes.Buffer)
+	mw := multipart.NewWriter(multipartBody)
+	mw.WriteField("query", "query Hero($ep: Episode) { hero(episode: $ep) { name } }")
+	mw.WriteField("variables", `{"ep":"JEDI"}`)
+	mw.Close()
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		want    *Request
+		wantErr error
+	}{
+		{
+			name: "POST with a JSON body",
+			req: newTestHTTPRequest(
+				http.MethodPost,
+				"/graphql",
+				"application/json",
+				`{"query":"{ hero { name } }","variables":{"ep":"JEDI"}}`,
+			),
+			want: &Request{
+				Query:     "{ hero { name } }",
+				Variables: map[string]interface{}{"ep": "JEDI"},
+			},
+		},
+		{
+			name: "POST with a JSON body and no content type",
+			req:  newTestHTTPRequest(http.MethodPost, "/graphql", "", `{"query":"{ hero { name } }"}`),
+			want: &Request{Query: "{ hero { name } }"},
+		},
+		{
+			name: "POST with an application/graphql body",
+			req: newTestHTTPRequest(
+				http.MethodPost,
+				"/graphql?operationName=Hero",
+				"application/graphql; charset=utf-8",
+				"query Hero { hero { name } }",
+			),
+			want: &Request{Query: "query Hero { hero { name } }", OperationName: "Hero"},
+		},
+		{
+			name: "POST with a form body",
+			req: newTestHTTPRequest(
+				http.MethodPost,
+				"/graphql",
+				"application/x-www-form-urlencoded",
+				url.Values{"query": {"mutation { createReview { stars } }"}}.Encode(),
+			),
+			want: &Request{Query: "mutation { createReview { stars } }"},
+		},
+		{
+			name: "POST with a multipart body",
+			req: newTestHTTPRequest(
+				http.MethodPost,
+				"/graphql",
+				mw.FormDataContentType(),
+				multipartBody.String(),
+			),
+			want: &Request{
+				Query:     "query Hero($ep: Episode) { hero(episode: $ep) { name } }",
+				Variables: map[string]interface{}{"ep": "JEDI"},
+			},
+		},
+		{
+			name:    "POST with an unsupported media type",
+			req:     newTestHTTPRequest(http.MethodPost, "/graphql", "text/plain", "{ hero { name } }"),
+			wantErr: ErrUnsupportedMediaType,
+		},
+		{
+			name: "GET with a query",
+			req: newTestHTTPRequest(
+				http.MethodGet,
+				"/graphql?"+url.Values{
+					"query":         {"query A { hero { name } } mutation B { createReview { stars } }"},
+					"operationName": {"A"},
+					"variables":     {`{"ep":"JEDI"}`},
+				}.Encode(),
+				"",
+				"",
+			),
+			want: &Request{
+				Query:         "query A { hero { name } } mutation B { createReview { stars } }",
+				OperationName: "A",
+				Variables:     map[string]interface{}{"ep": "JEDI"},
+			},
+		},
+		{
+			name: "GET with a mutation",
+			req: newTestHTTPRequest(
+				http.MethodGet,
+				"/graphql?"+url.Values{"query": {"mutation { createReview { stars } }"}}.Encode(),
+				"",
+				"",
+			),
+			wantErr: ErrOperationNotAllowed,
+		},
+		{
+			name:    "PUT request",
+			req:     newTestHTTPRequest(http.MethodPut, "/graphql", "application/json", `{"query":"{ hero { name } }"}`),
+			wantErr: ErrHTTPMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRequestFromHTTP(tt.req)
+			if err != tt.wantErr {
+				t.Fatalf("NewRequestFromHTTP() error = %v, expected %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewRequestFromHTTP() = %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestHTTPRequest(method, target, contentType, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
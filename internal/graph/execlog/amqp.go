@@ -0,0 +1,397 @@
+package execlog
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// OverflowPolicy defines what a buffered writer does with a new entry when
+// its buffer is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest drops the oldest buffered entry to make room for the
+	// new one.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowBlock blocks the write until there is room for the new entry.
+	OverflowBlock OverflowPolicy = "block"
+)
+
+var ErrWriterClosed = errors.New("execution log writer is closed")
+
+// AMQPConnection is a connection to an AMQP broker, as returned by
+// amqp.Dial.
+type AMQPConnection interface {
+	Channel() (AMQPChannel, error)
+	NotifyClose(chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// AMQPChannel is a channel of an AMQP connection, implemented by
+// *amqp.Channel.
+type AMQPChannel interface {
+	Confirm(noWait bool) error
+	NotifyPublish(chan amqp.Confirmation) chan amqp.Confirmation
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// AMQPDialer opens a connection to the AMQP broker at the given URL.
+type AMQPDialer func(url string) (AMQPConnection, error)
+
+// DialAMQP opens a connection to the AMQP broker at the given URL using the
+// streadway/amqp client.
+func DialAMQP(url string) (AMQPConnection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return &amqpConnection{conn}, nil
+}
+
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c *amqpConnection) Channel() (AMQPChannel, error) {
+	return c.Connection.Channel()
+}
+
+// AMQPEntryWriterConfig defines the configuration of an AMQPEntryWriter.
+type AMQPEntryWriterConfig struct {
+	URL        string
+	Exchange   string
+	RoutingKey string
+
+	// BufferSize is the number of entries kept in memory while they can not
+	// be published, 1000 by default.
+	BufferSize int
+	// OverflowPolicy defines what happens to new entries when the buffer is
+	// full, OverflowDropOldest by default.
+	OverflowPolicy OverflowPolicy
+	// MinBackoff and MaxBackoff bound the delay between reconnection
+	// attempts, the delay doubles after every failed attempt. They are 100ms
+	// and 30s by default.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// ConfirmTimeout is how long the broker has to confirm published entries
+	// before the connection is considered lost, 5s by default.
+	ConfirmTimeout time.Duration
+
+	// Dial opens the connections to the broker, DialAMQP by default.
+	Dial AMQPDialer
+}
+
+// AMQPEntryWriter is an EntryWriter publishing the JSON encoded entries to an
+// AMQP exchange. Entries are buffered in memory and published in the
+// background with publisher confirms, reconnecting to the broker when the
+// connection is lost.
+type AMQPEntryWriter struct {
+	cfg AMQPEntryWriterConfig
+	buf *entryBuffer
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// maxPublishBatch is the maximum number of entries published before waiting
+// for their confirmations.
+const maxPublishBatch = 100
+
+// NewAMQPEntryWriter returns a new AMQPEntryWriter and starts publishing in
+// the background. The writer must be closed to release its resources.
+func NewAMQPEntryWriter(cfg AMQPEntryWriterConfig) (*AMQPEntryWriter, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("missing AMQP URL")
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	switch cfg.OverflowPolicy {
+	case "":
+		cfg.OverflowPolicy = OverflowDropOldest
+	case OverflowDropOldest, OverflowBlock:
+	default:
+		return nil, errors.New("unknown overflow policy " + string(cfg.OverflowPolicy))
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.ConfirmTimeout <= 0 {
+		cfg.ConfirmTimeout = 5 * time.Second
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = DialAMQP
+	}
+
+	w := &AMQPEntryWriter{
+		cfg:  cfg,
+		buf:  newEntryBuffer(cfg.BufferSize, cfg.OverflowPolicy),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write buffers the entry to be published.
+func (w *AMQPEntryWriter) Write(entry *Entry) error {
+	bdy, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return w.buf.push(&amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    entry.ID,
+		Timestamp:    entry.StartTime,
+		Body:         bdy,
+	})
+}
+
+// Dropped returns the number of entries dropped because the buffer was full.
+func (w *AMQPEntryWriter) Dropped() uint64 {
+	return w.buf.droppedCount()
+}
+
+// Close stops accepting entries, publishes the buffered ones if the broker is
+// reachable and closes the connection.
+func (w *AMQPEntryWriter) Close() error {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+		w.buf.close()
+	}
+	<-w.done
+	return nil
+}
+
+func (w *AMQPEntryWriter) run() {
+	defer close(w.done)
+
+	backoff := w.cfg.MinBackoff
+	for {
+		conn, err := w.cfg.Dial(w.cfg.URL)
+		if err == nil {
+			backoff = w.cfg.MinBackoff
+			err = w.publish(conn)
+			conn.Close()
+			if err == nil {
+				return
+			}
+		}
+		log.Println("Failed to publish execution log entries to AMQP:", err.Error())
+
+		select {
+		case <-w.stop:
+			if w.buf.len() > 0 {
+				log.Printf("Dropping %d execution log entries not published to AMQP", w.buf.len())
+			}
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > w.cfg.MaxBackoff {
+			backoff = w.cfg.MaxBackoff
+		}
+	}
+}
+
+// publish publishes the buffered entries until the writer is closed and its
+// buffer drained, or the connection fails.
+func (w *AMQPEntryWriter) publish(conn AMQPConnection) error {
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, maxPublishBatch))
+
+	for {
+		batch := w.buf.take(maxPublishBatch)
+		if len(batch) == 0 {
+			if w.buf.isClosed() {
+				return nil
+			}
+			select {
+			case <-w.buf.ready():
+			case err := <-closed:
+				return connectionError(err)
+			}
+			continue
+		}
+		if err := w.publishBatch(ch, confirms, closed, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// publishBatch publishes the entries and waits for their confirmation. The
+// entries not confirmed are put back in the buffer.
+func (w *AMQPEntryWriter) publishBatch(ch AMQPChannel, confirms chan amqp.Confirmation, closed chan *amqp.Error, batch []*amqp.Publishing) error {
+	for _, msg := range batch {
+		if err := ch.Publish(w.cfg.Exchange, w.cfg.RoutingKey, false, false, *msg); err != nil {
+			w.buf.requeue(batch)
+			return err
+		}
+	}
+
+	nacked := make([]*amqp.Publishing, 0)
+	timeout := time.NewTimer(w.cfg.ConfirmTimeout)
+	defer timeout.Stop()
+	for i := range batch {
+		select {
+		case c, ok := <-confirms:
+			if !ok {
+				w.buf.requeue(append(nacked, batch[i:]...))
+				return errors.New("channel closed while waiting for publish confirmations")
+			}
+			if !c.Ack {
+				nacked = append(nacked, batch[i])
+			}
+		case err := <-closed:
+			w.buf.requeue(append(nacked, batch[i:]...))
+			return connectionError(err)
+		case <-timeout.C:
+			w.buf.requeue(append(nacked, batch[i:]...))
+			return errors.New("timed out waiting for publish confirmations")
+		}
+	}
+	// the broker refused some entries, they are published again with the
+	// next batch
+	w.buf.requeue(nacked)
+	return nil
+}
+
+func connectionError(err *amqp.Error) error {
+	if err == nil {
+		return errors.New("connection closed")
+	}
+	return err
+}
+
+// entryBuffer is a bounded FIFO of messages waiting to be published.
+type entryBuffer struct {
+	mu      sync.Mutex
+	space   *sync.Cond
+	msgs    []*amqp.Publishing
+	size    int
+	policy  OverflowPolicy
+	dropped uint64
+	closed  bool
+	notify  chan struct{}
+}
+
+func newEntryBuffer(size int, policy OverflowPolicy) *entryBuffer {
+	b := &entryBuffer{
+		size:   size,
+		policy: policy,
+		notify: make(chan struct{}, 1),
+	}
+	b.space = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *entryBuffer) push(msg *amqp.Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.policy == OverflowBlock && len(b.msgs) >= b.size && !b.closed {
+		b.space.Wait()
+	}
+	if b.closed {
+		return ErrWriterClosed
+	}
+	if len(b.msgs) >= b.size {
+		b.msgs = b.msgs[1:]
+		b.dropped++
+	}
+	b.msgs = append(b.msgs, msg)
+	b.signal()
+	return nil
+}
+
+// take removes and returns up to n messages from the front of the buffer.
+func (b *entryBuffer) take(n int) []*amqp.Publishing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > len(b.msgs) {
+		n = len(b.msgs)
+	}
+	msgs := make([]*amqp.Publishing, n)
+	copy(msgs, b.msgs)
+	b.msgs = b.msgs[n:]
+	if len(b.msgs) > 0 {
+		b.signal()
+	}
+	b.space.Broadcast()
+	return msgs
+}
+
+// requeue puts back messages taken from the buffer at its front. With the
+// OverflowDropOldest policy, the oldest messages are dropped if the buffer
+// overflows.
+func (b *entryBuffer) requeue(msgs []*amqp.Publishing) {
+	if len(msgs) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.msgs = append(append([]*amqp.Publishing{}, msgs...), b.msgs...)
+	if over := len(b.msgs) - b.size; b.policy == OverflowDropOldest && over > 0 {
+		b.msgs = b.msgs[over:]
+		b.dropped += uint64(over)
+	}
+	b.signal()
+}
+
+// ready returns a channel receiving a value when messages are available or
+// the buffer is closed.
+func (b *entryBuffer) ready() <-chan struct{} {
+	return b.notify
+}
+
+func (b *entryBuffer) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *entryBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.space.Broadcast()
+	b.signal()
+}
+
+func (b *entryBuffer) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *entryBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.msgs)
+}
+
+func (b *entryBuffer) droppedCount() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
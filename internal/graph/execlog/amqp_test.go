@@ -0,0 +1,282 @@
+package execlog
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fakeBroker is an in-memory stand-in for an AMQP broker.
+type fakeBroker struct {
+	mu        sync.Mutex
+	down      bool
+	nackNext  int
+	published []amqp.Publishing
+	exchanges []string
+	conn      *fakeConnection
+}
+
+func (b *fakeBroker) dial(url string) (AMQPConnection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.down {
+		return nil, errors.New("connection refused")
+	}
+	b.conn = &fakeConnection{broker: b}
+	return b.conn, nil
+}
+
+// drop closes the current connection and refuses new ones until restart.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	b.down = true
+	conn := b.conn
+	b.conn = nil
+	b.mu.Unlock()
+	if conn != nil {
+		conn.closeWithError(&amqp.Error{Code: amqp.ConnectionForced, Reason: "broker shutdown"})
+	}
+}
+
+func (b *fakeBroker) restart() {
+	b.mu.Lock()
+	b.down = false
+	b.mu.Unlock()
+}
+
+func (b *fakeBroker) ids() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]string, len(b.published))
+	for i, p := range b.published {
+		ids[i] = p.MessageId
+	}
+	return ids
+}
+
+type fakeConnection struct {
+	broker *fakeBroker
+	mu     sync.Mutex
+	closed bool
+	notify []chan *amqp.Error
+	ch     *fakeChannel
+}
+
+func (c *fakeConnection) Channel() (AMQPChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	c.ch = &fakeChannel{conn: c}
+	return c.ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(ch chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = append(c.notify, ch)
+	return ch
+}
+
+func (c *fakeConnection) Close() error {
+	c.closeWithError(nil)
+	return nil
+}
+
+func (c *fakeConnection) closeWithError(err *amqp.Error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, n := range c.notify {
+		if err != nil {
+			n <- err
+		}
+		close(n)
+	}
+}
+
+type fakeChannel struct {
+	conn     *fakeConnection
+	confirms chan amqp.Confirmation
+	tag      uint64
+}
+
+func (ch *fakeChannel) Confirm(noWait bool) error { return nil }
+
+func (ch *fakeChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.confirms = c
+	return c
+}
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.conn.mu.Lock()
+	closed := ch.conn.closed
+	ch.conn.mu.Unlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+
+	b := ch.conn.broker
+	b.mu.Lock()
+	ack := b.nackNext == 0
+	if ack {
+		b.published = append(b.published, msg)
+		b.exchanges = append(b.exchanges, exchange+"/"+key)
+	} else {
+		b.nackNext--
+	}
+	b.mu.Unlock()
+
+	ch.tag++
+	ch.confirms <- amqp.Confirmation{DeliveryTag: ch.tag, Ack: ack}
+	return nil
+}
+
+func (ch *fakeChannel) Close() error { return nil }
+
+func newTestAMQPEntryWriter(t *testing.T, b *fakeBroker, cfg AMQPEntryWriterConfig) *AMQPEntryWriter {
+	cfg.URL = "amqp://fake"
+	cfg.Exchange = "execlog"
+	cfg.RoutingKey = "entries"
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	cfg.Dial = b.dial
+	w, err := NewAMQPEntryWriter(cfg)
+	if err != nil {
+		t.Fatalf("NewAMQPEntryWriter() returned error: %s", err)
+	}
+	return w
+}
+
+func waitPublished(t *testing.T, b *fakeBroker, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(b.ids()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries published, expected %d", len(b.ids()), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func checkIDs(t *testing.T, actual []string, expected ...string) {
+	if len(actual) != len(expected) {
+		t.Fatalf("published entries are %v, expected %v", actual, expected)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatalf("published entries are %v, expected %v", actual, expected)
+		}
+	}
+}
+
+func TestAMQPEntryWriter(t *testing.T) {
+	b := &fakeBroker{}
+	w := newTestAMQPEntryWriter(t, b, AMQPEntryWriterConfig{})
+	for _, id := range []string{"a", "b", "c"} {
+		if err := w.Write(&Entry{ID: id}); err != nil {
+			t.Fatalf("Write() returned error: %s", err)
+		}
+	}
+	waitPublished(t, b, 3)
+	w.Close()
+
+	checkIDs(t, b.ids(), "a", "b", "c")
+	if b.exchanges[0] != "execlog/entries" {
+		t.Errorf("entry published to %s, expected execlog/entries", b.exchanges[0])
+	}
+	entry := new(Entry)
+	if err := json.Unmarshal(b.published[0].Body, entry); err != nil || entry.ID != "a" {
+		t.Errorf("published body %s is not the entry", b.published[0].Body)
+	}
+	if err := w.Write(&Entry{ID: "d"}); err != ErrWriterClosed {
+		t.Errorf("Write() after Close() returned %v, expected ErrWriterClosed", err)
+	}
+}
+
+func TestAMQPEntryWriter_reconnect(t *testing.T) {
+	b := &fakeBroker{}
+	w := newTestAMQPEntryWriter(t, b, AMQPEntryWriterConfig{})
+	defer w.Close()
+
+	w.Write(&Entry{ID: "a"})
+	waitPublished(t, b, 1)
+
+	b.drop()
+	w.Write(&Entry{ID: "b"})
+	w.Write(&Entry{ID: "c"})
+	time.Sleep(10 * time.Millisecond)
+	b.restart()
+
+	waitPublished(t, b, 3)
+	checkIDs(t, b.ids(), "a", "b", "c")
+}
+
+func TestAMQPEntryWriter_nack(t *testing.T) {
+	b := &fakeBroker{nackNext: 1}
+	w := newTestAMQPEntryWriter(t, b, AMQPEntryWriterConfig{})
+	defer w.Close()
+
+	w.Write(&Entry{ID: "a"})
+	waitPublished(t, b, 1)
+	checkIDs(t, b.ids(), "a")
+}
+
+func TestAMQPEntryWriter_overflow(t *testing.T) {
+	t.Run("drop oldest", func(t *testing.T) {
+		b := &fakeBroker{down: true}
+		w := newTestAMQPEntryWriter(t, b, AMQPEntryWriterConfig{
+			BufferSize:     2,
+			OverflowPolicy: OverflowDropOldest,
+		})
+		defer w.Close()
+
+		for _, id := range []string{"a", "b", "c", "d"} {
+			w.Write(&Entry{ID: id})
+		}
+		b.restart()
+
+		waitPublished(t, b, 2)
+		checkIDs(t, b.ids(), "c", "d")
+		if w.Dropped() != 2 {
+			t.Errorf("Dropped() = %d, expected 2", w.Dropped())
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		b := &fakeBroker{down: true}
+		w := newTestAMQPEntryWriter(t, b, AMQPEntryWriterConfig{
+			BufferSize:     2,
+			OverflowPolicy: OverflowBlock,
+		})
+		defer w.Close()
+
+		w.Write(&Entry{ID: "a"})
+		w.Write(&Entry{ID: "b"})
+		written := make(chan struct{})
+		go func() {
+			w.Write(&Entry{ID: "c"})
+			close(written)
+		}()
+		select {
+		case <-written:
+			t.Fatal("Write() did not block on a full buffer")
+		case <-time.After(10 * time.Millisecond):
+		}
+		b.restart()
+
+		<-written
+		waitPublished(t, b, 3)
+		checkIDs(t, b.ids(), "a", "b", "c")
+		if w.Dropped() != 0 {
+			t.Errorf("Dropped() = %d, expected 0", w.Dropped())
+		}
+	})
+}
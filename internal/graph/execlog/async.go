@@ -0,0 +1,206 @@
+package execlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BatchEntryWriter is an EntryWriter able to write several entries at once.
+type BatchEntryWriter interface {
+	EntryWriter
+	WriteBatch([]*Entry) error
+}
+
+// AsyncEntryWriterConfig defines the configuration of an AsyncEntryWriter.
+type AsyncEntryWriterConfig struct {
+	// EntryWriter is the writer the entries are written to in the background.
+	EntryWriter EntryWriter
+	// QueueSize is the number of entries waiting to be written above which new
+	// entries are dropped, 10000 by default.
+	QueueSize int
+	// BatchSize is the maximum number of entries written at once, 100 by
+	// default.
+	BatchSize int
+	// FlushInterval is the maximum time an entry waits for its batch to fill
+	// up before being written, 1s by default.
+	FlushInterval time.Duration
+	// Workers is the number of batches written concurrently, 1 by default.
+	Workers int
+
+	// Namespace and Subsystem prefix the names of the metrics, registered
+	// with Registerer or the default prometheus registerer.
+	Namespace  string
+	Subsystem  string
+	Registerer prometheus.Registerer
+}
+
+// AsyncEntryWriter is an EntryWriter queuing the entries to write them in
+// batches in the background, so that writing an entry never blocks. Entries
+// are dropped when the queue is full.
+type AsyncEntryWriter struct {
+	cfg   AsyncEntryWriterConfig
+	queue chan *Entry
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	droppedTotal     prometheus.Counter
+	writeErrorsTotal prometheus.Counter
+	queueDepth       prometheus.GaugeFunc
+}
+
+// NewAsyncEntryWriter returns a new AsyncEntryWriter and starts its
+// workers. It must be shut down to write the queued entries.
+func NewAsyncEntryWriter(cfg AsyncEntryWriterConfig) (*AsyncEntryWriter, error) {
+	if cfg.EntryWriter == nil {
+		return nil, errors.New("missing entry writer")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+
+	w := &AsyncEntryWriter{
+		cfg:   cfg,
+		queue: make(chan *Entry, cfg.QueueSize),
+	}
+	w.droppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      "execlog_dropped_entries_total",
+		Help:      "Number of execution log entries dropped because the queue was full.",
+	})
+	w.writeErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      "execlog_write_errors_total",
+		Help:      "Number of execution log batches the entry writer failed to write.",
+	})
+	w.queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      "execlog_queue_depth",
+		Help:      "Number of execution log entries waiting to be written.",
+	}, func() float64 {
+		return float64(len(w.queue))
+	})
+	for _, c := range []prometheus.Collector{w.droppedTotal, w.writeErrorsTotal, w.queueDepth} {
+		if err := cfg.Registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	w.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go w.work()
+	}
+	return w, nil
+}
+
+// Write queues the entry to be written, dropping it if the queue is full.
+func (w *AsyncEntryWriter) Write(entry *Entry) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	select {
+	case w.queue <- entry:
+	default:
+		w.droppedTotal.Inc()
+	}
+	return nil
+}
+
+// Shutdown stops accepting entries and waits for the queued ones to be
+// written, or for the context to be done. The entry writer is then closed if
+// it implements io.Closer.
+func (w *AsyncEntryWriter) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, c := range []prometheus.Collector{w.droppedTotal, w.writeErrorsTotal, w.queueDepth} {
+		w.cfg.Registerer.Unregister(c)
+	}
+	if c, ok := w.cfg.EntryWriter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (w *AsyncEntryWriter) work() {
+	defer w.wg.Done()
+
+	batch := make([]*Entry, 0, w.cfg.BatchSize)
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) < w.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (w *AsyncEntryWriter) flush(batch []*Entry) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	if bw, ok := w.cfg.EntryWriter.(BatchEntryWriter); ok {
+		err = bw.WriteBatch(batch)
+	} else {
+		for _, entry := range batch {
+			if err = w.cfg.EntryWriter.Write(entry); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		w.writeErrorsTotal.Inc()
+		log.Println("Failed to write execution log entries:", err.Error())
+	}
+}
@@ -0,0 +1,173 @@
+package execlog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordingEntryWriter records the batches it writes, blocking until
+// released when gated.
+type recordingEntryWriter struct {
+	mu      sync.Mutex
+	batches [][]string
+	gate    chan struct{}
+	err     error
+	closed  bool
+}
+
+func (w *recordingEntryWriter) Write(entry *Entry) error {
+	return w.WriteBatch([]*Entry{entry})
+}
+
+func (w *recordingEntryWriter) WriteBatch(entries []*Entry) error {
+	if w.gate != nil {
+		<-w.gate
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.batches = append(w.batches, ids)
+	return w.err
+}
+
+func (w *recordingEntryWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *recordingEntryWriter) ids() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]string, 0)
+	for _, batch := range w.batches {
+		ids = append(ids, batch...)
+	}
+	return ids
+}
+
+func newTestAsyncEntryWriter(t *testing.T, cfg AsyncEntryWriterConfig) *AsyncEntryWriter {
+	cfg.Registerer = prometheus.NewRegistry()
+	w, err := NewAsyncEntryWriter(cfg)
+	if err != nil {
+		t.Fatalf("NewAsyncEntryWriter() returned error: %s", err)
+	}
+	return w
+}
+
+func TestAsyncEntryWriter(t *testing.T) {
+	rec := &recordingEntryWriter{}
+	w := newTestAsyncEntryWriter(t, AsyncEntryWriterConfig{
+		EntryWriter:   rec,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	for _, id := range []string{"a", "b", "c"} {
+		if err := w.Write(&Entry{ID: id}); err != nil {
+			t.Fatalf("Write() returned error: %s", err)
+		}
+	}
+
+	// the full batch is written without waiting for the flush interval
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rec.ids()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("full batch not written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() returned error: %s", err)
+	}
+	checkIDs(t, rec.ids(), "a", "b", "c")
+	if len(rec.batches) != 2 {
+		t.Errorf("entries written in batches %v, expected [[a b] [c]]", rec.batches)
+	}
+	if !rec.closed {
+		t.Error("Shutdown() did not close the entry writer")
+	}
+	if err := w.Write(&Entry{ID: "d"}); err != ErrWriterClosed {
+		t.Errorf("Write() after Shutdown() returned %v, expected ErrWriterClosed", err)
+	}
+}
+
+func TestAsyncEntryWriter_flushInterval(t *testing.T) {
+	rec := &recordingEntryWriter{}
+	w := newTestAsyncEntryWriter(t, AsyncEntryWriterConfig{
+		EntryWriter:   rec,
+		FlushInterval: time.Millisecond,
+	})
+	defer w.Shutdown(context.Background())
+
+	w.Write(&Entry{ID: "a"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rec.ids()) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("entry not written after the flush interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAsyncEntryWriter_metrics(t *testing.T) {
+	rec := &recordingEntryWriter{gate: make(chan struct{}), err: errors.New("disk full")}
+	w := newTestAsyncEntryWriter(t, AsyncEntryWriterConfig{
+		EntryWriter:   rec,
+		QueueSize:     2,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+	})
+
+	// the worker blocks writing the first entry while the next two fill the
+	// queue, the last one is dropped without blocking
+	w.Write(&Entry{ID: "a"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(w.queue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first entry not taken from the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, id := range []string{"b", "c", "d"} {
+		w.Write(&Entry{ID: id})
+	}
+	if v := testutil.ToFloat64(w.queueDepth); v != 2 {
+		t.Errorf("queue depth is %v, expected 2", v)
+	}
+	if v := testutil.ToFloat64(w.droppedTotal); v != 1 {
+		t.Errorf("dropped entries are %v, expected 1", v)
+	}
+
+	close(rec.gate)
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() returned error: %s", err)
+	}
+	checkIDs(t, rec.ids(), "a", "b", "c")
+	if v := testutil.ToFloat64(w.writeErrorsTotal); v != 3 {
+		t.Errorf("write errors are %v, expected 3", v)
+	}
+}
+
+func TestAsyncEntryWriter_shutdownTimeout(t *testing.T) {
+	rec := &recordingEntryWriter{gate: make(chan struct{})}
+	defer close(rec.gate)
+	w := newTestAsyncEntryWriter(t, AsyncEntryWriterConfig{EntryWriter: rec})
+	w.Write(&Entry{ID: "a"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() returned %v, expected context.DeadlineExceeded", err)
+	}
+}
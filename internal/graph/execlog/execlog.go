@@ -1,8 +1,11 @@
 package execlog
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/herzult/porte/internal/graph"
@@ -18,20 +21,56 @@ type Entry struct {
 	Request       *graph.Request  `json:"request"`
 	Response      *graph.Response `json:"response"`
 	Error         string          `json:"error"`
+	// Differences are set on the entries of shadow executions whose
+	// response differs from the primary one.
+	Differences []*graph.Difference `json:"differences,omitempty"`
 }
 
 type EntryWriter interface {
 	Write(*Entry) error
 }
 
+// FileEntryWriter is an EntryWriter writing JSON lines to a file. It is
+// safe for concurrent use.
 type FileEntryWriter struct {
 	File *os.File
+	mu   sync.Mutex
 }
 
 func (w *FileEntryWriter) Write(entry *Entry) error {
-	return json.NewEncoder(w.File).Encode(entry)
+	return w.WriteBatch([]*Entry{entry})
 }
 
-type AMQPLogWriter struct {
-	Chann
+// WriteBatch writes the entries to the file at once.
+func (w *FileEntryWriter) WriteBatch(entries []*Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.File.Write(buf.Bytes())
+	return err
+}
+
+// EntryReader reads the entries written by a FileEntryWriter.
+type EntryReader struct {
+	dec *json.Decoder
+}
+
+// NewEntryReader returns a new EntryReader reading entries from r.
+func NewEntryReader(r io.Reader) *EntryReader {
+	return &EntryReader{dec: json.NewDecoder(r)}
+}
+
+// Read returns the next entry, or io.EOF when there are no more entries.
+func (r *EntryReader) Read() (*Entry, error) {
+	entry := new(Entry)
+	if err := r.dec.Decode(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
 }
@@ -0,0 +1,101 @@
+package execlog
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+func TestEntryReader(t *testing.T) {
+	f, err := ioutil.TempFile("", "execlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := &FileEntryWriter{File: f}
+	for _, id := range []string{"a", "b"} {
+		if err := w.Write(&Entry{ID: id, Request: &graph.Request{Query: "{ a }"}}); err != nil {
+			t.Fatalf("Write() returned error: %s", err)
+		}
+	}
+
+	f.Seek(0, io.SeekStart)
+	r := NewEntryReader(f)
+	for _, id := range []string{"a", "b"} {
+		entry, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() returned error: %s", err)
+		}
+		if entry.ID != id || entry.Request.Query != "{ a }" {
+			t.Errorf("Read() = %+v, expected entry %s", entry, id)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Read() returned %v, expected io.EOF", err)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	data := map[string]interface{}{
+		"user": map[string]interface{}{
+			"name":  "Luke",
+			"Email": "luke@rebels.org",
+		},
+		"list": []interface{}{map[string]interface{}{"token": "x"}},
+	}
+	entry := &Entry{
+		ID: "a",
+		Request: &graph.Request{
+			Query:     `mutation { login(password: "inline") }`,
+			Variables: map[string]interface{}{"password": "secret", "id": "1"},
+		},
+		Response: &graph.Response{
+			Data: data,
+			Errors: []*graph.Error{
+				{Message: "invalid token", Extensions: map[string]interface{}{"code": "UNAUTHENTICATED", "token": "x"}},
+			},
+		},
+		Differences: []*graph.Difference{
+			{Path: "data.user.Email", Expected: "luke@rebels.org", Actual: "luke@empire.org"},
+			{Path: "data.list.0", Expected: map[string]interface{}{"token": "x"}, Actual: nil},
+		},
+	}
+
+	redacted := newRedactor([]string{"password", "email", "token"}).redactEntry(entry)
+
+	if v := redacted.Request.Variables; v["password"] != RedactedValue || v["id"] != "1" {
+		t.Errorf("redacted variables are %v", v)
+	}
+	// the inline arguments are written as they are
+	if q := redacted.Request.Query; q != entry.Request.Query {
+		t.Errorf("redacted query is %s", q)
+	}
+	if ext := redacted.Response.Errors[0].Extensions; ext["token"] != RedactedValue || ext["code"] != "UNAUTHENTICATED" {
+		t.Errorf("redacted error extensions are %v", ext)
+	}
+	if ext := entry.Response.Errors[0].Extensions; ext["token"] != "x" {
+		t.Error("redaction modified the original entry errors")
+	}
+	user := redacted.Response.Data.(map[string]interface{})["user"].(map[string]interface{})
+	if user["Email"] != RedactedValue || user["name"] != "Luke" {
+		t.Errorf("redacted user is %v", user)
+	}
+	item := redacted.Response.Data.(map[string]interface{})["list"].([]interface{})[0].(map[string]interface{})
+	if item["token"] != RedactedValue {
+		t.Errorf("redacted list item is %v", item)
+	}
+	if d := redacted.Differences[0]; d.Expected != RedactedValue || d.Actual != RedactedValue {
+		t.Errorf("redacted difference is %v", d)
+	}
+	if d := redacted.Differences[1]; d.Expected.(map[string]interface{})["token"] != RedactedValue || d.Actual != nil {
+		t.Errorf("redacted difference is %v", d)
+	}
+	if entry.Request.Variables["password"] != "secret" || entry.Differences[0].Expected != "luke@rebels.org" || data["user"].(map[string]interface{})["Email"] != "luke@rebels.org" {
+		t.Error("redaction modified the original entry")
+	}
+}
@@ -2,58 +2,142 @@ package execlog
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/herzult/porte/internal/graph"
 	"github.com/herzult/porte/internal/graph/proxy"
 )
 
+// ProxyPluginConfig defines the configuration of the proxy plugin
+type ProxyPluginConfig struct {
+	EntryWriter EntryWriter
+	// SampleRate is the fraction of the executions written, between 0 and 1.
+	// Executions are sampled as a whole: all the parts of a stream or
+	// subscription are written, or none of them.
+	SampleRate float64
+	// Redact lists the keys whose values are redacted from the written
+	// entries, wherever they appear in the request variables and extensions
+	// and in the response data, extensions and error extensions. Keys are
+	// matched case insensitively. The arguments written inline in the
+	// queries and the error messages are not redacted: sensitive values
+	// belong in variables.
+	Redact []string
+}
+
 // NewProxyPlugin returns a new proxy plugin instance configured to write
-// every execution of the graph using the provided ExecLogEntryWriter.
-func NewProxyPlugin(entryWriter EntryWriter) (*proxy.Plugin, error) {
+// the executions of the graph using the configured EntryWriter.
+func NewProxyPlugin(cfg ProxyPluginConfig) (*proxy.Plugin, error) {
+	if cfg.EntryWriter == nil {
+		return nil, errors.New("missing entry writer")
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %v, expected a value between 0 and 1", cfg.SampleRate)
+	}
+	entryWriter := cfg.EntryWriter
+	redactor := newRedactor(cfg.Redact)
+
 	return &proxy.Plugin{
 		InitContext: func(ctx context.Context) context.Context {
 			graph := proxy.GetGraph(ctx)
-			return context.WithValue(ctx, stateKey{}, &Entry{
-				ID:      proxy.GetExecID(ctx),
-				GraphID: graph.ID(),
+			return context.WithValue(ctx, stateKey{}, &state{
+				sampled: rand.Float64() < cfg.SampleRate,
+				entry: Entry{
+					ID:      proxy.GetExecID(ctx),
+					GraphID: graph.ID(),
+				},
 			})
 		},
 		ReadProxyRequest: func(next proxy.ReadProxyRequest) proxy.ReadProxyRequest {
 			return func(req *http.Request) (*graph.Request, error) {
-				entry, _ := req.Context().Value(stateKey{}).(*Entry)
-				entry.ClientName = req.Header.Get("Client-Name")
-				entry.ClientVersion = req.Header.Get("Client-Version")
-
+				st, _ := req.Context().Value(stateKey{}).(*state)
 				nextReq, nextErr := next(req)
+
+				st.mu.Lock()
+				defer st.mu.Unlock()
+				st.entry.ClientName = req.Header.Get("Client-Name")
+				st.entry.ClientVersion = req.Header.Get("Client-Version")
 				if nextReq != nil {
-					entry.Request = nextReq
+					st.entry.Request = nextReq
 				}
 				return nextReq, nextErr
 			}
 		},
 		SendGraphRequest: func(next http.RoundTripper) http.RoundTripper {
 			return proxy.SendGraphRequest(func(req *http.Request) (*http.Response, error) {
-				entry, _ := req.Context().Value(stateKey{}).(*Entry)
-				entry.StartTime = time.Now()
+				// a batch of operations shares the same graph request
+				states := make([]*state, 0)
+				for _, ctx := range proxy.GetExecContexts(req.Context()) {
+					if st, ok := ctx.Value(stateKey{}).(*state); ok {
+						states = append(states, st)
+					}
+				}
+				startTime := time.Now()
 				nextRes, nextErr := next.RoundTrip(req)
-				entry.Duration = time.Since(entry.StartTime)
+				endTime := time.Now()
+				for _, st := range states {
+					st.observe(startTime, endTime)
+				}
 				return nextRes, nextErr
 			})
 		},
 		WriteProxyResponse: func(next proxy.WriteProxyResponse) proxy.WriteProxyResponse {
 			return func(ctx context.Context, w http.ResponseWriter, graphRes *graph.Response, graphErr error) {
 				next(ctx, w, graphRes, graphErr)
-				entry, _ := ctx.Value(stateKey{}).(*Entry)
+				st := ctx.Value(stateKey{}).(*state)
+				if !st.sampled {
+					return
+				}
+				// the entry is written once per part of streams and
+				// subscriptions, and may be encoded in the background:
+				// every part gets a copy of its own
+				entry := st.snapshot()
 				entry.Response = graphRes
 				if graphErr != nil {
 					entry.Error = graphErr.Error()
 				}
-				entryWriter.Write(entry)
+				if err := entryWriter.Write(redactor.redactEntry(&entry)); err != nil {
+					log.Println("Failed to write execution log entry:", err.Error())
+				}
 			}
 		},
 	}, nil
 }
 
 type stateKey struct{}
+
+// state holds the entry of an execution. Gateways send the graph requests of
+// an execution concurrently, its entry spans all of them.
+type state struct {
+	sampled bool
+
+	mu    sync.Mutex
+	entry Entry
+	end   time.Time
+}
+
+// observe extends the entry duration to the graph request sent between
+// start and end.
+func (st *state) observe(start, end time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.entry.StartTime.IsZero() || start.Before(st.entry.StartTime) {
+		st.entry.StartTime = start
+	}
+	if end.After(st.end) {
+		st.end = end
+	}
+	st.entry.Duration = st.end.Sub(st.entry.StartTime)
+}
+
+// snapshot returns a copy of the entry.
+func (st *state) snapshot() Entry {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.entry
+}
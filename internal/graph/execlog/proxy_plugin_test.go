@@ -0,0 +1,212 @@
+package execlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/proxy"
+	"github.com/herzult/porte/internal/graph/stitching"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// encodingEntryWriter JSON encodes the entries it writes, the way the
+// entry writers writing them out do.
+type encodingEntryWriter struct {
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+func (w *encodingEntryWriter) Write(entry *Entry) error {
+	return w.WriteBatch([]*Entry{entry})
+}
+
+func (w *encodingEntryWriter) WriteBatch(entries []*Entry) error {
+	for _, entry := range entries {
+		bdy, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		decoded := new(Entry)
+		if err := json.Unmarshal(bdy, decoded); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.entries = append(w.entries, decoded)
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+func TestProxyPlugin_stream(t *testing.T) {
+	const parts = 200
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="-"`)
+		for i := 0; i < parts; i++ {
+			fmt.Fprintf(w, "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n"+`{"data":{"n":%d},"hasNext":%t}`, i, i < parts-1)
+		}
+		io.WriteString(w, "\r\n-----\r\n")
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := &encodingEntryWriter{}
+	w := newTestAsyncEntryWriter(t, AsyncEntryWriterConfig{EntryWriter: enc, BatchSize: 1})
+	plug, err := NewProxyPlugin(ProxyPluginConfig{EntryWriter: w, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := proxy.New(&proxy.Config{Graph: g, Plugins: []*proxy.Plugin{plug}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ n }"}`))
+	r.Header.Set("Content-Type", "application/json")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := enc.entries
+	if len(entries) != parts {
+		t.Fatalf("got %d entries, expected %d", len(entries), parts)
+	}
+	for i, entry := range entries {
+		data, _ := entry.Response.Data.(map[string]interface{})
+		if n, _ := data["n"].(float64); int(n) != i {
+			t.Errorf("got entry %d with data %v", i, entry.Response.Data)
+		}
+	}
+}
+
+func TestProxyPlugin_sampling(t *testing.T) {
+	const parts = 3
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="-"`)
+		for i := 0; i < parts; i++ {
+			fmt.Fprintf(w, "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n"+`{"data":{"n":%d},"hasNext":%t}`, i, i < parts-1)
+		}
+		io.WriteString(w, "\r\n-----\r\n")
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rate := range []float64{0, 0.5, 1} {
+		enc := &encodingEntryWriter{}
+		plug, err := NewProxyPlugin(ProxyPluginConfig{EntryWriter: enc, SampleRate: rate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := proxy.New(&proxy.Config{Graph: g, Plugins: []*proxy.Plugin{plug}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		const executions = 50
+		for i := 0; i < executions; i++ {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ n }"}`))
+			r.Header.Set("Content-Type", "application/json")
+			p.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		written := make(map[string]int)
+		for _, entry := range enc.entries {
+			written[entry.ID]++
+		}
+		for id, n := range written {
+			if n != parts {
+				t.Errorf("rate %v: got %d entries of execution %s, expected all of its %d parts", rate, n, id, parts)
+			}
+		}
+		switch {
+		case rate == 0 && len(written) != 0:
+			t.Errorf("rate 0: got %d executions written, expected none", len(written))
+		case rate == 1 && len(written) != executions:
+			t.Errorf("rate 1: got %d executions written, expected %d", len(written), executions)
+		case rate == 0.5 && (len(written) == 0 || len(written) == executions):
+			t.Errorf("rate 0.5: got %d of %d executions written", len(written), executions)
+		}
+	}
+}
+
+func TestProxyPlugin_gateway(t *testing.T) {
+	// the gateway sends the fields to their graphs concurrently
+	subschemas := make([]*stitching.Subschema, 0, 2)
+	for _, sub := range []struct {
+		field string
+		delay time.Duration
+	}{
+		{field: "a", delay: 10 * time.Millisecond},
+		{field: "b", delay: 50 * time.Millisecond},
+	} {
+		sub := sub
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(sub.delay)
+			fmt.Fprintf(w, `{"data":{"%s":1}}`, sub.field)
+		}))
+		defer srv.Close()
+		u, _ := url.Parse(srv.URL)
+		g, err := graph.NewGraph(&graph.GraphConfig{Name: sub.field, ServiceURL: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := schema.ParseSDL("type Query { " + sub.field + ": Int }")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := schema.NewSchema(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subschemas = append(subschemas, &stitching.Subschema{Graph: g, Schema: s})
+	}
+	gw, err := stitching.NewGateway(&stitching.GatewayConfig{Name: "gateway", Subschemas: subschemas})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := &encodingEntryWriter{}
+	plug, err := NewProxyPlugin(ProxyPluginConfig{EntryWriter: enc, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := proxy.New(&proxy.Config{Graph: gw, Plugins: []*proxy.Plugin{plug}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startTime := time.Now()
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ a b }"}`))
+	r.Header.Set("Content-Type", "application/json")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	endTime := time.Now()
+
+	if len(enc.entries) != 1 {
+		t.Fatalf("got %d entries, expected 1", len(enc.entries))
+	}
+	entry := enc.entries[0]
+	if entry.StartTime.Before(startTime) || entry.StartTime.Add(entry.Duration).After(endTime) {
+		t.Errorf("got start time %s and duration %s outside of the request", entry.StartTime, entry.Duration)
+	}
+	// the entry spans the slowest graph request
+	if entry.Duration < 50*time.Millisecond {
+		t.Errorf("got duration %s, expected the one of the slowest graph request", entry.Duration)
+	}
+}
@@ -0,0 +1,155 @@
+package execlog
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// OperationName returns the name of the operation the entry request
+// executed, or an empty string for an anonymous operation or an invalid
+// request.
+func (e *Entry) OperationName() string {
+	if e.Request == nil {
+		return ""
+	}
+	if e.Request.OperationName != "" {
+		return e.Request.OperationName
+	}
+	op, err := e.Request.Operation()
+	if err != nil || op.Name == nil {
+		return ""
+	}
+	return op.Name.Value
+}
+
+// HasErrors reports whether the execution failed or its response contains
+// errors.
+func (e *Entry) HasErrors() bool {
+	return e.Error != "" || e.Response != nil && len(e.Response.Errors) > 0
+}
+
+// ErrorFilter selects entries by the presence of errors.
+type ErrorFilter string
+
+const (
+	// ErrorFilterAny selects the entries with or without errors.
+	ErrorFilterAny ErrorFilter = ""
+	// ErrorFilterOnly selects the entries with errors.
+	ErrorFilterOnly ErrorFilter = "only"
+	// ErrorFilterNone selects the entries without errors.
+	ErrorFilterNone ErrorFilter = "none"
+)
+
+// Filter selects execution log entries. Its zero value selects every entry,
+// each set field restricts the selection.
+type Filter struct {
+	// Since and Until bound the start time of the executions, Until
+	// excluded.
+	Since time.Time
+	Until time.Time
+
+	GraphID       string
+	ClientName    string
+	ClientVersion string
+	OperationName string
+	Errors        ErrorFilter
+	// MinDuration selects the executions lasting at least this long.
+	MinDuration time.Duration
+}
+
+// Match reports whether the filter selects the entry.
+func (f *Filter) Match(e *Entry) bool {
+	switch {
+	case !f.Since.IsZero() && e.StartTime.Before(f.Since),
+		!f.Until.IsZero() && !e.StartTime.Before(f.Until),
+		f.GraphID != "" && e.GraphID != f.GraphID,
+		f.ClientName != "" && e.ClientName != f.ClientName,
+		f.ClientVersion != "" && e.ClientVersion != f.ClientVersion,
+		f.MinDuration > 0 && e.Duration < f.MinDuration,
+		f.Errors == ErrorFilterOnly && !e.HasErrors(),
+		f.Errors == ErrorFilterNone && e.HasErrors():
+		return false
+	}
+	// parsing the query is the most expensive check
+	return f.OperationName == "" || e.OperationName() == f.OperationName
+}
+
+// OperationStats are the statistics of the executions of an operation.
+type OperationStats struct {
+	OperationName string        `json:"operationName"`
+	Count         int           `json:"count"`
+	Errors        int           `json:"errors"`
+	ErrorRate     float64       `json:"errorRate"`
+	P50           time.Duration `json:"p50"`
+	P95           time.Duration `json:"p95"`
+	P99           time.Duration `json:"p99"`
+}
+
+// Stats aggregates execution log entries by operation.
+type Stats struct {
+	ops map[string]*operationStats
+}
+
+type operationStats struct {
+	count     int
+	errors    int
+	durations []time.Duration
+}
+
+// NewStats returns a new empty Stats.
+func NewStats() *Stats {
+	return &Stats{ops: map[string]*operationStats{}}
+}
+
+// Add adds the execution of the entry to the statistics of its operation.
+func (s *Stats) Add(e *Entry) {
+	name := e.OperationName()
+	op, ok := s.ops[name]
+	if !ok {
+		op = &operationStats{}
+		s.ops[name] = op
+	}
+	op.count++
+	if e.HasErrors() {
+		op.errors++
+	}
+	op.durations = append(op.durations, e.Duration)
+}
+
+// Operations returns the statistics of each operation, the most executed
+// first. Anonymous operations are aggregated under an empty name.
+func (s *Stats) Operations() []*OperationStats {
+	stats := make([]*OperationStats, 0, len(s.ops))
+	for name, op := range s.ops {
+		sort.Slice(op.durations, func(i, j int) bool { return op.durations[i] < op.durations[j] })
+		stats = append(stats, &OperationStats{
+			OperationName: name,
+			Count:         op.count,
+			Errors:        op.errors,
+			ErrorRate:     float64(op.errors) / float64(op.count),
+			P50:           percentile(op.durations, 50),
+			P95:           percentile(op.durations, 95),
+			P99:           percentile(op.durations, 99),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].OperationName < stats[j].OperationName
+	})
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
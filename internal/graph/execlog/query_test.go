@@ -0,0 +1,80 @@
+package execlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+func TestFilter(t *testing.T) {
+	now := time.Now()
+	entry := &Entry{
+		GraphID:       "http://graph",
+		ClientName:    "ios",
+		ClientVersion: "1.2",
+		StartTime:     now,
+		Duration:      100 * time.Millisecond,
+		Request:       &graph.Request{Query: "query Hero { hero { name } }"},
+		Response:      &graph.Response{Errors: []*graph.Error{{Message: "droid not found"}}},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "zero value", want: true},
+		{name: "since", filter: Filter{Since: now}, want: true},
+		{name: "since after", filter: Filter{Since: now.Add(time.Second)}},
+		{name: "until", filter: Filter{Until: now.Add(time.Second)}, want: true},
+		{name: "until excluded", filter: Filter{Until: now}},
+		{name: "graph", filter: Filter{GraphID: "http://graph"}, want: true},
+		{name: "other graph", filter: Filter{GraphID: "http://other"}},
+		{name: "client", filter: Filter{ClientName: "ios", ClientVersion: "1.2"}, want: true},
+		{name: "other client version", filter: Filter{ClientName: "ios", ClientVersion: "1.3"}},
+		{name: "operation from query", filter: Filter{OperationName: "Hero"}, want: true},
+		{name: "other operation", filter: Filter{OperationName: "Droid"}},
+		{name: "errors only", filter: Filter{Errors: ErrorFilterOnly}, want: true},
+		{name: "no errors", filter: Filter{Errors: ErrorFilterNone}},
+		{name: "min duration", filter: Filter{MinDuration: 100 * time.Millisecond}, want: true},
+		{name: "longer min duration", filter: Filter{MinDuration: time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(entry); got != tt.want {
+				t.Errorf("Match() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := NewStats()
+	for i := 1; i <= 100; i++ {
+		entry := &Entry{
+			Duration: time.Duration(i) * time.Millisecond,
+			Request:  &graph.Request{Query: "query Hero { hero { name } }"},
+		}
+		if i%10 == 0 {
+			entry.Error = "graphql service not available"
+		}
+		stats.Add(entry)
+	}
+	stats.Add(&Entry{Request: &graph.Request{Query: "{ droid { name } }"}})
+
+	ops := stats.Operations()
+	if len(ops) != 2 {
+		t.Fatalf("Operations() returned %d operations, expected 2", len(ops))
+	}
+	hero := ops[0]
+	if hero.OperationName != "Hero" || hero.Count != 100 || hero.Errors != 10 || hero.ErrorRate != 0.1 {
+		t.Errorf("Hero operation stats are %+v", hero)
+	}
+	if hero.P50 != 50*time.Millisecond || hero.P95 != 95*time.Millisecond || hero.P99 != 99*time.Millisecond {
+		t.Errorf("Hero operation percentiles are %s, %s and %s", hero.P50, hero.P95, hero.P99)
+	}
+	if ops[1].OperationName != "" || ops[1].Count != 1 {
+		t.Errorf("anonymous operation stats are %+v", ops[1])
+	}
+}
@@ -0,0 +1,145 @@
+package execlog
+
+import (
+	"strings"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// RedactedValue replaces the redacted values of the written entries.
+const RedactedValue = "[REDACTED]"
+
+// RedactingEntryWriter is an EntryWriter writing the entries to another one
+// with the values of some keys redacted, like the Redact keys of the
+// ProxyPluginConfig. The differences of the entries are redacted too.
+type RedactingEntryWriter struct {
+	w        EntryWriter
+	redactor *redactor
+}
+
+// NewRedactingEntryWriter returns an EntryWriter writing the entries to w with
+// the values of the given keys redacted.
+func NewRedactingEntryWriter(w EntryWriter, keys []string) *RedactingEntryWriter {
+	return &RedactingEntryWriter{w: w, redactor: newRedactor(keys)}
+}
+
+func (w *RedactingEntryWriter) Write(entry *Entry) error {
+	return w.w.Write(w.redactor.redactEntry(entry))
+}
+
+// redactor replaces the values of some keys in the entries.
+type redactor struct {
+	keys map[string]bool
+}
+
+func newRedactor(keys []string) *redactor {
+	r := &redactor{keys: map[string]bool{}}
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" {
+			r.keys[strings.ToLower(k)] = true
+		}
+	}
+	return r
+}
+
+// redactEntry returns a copy of the entry with the values of the redacted
+// keys replaced. The request and response of the entry are shared with the
+// proxy, they are copied rather than modified. The query documents and the
+// error messages are text, they are written as they are: the values of
+// their arguments are not redacted.
+func (r *redactor) redactEntry(entry *Entry) *Entry {
+	if len(r.keys) == 0 {
+		return entry
+	}
+	redacted := *entry
+	if entry.Request != nil {
+		req := *entry.Request
+		req.Variables = r.redactMap(req.Variables)
+		req.Extensions = r.redactMap(req.Extensions)
+		redacted.Request = &req
+	}
+	if entry.Response != nil {
+		res := *entry.Response
+		res.Data = r.redact(res.Data)
+		res.Extensions = r.redactMap(res.Extensions)
+		if len(res.Errors) > 0 {
+			res.Errors = make([]*graph.Error, len(entry.Response.Errors))
+			for i, e := range entry.Response.Errors {
+				redactedErr := *e
+				redactedErr.Extensions = r.redactMap(e.Extensions)
+				res.Errors[i] = &redactedErr
+			}
+		}
+		redacted.Response = &res
+	}
+	if len(entry.Differences) > 0 {
+		redacted.Differences = make([]*graph.Difference, len(entry.Differences))
+		for i, d := range entry.Differences {
+			redacted.Differences[i] = r.redactDifference(d)
+		}
+	}
+	return &redacted
+}
+
+// redactDifference returns a copy of the difference whose values are
+// redacted, entirely when one of the keys of its path is redacted.
+func (r *redactor) redactDifference(d *graph.Difference) *graph.Difference {
+	for _, k := range strings.Split(d.Path, ".") {
+		if r.keys[strings.ToLower(k)] {
+			return &graph.Difference{Path: d.Path, Expected: RedactedValue, Actual: RedactedValue}
+		}
+	}
+	return &graph.Difference{Path: d.Path, Expected: r.redact(d.Expected), Actual: r.redact(d.Actual)}
+}
+
+func (r *redactor) redactMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return r.redact(m).(map[string]interface{})
+}
+
+func (r *redactor) redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if r.keys[strings.ToLower(k)] {
+				m[k] = RedactedValue
+			} else {
+				m[k] = r.redact(val)
+			}
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			s[i] = r.redact(val)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// hasRedactedValue reports whether a redacted value replaced one of the
+// values of v.
+func hasRedactedValue(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, val := range v {
+			if hasRedactedValue(val) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, val := range v {
+			if hasRedactedValue(val) {
+				return true
+			}
+		}
+	case string:
+		return v == RedactedValue
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package execlog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// ReplayConfig defines the configuration of a replay.
+type ReplayConfig struct {
+	// Graph is the graph the recorded requests are sent to.
+	Graph     graph.Graph
+	Transport http.RoundTripper
+	// Concurrency is the number of requests sent concurrently, 1 by default.
+	Concurrency int
+	// Rate is the maximum number of requests sent per second. The requests
+	// are sent as fast as possible when it is 0.
+	Rate float64
+	// Ignore lists the paths of the response values not compared, as
+	// described by graph.CompareResponses. The values redacted from the
+	// recorded responses are never compared.
+	Ignore []string
+}
+
+// ReplayResult is the result of the replay of an entry.
+type ReplayResult struct {
+	Entry    *Entry
+	Response *graph.Response
+	// Error is set when the request failed or was not sent, because values
+	// were redacted from it, the response is then not compared.
+	Error       error
+	Differences []*graph.Difference
+}
+
+// Replay sends the requests of the entries to the graph and compares its
+// responses with the recorded ones. It returns a channel receiving the result
+// of each entry as soon as it is replayed, which is closed once the entries
+// channel is closed and every entry replayed, or the context is done.
+func Replay(ctx context.Context, cfg ReplayConfig, entries <-chan *Entry) (<-chan *ReplayResult, error) {
+	if cfg.Graph == nil {
+		return nil, errors.New("missing graph")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Rate < 0 {
+		return nil, errors.New("invalid replay rate, it can not be negative")
+	}
+
+	throttled := entries
+	if cfg.Rate > 0 {
+		ch := make(chan *Entry)
+		throttled = ch
+		go func() {
+			defer close(ch)
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+			defer ticker.Stop()
+			for entry := range entries {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case ch <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	results := make(chan *ReplayResult)
+	var wg sync.WaitGroup
+	wg.Add(cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var entry *Entry
+				select {
+				case e, ok := <-throttled:
+					if !ok {
+						return
+					}
+					entry = e
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case results <- replayEntry(ctx, cfg, entry):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results, nil
+}
+
+func replayEntry(ctx context.Context, cfg ReplayConfig, entry *Entry) *ReplayResult {
+	res := &ReplayResult{Entry: entry}
+	if entry.Request == nil {
+		res.Error = errors.New("missing recorded request")
+		return res
+	}
+	// the redacted values are not the ones the client sent, the request
+	// would not be the recorded one
+	if hasRedactedValue(entry.Request.Variables) || hasRedactedValue(entry.Request.Extensions) {
+		res.Error = errors.New("request has redacted values")
+		return res
+	}
+	res.Response, res.Error = cfg.Graph.Execute(ctx, entry.Request, cfg.Transport)
+	if res.Error != nil {
+		return res
+	}
+	diffs, err := graph.CompareResponses(entry.Response, res.Response, cfg.Ignore)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	for _, d := range diffs {
+		if d.Expected != RedactedValue {
+			res.Differences = append(res.Differences, d)
+		}
+	}
+	return res
+}
@@ -0,0 +1,109 @@
+package execlog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+func TestReplay(t *testing.T) {
+	var sent int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		req := new(graph.Request)
+		json.NewDecoder(r.Body).Decode(req)
+		w.Header().Set("Content-Type", "application/json")
+		switch req.OperationName {
+		case "Same":
+			w.Write([]byte(`{"data":{"hero":{"name":"R2-D2"}},"extensions":{"tracing":2}}`))
+		case "Redacted":
+			w.Write([]byte(`{"data":{"hero":{"name":"R2-D2","token":"abc"}}}`))
+		case "Changed":
+			w.Write([]byte(`{"data":{"hero":{"name":"C-3PO"}}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	serviceURL, _ := url.Parse(server.URL)
+	g, _ := graph.NewGraph(&graph.GraphConfig{ServiceURL: serviceURL})
+
+	recorded := &graph.Response{
+		Data:       map[string]interface{}{"hero": map[string]interface{}{"name": "R2-D2"}},
+		Extensions: map[string]interface{}{"tracing": 1},
+	}
+	entries := make(chan *Entry, 5)
+	for _, name := range []string{"Same", "Changed", "Failed"} {
+		entries <- &Entry{
+			ID:       name,
+			Request:  &graph.Request{Query: "query " + name + " { hero { name } }", OperationName: name},
+			Response: recorded,
+		}
+	}
+	entries <- &Entry{
+		ID:      "Redacted",
+		Request: &graph.Request{Query: "query Redacted { hero { name token } }", OperationName: "Redacted"},
+		Response: &graph.Response{
+			Data: map[string]interface{}{"hero": map[string]interface{}{"name": "R2-D2", "token": RedactedValue}},
+		},
+	}
+	entries <- &Entry{
+		ID: "RedactedRequest",
+		Request: &graph.Request{
+			Query:         "query RedactedRequest($password: String) { login(password: $password) }",
+			OperationName: "RedactedRequest",
+			Variables:     map[string]interface{}{"password": RedactedValue},
+		},
+		Response: recorded,
+	}
+	close(entries)
+
+	start := time.Now()
+	results, err := Replay(context.Background(), ReplayConfig{
+		Graph:       g,
+		Concurrency: 2,
+		Rate:        100,
+		Ignore:      []string{"extensions"},
+	}, entries)
+	if err != nil {
+		t.Fatalf("Replay() returned error: %s", err)
+	}
+	byID := map[string]*ReplayResult{}
+	ids := make([]string, 0)
+	for res := range results {
+		byID[res.Entry.ID] = res
+		ids = append(ids, res.Entry.ID)
+	}
+	sort.Strings(ids)
+	checkIDs(t, ids, "Changed", "Failed", "Redacted", "RedactedRequest", "Same")
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("4 entries replayed in %s at 100 requests per second", d)
+	}
+
+	if res := byID["Same"]; res.Error != nil || len(res.Differences) != 0 {
+		t.Errorf("Same operation replayed with error %v and differences %v", res.Error, res.Differences)
+	}
+	if res := byID["Changed"]; res.Error != nil || len(res.Differences) != 1 || res.Differences[0].Path != "data.hero.name" {
+		t.Errorf("Changed operation replayed with error %v and differences %v", res.Error, res.Differences)
+	}
+	if res := byID["Redacted"]; res.Error != nil || len(res.Differences) != 0 {
+		t.Errorf("Redacted operation replayed with error %v and differences %v", res.Error, res.Differences)
+	}
+	if res := byID["RedactedRequest"]; res.Error == nil || res.Error.Error() != "request has redacted values" {
+		t.Errorf("RedactedRequest operation replayed with error %v, expected its redacted values to be reported", res.Error)
+	}
+	if res := byID["Failed"]; res.Error == nil {
+		t.Error("Failed operation replayed without error")
+	}
+	if sent != 4 {
+		t.Errorf("%d requests sent, expected 4", sent)
+	}
+}
@@ -0,0 +1,361 @@
+package execlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is the format of the time in the names of the rotated
+// files, sorting them chronologically.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// RotatingFileEntryWriterConfig defines the configuration of a
+// RotatingFileEntryWriter.
+type RotatingFileEntryWriterConfig struct {
+	// Path is the path of the file the entries are written to. Rotated files
+	// are kept next to it, named after it with the time of the rotation:
+	// execlog.jsonl is rotated to execlog-20190102T150405.000.jsonl.
+	Path string
+	// MaxSize is the size in bytes above which the file is rotated. The file
+	// is not rotated by size when it is 0.
+	MaxSize int64
+	// MaxAge is how long entries are written to the same file before it is
+	// rotated. The file is not rotated by time when it is 0.
+	MaxAge time.Duration
+	// Compress gzips the rotated files.
+	Compress bool
+	// MaxBackups is the number of rotated files kept, the oldest are removed.
+	// Every rotated file is kept when it is 0.
+	MaxBackups int
+	// MaxBackupAge is how long rotated files are kept. Rotated files are kept
+	// regardless of their age when it is 0.
+	MaxBackupAge time.Duration
+}
+
+// RotatingFileEntryWriter is an EntryWriter writing JSON lines to a file,
+// rotating it by size and time. Rotated files are compressed and removed
+// according to the retention policy in the background.
+type RotatingFileEntryWriter struct {
+	cfg RotatingFileEntryWriterConfig
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+
+	// mill compresses and removes the rotated files, one run at a time
+	millMu sync.Mutex
+	millWG sync.WaitGroup
+}
+
+// NewRotatingFileEntryWriter returns a new RotatingFileEntryWriter, opening
+// the file in append mode. The writer must be closed to release the file.
+func NewRotatingFileEntryWriter(cfg RotatingFileEntryWriterConfig) (*RotatingFileEntryWriter, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("missing execution log file path")
+	}
+	if cfg.MaxSize < 0 || cfg.MaxAge < 0 || cfg.MaxBackups < 0 || cfg.MaxBackupAge < 0 {
+		return nil, errors.New("invalid execution log rotation, limits can not be negative")
+	}
+	w := &RotatingFileEntryWriter{cfg: cfg}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes the entry to the file.
+func (w *RotatingFileEntryWriter) Write(entry *Entry) error {
+	return w.WriteBatch([]*Entry{entry})
+}
+
+// WriteBatch writes the entries to the file at once, rotating it first if
+// they would make it exceed its maximum size or if it is too old.
+func (w *RotatingFileEntryWriter) WriteBatch(entries []*Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if w.size > 0 && w.shouldRotate(int64(buf.Len())) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(buf.Bytes())
+	w.size += int64(n)
+	return err
+}
+
+// Rotate rotates the file regardless of its size and age.
+func (w *RotatingFileEntryWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	return w.rotate()
+}
+
+// Reopen closes the file and opens it again at the configured path. It lets
+// an external tool like logrotate move the file away: the writer keeps
+// writing to the moved file until it is reopened, usually on SIGHUP.
+func (w *RotatingFileEntryWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// Close closes the file and waits for the rotated files to be compressed.
+func (w *RotatingFileEntryWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	err := w.file.Close()
+	w.mu.Unlock()
+
+	w.millWG.Wait()
+	return err
+}
+
+func (w *RotatingFileEntryWriter) shouldRotate(n int64) bool {
+	if w.cfg.MaxSize > 0 && w.size+n > w.cfg.MaxSize {
+		return true
+	}
+	return w.cfg.MaxAge > 0 && time.Since(w.openedAt) >= w.cfg.MaxAge
+}
+
+func (w *RotatingFileEntryWriter) open() error {
+	f, err := os.OpenFile(w.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+func (w *RotatingFileEntryWriter) rotate() error {
+	rotated, err := w.rotatedPath(time.Now())
+	if err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	// the file is reopened even if it could not be moved, to keep writing
+	renameErr := os.Rename(w.cfg.Path, rotated)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	w.millWG.Add(1)
+	go func() {
+		defer w.millWG.Done()
+		w.mill(rotated)
+	}()
+	return nil
+}
+
+// rotatedPath returns the path the file is renamed to when rotated at the
+// given time, which is not used by another rotated file.
+func (w *RotatingFileEntryWriter) rotatedPath(t time.Time) (string, error) {
+	prefix, ext := w.rotatedPrefix()
+	name := prefix + t.Format(rotatedTimeFormat)
+	for i := 1; ; i++ {
+		path := name + ext
+		_, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			_, err = os.Stat(path + ".gz")
+		}
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name = prefix + t.Format(rotatedTimeFormat) + "-" + strconv.Itoa(i)
+	}
+}
+
+func (w *RotatingFileEntryWriter) rotatedPrefix() (prefix, ext string) {
+	ext = filepath.Ext(w.cfg.Path)
+	return strings.TrimSuffix(w.cfg.Path, ext) + "-", ext
+}
+
+// mill compresses the rotated file and applies the retention policy.
+func (w *RotatingFileEntryWriter) mill(rotated string) {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+
+	if w.cfg.Compress {
+		if err := compressFile(rotated); err != nil {
+			log.Println("Failed to compress execution log file:", err.Error())
+		}
+	}
+	if err := w.removeBackups(); err != nil {
+		log.Println("Failed to remove old execution log files:", err.Error())
+	}
+}
+
+// removeBackups removes the rotated files exceeding the retention policy.
+func (w *RotatingFileEntryWriter) removeBackups() error {
+	if w.cfg.MaxBackups == 0 && w.cfg.MaxBackupAge == 0 {
+		return nil
+	}
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	for i, b := range backups {
+		tooMany := w.cfg.MaxBackups > 0 && i < len(backups)-w.cfg.MaxBackups
+		tooOld := w.cfg.MaxBackupAge > 0 && time.Since(b.ModTime()) > w.cfg.MaxBackupAge
+		if tooMany || tooOld {
+			if err := os.Remove(filepath.Join(filepath.Dir(w.cfg.Path), b.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backups returns the rotated files, the oldest first.
+func (w *RotatingFileEntryWriter) backups() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(filepath.Dir(w.cfg.Path))
+	if err != nil {
+		return nil, err
+	}
+	prefix, ext := w.rotatedPrefix()
+	prefix = filepath.Base(prefix)
+	type backup struct {
+		info os.FileInfo
+		// files rotated during the same millisecond are numbered
+		time string
+		n    int
+	}
+	found := make([]backup, 0)
+	for _, info := range infos {
+		name := strings.TrimSuffix(info.Name(), ".gz")
+		if info.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		b := backup{info: info, time: strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)}
+		if i := strings.IndexByte(b.time, '-'); i >= 0 {
+			if b.n, err = strconv.Atoi(b.time[i+1:]); err != nil {
+				continue
+			}
+			b.time = b.time[:i]
+		}
+		if _, err := time.Parse(rotatedTimeFormat, b.time); err != nil {
+			continue
+		}
+		found = append(found, b)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].time != found[j].time {
+			return found[i].time < found[j].time
+		}
+		return found[i].n < found[j].n
+	})
+	backups := make([]os.FileInfo, len(found))
+	for i, b := range found {
+		backups[i] = b.info
+	}
+	return backups, nil
+}
+
+// compressFile gzips the file to path.gz and removes it.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// OpenEntryFile opens an execution log file for reading, decompressing it
+// when its name ends with .gz.
+func OpenEntryFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: gz, file: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
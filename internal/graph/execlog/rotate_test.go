@@ -0,0 +1,191 @@
+package execlog
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRotatingFileEntryWriter(t *testing.T, cfg RotatingFileEntryWriterConfig) (*RotatingFileEntryWriter, string) {
+	dir, err := ioutil.TempDir("", "execlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Path = filepath.Join(dir, "execlog.jsonl")
+	w, err := NewRotatingFileEntryWriter(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewRotatingFileEntryWriter() returned error: %s", err)
+	}
+	return w, dir
+}
+
+// readEntryIDs returns the IDs of the entries of the execution log file.
+func readEntryIDs(t *testing.T, path string) []string {
+	f, err := OpenEntryFile(path)
+	if err != nil {
+		t.Fatalf("OpenEntryFile() returned error: %s", err)
+	}
+	defer f.Close()
+	ids := make([]string, 0)
+	r := NewEntryReader(f)
+	for {
+		entry, err := r.Read()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatalf("Read() returned error: %s", err)
+		}
+		ids = append(ids, entry.ID)
+	}
+}
+
+// rotatedPaths returns the paths of the rotated files, the oldest first.
+func rotatedPaths(t *testing.T, w *RotatingFileEntryWriter) []string {
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(backups))
+	for i, b := range backups {
+		paths[i] = filepath.Join(filepath.Dir(w.cfg.Path), b.Name())
+	}
+	return paths
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names
+}
+
+func TestRotatingFileEntryWriter(t *testing.T) {
+	// an entry with an ID of a single character is 149 bytes long
+	w, dir := newTestRotatingFileEntryWriter(t, RotatingFileEntryWriterConfig{
+		MaxSize:  300,
+		Compress: true,
+	})
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if err := w.Write(&Entry{ID: id}); err != nil {
+			t.Fatalf("Write() returned error: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() returned error: %s", err)
+	}
+
+	if names := dirNames(t, dir); len(names) != 3 {
+		t.Fatalf("execution log files are %v, expected 2 rotated files and execlog.jsonl", names)
+	}
+	rotated := rotatedPaths(t, w)
+	for _, path := range rotated {
+		if name := filepath.Base(path); !strings.HasPrefix(name, "execlog-") || !strings.HasSuffix(name, ".jsonl.gz") {
+			t.Errorf("rotated file %s is not named execlog-<time>.jsonl.gz", name)
+		}
+	}
+	checkIDs(t, readEntryIDs(t, rotated[0]), "a", "b")
+	checkIDs(t, readEntryIDs(t, rotated[1]), "c", "d")
+	checkIDs(t, readEntryIDs(t, w.cfg.Path), "e")
+
+	if err := w.Write(&Entry{ID: "f"}); err != ErrWriterClosed {
+		t.Errorf("Write() after Close() returned %v, expected ErrWriterClosed", err)
+	}
+}
+
+func TestRotatingFileEntryWriter_maxAge(t *testing.T) {
+	w, dir := newTestRotatingFileEntryWriter(t, RotatingFileEntryWriterConfig{MaxAge: 10 * time.Millisecond})
+	defer os.RemoveAll(dir)
+	defer w.Close()
+
+	w.Write(&Entry{ID: "a"})
+	w.Write(&Entry{ID: "b"})
+	time.Sleep(20 * time.Millisecond)
+	w.Write(&Entry{ID: "c"})
+
+	rotated := rotatedPaths(t, w)
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files are %v, expected 1", rotated)
+	}
+	checkIDs(t, readEntryIDs(t, rotated[0]), "a", "b")
+	checkIDs(t, readEntryIDs(t, w.cfg.Path), "c")
+}
+
+func TestRotatingFileEntryWriter_retention(t *testing.T) {
+	t.Run("max backups", func(t *testing.T) {
+		w, dir := newTestRotatingFileEntryWriter(t, RotatingFileEntryWriterConfig{MaxBackups: 2})
+		defer os.RemoveAll(dir)
+
+		for _, id := range []string{"a", "b", "c", "d"} {
+			w.Write(&Entry{ID: id})
+			if err := w.Rotate(); err != nil {
+				t.Fatalf("Rotate() returned error: %s", err)
+			}
+		}
+		w.Close()
+
+		rotated := rotatedPaths(t, w)
+		if len(rotated) != 2 {
+			t.Fatalf("rotated files are %v, expected 2", rotated)
+		}
+		checkIDs(t, readEntryIDs(t, rotated[0]), "c")
+		checkIDs(t, readEntryIDs(t, rotated[1]), "d")
+	})
+
+	t.Run("max backup age", func(t *testing.T) {
+		w, dir := newTestRotatingFileEntryWriter(t, RotatingFileEntryWriterConfig{MaxBackupAge: time.Hour})
+		defer os.RemoveAll(dir)
+
+		old := filepath.Join(dir, "execlog-20190102T150405.000.jsonl.gz")
+		if err := ioutil.WriteFile(old, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(old, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour))
+		unrelated := filepath.Join(dir, "execlog-notes.jsonl")
+		if err := ioutil.WriteFile(unrelated, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(unrelated, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour))
+
+		w.Write(&Entry{ID: "a"})
+		w.Rotate()
+		w.Close()
+
+		names := dirNames(t, dir)
+		if len(names) != 3 || names[0] == filepath.Base(old) || names[2] != "execlog.jsonl" {
+			t.Errorf("execution log files are %v, expected the old rotated file to be removed", names)
+		}
+	})
+}
+
+func TestRotatingFileEntryWriter_Reopen(t *testing.T) {
+	w, dir := newTestRotatingFileEntryWriter(t, RotatingFileEntryWriterConfig{})
+	defer os.RemoveAll(dir)
+	defer w.Close()
+
+	path := filepath.Join(dir, "execlog.jsonl")
+	w.Write(&Entry{ID: "a"})
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(&Entry{ID: "b"})
+	if err := w.Reopen(); err != nil {
+		t.Fatalf("Reopen() returned error: %s", err)
+	}
+	w.Write(&Entry{ID: "c"})
+
+	checkIDs(t, readEntryIDs(t, path+".1"), "a", "b")
+	checkIDs(t, readEntryIDs(t, path), "c")
+}
@@ -0,0 +1,462 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// Subgraph is a graph implementing the federation specification: its _entities
+// field resolves the entities it defines or extends from their
+// representations.
+type Subgraph struct {
+	Graph graph.Graph
+	// SDL is the schema of the graph along with its federation directives,
+	// as returned by its _service field.
+	SDL string
+}
+
+// FetchSDL returns the SDL the graph exposes through its _service field.
+func FetchSDL(ctx context.Context, g graph.Graph, transport http.RoundTripper) (string, error) {
+	res, err := g.Execute(ctx, &graph.Request{Query: "{ _service { sdl } }"}, transport)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute service query: %s", err)
+	}
+	if len(res.Errors) > 0 {
+		msgs := make([]string, len(res.Errors))
+		for i, e := range res.Errors {
+			msgs[i] = e.Message
+		}
+		return "", fmt.Errorf("service query failed: %s", strings.Join(msgs, "; "))
+	}
+	var result struct {
+		Service *struct {
+			SDL string `json:"sdl"`
+		} `json:"_service"`
+	}
+	data, err := json.Marshal(res.Data)
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil || result.Service == nil {
+		return "", errors.New("service query returned no SDL")
+	}
+	return result.Service.SDL, nil
+}
+
+// federationTypes and federationDirectives are declared by the subgraphs for
+// the needs of the federation, they are not part of the supergraph.
+var (
+	federationTypes = map[string]bool{
+		"_Any":      true,
+		"_FieldSet": true,
+		"_Service":  true,
+		"_Entity":   true,
+	}
+	federationDirectives = map[string]bool{
+		"key":      true,
+		"external": true,
+		"requires": true,
+		"provides": true,
+		"extends":  true,
+	}
+)
+
+// subgraph is a subgraph along with the federation directives of its SDL.
+type subgraph struct {
+	*Subgraph
+	name string
+	cfg  *schema.SchemaConfig
+	// keys are the fields identifying the entities of the subgraph, by type
+	// name, only the first @key of a type is used
+	keys map[string]*ast.SelectionSet
+	// requires, provides and external are indexed by field coordinate,
+	// Type.field
+	requires map[string]*ast.SelectionSet
+	provides map[string]*ast.SelectionSet
+	external map[string]bool
+}
+
+// Supergraph is the schema composed of the schemas of several subgraphs,
+// along with the subgraphs resolving each of its fields.
+type Supergraph struct {
+	schema    schema.Schema
+	subgraphs []*subgraph
+	// resolvers are the subgraphs resolving the fields, by coordinate
+	resolvers map[string][]*subgraph
+}
+
+// Schema returns the composed schema.
+func (s *Supergraph) Schema() schema.Schema { return s.schema }
+
+// Compose composes the schemas of the subgraphs into a supergraph.
+//
+// An entity, a type with a @key, may be defined by a subgraph and extended by
+// the others, each field of an entity is resolved by a single subgraph apart
+// from its key fields. The other types defined by several subgraphs must be
+// the same, as must be the fields they define on an entity. Root fields are
+// resolved by a single subgraph. The subscription types are left out.
+func Compose(subgraphs []*Subgraph) (*Supergraph, error) {
+	c := &composer{
+		sg: &Supergraph{
+			resolvers: make(map[string][]*subgraph),
+		},
+		types:      make(map[string]*schema.TypeConfig),
+		typeDefs:   make(map[string][]*typeDef),
+		directives: make(map[string]*schema.DirectiveConfig),
+		dirGraphs:  make(map[string]string),
+		fieldDefs:  make(map[string]string),
+	}
+	for _, s := range subgraphs {
+		sub, err := parseSubgraph(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range c.sg.subgraphs {
+			if other.name == sub.name {
+				return nil, fmt.Errorf("graph %s is composed more than once", sub.name)
+			}
+		}
+		c.sg.subgraphs = append(c.sg.subgraphs, sub)
+		if err := c.add(sub); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.types["Query"]; ok {
+		c.cfg.QueryType = &schema.TypeRefConfig{Name: "Query"}
+	}
+	if _, ok := c.types["Mutation"]; ok {
+		c.cfg.MutationType = &schema.TypeRefConfig{Name: "Mutation"}
+	}
+	s, err := schema.NewSchema(&c.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid composed schema: %s", err)
+	}
+	c.sg.schema = s
+	return c.sg, nil
+}
+
+// typeDef is the definition of a type by a subgraph.
+type typeDef struct {
+	sub *subgraph
+	cfg *schema.TypeConfig
+}
+
+type composer struct {
+	sg         *Supergraph
+	cfg        schema.SchemaConfig
+	types      map[string]*schema.TypeConfig
+	typeDefs   map[string][]*typeDef
+	directives map[string]*schema.DirectiveConfig
+	dirGraphs  map[string]string
+	// fieldDefs are the graphs that first defined the fields, by coordinate
+	fieldDefs map[string]string
+}
+
+func (c *composer) add(sub *subgraph) error {
+	for _, t := range sub.cfg.Types {
+		if t.Kind == schema.TypeKindObject && len(t.Fields) == 0 {
+			// the root types of subgraphs only extending entities
+			continue
+		}
+		if err := c.addType(sub, t); err != nil {
+			return err
+		}
+	}
+	for _, d := range sub.cfg.Directives {
+		existing, ok := c.directives[d.Name]
+		if !ok {
+			c.directives[d.Name] = d
+			c.dirGraphs[d.Name] = sub.name
+			c.cfg.Directives = append(c.cfg.Directives, d)
+			continue
+		}
+		if schema.DirectiveSignature(existing) != schema.DirectiveSignature(d) {
+			return fmt.Errorf("directive \"%s\" of graph %s conflicts with the one of graph %s", d.Name, sub.name, c.dirGraphs[d.Name])
+		}
+	}
+	return nil
+}
+
+func (c *composer) addType(sub *subgraph, t *schema.TypeConfig) error {
+	defs := c.typeDefs[t.Name]
+	c.typeDefs[t.Name] = append(defs, &typeDef{sub: sub, cfg: t})
+	existing, ok := c.types[t.Name]
+	if !ok {
+		merged := *t
+		merged.Fields = nil
+		merged.Interfaces = append([]*schema.TypeRefConfig{}, t.Interfaces...)
+		c.types[t.Name] = &merged
+		c.cfg.Types = append(c.cfg.Types, &merged)
+		existing = &merged
+	} else if t.Kind != schema.TypeKindObject || existing.Kind != schema.TypeKindObject {
+		if schema.TypeSignature(existing) != schema.TypeSignature(t) {
+			return fmt.Errorf("type \"%s\" of graph %s conflicts with the one of graph %s", t.Name, sub.name, defs[0].sub.name)
+		}
+	}
+	if t.Kind != schema.TypeKindObject && t.Kind != schema.TypeKindInterface {
+		return nil
+	}
+
+	// the fields of the object types are the union of the subgraphs ones
+	for _, f := range t.Fields {
+		coord := t.Name + "." + f.Name
+		if !sub.external[coord] {
+			c.sg.resolvers[coord] = append(c.sg.resolvers[coord], sub)
+		}
+		var field *schema.FieldConfig
+		for _, ef := range existing.Fields {
+			if ef.Name == f.Name {
+				field = ef
+			}
+		}
+		if field == nil {
+			existing.Fields = append(existing.Fields, f)
+			c.fieldDefs[coord] = sub.name
+			continue
+		}
+		if fieldSignature(field) != fieldSignature(f) {
+			return fmt.Errorf("field \"%s\" of graph %s conflicts with the one of graph %s", coord, sub.name, c.fieldDefs[coord])
+		}
+	}
+	for _, i := range t.Interfaces {
+		implemented := false
+		for _, ei := range existing.Interfaces {
+			implemented = implemented || ei.Name == i.Name
+		}
+		if !implemented {
+			existing.Interfaces = append(existing.Interfaces, i)
+		}
+	}
+	return nil
+}
+
+// check checks that every field of the composed types has a single resolver,
+// unless it is a key field or a field of a value type.
+func (c *composer) check() error {
+	for _, t := range c.cfg.Types {
+		if t.Kind != schema.TypeKindObject {
+			continue
+		}
+		isRoot := t.Name == "Query" || t.Name == "Mutation"
+		entity := false
+		for _, def := range c.typeDefs[t.Name] {
+			entity = entity || def.sub.keys[t.Name] != nil
+		}
+		if !entity && !isRoot {
+			// value types are the same in every subgraph
+			defs := c.typeDefs[t.Name]
+			for _, def := range defs[1:] {
+				if schema.TypeSignature(def.cfg) != schema.TypeSignature(defs[0].cfg) {
+					return fmt.Errorf("type \"%s\" of graph %s conflicts with the one of graph %s", t.Name, def.sub.name, defs[0].sub.name)
+				}
+			}
+			continue
+		}
+		for _, f := range t.Fields {
+			coord := t.Name + "." + f.Name
+			resolvers := c.sg.resolvers[coord]
+			switch {
+			case len(resolvers) == 0:
+				return fmt.Errorf("field \"%s\" is external in every graph", coord)
+			case len(resolvers) == 1:
+				continue
+			case isRoot:
+				return fmt.Errorf("%s root field \"%s\" of graph %s conflicts with the one of graph %s", strings.ToLower(t.Name), f.Name, resolvers[1].name, resolvers[0].name)
+			}
+			for _, sub := range resolvers {
+				if !selectsField(sub.keys[t.Name], f.Name) {
+					return fmt.Errorf("field \"%s\" is resolved by both graphs %s and %s", coord, resolvers[0].name, resolvers[1].name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseSubgraph parses the SDL of the subgraph. The federation types,
+// directives and fields are left out of its config, and the extensions of
+// the types it does not define become definitions.
+func parseSubgraph(s *Subgraph) (*subgraph, error) {
+	sub := &subgraph{
+		Subgraph: s,
+		name:     s.Graph.ID(),
+		keys:     make(map[string]*ast.SelectionSet),
+		requires: make(map[string]*ast.SelectionSet),
+		provides: make(map[string]*ast.SelectionSet),
+		external: make(map[string]bool),
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(s.SDL),
+			Name: "GraphQL SDL",
+		}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid SDL of graph %s: %s", sub.name, err)
+	}
+
+	defined := make(map[string]bool)
+	for _, def := range doc.Definitions {
+		if def, ok := def.(*ast.ObjectDefinition); ok {
+			defined[def.Name.Value] = true
+		}
+	}
+	defs := make([]ast.Node, 0, len(doc.Definitions))
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.ObjectDefinition:
+			obj, err := sub.objectDefinition(def)
+			if err != nil {
+				return nil, err
+			}
+			if obj != nil {
+				defs = append(defs, obj)
+			}
+		case *ast.TypeExtensionDefinition:
+			obj, err := sub.objectDefinition(def.Definition)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case obj == nil:
+			case !defined[obj.Name.Value]:
+				defined[obj.Name.Value] = true
+				defs = append(defs, obj)
+			default:
+				defs = append(defs, ast.NewTypeExtensionDefinition(&ast.TypeExtensionDefinition{Definition: obj}))
+			}
+		case *ast.ScalarDefinition:
+			if !federationTypes[def.Name.Value] {
+				defs = append(defs, def)
+			}
+		case *ast.UnionDefinition:
+			if !federationTypes[def.Name.Value] {
+				defs = append(defs, def)
+			}
+		case *ast.DirectiveDefinition:
+			if !federationDirectives[def.Name.Value] {
+				defs = append(defs, def)
+			}
+		default:
+			defs = append(defs, def)
+		}
+	}
+
+	cfg, err := schema.ParseSDLDocument(ast.NewDocument(&ast.Document{Definitions: defs}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SDL of graph %s: %s", sub.name, err)
+	}
+	for _, root := range []*schema.TypeRefConfig{cfg.QueryType, cfg.MutationType} {
+		if root != nil && root.Name != "Query" && root.Name != "Mutation" {
+			return nil, fmt.Errorf("root type \"%s\" of graph %s must be named Query or Mutation", root.Name, sub.name)
+		}
+	}
+	if cfg.SubscriptionType != nil {
+		types := make([]*schema.TypeConfig, 0, len(cfg.Types))
+		for _, t := range cfg.Types {
+			if t.Name != cfg.SubscriptionType.Name {
+				types = append(types, t)
+			}
+		}
+		cfg.Types = types
+		cfg.SubscriptionType = nil
+	}
+	sub.cfg = cfg
+	return sub, nil
+}
+
+// objectDefinition reads the federation directives of the object definition
+// and returns it without the federation types and fields.
+func (sub *subgraph) objectDefinition(def *ast.ObjectDefinition) (*ast.ObjectDefinition, error) {
+	name := def.Name.Value
+	if federationTypes[name] {
+		return nil, nil
+	}
+	for _, d := range def.Directives {
+		if d.Name.Value != "key" || sub.keys[name] != nil {
+			continue
+		}
+		set, err := fieldSet(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid @key of type \"%s\" of graph %s: %s", name, sub.name, err)
+		}
+		sub.keys[name] = set
+	}
+
+	fields := make([]*ast.FieldDefinition, 0, len(def.Fields))
+	for _, f := range def.Fields {
+		if name == "Query" && (f.Name.Value == "_service" || f.Name.Value == "_entities") {
+			continue
+		}
+		coord := name + "." + f.Name.Value
+		for _, d := range f.Directives {
+			var err error
+			switch d.Name.Value {
+			case "external":
+				sub.external[coord] = true
+			case "requires":
+				sub.requires[coord], err = fieldSet(d)
+			case "provides":
+				sub.provides[coord], err = fieldSet(d)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid @%s of field \"%s\" of graph %s: %s", d.Name.Value, coord, sub.name, err)
+			}
+		}
+		fields = append(fields, f)
+	}
+	return ast.NewObjectDefinition(&ast.ObjectDefinition{
+		Name:        def.Name,
+		Description: def.Description,
+		Interfaces:  def.Interfaces,
+		Fields:      fields,
+	}), nil
+}
+
+// fieldSet parses the fields argument of a federation directive.
+func fieldSet(d *ast.Directive) (*ast.SelectionSet, error) {
+	for _, arg := range d.Arguments {
+		v, ok := arg.Value.(*ast.StringValue)
+		if arg.Name.Value != "fields" || !ok {
+			continue
+		}
+		doc, err := parser.Parse(parser.ParseParams{Source: "{" + v.Value + "}"})
+		if err != nil {
+			return nil, err
+		}
+		return doc.Definitions[0].(*ast.OperationDefinition).SelectionSet, nil
+	}
+	return nil, errors.New("missing fields argument")
+}
+
+// selectsField tells whether the field is one of the fields of the set.
+func selectsField(set *ast.SelectionSet, name string) bool {
+	if set == nil {
+		return false
+	}
+	for _, sel := range set.Selections {
+		if f, ok := sel.(*ast.Field); ok && f.Name.Value == name {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldSignature(f *schema.FieldConfig) string {
+	return schema.TypeSignature(&schema.TypeConfig{Fields: []*schema.FieldConfig{f}})
+}
@@ -0,0 +1,542 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/validation"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// GatewayConfig defines the configuration of a federation gateway.
+type GatewayConfig struct {
+	// Name is the ID of the gateway graph.
+	Name      string
+	Subgraphs []*Subgraph
+}
+
+// Gateway is a graph serving the supergraph composed of several subgraphs.
+// The operations it executes are planned into fetches of root fields and of
+// entities, sent to the subgraphs resolving them. Subscriptions are not
+// supported.
+type Gateway struct {
+	name       string
+	supergraph *Supergraph
+	executable *graphql.Schema
+	validator  *validation.Validator
+}
+
+var _ graph.Graph = (*Gateway)(nil)
+
+// NewGateway composes the subgraphs and returns the gateway serving the
+// supergraph.
+func NewGateway(cfg *GatewayConfig) (*Gateway, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("missing gateway name")
+	}
+	sg, err := Compose(cfg.Subgraphs)
+	if err != nil {
+		return nil, err
+	}
+	executable, err := validation.NewExecutableSchema(sg.Schema())
+	if err != nil {
+		return nil, fmt.Errorf("invalid composed schema: %s", err)
+	}
+	validator, err := validation.NewValidator(sg.Schema())
+	if err != nil {
+		return nil, err
+	}
+	return &Gateway{
+		name:       cfg.Name,
+		supergraph: sg,
+		executable: executable,
+		validator:  validator,
+	}, nil
+}
+
+func (g *Gateway) ID() string { return g.name }
+
+// Schema returns the supergraph schema served by the gateway.
+func (g *Gateway) Schema() schema.Schema { return g.supergraph.Schema() }
+
+// Plan returns the plan of the operation of the request.
+func (g *Gateway) Plan(req *graph.Request) (PlanNode, error) {
+	op, fragments, errs := g.operation(req)
+	if len(errs) > 0 {
+		return nil, errors.New(errs[0].Message)
+	}
+	return g.planner(op, fragments).plan()
+}
+
+// Execute plans the operation of the request and executes its plan. The
+// response holds the data and errors of every subgraph, the returned error
+// reports the subgraphs that could not be reached.
+func (g *Gateway) Execute(ctx context.Context, req *graph.Request, transport http.RoundTripper) (*graph.Response, error) {
+	op, fragments, errs := g.operation(req)
+	if len(errs) > 0 {
+		return &graph.Response{Errors: errs}, nil
+	}
+	p := g.planner(op, fragments)
+	plan, err := p.plan()
+	if err != nil {
+		return &graph.Response{Errors: []*graph.Error{{Message: err.Error()}}}, nil
+	}
+
+	e := &execution{
+		gateway:   g,
+		ctx:       ctx,
+		transport: transport,
+		req:       req,
+		data:      make(map[string]interface{}),
+	}
+	e.run(plan)
+
+	root := g.supergraph.schema.QueryType()
+	if op.Operation == graph.OperationTypeMutation {
+		root = g.supergraph.schema.MutationType()
+	}
+	pr := &pruner{
+		schema:    g.supergraph.schema,
+		fragments: fragments,
+		variables: make(map[string]interface{}),
+	}
+	for _, def := range op.VariableDefinitions {
+		name := def.Variable.Name.Value
+		if v, ok := req.Variables[name]; ok {
+			pr.variables[name] = v
+		} else if v, ok := def.DefaultValue.(*ast.BooleanValue); ok {
+			pr.variables[name] = v.Value
+		}
+	}
+	res := &graph.Response{Errors: e.errors}
+	if data := make(map[string]interface{}); pr.fields(e.data, op.SelectionSet, root, data) {
+		res.Data = data
+	}
+	if len(e.failures) > 0 {
+		return res, fmt.Errorf("failed to execute graph requests: %s", strings.Join(e.failures, "; "))
+	}
+	return res, nil
+}
+
+// operation returns the validated operation of the request and the fragments
+// of its document.
+func (g *Gateway) operation(req *graph.Request) (*ast.OperationDefinition, map[string]*ast.FragmentDefinition, []*graph.Error) {
+	doc, err := req.ParseQuery()
+	if err != nil {
+		return nil, nil, []*graph.Error{{Message: err.Error()}}
+	}
+	if errs := g.validator.ValidateDocument(doc); len(errs) > 0 {
+		return nil, nil, errs
+	}
+	op, err := graph.SelectOperation(doc, req.OperationName)
+	if err != nil {
+		return nil, nil, []*graph.Error{{Message: err.Error()}}
+	}
+	if op.Operation == "" {
+		op.Operation = graph.OperationTypeQuery
+	}
+	if op.Operation == graph.OperationTypeSubscription {
+		return nil, nil, []*graph.Error{{Message: "subscriptions are not supported by the gateway"}}
+	}
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[frag.Name.Value] = frag
+		}
+	}
+	return op, fragments, nil
+}
+
+func (g *Gateway) planner(op *ast.OperationDefinition, fragments map[string]*ast.FragmentDefinition) *planner {
+	return &planner{
+		sg:            g.supergraph,
+		op:            op,
+		fragments:     fragments,
+		entityFetches: make(map[string]*fetch),
+	}
+}
+
+// execution is the execution of a plan. The data of the fetches is merged as
+// they complete.
+type execution struct {
+	gateway   *Gateway
+	ctx       context.Context
+	transport http.RoundTripper
+	req       *graph.Request
+
+	mu       sync.Mutex
+	data     map[string]interface{}
+	errors   []*graph.Error
+	failures []string
+}
+
+func (e *execution) run(n PlanNode) {
+	switch n := n.(type) {
+	case *Sequence:
+		for _, child := range n.Nodes {
+			e.run(child)
+		}
+	case *Parallel:
+		var wg sync.WaitGroup
+		for _, child := range n.Nodes {
+			wg.Add(1)
+			go func(child PlanNode) {
+				defer wg.Done()
+				e.run(child)
+			}(child)
+		}
+		wg.Wait()
+	case *Fetch:
+		if n.fetch.typeName != "" {
+			e.fetchEntities(n.fetch)
+		} else {
+			e.fetchRoot(n.fetch)
+		}
+	}
+}
+
+func (e *execution) variables(f *fetch) map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, name := range f.variables {
+		if v, ok := e.req.Variables[name]; ok {
+			vars[name] = v
+		}
+	}
+	return vars
+}
+
+func (e *execution) fetchRoot(f *fetch) {
+	var res *graph.Response
+	var err error
+	if f.sub == nil {
+		res, err = e.executeLocally(f)
+	} else {
+		req := &graph.Request{Query: graph.PrintDocument(f.doc), Variables: e.variables(f)}
+		if name := f.doc.Definitions[0].(*ast.OperationDefinition).Name; name != nil {
+			req.OperationName = name.Value
+		}
+		res, err = f.sub.Graph.Execute(e.ctx, req, e.transport)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		graphID := e.gateway.name
+		if f.sub != nil {
+			graphID = f.sub.name
+		}
+		e.failures = append(e.failures, fmt.Sprintf("%s: %s", graphID, err))
+		for _, key := range f.keys {
+			e.data[key] = nil
+			e.errors = append(e.errors, &graph.Error{
+				Message:    fmt.Sprintf("Failed to execute graph %s request.", graphID),
+				Path:       []interface{}{key},
+				Extensions: graph.ErrorExtensions(err),
+			})
+		}
+		return
+	}
+	if data, ok := res.Data.(map[string]interface{}); ok {
+		mergeObjects(e.data, data)
+	} else {
+		for _, key := range f.keys {
+			e.data[key] = nil
+		}
+	}
+	for _, err := range res.Errors {
+		// the locations point into the fetch document, not the client one
+		if f.sub != nil {
+			err.Locations = nil
+		}
+		e.errors = append(e.errors, err)
+	}
+}
+
+// executeLocally executes the fetch on the supergraph schema.
+func (e *execution) executeLocally(f *fetch) (*graph.Response, error) {
+	return validation.Execute(e.ctx, e.gateway.executable, f.doc, e.variables(f))
+}
+
+// fetchEntities fetches the fields of the entities found at the path of the
+// fetch in the data, and merges them into the entities.
+func (e *execution) fetchEntities(f *fetch) {
+	e.mu.Lock()
+	entities := make([]*entity, 0)
+	collectEntities(e.data, f.path, f.typeName, nil, &entities)
+	representations := make([]interface{}, len(entities))
+	for i, ent := range entities {
+		representations[i] = representation(ent.object, f.representation)
+	}
+	e.mu.Unlock()
+	if len(entities) == 0 {
+		return
+	}
+
+	vars := e.variables(f)
+	vars["representations"] = representations
+	res, err := f.sub.Graph.Execute(e.ctx, &graph.Request{Query: graph.PrintDocument(f.doc), Variables: vars}, e.transport)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.failures = append(e.failures, fmt.Sprintf("%s: %s", f.sub.name, err))
+		e.errors = append(e.errors, &graph.Error{
+			Message:    fmt.Sprintf("Failed to execute graph %s request.", f.sub.name),
+			Extensions: graph.ErrorExtensions(err),
+		})
+		return
+	}
+	data, _ := res.Data.(map[string]interface{})
+	results, _ := data["_entities"].([]interface{})
+	for i, result := range results {
+		if object, ok := result.(map[string]interface{}); ok && i < len(entities) {
+			mergeObjects(entities[i].object, object)
+		}
+	}
+	for _, err := range res.Errors {
+		err.Locations = nil
+		// the paths of the entities errors are the ones of the entities
+		if len(err.Path) >= 2 && err.Path[0] == "_entities" {
+			if i, ok := err.Path[1].(float64); ok && int(i) < len(entities) {
+				err.Path = append(append([]interface{}{}, entities[int(i)].path...), err.Path[2:]...)
+			}
+		}
+		e.errors = append(e.errors, err)
+	}
+}
+
+// entity is an object of the data along with its response path.
+type entity struct {
+	object map[string]interface{}
+	path   []interface{}
+}
+
+// collectEntities collects the objects of the given type found at the path
+// in the data.
+func collectEntities(data interface{}, path []string, typeName string, at []interface{}, entities *[]*entity) {
+	if len(path) == 0 {
+		if object, ok := data.(map[string]interface{}); ok && object["__typename"] == typeName {
+			*entities = append(*entities, &entity{object: object, path: at})
+		}
+		return
+	}
+	if path[0] == "@" {
+		items, _ := data.([]interface{})
+		for i, item := range items {
+			collectEntities(item, path[1:], typeName, append(append([]interface{}{}, at...), i), entities)
+		}
+		return
+	}
+	if object, ok := data.(map[string]interface{}); ok {
+		collectEntities(object[path[0]], path[1:], typeName, append(append([]interface{}{}, at...), path[0]), entities)
+	}
+}
+
+// representation returns the fields of the object selected by the
+// representation selections.
+func representation(data interface{}, sels []ast.Selection) interface{} {
+	switch data := data.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(data))
+		for i, item := range data {
+			items[i] = representation(item, sels)
+		}
+		return items
+	case map[string]interface{}:
+		rep := make(map[string]interface{})
+		for _, sel := range sels {
+			field, ok := sel.(*ast.Field)
+			if !ok {
+				continue
+			}
+			name := field.Name.Value
+			if field.SelectionSet != nil {
+				rep[name] = representation(data[name], field.SelectionSet.Selections)
+			} else {
+				rep[name] = data[name]
+			}
+		}
+		return rep
+	}
+	return data
+}
+
+// mergeObjects merges the fields of the source object into the destination
+// one, recursively.
+func mergeObjects(dst, src map[string]interface{}) {
+	for key, v := range src {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if existing, ok := dst[key].(map[string]interface{}); ok {
+				mergeObjects(existing, v)
+				continue
+			}
+		case []interface{}:
+			if existing, ok := dst[key].([]interface{}); ok && len(existing) == len(v) {
+				for i, item := range v {
+					existingItem, ok := existing[i].(map[string]interface{})
+					if itemObject, isObject := item.(map[string]interface{}); ok && isObject {
+						mergeObjects(existingItem, itemObject)
+					}
+				}
+				continue
+			}
+		}
+		dst[key] = v
+	}
+}
+
+// pruner shapes the merged data after the client selection: the fields the
+// gateway added to the fetches are left out, and a null non-null field nulls
+// its parent.
+type pruner struct {
+	schema    schema.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// value returns the value of the given type, or false when it is null but
+// must not be.
+func (p *pruner) value(v interface{}, set *ast.SelectionSet, t schema.Type) (interface{}, bool) {
+	if t.Kind() == schema.TypeKindNonNull {
+		pruned, ok := p.value(v, set, t.OfType())
+		return pruned, ok && pruned != nil
+	}
+	if v == nil {
+		return nil, true
+	}
+	switch t.Kind() {
+	case schema.TypeKindList:
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, true
+		}
+		pruned := make([]interface{}, len(items))
+		for i, item := range items {
+			if pruned[i], ok = p.value(item, set, t.OfType()); !ok {
+				return nil, true
+			}
+		}
+		return pruned, true
+	case schema.TypeKindObject, schema.TypeKindInterface, schema.TypeKindUnion:
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, true
+		}
+		pruned := make(map[string]interface{})
+		if !p.fields(object, set, p.schema.Type(t.Name()), pruned) {
+			return nil, true
+		}
+		return pruned, true
+	}
+	return v, true
+}
+
+// fields adds the fields of the object selected by the set to the pruned
+// object. It returns false when a non-null field is null.
+func (p *pruner) fields(object map[string]interface{}, set *ast.SelectionSet, parent schema.Type, pruned map[string]interface{}) bool {
+	typeName, _ := object["__typename"].(string)
+	if typeName == "" {
+		typeName = parent.Name()
+	}
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if !p.included(sel.Directives) {
+				continue
+			}
+			key := graph.ResponseKey(sel)
+			if sel.Name.Value == "__typename" {
+				pruned[key] = typeName
+				continue
+			}
+			def := parent.Field(sel.Name.Value)
+			if def == nil {
+				// the introspection fields are executed by the gateway
+				if strings.HasPrefix(sel.Name.Value, "__") {
+					pruned[key] = object[key]
+				}
+				continue
+			}
+			v, ok := p.value(object[key], sel.SelectionSet, def.Type())
+			if !ok {
+				return false
+			}
+			// the fields selected several times are merged
+			existing, isObject := pruned[key].(map[string]interface{})
+			if vObject, ok := v.(map[string]interface{}); isObject && ok {
+				mergeObjects(existing, vObject)
+			} else {
+				pruned[key] = v
+			}
+		case *ast.InlineFragment:
+			t := parent
+			if sel.TypeCondition != nil {
+				t = p.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			if p.included(sel.Directives) && p.applies(t, typeName) && !p.fields(object, sel.SelectionSet, t, pruned) {
+				return false
+			}
+		case *ast.FragmentSpread:
+			frag, ok := p.fragments[sel.Name.Value]
+			if !ok {
+				continue
+			}
+			t := p.schema.Type(frag.TypeCondition.Name.Value)
+			if p.included(sel.Directives) && p.applies(t, typeName) && !p.fields(object, frag.SelectionSet, t, pruned) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applies tells whether a fragment on the given type applies to an object of
+// the given type name.
+func (p *pruner) applies(t schema.Type, typeName string) bool {
+	if t == nil {
+		return false
+	}
+	if t.Name() == typeName {
+		return true
+	}
+	for _, pt := range t.PossibleTypes() {
+		if pt.Name() == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// included evaluates the @skip and @include directives.
+func (p *pruner) included(dirs []*ast.Directive) bool {
+	for _, d := range dirs {
+		if d.Name.Value != "skip" && d.Name.Value != "include" {
+			continue
+		}
+		for _, arg := range d.Arguments {
+			if arg.Name.Value != "if" {
+				continue
+			}
+			var value bool
+			switch v := arg.Value.(type) {
+			case *ast.BooleanValue:
+				value = v.Value
+			case *ast.Variable:
+				value, _ = p.variables[v.Name.Value].(bool)
+			}
+			if value == (d.Name.Value == "skip") {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,554 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/handler"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// testSubgraph defines a federated graph, its _entities field resolves the
+// representations with resolveEntity.
+type testSubgraph struct {
+	sdl           string
+	query         graphql.Fields
+	entities      []*graphql.Object
+	resolveEntity func(rep map[string]interface{}) interface{}
+}
+
+func (ts *testSubgraph) schema() graphql.Schema {
+	anyScalar := graphql.NewScalar(graphql.ScalarConfig{
+		Name:         "_Any",
+		Serialize:    func(v interface{}) interface{} { return v },
+		ParseValue:   func(v interface{}) interface{} { return v },
+		ParseLiteral: func(v ast.Value) interface{} { return nil },
+	})
+	service := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "_Service",
+		Fields: graphql.Fields{"sdl": &graphql.Field{Type: graphql.String}},
+	})
+	fields := graphql.Fields{
+		"_service": &graphql.Field{
+			Type: graphql.NewNonNull(service),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return map[string]interface{}{"sdl": ts.sdl}, nil
+			},
+		},
+	}
+	for name, f := range ts.query {
+		fields[name] = f
+	}
+	if len(ts.entities) > 0 {
+		entity := graphql.NewUnion(graphql.UnionConfig{
+			Name:  "_Entity",
+			Types: ts.entities,
+			ResolveType: func(p graphql.ResolveTypeParams) *graphql.Object {
+				typeName := p.Value.(map[string]interface{})["__typename"]
+				for _, obj := range ts.entities {
+					if obj.Name() == typeName {
+						return obj
+					}
+				}
+				return nil
+			},
+		})
+		fields["_entities"] = &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(entity)),
+			Args: graphql.FieldConfigArgument{
+				"representations": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(anyScalar))),
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				reps := p.Args["representations"].([]interface{})
+				entities := make([]interface{}, len(reps))
+				for i, rep := range reps {
+					entities[i] = ts.resolveEntity(rep.(map[string]interface{}))
+				}
+				return entities, nil
+			},
+		}
+	}
+	s, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: fields}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+var accountsSubgraph = func() *testSubgraph {
+	users := map[string]map[string]interface{}{
+		"1": {"__typename": "User", "id": "1", "name": "Ada Lovelace", "username": "@ada"},
+		"2": {"__typename": "User", "id": "2", "name": "Alan Turing", "username": "@complete"},
+	}
+	user := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":     &graphql.Field{Type: graphql.String},
+			"username": &graphql.Field{Type: graphql.String},
+		},
+	})
+	return &testSubgraph{
+		sdl: `
+			extend type Query {
+				me: User
+			}
+
+			type User @key(fields: "id") {
+				id: ID!
+				name: String
+				username: String
+			}`,
+		query: graphql.Fields{
+			"me": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return users["1"], nil
+				},
+			},
+		},
+		entities: []*graphql.Object{user},
+		resolveEntity: func(rep map[string]interface{}) interface{} {
+			return users[rep["id"].(string)]
+		},
+	}
+}()
+
+var productsSubgraph = func() *testSubgraph {
+	products := []interface{}{
+		map[string]interface{}{"__typename": "Product", "upc": "1", "name": "Table", "price": 899, "weight": 100},
+		map[string]interface{}{"__typename": "Product", "upc": "2", "name": "Couch", "price": 1299, "weight": 1000},
+		map[string]interface{}{"__typename": "Product", "upc": "3", "name": "Chair", "price": 54, "weight": 50},
+	}
+	product := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"upc":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"name":   &graphql.Field{Type: graphql.String},
+			"price":  &graphql.Field{Type: graphql.Int},
+			"weight": &graphql.Field{Type: graphql.Int},
+		},
+	})
+	return &testSubgraph{
+		sdl: `
+			extend type Query {
+				topProducts(first: Int = 5): [Product]
+			}
+
+			type Product @key(fields: "upc") {
+				upc: String!
+				name: String
+				price: Int
+				weight: Int
+			}`,
+		query: graphql.Fields{
+			"topProducts": &graphql.Field{
+				Type: graphql.NewList(product),
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 5},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if first := p.Args["first"].(int); first < len(products) {
+						return products[:first], nil
+					}
+					return products, nil
+				},
+			},
+		},
+		entities: []*graphql.Object{product},
+		resolveEntity: func(rep map[string]interface{}) interface{} {
+			for _, p := range products {
+				if p.(map[string]interface{})["upc"] == rep["upc"] {
+					return p
+				}
+			}
+			return nil
+		},
+	}
+}()
+
+var reviewsSubgraph = func() *testSubgraph {
+	reviews := []map[string]interface{}{
+		{"id": "1", "authorID": "1", "upc": "1", "body": "Love it!"},
+		{"id": "2", "authorID": "1", "upc": "2", "body": "Too expensive."},
+		{"id": "3", "authorID": "2", "upc": "3", "body": "Could be better."},
+		{"id": "4", "authorID": "2", "upc": "1", "body": "Prefer something else."},
+	}
+	usernames := map[string]string{"1": "@ada", "2": "@complete"}
+	filter := func(key string, value interface{}) []interface{} {
+		matching := make([]interface{}, 0)
+		for _, r := range reviews {
+			if r[key] == value {
+				matching = append(matching, r)
+			}
+		}
+		return matching
+	}
+
+	user := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"username": &graphql.Field{Type: graphql.String},
+		},
+	})
+	product := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"upc": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	review := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Review",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"body": &graphql.Field{Type: graphql.String},
+			"author": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id := p.Source.(map[string]interface{})["authorID"].(string)
+					return map[string]interface{}{"__typename": "User", "id": id, "username": usernames[id]}, nil
+				},
+			},
+			"product": &graphql.Field{
+				Type: product,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return map[string]interface{}{"__typename": "Product", "upc": p.Source.(map[string]interface{})["upc"]}, nil
+				},
+			},
+		},
+	})
+	user.AddFieldConfig("reviews", &graphql.Field{
+		Type: graphql.NewList(review),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return filter("authorID", p.Source.(map[string]interface{})["id"]), nil
+		},
+	})
+	product.AddFieldConfig("reviews", &graphql.Field{
+		Type: graphql.NewList(review),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return filter("upc", p.Source.(map[string]interface{})["upc"]), nil
+		},
+	})
+	return &testSubgraph{
+		sdl: `
+			type Review @key(fields: "id") {
+				id: ID!
+				body: String
+				author: User @provides(fields: "username")
+				product: Product
+			}
+
+			extend type User @key(fields: "id") {
+				id: ID! @external
+				username: String @external
+				reviews: [Review]
+			}
+
+			extend type Product @key(fields: "upc") {
+				upc: String! @external
+				reviews: [Review]
+			}`,
+		entities: []*graphql.Object{user, product, review},
+		resolveEntity: func(rep map[string]interface{}) interface{} {
+			return rep
+		},
+	}
+}()
+
+var inventorySubgraph = func() *testSubgraph {
+	inStock := map[string]bool{"1": true, "2": false, "3": true}
+	product := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"upc":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"weight":  &graphql.Field{Type: graphql.Int},
+			"price":   &graphql.Field{Type: graphql.Int},
+			"inStock": &graphql.Field{Type: graphql.Boolean},
+			"shippingEstimate": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rep := p.Source.(map[string]interface{})
+					// free for expensive items
+					if rep["price"].(float64) > 1000 {
+						return 0, nil
+					}
+					return int(rep["weight"].(float64) / 2), nil
+				},
+			},
+		},
+	})
+	return &testSubgraph{
+		sdl: `
+			extend type Product @key(fields: "upc") {
+				upc: String! @external
+				weight: Int @external
+				price: Int @external
+				inStock: Boolean
+				shippingEstimate: Int @requires(fields: "price weight")
+			}`,
+		entities: []*graphql.Object{product},
+		resolveEntity: func(rep map[string]interface{}) interface{} {
+			rep["inStock"] = inStock[rep["upc"].(string)]
+			return rep
+		},
+	}
+}()
+
+// newTestSubgraph serves the subgraph and returns it with its SDL.
+func newTestSubgraph(t *testing.T, name string, ts *testSubgraph) (*Subgraph, func()) {
+	s := ts.schema()
+	server := httptest.NewServer(handler.New(&handler.Config{Schema: &s}))
+	u, _ := url.Parse(server.URL)
+	g, _ := graph.NewGraph(&graph.GraphConfig{Name: name, ServiceURL: u})
+	sdl, err := FetchSDL(context.Background(), g, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("FetchSDL() returned error: %s", err)
+	}
+	return &Subgraph{Graph: g, SDL: sdl}, server.Close
+}
+
+// newTestSubgraphs serves the accounts, products, reviews and inventory
+// subgraphs.
+func newTestSubgraphs(t *testing.T) ([]*Subgraph, func()) {
+	subs := make([]*Subgraph, 0)
+	closers := make([]func(), 0)
+	for _, name := range []string{"accounts", "products", "reviews", "inventory"} {
+		sub, close := newTestSubgraph(t, name, testSubgraphs[name])
+		subs = append(subs, sub)
+		closers = append(closers, close)
+	}
+	return subs, func() {
+		for _, close := range closers {
+			close()
+		}
+	}
+}
+
+var testSubgraphs = map[string]*testSubgraph{
+	"accounts":  accountsSubgraph,
+	"products":  productsSubgraph,
+	"reviews":   reviewsSubgraph,
+	"inventory": inventorySubgraph,
+}
+
+func TestCompose(t *testing.T) {
+	subs, closeSubgraphs := newTestSubgraphs(t)
+	defer closeSubgraphs()
+
+	sg, err := Compose(subs)
+	if err != nil {
+		t.Fatalf("Compose() returned error: %s", err)
+	}
+	want := map[string]string{
+		"Query":   "me,topProducts",
+		"User":    "id,name,username,reviews",
+		"Product": "upc,name,price,weight,reviews,inStock,shippingEstimate",
+		"Review":  "id,body,author,product",
+	}
+	for typeName, wantFields := range want {
+		fields := make([]string, 0)
+		for _, f := range sg.Schema().Type(typeName).Fields() {
+			fields = append(fields, f.Name())
+		}
+		if strings.Join(fields, ",") != wantFields {
+			t.Errorf("type %s has fields %v, expected %s", typeName, fields, wantFields)
+		}
+	}
+	for _, name := range []string{"_Any", "_Entity", "_Service"} {
+		if sg.Schema().Type(name) != nil {
+			t.Errorf("type %s is part of the supergraph", name)
+		}
+	}
+	if sg.Schema().Directive("key") != nil {
+		t.Errorf("directive key is part of the supergraph")
+	}
+}
+
+func TestCompose_conflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		sdls    []string
+		wantErr string
+	}{
+		{
+			name: "root field",
+			sdls: []string{
+				`type Query { me: String }`,
+				`extend type Query { me: String }`,
+			},
+			wantErr: `query root field "me" of graph b conflicts with the one of graph a`,
+		},
+		{
+			name: "entity field",
+			sdls: []string{
+				`type Query { me: User } type User @key(fields: "id") { id: ID! name: String }`,
+				`extend type User @key(fields: "id") { id: ID! @external name: String }`,
+			},
+			wantErr: `field "User.name" is resolved by both graphs a and b`,
+		},
+		{
+			name: "field type",
+			sdls: []string{
+				`type Query { me: User } type User @key(fields: "id") { id: ID! name: String }`,
+				`extend type User @key(fields: "id") { id: ID! @external name: Int @external }`,
+			},
+			wantErr: `field "User.name" of graph b conflicts with the one of graph a`,
+		},
+		{
+			name: "value type",
+			sdls: []string{
+				`type Query { a: Money } type Money { amount: Int }`,
+				`extend type Query { b: Money } type Money { amount: Int currency: String }`,
+			},
+			wantErr: `type "Money" of graph b conflicts with the one of graph a`,
+		},
+		{
+			name: "external field",
+			sdls: []string{
+				`type Query { me: User } type User @key(fields: "id") { id: ID! }`,
+				`extend type User @key(fields: "id") { id: ID! @external name: String @external }`,
+			},
+			wantErr: `field "User.name" is external in every graph`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs := make([]*Subgraph, len(tt.sdls))
+			for i, sdl := range tt.sdls {
+				g, _ := graph.NewGraph(&graph.GraphConfig{Name: string(rune('a' + i)), ServiceURL: &url.URL{}})
+				subs[i] = &Subgraph{Graph: g, SDL: sdl}
+			}
+			_, err := Compose(subs)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Compose() returned error %v, expected %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGateway_Plan(t *testing.T) {
+	subs, closeSubgraphs := newTestSubgraphs(t)
+	defer closeSubgraphs()
+	gw, err := NewGateway(&GatewayConfig{Name: "gateway", Subgraphs: subs})
+	if err != nil {
+		t.Fatalf("NewGateway() returned error: %s", err)
+	}
+
+	plan, err := gw.Plan(&graph.Request{Query: `query Me {
+		me {
+			username
+			reviews { body author { username } product { name shippingEstimate } }
+		}
+	}`})
+	if err != nil {
+		t.Fatalf("Plan() returned error: %s", err)
+	}
+	want := `Sequence
+  Fetch accounts: query Me { me { __typename username id } }
+  Fetch reviews me User { __typename id }: query ($representations: [_Any!]!) { _entities(representations: $representations) { ... on User { reviews { __typename body author { __typename username } product { __typename upc } } } } }
+  Fetch products me.reviews.@.product Product { __typename upc }: query ($representations: [_Any!]!) { _entities(representations: $representations) { ... on Product { name price weight } } }
+  Fetch inventory me.reviews.@.product Product { __typename upc price weight }: query ($representations: [_Any!]!) { _entities(representations: $representations) { ... on Product { shippingEstimate } } }
+`
+	if got := PrintPlan(plan); got != want {
+		t.Errorf("Plan() returned\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestGateway_Execute(t *testing.T) {
+	subs, closeSubgraphs := newTestSubgraphs(t)
+	defer closeSubgraphs()
+	gw, err := NewGateway(&GatewayConfig{Name: "gateway", Subgraphs: subs})
+	if err != nil {
+		t.Fatalf("NewGateway() returned error: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			name:  "root fields of several graphs",
+			query: `{ me { name } topProducts(first: 2) { name } }`,
+			want:  `{"data":{"me":{"name":"Ada Lovelace"},"topProducts":[{"name":"Table"},{"name":"Couch"}]}}`,
+		},
+		{
+			name:  "entities",
+			query: `{ me { username reviews { body product { upc name } } } }`,
+			want:  `{"data":{"me":{"reviews":[{"body":"Love it!","product":{"name":"Table","upc":"1"}},{"body":"Too expensive.","product":{"name":"Couch","upc":"2"}}],"username":"@ada"}}}`,
+		},
+		{
+			name:  "provided and required fields",
+			query: `{ topProducts { name inStock shippingEstimate reviews { author { username } } } }`,
+			want: `{"data":{"topProducts":[` +
+				`{"inStock":true,"name":"Table","reviews":[{"author":{"username":"@ada"}},{"author":{"username":"@complete"}}],"shippingEstimate":50},` +
+				`{"inStock":false,"name":"Couch","reviews":[{"author":{"username":"@ada"}}],"shippingEstimate":0},` +
+				`{"inStock":true,"name":"Chair","reviews":[{"author":{"username":"@complete"}}],"shippingEstimate":25}]}}`,
+		},
+		{
+			name: "fragments and conditions",
+			query: `query($withName: Boolean!) { topProducts(first: 1) { ...Product } }
+				fragment Product on Product { upc name @include(if: $withName) ... @skip(if: $withName) { inStock } }`,
+			variables: map[string]interface{}{"withName": false},
+			want:      `{"data":{"topProducts":[{"inStock":true,"upc":"1"}]}}`,
+		},
+		{
+			name:  "typename and introspection",
+			query: `{ __typename __type(name: "Review") { fields { name } } me { __typename id } }`,
+			want:  `{"data":{"__type":{"fields":[{"name":"author"},{"name":"body"},{"name":"id"},{"name":"product"}]},"__typename":"Query","me":{"__typename":"User","id":"1"}}}`,
+		},
+		{
+			name:  "invalid operation",
+			query: `{ me { email } }`,
+			want:  `{"errors":[{"message":"Cannot query field \"email\" on type \"User\".","locations":[{"line":1,"column":8}]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := gw.Execute(context.Background(), &graph.Request{Query: tt.query, Variables: tt.variables}, http.DefaultTransport)
+			if err != nil {
+				t.Fatalf("Execute() returned error: %s", err)
+			}
+			got, _ := json.Marshal(res)
+			if string(got) != tt.want {
+				t.Errorf("Execute() returned\n%s\nexpected\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGateway_Execute_unavailable_subgraph(t *testing.T) {
+	accounts, closeAccounts := newTestSubgraph(t, "accounts", accountsSubgraph)
+	defer closeAccounts()
+	products, closeProducts := newTestSubgraph(t, "products", productsSubgraph)
+	gw, err := NewGateway(&GatewayConfig{Name: "gateway", Subgraphs: []*Subgraph{accounts, products}})
+	if err != nil {
+		t.Fatalf("NewGateway() returned error: %s", err)
+	}
+	closeProducts()
+
+	res, err := gw.Execute(context.Background(), &graph.Request{
+		Query: `{ me { name } topProducts { name } }`,
+	}, http.DefaultTransport)
+	if err == nil || !strings.Contains(err.Error(), "products") {
+		t.Errorf("Execute() returned error %v, expected a products failure", err)
+	}
+	want := `{"data":{"me":{"name":"Ada Lovelace"},"topProducts":null},"errors":[{"message":"Failed to execute graph products request.","path":["topProducts"],"extensions":{"code":"SERVICE_UNREACHABLE"}}]}`
+	if got, _ := json.Marshal(res); string(got) != want {
+		t.Errorf("Execute() returned\n%s\nexpected\n%s", got, want)
+	}
+}
@@ -0,0 +1,568 @@
+package federation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// PlanNode is a node of a query plan: a *Fetch, a *Sequence or a *Parallel.
+type PlanNode interface {
+	planNode()
+}
+
+// Sequence executes its nodes one after the other.
+type Sequence struct {
+	Nodes []PlanNode
+}
+
+// Parallel executes its nodes concurrently.
+type Parallel struct {
+	Nodes []PlanNode
+}
+
+// Fetch sends an operation to a subgraph. Root fetches select root fields,
+// entity fetches select the fields of the entities found at their path in the
+// data of the previous fetches.
+type Fetch struct {
+	// Graph is the ID of the subgraph, the gateway executes the fetches of
+	// the introspection fields itself.
+	Graph string
+	// Path is the response path of the entities, "@" stands for the items
+	// of a list. It is empty for root fetches.
+	Path []string
+	// Type is the type of the entities.
+	Type string
+	// Representation is the selection set of the entity representations,
+	// the entity fields sent to the subgraph.
+	Representation string
+	// Operation is the document sent to the subgraph.
+	Operation string
+
+	fetch *fetch
+}
+
+func (*Sequence) planNode() {}
+func (*Parallel) planNode() {}
+func (*Fetch) planNode()    {}
+
+// PrintPlan prints the plan, one node per line.
+func PrintPlan(n PlanNode) string {
+	var b strings.Builder
+	printPlanNode(&b, n, "")
+	return b.String()
+}
+
+func printPlanNode(b *strings.Builder, n PlanNode, indent string) {
+	switch n := n.(type) {
+	case *Sequence:
+		b.WriteString(indent + "Sequence\n")
+		for _, child := range n.Nodes {
+			printPlanNode(b, child, indent+"  ")
+		}
+	case *Parallel:
+		b.WriteString(indent + "Parallel\n")
+		for _, child := range n.Nodes {
+			printPlanNode(b, child, indent+"  ")
+		}
+	case *Fetch:
+		graphID := n.Graph
+		if graphID == "" {
+			graphID = "gateway"
+		}
+		b.WriteString(indent + "Fetch " + graphID)
+		if n.Type != "" {
+			b.WriteString(" " + strings.Join(n.Path, ".") + " " + n.Type + " " + n.Representation)
+		}
+		b.WriteString(": " + compact(n.Operation) + "\n")
+	}
+}
+
+// fetch is a fetch being planned.
+type fetch struct {
+	// sub is nil for the fetch executed by the gateway
+	sub        *subgraph
+	path       []string
+	typeName   string
+	selections []ast.Selection
+	// representation is the selection set of the entity representations
+	representation []ast.Selection
+	// deps are the fetches the data of the fetch depends on
+	deps []*fetch
+	// keys are the response keys of the root fields of a root fetch
+	keys []string
+
+	doc       *ast.Document
+	variables []string
+}
+
+// planner splits an operation into fetches.
+type planner struct {
+	sg        *Supergraph
+	op        *ast.OperationDefinition
+	fragments map[string]*ast.FragmentDefinition
+	fetches   []*fetch
+	// entityFetches are indexed by graph, path and type
+	entityFetches map[string]*fetch
+	// touched collects the entity fetches selections are planned in
+	touched []*fetch
+}
+
+// plan returns the plan of the operation. The root fields of a subgraph share
+// a fetch, unless the operation is a mutation: then only the consecutive ones
+// do and the root fetches are executed one after the other. Entity fetches
+// are executed once the fetches their representations depend on are.
+func (p *planner) plan() (PlanNode, error) {
+	root := p.sg.schema.QueryType()
+	serial := p.op.Operation == graph.OperationTypeMutation
+	if serial {
+		root = p.sg.schema.MutationType()
+	}
+
+	groups := make([][]*fetch, 0)
+	byOwner := make(map[*subgraph]*fetch)
+	var last *subgraph
+	for _, sel := range graph.RootFields(p.op.SelectionSet, p.fragments) {
+		name := sel.Field.Name.Value
+		var sub *subgraph
+		if !strings.HasPrefix(name, "__") {
+			sub = p.sg.resolvers[root.Name()+"."+name][0]
+		}
+		f, ok := byOwner[sub]
+		if !ok || serial && sub != last {
+			f = &fetch{sub: sub}
+			byOwner[sub] = f
+			if serial || len(groups) == 0 {
+				groups = append(groups, nil)
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], f)
+			p.fetches = append(p.fetches, f)
+		}
+		last = sub
+
+		key := graph.ResponseKey(sel.Field)
+		if !containsString(f.keys, key) {
+			f.keys = append(f.keys, key)
+		}
+		if sub == nil {
+			// introspection fields are executed as they are by the gateway
+			f.addSelection(sel.Field, sel.Conditions)
+			continue
+		}
+		start := len(p.fetches)
+		field, err := p.field(f, root, sel.Field, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		f.addSelection(field, sel.Conditions)
+		// the entity fetches of the field belong to the group of its
+		// root fetch
+		groups[len(groups)-1] = append(groups[len(groups)-1], p.fetches[start:]...)
+	}
+
+	nodes := make([]PlanNode, 0)
+	for _, group := range groups {
+		levels, err := levels(group)
+		if err != nil {
+			return nil, err
+		}
+		for _, level := range levels {
+			fetches := make([]PlanNode, len(level))
+			for i, f := range level {
+				fetches[i] = p.planFetch(f)
+			}
+			if len(fetches) == 1 {
+				nodes = append(nodes, fetches[0])
+			} else {
+				nodes = append(nodes, &Parallel{Nodes: fetches})
+			}
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &Sequence{Nodes: nodes}, nil
+}
+
+// levels sorts the fetches of a group by the number of fetches of the group
+// they depend on, transitively.
+func levels(group []*fetch) ([][]*fetch, error) {
+	inGroup := make(map[*fetch]bool)
+	for _, f := range group {
+		inGroup[f] = true
+	}
+	depths := make(map[*fetch]int)
+	visiting := make(map[*fetch]bool)
+	var depth func(f *fetch) (int, error)
+	depth = func(f *fetch) (int, error) {
+		if d, ok := depths[f]; ok {
+			return d, nil
+		}
+		if visiting[f] {
+			return 0, fmt.Errorf("fetches of graph %s depend on each other", f.sub.name)
+		}
+		visiting[f] = true
+		d := 0
+		for _, dep := range f.deps {
+			if !inGroup[dep] {
+				continue
+			}
+			depDepth, err := depth(dep)
+			if err != nil {
+				return 0, err
+			}
+			if depDepth+1 > d {
+				d = depDepth + 1
+			}
+		}
+		depths[f] = d
+		return d, nil
+	}
+
+	levels := make([][]*fetch, 0)
+	for _, f := range group {
+		d, err := depth(f)
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], f)
+	}
+	return levels, nil
+}
+
+// field plans the field resolved by the subgraph of the fetch, at the given
+// path of the parent type. It returns a copy of the field holding the
+// selections of the fetch.
+func (p *planner) field(f *fetch, parent schema.Type, field *ast.Field, path []string, provided *ast.SelectionSet) (*ast.Field, error) {
+	if field.SelectionSet == nil {
+		return field, nil
+	}
+	def := parent.Field(field.Name.Value)
+	t := def.Type()
+	fieldPath := append(append([]string{}, path...), graph.ResponseKey(field))
+	for ; t.Kind() == schema.TypeKindNonNull || t.Kind() == schema.TypeKindList; t = t.OfType() {
+		if t.Kind() == schema.TypeKindList {
+			fieldPath = append(fieldPath, "@")
+		}
+	}
+	// the fields provided by the field are resolved by its subgraph
+	fieldProvided := f.sub.provides[parent.Name()+"."+field.Name.Value]
+	if provided != nil {
+		for _, sel := range provided.Selections {
+			if pf, ok := sel.(*ast.Field); ok && pf.Name.Value == field.Name.Value && pf.SelectionSet != nil {
+				fieldProvided = pf.SelectionSet
+			}
+		}
+	}
+
+	sels, err := p.selectionSet(f, p.sg.schema.Type(t.Name()), field.SelectionSet, fieldPath, fieldProvided, nil)
+	if err != nil {
+		return nil, err
+	}
+	planned := *field
+	planned.SelectionSet = ast.NewSelectionSet(&ast.SelectionSet{
+		Selections: appendSelections([]ast.Selection{typenameField()}, sels...),
+	})
+	return &planned, nil
+}
+
+// appendSelections appends the selections to the given ones, the fields
+// already selected with the same response key are merged.
+func appendSelections(sels []ast.Selection, added ...ast.Selection) []ast.Selection {
+	for _, sel := range added {
+		field, ok := sel.(*ast.Field)
+		merged := false
+		for i, existing := range sels {
+			existingField, isField := existing.(*ast.Field)
+			if !ok || !isField || graph.ResponseKey(existingField) != graph.ResponseKey(field) || existingField.Name.Value != field.Name.Value {
+				continue
+			}
+			if field.SelectionSet != nil && existingField.SelectionSet != nil {
+				mergedField := *existingField
+				mergedField.SelectionSet = ast.NewSelectionSet(&ast.SelectionSet{
+					Selections: appendSelections(append([]ast.Selection{}, existingField.SelectionSet.Selections...), field.SelectionSet.Selections...),
+				})
+				sels[i] = &mergedField
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			sels = append(sels, sel)
+		}
+	}
+	return sels
+}
+
+// selectionSet plans the selection set of the parent type in the fetch. The
+// fields the subgraph of the fetch does not resolve are planned in entity
+// fetches, under the given conditions. It returns the selections of the fetch.
+func (p *planner) selectionSet(f *fetch, parent schema.Type, set *ast.SelectionSet, path []string, provided *ast.SelectionSet, conditions [][]*ast.Directive) ([]ast.Selection, error) {
+	sels := make([]ast.Selection, 0, len(set.Selections))
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if sel.Name.Value == "__typename" {
+				sels = appendSelections(sels, sel)
+				continue
+			}
+			if p.resolves(f.sub, parent, sel.Name.Value) || selectsField(provided, sel.Name.Value) {
+				field, err := p.field(f, parent, sel, path, provided)
+				if err != nil {
+					return nil, err
+				}
+				sels = appendSelections(sels, field)
+				continue
+			}
+			representation, err := p.entityField(f, parent, sel, path, conditions)
+			if err != nil {
+				return nil, err
+			}
+			sels = appendSelections(sels, representation...)
+		case *ast.InlineFragment:
+			t := parent
+			if sel.TypeCondition != nil {
+				t = p.sg.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			fragSels, err := p.selectionSet(f, t, sel.SelectionSet, path, provided, graph.WithConditions(conditions, sel.Directives))
+			if err != nil {
+				return nil, err
+			}
+			if len(fragSels) > 0 {
+				frag := *sel
+				frag.SelectionSet = ast.NewSelectionSet(&ast.SelectionSet{Selections: fragSels})
+				sels = append(sels, &frag)
+			}
+		case *ast.FragmentSpread:
+			// fragments are planned as inline fragments
+			def, ok := p.fragments[sel.Name.Value]
+			if !ok {
+				continue
+			}
+			fragSels, err := p.selectionSet(f, p.sg.schema.Type(def.TypeCondition.Name.Value), def.SelectionSet, path, provided, graph.WithConditions(conditions, sel.Directives))
+			if err != nil {
+				return nil, err
+			}
+			if len(fragSels) > 0 {
+				sels = append(sels, ast.NewInlineFragment(&ast.InlineFragment{
+					TypeCondition: def.TypeCondition,
+					Directives:    sel.Directives,
+					SelectionSet:  ast.NewSelectionSet(&ast.SelectionSet{Selections: fragSels}),
+				}))
+			}
+		}
+	}
+	return sels, nil
+}
+
+// resolves tells whether the subgraph resolves the field of the parent type.
+// The fields of abstract types are resolved by the subgraph returning them,
+// the key fields of an entity by every subgraph defining its key.
+func (p *planner) resolves(sub *subgraph, parent schema.Type, name string) bool {
+	if parent.Kind() != schema.TypeKindObject || selectsField(sub.keys[parent.Name()], name) {
+		return true
+	}
+	for _, resolver := range p.sg.resolvers[parent.Name()+"."+name] {
+		if resolver == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// entityField plans the field of the entity in an entity fetch of a subgraph
+// resolving it. It returns the selections of the representation of the
+// entity, planned in the given fetch.
+func (p *planner) entityField(f *fetch, parent schema.Type, field *ast.Field, path []string, conditions [][]*ast.Directive) ([]ast.Selection, error) {
+	coord := parent.Name() + "." + field.Name.Value
+	var sub *subgraph
+	for _, resolver := range p.sg.resolvers[coord] {
+		if resolver.keys[parent.Name()] != nil {
+			sub = resolver
+			break
+		}
+	}
+	if sub == nil {
+		return nil, fmt.Errorf("no graph resolves field \"%s\" of the %s entities", coord, parent.Name())
+	}
+
+	ef := p.entityFetch(f, sub, parent.Name(), path)
+	entityField, err := p.field(ef, parent, field, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	ef.addSelection(entityField, conditions)
+
+	// the representation is the key of the entity and the fields required
+	// by the field, they may be resolved by other entity fetches
+	representation := []ast.Selection{typenameField()}
+	representation = append(representation, sub.keys[parent.Name()].Selections...)
+	if requires := sub.requires[coord]; requires != nil {
+		representation = append(representation, requires.Selections...)
+	}
+	touched := p.touched
+	p.touched = nil
+	sels, err := p.selectionSet(f, parent, ast.NewSelectionSet(&ast.SelectionSet{Selections: representation}), path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range p.touched {
+		ef.addDep(dep)
+	}
+	p.touched = append(touched, ef)
+	for _, sel := range representation {
+		if !containsSelection(ef.representation, sel) {
+			ef.representation = append(ef.representation, sel)
+		}
+	}
+	return sels, nil
+}
+
+// entityFetch returns the fetch of the entities of the given type at the path,
+// fetched from the subgraph once the parent fetch is executed.
+func (p *planner) entityFetch(parent *fetch, sub *subgraph, typeName string, path []string) *fetch {
+	key := sub.name + " " + strings.Join(path, ".") + " " + typeName
+	ef, ok := p.entityFetches[key]
+	if !ok {
+		ef = &fetch{sub: sub, path: path, typeName: typeName}
+		p.entityFetches[key] = ef
+		p.fetches = append(p.fetches, ef)
+	}
+	ef.addDep(parent)
+	return ef
+}
+
+func (f *fetch) addDep(dep *fetch) {
+	if dep == f {
+		return
+	}
+	for _, d := range f.deps {
+		if d == dep {
+			return
+		}
+	}
+	f.deps = append(f.deps, dep)
+}
+
+// addSelection adds the selection to the fetch, with the conditions it was
+// selected with.
+func (f *fetch) addSelection(sel ast.Selection, conditions [][]*ast.Directive) {
+	f.selections = append(f.selections, graph.NestInConditions(sel, conditions))
+}
+
+// planFetch builds the document of the fetch and returns its plan node.
+func (p *planner) planFetch(f *fetch) *Fetch {
+	set := ast.NewSelectionSet(&ast.SelectionSet{Selections: f.selections})
+	op := ast.NewOperationDefinition(&ast.OperationDefinition{
+		Operation: p.op.Operation,
+		Name:      p.op.Name,
+	})
+	if f.typeName != "" {
+		// entities are fetched by a query selecting the _entities field
+		op.Operation = graph.OperationTypeQuery
+		op.Name = nil
+		op.VariableDefinitions = []*ast.VariableDefinition{representationsVariable()}
+		set = ast.NewSelectionSet(&ast.SelectionSet{Selections: []ast.Selection{
+			ast.NewField(&ast.Field{
+				Name: ast.NewName(&ast.Name{Value: "_entities"}),
+				Arguments: []*ast.Argument{ast.NewArgument(&ast.Argument{
+					Name:  ast.NewName(&ast.Name{Value: "representations"}),
+					Value: ast.NewVariable(&ast.Variable{Name: ast.NewName(&ast.Name{Value: "representations"})}),
+				})},
+				SelectionSet: ast.NewSelectionSet(&ast.SelectionSet{Selections: []ast.Selection{
+					ast.NewInlineFragment(&ast.InlineFragment{
+						TypeCondition: ast.NewNamed(&ast.Named{Name: ast.NewName(&ast.Name{Value: f.typeName})}),
+						SelectionSet:  set,
+					}),
+				}}),
+			}),
+		}})
+	}
+	op.SelectionSet = set
+	defs := []ast.Node{op}
+
+	usedVariables, usedFragments := graph.VariablesAndFragmentsUsed(set, p.fragments)
+	for _, name := range usedFragments {
+		defs = append(defs, p.fragments[name])
+	}
+	for _, def := range p.op.VariableDefinitions {
+		if name := def.Variable.Name.Value; usedVariables[name] {
+			op.VariableDefinitions = append(op.VariableDefinitions, def)
+			f.variables = append(f.variables, name)
+		}
+	}
+	f.doc = ast.NewDocument(&ast.Document{Definitions: defs})
+
+	node := &Fetch{
+		Path:      f.path,
+		Type:      f.typeName,
+		Operation: graph.PrintDocument(f.doc),
+		fetch:     f,
+	}
+	if f.sub != nil {
+		node.Graph = f.sub.name
+	}
+	if f.typeName != "" {
+		node.Representation = compact(graph.PrintDocument(ast.NewDocument(&ast.Document{Definitions: []ast.Node{
+			ast.NewOperationDefinition(&ast.OperationDefinition{
+				Operation:    graph.OperationTypeQuery,
+				SelectionSet: ast.NewSelectionSet(&ast.SelectionSet{Selections: f.representation}),
+			}),
+		}})))
+	}
+	return node
+}
+
+// representationsVariable is the definition of the $representations variable
+// of the entity fetches.
+func representationsVariable() *ast.VariableDefinition {
+	named := func(name string) *ast.Named {
+		return ast.NewNamed(&ast.Named{Name: ast.NewName(&ast.Name{Value: name})})
+	}
+	return ast.NewVariableDefinition(&ast.VariableDefinition{
+		Variable: ast.NewVariable(&ast.Variable{Name: ast.NewName(&ast.Name{Value: "representations"})}),
+		Type: ast.NewNonNull(&ast.NonNull{Type: ast.NewList(&ast.List{
+			Type: ast.NewNonNull(&ast.NonNull{Type: named("_Any")}),
+		})}),
+	})
+}
+
+func typenameField() *ast.Field {
+	return ast.NewField(&ast.Field{Name: ast.NewName(&ast.Name{Value: "__typename"})})
+}
+
+// containsSelection tells whether a field of the same name is among the
+// selections.
+func containsSelection(sels []ast.Selection, sel ast.Selection) bool {
+	field, ok := sel.(*ast.Field)
+	if !ok {
+		return false
+	}
+	for _, s := range sels {
+		if f, ok := s.(*ast.Field); ok && f.Name.Value == field.Name.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// compact prints the document on a single line.
+func compact(doc string) string {
+	return strings.Join(strings.Fields(doc), " ")
+}
@@ -7,9 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"sync/atomic"
+	"time"
 )
 
+// ErrGraphQLServiceNotAvailable is matched by the errors of the requests
+// that did not reach the GraphQL service, or that it did not respond to in
+// time, see ServiceError.
 var ErrGraphQLServiceNotAvailable = errors.New("graphql service not available")
 
 type Graph interface {
@@ -17,8 +23,51 @@ type Graph interface {
 	Execute(context.Context, *Request, http.RoundTripper) (*Response, error)
 }
 
+// BatchGraph is implemented by graphs able to execute several requests in a
+// single round trip to the GraphQL service.
+type BatchGraph interface {
+	Graph
+	ExecuteBatch(context.Context, []*Request, http.RoundTripper) ([]*Response, error)
+}
+
+// SubscriptionGraph is implemented by graphs serving subscriptions over a
+// WebSocket endpoint.
+type SubscriptionGraph interface {
+	Graph
+	SubscriptionURL() *url.URL
+}
+
 type GraphConfig struct {
+	// Name identifies the graph, its ID is its service URL when it has no
+	// name.
+	Name       string
 	ServiceURL *url.URL
+	// SubscriptionURL is the WebSocket endpoint of the GraphQL service. It
+	// defaults to the service URL with a ws or wss scheme.
+	SubscriptionURL *url.URL
+	// Balancer spreads the requests over several instances of the GraphQL
+	// service, they are all sent to the service URL when it is nil.
+	Balancer Balancer
+	// Timeout bounds the execution of a request, retries and the read of
+	// the response included. ReadTimeout bounds each attempt, from sending
+	// the request to reading the response, and ConnectTimeout the wait for
+	// a connection to the service of each attempt, whatever the transport.
+	// There is no limit when they are zero.
+	Timeout        time.Duration
+	ReadTimeout    time.Duration
+	ConnectTimeout time.Duration
+	Retry          RetryPolicy
+	// CircuitBreaker rejects the requests with ErrCircuitOpen while the
+	// service fails, the requests are always sent when it is nil.
+	CircuitBreaker *CircuitBreaker
+}
+
+// Balancer sends the requests of a graph to the instances of its GraphQL
+// service.
+type Balancer interface {
+	// RoundTrip sends the request, addressed to the graph service URL, to
+	// one of the instances using the transport.
+	RoundTrip(*http.Request, http.RoundTripper) (*http.Response, error)
 }
 
 func NewGraph(cfg *GraphConfig) (Graph, error) {
@@ -26,50 +75,272 @@ func NewGraph(cfg *GraphConfig) (Graph, error) {
 		return nil, errors.New("graph config must have a service URL")
 	}
 
+	subscriptionURL := cfg.SubscriptionURL
+	if subscriptionURL == nil {
+		u := *cfg.ServiceURL
+		switch u.Scheme {
+		case "https":
+			u.Scheme = "wss"
+		default:
+			u.Scheme = "ws"
+		}
+		subscriptionURL = &u
+	}
+
+	retry := cfg.Retry
+	if retry.Backoff <= 0 {
+		retry.Backoff = 100 * time.Millisecond
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = 2 * time.Second
+	}
+
 	return &graph{
-		serviceURL: cfg.ServiceURL,
+		name:            cfg.Name,
+		serviceURL:      cfg.ServiceURL,
+		subscriptionURL: subscriptionURL,
+		balancer:        cfg.Balancer,
+		timeout:         cfg.Timeout,
+		readTimeout:     cfg.ReadTimeout,
+		connectTimeout:  cfg.ConnectTimeout,
+		retry:           retry,
+		breaker:         cfg.CircuitBreaker,
 	}, nil
 }
 
-var _ Graph = (*graph)(nil)
+var _ BatchGraph = (*graph)(nil)
+var _ SubscriptionGraph = (*graph)(nil)
+var _ StreamGraph = (*graph)(nil)
 
 type graph struct {
-	serviceURL *url.URL
+	name            string
+	serviceURL      *url.URL
+	subscriptionURL *url.URL
+	balancer        Balancer
+	timeout         time.Duration
+	readTimeout     time.Duration
+	connectTimeout  time.Duration
+	retry           RetryPolicy
+	breaker         *CircuitBreaker
 }
 
 func (g *graph) ID() string {
+	if g.name != "" {
+		return g.name
+	}
 	return g.serviceURL.String()
 }
 
+func (g *graph) SubscriptionURL() *url.URL {
+	return g.subscriptionURL
+}
+
 func (g *graph) Execute(ctx context.Context, graphReq *Request, transport http.RoundTripper) (*Response, error) {
-	bdy, err := json.Marshal(graphReq)
+	httpRes, err := g.post(ctx, graphReq, g.retry.retries(graphReq), transport)
+	if err != nil {
+		if gqlRes := statusErrorResponse(err); gqlRes != nil {
+			return gqlRes, nil
+		}
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	if isResponseStream(httpRes) {
+		return nil, &ServiceError{
+			Code: ErrorCodeServiceInvalidResponse,
+			Err:  errors.New("unexpected incremental delivery"),
+		}
+	}
+
+	gqlRes := new(Response)
+	if err := json.NewDecoder(httpRes.Body).Decode(gqlRes); err != nil {
+		return nil, newDecodeError(ctx, err)
+	}
+	gqlRes.StatusCode = httpRes.StatusCode
+	gqlRes.Header = httpRes.Header
+
+	return gqlRes, nil
+}
+
+func (g *graph) ExecuteStream(ctx context.Context, graphReq *Request, transport http.RoundTripper) (*Response, ResponseStream, error) {
+	httpRes, err := g.post(ctx, graphReq, g.retry.retries(graphReq), transport)
+	if err != nil {
+		if gqlRes := statusErrorResponse(err); gqlRes != nil {
+			return gqlRes, nil, nil
+		}
+		return nil, nil, err
+	}
+	if stream, err := newResponseStream(httpRes); stream != nil || err != nil {
+		return nil, stream, err
+	}
+	defer httpRes.Body.Close()
+
+	gqlRes := new(Response)
+	if err := json.NewDecoder(httpRes.Body).Decode(gqlRes); err != nil {
+		return nil, nil, newDecodeError(ctx, err)
+	}
+	gqlRes.StatusCode = httpRes.StatusCode
+	gqlRes.Header = httpRes.Header
+
+	return gqlRes, nil, nil
+}
+
+func (g *graph) ExecuteBatch(ctx context.Context, graphReqs []*Request, transport http.RoundTripper) ([]*Response, error) {
+	httpRes, err := g.post(ctx, graphReqs, g.retry.retries(graphReqs...), transport)
+	if err != nil {
+		if gqlRes := statusErrorResponses(err, len(graphReqs)); gqlRes != nil {
+			return gqlRes, nil
+		}
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+
+	gqlRes := make([]*Response, 0, len(graphReqs))
+	if err := json.NewDecoder(httpRes.Body).Decode(&gqlRes); err != nil {
+		return nil, newDecodeError(ctx, err)
+	}
+	if len(gqlRes) != len(graphReqs) {
+		return nil, &ServiceError{
+			Code: ErrorCodeServiceInvalidResponse,
+			Err:  fmt.Errorf("%d results for a batch of %d requests", len(gqlRes), len(graphReqs)),
+		}
+	}
+	for _, r := range gqlRes {
+		if r != nil {
+			r.StatusCode = httpRes.StatusCode
+			r.Header = httpRes.Header
+		}
+	}
+
+	return gqlRes, nil
+}
+
+// post sends the JSON encoded payload to the GraphQL service, retrying it
+// when retryable, and returns its successful response. The execution ends
+// once the response body is closed.
+func (g *graph) post(ctx context.Context, payload interface{}, retryable bool, transport http.RoundTripper) (*http.Response, error) {
+	bdy, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode graphql service request: %s", err)
 	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	cancel := func() {}
+	if g.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+	}
+	for attempt := 1; ; attempt++ {
+		httpRes, retry, err := g.attempt(ctx, bdy, transport)
+		if err == nil {
+			httpRes.Body = &cancelingBody{ReadCloser: httpRes.Body, cancel: cancel}
+			return httpRes, nil
+		}
+		if !retryable || !retry || attempt >= g.retry.MaxAttempts || !sleep(ctx, g.retry.backoff(attempt)) {
+			cancel()
+			return nil, err
+		}
+	}
+}
+
+// attempt sends the JSON encoded body to the GraphQL service once, and
+// returns whether the request may be sent again on error.
+func (g *graph) attempt(ctx context.Context, bdy []byte, transport http.RoundTripper) (*http.Response, bool, error) {
+	if g.breaker != nil {
+		if err := g.breaker.allow(); err != nil {
+			return nil, false, err
+		}
+	}
+	record := func(o outcome) {
+		if g.breaker != nil {
+			g.breaker.record(o)
+		}
+	}
+
+	attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+	if g.readTimeout > 0 {
+		attemptCtx, cancel = context.WithTimeout(ctx, g.readTimeout)
+	}
+	connectTimedOut := func() bool { return false }
+	if g.connectTimeout > 0 {
+		var cancelConnect context.CancelFunc
+		attemptCtx, cancelConnect, connectTimedOut = withConnectTimeout(attemptCtx, g.connectTimeout)
+		cancelAttempt := cancel
+		cancel = func() {
+			cancelConnect()
+			cancelAttempt()
+		}
+	}
 	httpReq, err := http.NewRequestWithContext(
-		ctx,
+		attemptCtx,
 		http.MethodPost,
 		g.serviceURL.String(),
 		bytes.NewReader(bdy),
 	)
+	if err != nil {
+		cancel()
+		record(outcomeIgnored)
+		return nil, false, fmt.Errorf("failed to create graphql service request: %s", err)
+	}
 	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	httpReq.Header.Set("Accept", MediaTypeGraphQLResponse+", "+MediaTypeJSON+";q=0.9")
 
-	if transport == nil {
-		transport = http.DefaultTransport
+	var httpRes *http.Response
+	if g.balancer != nil {
+		httpRes, err = g.balancer.RoundTrip(httpReq, transport)
+	} else {
+		httpRes, err = transport.RoundTrip(httpReq)
 	}
-	httpRes, err := transport.RoundTrip(httpReq)
 	if err != nil {
-		// TODO: check if this is always the case
-		return nil, ErrGraphQLServiceNotAvailable
+		cancel()
+		if connectTimedOut() {
+			record(outcomeFailure)
+			return nil, ctx.Err() == nil, &ServiceError{
+				Code: ErrorCodeServiceUnreachable,
+				Err:  fmt.Errorf("no connection to the graphql service within %s: %w", g.connectTimeout, err),
+			}
+		}
+		transportErr := newTransportError(ctx, err)
+		// requests canceled by their client say nothing of the service
+		if transportErr.Code == ErrorCodeRequestCanceled {
+			record(outcomeIgnored)
+			return nil, false, transportErr
+		}
+		record(outcomeFailure)
+		return nil, ctx.Err() == nil, transportErr
 	}
 	if httpRes.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("graphql service responded with error: %s", httpRes.Status)
+		statusErr := newStatusError(httpRes)
+		httpRes.Body.Close()
+		cancel()
+		if httpRes.StatusCode >= http.StatusInternalServerError {
+			record(outcomeFailure)
+		} else {
+			record(outcomeSuccess)
+		}
+		return nil, isRetryableStatus(httpRes.StatusCode), statusErr
 	}
+	record(outcomeSuccess)
 
-	gqlRes := new(Response)
-	if err := json.NewDecoder(httpRes.Body).Decode(gqlRes); err != nil {
-		return nil, fmt.Errorf("failed to decode graphql service response: %s", err)
-	}
+	httpRes.Body = &cancelingBody{ReadCloser: httpRes.Body, cancel: cancel}
+	return httpRes, false, nil
+}
 
-	return gqlRes, nil
+// withConnectTimeout returns a context canceled when no connection is
+// obtained for the request within the timeout, and whether it was.
+func withConnectTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, func() bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	var expired int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		cancel()
+	})
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { timer.Stop() },
+	})
+	stop := func() {
+		timer.Stop()
+		cancel()
+	}
+	return ctx, stop, func() bool { return atomic.LoadInt32(&expired) == 1 }
 }
@@ -0,0 +1,103 @@
+package headers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientKey struct{}
+
+// client holds what the rules need to know of the client request of a graph
+// request.
+type client struct {
+	execID string
+	ip     string
+	host   string
+	proto  string
+	header http.Header
+	claims map[string]string
+}
+
+// clientOf returns the client of the graph request sent with ctx, an empty
+// one when the request does not come from a client.
+func clientOf(ctx context.Context) *client {
+	if c, ok := ctx.Value(clientKey{}).(*client); ok {
+		return c
+	}
+	return &client{}
+}
+
+func (c *client) read(r *http.Request, withClaims bool) {
+	c.ip = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		c.ip = host
+	}
+	c.host = r.Host
+	c.proto = "http"
+	if r.TLS != nil {
+		c.proto = "https"
+	}
+	c.header = r.Header.Clone()
+	if withClaims {
+		c.claims = bearerClaims(r.Header)
+	}
+}
+
+// forwardedElement returns the element of the Forwarded header describing the
+// client, see RFC 7239.
+func (c *client) forwardedElement() string {
+	if c.ip == "" {
+		return ""
+	}
+	elem := "for=" + c.ip
+	if strings.Contains(c.ip, ":") {
+		elem = `for="[` + c.ip + `]"`
+	}
+	if c.host != "" {
+		elem += `;host="` + c.host + `"`
+	}
+	return elem + ";proto=" + c.proto
+}
+
+// bearerClaims returns the claims of the JWT bearer token of the
+// Authorization header, without verifying it, or nil when there is none.
+func bearerClaims(h http.Header) map[string]string {
+	auth := h.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSpace(auth[len("Bearer "):]), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil
+	}
+
+	claims := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			claims[name] = v
+		case json.Number, bool:
+			claims[name] = fmt.Sprint(v)
+		default:
+			encoded, _ := json.Marshal(v)
+			claims[name] = string(encoded)
+		}
+	}
+	return claims
+}
@@ -0,0 +1,224 @@
+package headers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/proxy"
+)
+
+// DefaultRequestIDHeader is the header the porte proxy command sends the
+// execution ID of the graph requests in.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// ForwardedMode defines what is done with the X-Forwarded-For or Forwarded
+// header of the client requests.
+type ForwardedMode string
+
+const (
+	// ForwardedKeep sends the header of the client as is, when allowed.
+	ForwardedKeep ForwardedMode = "keep"
+	// ForwardedStrip removes the header.
+	ForwardedStrip ForwardedMode = "strip"
+	// ForwardedAppend appends the client to the header.
+	ForwardedAppend ForwardedMode = "append"
+)
+
+// ProxyPluginConfig defines the rules applied to the client request headers
+// sent to the graph, in the order of its fields. Header names are case
+// insensitive, and the names of Allow and Deny ending with * match the names
+// starting with what precedes it. The Content-Type and Accept headers of the
+// graph requests are never changed: they are set by the proxy, which relies
+// on them to negotiate the media type of the responses.
+type ProxyPluginConfig struct {
+	// Allow lists the client headers sent to the graph, all of them by
+	// default.
+	Allow []string
+	// Deny lists the client headers not sent to the graph, even when
+	// allowed.
+	Deny []string
+	// Rename maps the names of the client headers to the names they are
+	// sent to the graph with.
+	Rename map[string]string
+	// ForwardedFor and Forwarded define what is done with the
+	// X-Forwarded-For and Forwarded headers, ForwardedKeep by default.
+	ForwardedFor ForwardedMode
+	Forwarded    ForwardedMode
+	// Set maps the names of headers to the templates of their values,
+	// executed with the TemplateData of the request, like "{{.ClientIP}}"
+	// or "{{.Claims.sub}}". A header whose value is empty is removed.
+	Set map[string]string
+	// UnverifiedClaims exposes the claims of the JWT bearer token of the
+	// client requests to the templates. The token is NOT verified, anyone
+	// can forge its claims: only enable it when something in front of the
+	// proxy verifies the token.
+	UnverifiedClaims bool
+	// RequestIDHeader, when set, is the header the execution ID of the
+	// request is sent in.
+	RequestIDHeader string
+}
+
+// TemplateData is the data the templates of the header values are executed
+// with.
+type TemplateData struct {
+	// ClientIP is the IP address of the client, the proxy is connected to.
+	ClientIP string
+	// ExecID is the execution ID of the request.
+	ExecID string
+	// Header is the header of the client request.
+	Header http.Header
+	// Claims are the claims of the JWT bearer token of the Authorization
+	// header, the objects and arrays being JSON encoded. It is empty unless
+	// the UnverifiedClaims of the configuration is set.
+	Claims map[string]string
+}
+
+// NewProxyPlugin returns a new proxy plugin applying the configured rules to
+// the headers of the graph requests and of the graph subscriptions WebSocket
+// handshakes. Its SendGraphRequest applies them to the requests sent with an
+// execution context by other means, like the shadow graph ones.
+func NewProxyPlugin(cfg ProxyPluginConfig) (*proxy.Plugin, error) {
+	for _, mode := range []ForwardedMode{cfg.ForwardedFor, cfg.Forwarded} {
+		switch mode {
+		case "", ForwardedKeep, ForwardedStrip, ForwardedAppend:
+		default:
+			return nil, fmt.Errorf("invalid forwarded header mode %q, expected keep, strip or append", mode)
+		}
+	}
+	r := &rules{
+		cfg:       cfg,
+		rename:    make(map[string]string, len(cfg.Rename)),
+		templates: make(map[string]*template.Template, len(cfg.Set)),
+	}
+	for from, to := range cfg.Rename {
+		r.rename[http.CanonicalHeaderKey(from)] = http.CanonicalHeaderKey(to)
+	}
+	for name, text := range cfg.Set {
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of header %s: %s", name, err)
+		}
+		r.templates[http.CanonicalHeaderKey(name)] = tmpl
+	}
+
+	// the claims are only decoded when the templates may use them
+	withClaims := cfg.UnverifiedClaims && len(r.templates) > 0
+
+	return &proxy.Plugin{
+		InitContext: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, clientKey{}, &client{execID: proxy.GetExecID(ctx)})
+		},
+		ReadProxyRequest: func(next proxy.ReadProxyRequest) proxy.ReadProxyRequest {
+			return func(req *http.Request) (*graph.Request, error) {
+				graphReq, err := next(req)
+				if c, ok := req.Context().Value(clientKey{}).(*client); ok && err == nil {
+					c.read(req, withClaims)
+				}
+				return graphReq, err
+			}
+		},
+		SendGraphRequest: func(next http.RoundTripper) http.RoundTripper {
+			return proxy.SendGraphRequest(func(req *http.Request) (*http.Response, error) {
+				req = req.Clone(req.Context())
+				r.apply(req.Context(), req.Header)
+				return next.RoundTrip(req)
+			})
+		},
+		SendSubscriptionHeader: func(next proxy.SendSubscriptionHeader) proxy.SendSubscriptionHeader {
+			return func(req *http.Request, head http.Header) http.Header {
+				head = next(req, head)
+				if c, ok := req.Context().Value(clientKey{}).(*client); ok {
+					c.read(req, withClaims)
+				}
+				r.apply(req.Context(), head)
+				return head
+			}
+		},
+	}, nil
+}
+
+type rules struct {
+	cfg       ProxyPluginConfig
+	rename    map[string]string
+	templates map[string]*template.Template
+}
+
+// apply applies the rules to the header of a graph request sent with ctx.
+func (r *rules) apply(ctx context.Context, h http.Header) {
+	c := clientOf(ctx)
+	kept := make(http.Header)
+	for _, name := range keptHeaders {
+		if values, ok := h[name]; ok {
+			kept[name] = values
+		}
+	}
+
+	for name := range h {
+		if len(r.cfg.Allow) > 0 && !proxy.MatchHeader(r.cfg.Allow, name) || proxy.MatchHeader(r.cfg.Deny, name) {
+			h.Del(name)
+		}
+	}
+
+	renamed := make(http.Header)
+	for from, to := range r.rename {
+		if values, ok := h[from]; ok {
+			renamed[to] = append(renamed[to], values...)
+			h.Del(from)
+		}
+	}
+	for name, values := range renamed {
+		h[name] = append(h[name], values...)
+	}
+
+	forward(h, "X-Forwarded-For", r.cfg.ForwardedFor, c.ip)
+	forward(h, "Forwarded", r.cfg.Forwarded, c.forwardedElement())
+
+	data := &TemplateData{ClientIP: c.ip, ExecID: c.execID, Header: c.header, Claims: c.claims}
+	for name, tmpl := range r.templates {
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, data); err != nil {
+			log.Printf("Failed to execute the template of header %s: %s", name, err)
+			h.Del(name)
+			continue
+		}
+		if value.Len() == 0 {
+			h.Del(name)
+			continue
+		}
+		h.Set(name, value.String())
+	}
+
+	if r.cfg.RequestIDHeader != "" && c.execID != "" {
+		h.Set(r.cfg.RequestIDHeader, c.execID)
+	}
+
+	for name, values := range kept {
+		h[name] = values
+	}
+}
+
+// keptHeaders are the headers of the graph requests the rules never change.
+var keptHeaders = []string{"Content-Type", "Accept"}
+
+// forward applies mode to the header with the given name, appending value
+// to it in ForwardedAppend.
+func forward(h http.Header, name string, mode ForwardedMode, value string) {
+	switch mode {
+	case ForwardedStrip:
+		h.Del(name)
+	case ForwardedAppend:
+		if value == "" {
+			return
+		}
+		if prior := h[name]; len(prior) > 0 {
+			value = strings.Join(prior, ", ") + ", " + value
+		}
+		h.Set(name, value)
+	}
+}
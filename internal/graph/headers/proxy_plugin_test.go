@@ -0,0 +1,322 @@
+package headers
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/proxy"
+	"github.com/herzult/porte/internal/graph/shadow"
+)
+
+func TestProxyPlugin(t *testing.T) {
+	token := "eyJhbGciOiJIUzI1NiJ9." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"luke","admin":true,"exp":1700000000,"roles":["jedi"]}`)) +
+		".c2lnbmF0dXJl"
+
+	tests := []struct {
+		name   string
+		cfg    ProxyPluginConfig
+		header http.Header
+		// want are the headers the graph receives, aside from the ones Go
+		// adds, with the execution ID in place of an exec value
+		want http.Header
+	}{
+		{
+			name:   "everything by default",
+			header: http.Header{"X-Tenant": {"rebels"}, "Cookie": {"session=abc"}},
+			want:   http.Header{"X-Tenant": {"rebels"}, "Cookie": {"session=abc"}},
+		},
+		{
+			name:   "allowed headers",
+			cfg:    ProxyPluginConfig{Allow: []string{"x-tenant", "X-Trace-*"}},
+			header: http.Header{"X-Tenant": {"rebels"}, "X-Trace-Id": {"1"}, "Cookie": {"session=abc"}},
+			want:   http.Header{"X-Tenant": {"rebels"}, "X-Trace-Id": {"1"}},
+		},
+		{
+			name:   "media types kept",
+			cfg:    ProxyPluginConfig{Allow: []string{"Authorization"}, Deny: []string{"Accept"}, Set: map[string]string{"Content-Type": "text/plain"}},
+			header: http.Header{"Authorization": {"Bearer secret"}, "Cookie": {"session=abc"}},
+			want:   http.Header{"Authorization": {"Bearer secret"}},
+		},
+		{
+			name:   "denied headers",
+			cfg:    ProxyPluginConfig{Deny: []string{"Cookie", "X-Internal-*"}},
+			header: http.Header{"X-Tenant": {"rebels"}, "X-Internal-Token": {"secret"}, "Cookie": {"session=abc"}},
+			want:   http.Header{"X-Tenant": {"rebels"}},
+		},
+		{
+			name:   "renamed headers",
+			cfg:    ProxyPluginConfig{Rename: map[string]string{"x-tenant": "X-Org", "X-Org": "X-Legacy-Org"}},
+			header: http.Header{"X-Tenant": {"rebels"}, "X-Org": {"empire"}},
+			want:   http.Header{"X-Org": {"rebels"}, "X-Legacy-Org": {"empire"}},
+		},
+		{
+			name: "set headers",
+			cfg: ProxyPluginConfig{UnverifiedClaims: true, Set: map[string]string{
+				"X-Gateway":   "porte",
+				"X-Client-Ip": "{{.ClientIP}}",
+				"X-User":      "{{.Claims.sub}}",
+				"X-Admin":     "{{.Claims.admin}}",
+				"X-Exp":       "{{.Claims.exp}}",
+				"X-Roles":     "{{.Claims.roles}}",
+				"X-Org":       "{{.Claims.org}}",
+				"X-Tenant":    `{{.Header.Get "X-Tenant"}}-1`,
+			}},
+			header: http.Header{"Authorization": {"Bearer " + token}, "X-Tenant": {"rebels"}, "X-Org": {"forged"}},
+			want: http.Header{
+				"Authorization": {"Bearer " + token},
+				"X-Gateway":     {"porte"},
+				"X-Client-Ip":   {"192.0.2.1"},
+				"X-User":        {"luke"},
+				"X-Admin":       {"true"},
+				"X-Exp":         {"1700000000"},
+				"X-Roles":       {`["jedi"]`},
+				"X-Tenant":      {"rebels-1"},
+			},
+		},
+		{
+			name:   "claims not exposed by default",
+			cfg:    ProxyPluginConfig{Set: map[string]string{"X-User": "{{.Claims.sub}}"}},
+			header: http.Header{"Authorization": {"Bearer " + token}, "X-User": {"forged"}},
+			want:   http.Header{"Authorization": {"Bearer " + token}},
+		},
+		{
+			name:   "appended forwarded headers",
+			cfg:    ProxyPluginConfig{ForwardedFor: ForwardedAppend, Forwarded: ForwardedAppend},
+			header: http.Header{"X-Forwarded-For": {"198.51.100.7"}},
+			want: http.Header{
+				"X-Forwarded-For": {"198.51.100.7, 192.0.2.1"},
+				"Forwarded":       {`for=192.0.2.1;host="porte.test";proto=http`},
+			},
+		},
+		{
+			name:   "stripped forwarded headers",
+			cfg:    ProxyPluginConfig{ForwardedFor: ForwardedStrip, Forwarded: ForwardedStrip},
+			header: http.Header{"X-Forwarded-For": {"198.51.100.7"}, "Forwarded": {"for=198.51.100.7"}},
+			want:   http.Header{},
+		},
+		{
+			name:   "request ID",
+			cfg:    ProxyPluginConfig{RequestIDHeader: DefaultRequestIDHeader},
+			header: http.Header{"X-Request-Id": {"forged"}},
+			want:   http.Header{"X-Request-Id": {"exec"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+			}))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+			g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plug, err := NewProxyPlugin(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewProxyPlugin() returned error: %s", err)
+			}
+			var execID string
+			recorder := &proxy.Plugin{
+				InitContext: func(ctx context.Context) context.Context {
+					execID = proxy.GetExecID(ctx)
+					return ctx
+				},
+			}
+			p, err := proxy.New(&proxy.Config{Graph: g, Plugins: []*proxy.Plugin{recorder, plug}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "http://porte.test/graphql", strings.NewReader(`{"query":"{ hero }"}`))
+			r.RemoteAddr = "192.0.2.1:4321"
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			r.Header.Set("Content-Type", "application/json")
+			p.ServeHTTP(httptest.NewRecorder(), r)
+
+			if ct := got.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("graph received content type %s", ct)
+			}
+			if accept := got.Get("Accept"); accept != "application/graphql-response+json, application/json;q=0.9" {
+				t.Errorf("graph received accept %s", accept)
+			}
+			got.Del("Content-Type")
+			got.Del("Accept")
+			got.Del("Content-Length")
+			got.Del("Accept-Encoding")
+			got.Del("User-Agent")
+			want := tt.want.Clone()
+			if want.Get("X-Request-Id") == "exec" {
+				want.Set("X-Request-Id", execID)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("graph received headers %v, expected %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewProxyPlugin_errors(t *testing.T) {
+	for _, cfg := range []ProxyPluginConfig{
+		{ForwardedFor: "drop"},
+		{Set: map[string]string{"X-User": "{{.Claims.sub"}},
+	} {
+		if _, err := NewProxyPlugin(cfg); err == nil {
+			t.Errorf("NewProxyPlugin(%+v) returned no error", cfg)
+		}
+	}
+}
+
+var testRules = ProxyPluginConfig{
+	Deny:            []string{"Cookie", "X-Internal-*"},
+	ForwardedFor:    ForwardedAppend,
+	RequestIDHeader: DefaultRequestIDHeader,
+}
+
+// checkRulesApplied checks the rules of testRules were applied to the header
+// received by a graph from a client connected from 192.0.2.1.
+func checkRulesApplied(t *testing.T, got http.Header) {
+	for _, name := range []string{"Cookie", "X-Internal-Token"} {
+		if v := got.Get(name); v != "" {
+			t.Errorf("graph received denied header %s: %s", name, v)
+		}
+	}
+	if v := got.Get("X-Tenant"); v != "rebels" {
+		t.Errorf("graph received X-Tenant %q, expected rebels", v)
+	}
+	if v := got.Get("X-Forwarded-For"); v != "192.0.2.1" {
+		t.Errorf("graph received X-Forwarded-For %q, expected 192.0.2.1", v)
+	}
+	if v := got.Get("X-Request-Id"); v == "" {
+		t.Errorf("graph received no request ID")
+	}
+}
+
+var testClientHeader = http.Header{
+	"X-Tenant":         {"rebels"},
+	"X-Internal-Token": {"secret"},
+	"Cookie":           {"session=abc"},
+}
+
+func TestProxyPlugin_subscriptions(t *testing.T) {
+	got := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{proxy.SubprotocolGraphQLTransportWS}}
+	graphSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer graphSrv.Close()
+	graphURL, _ := url.Parse(graphSrv.URL)
+	subscriptionURL, _ := url.Parse("ws" + strings.TrimPrefix(graphSrv.URL, "http"))
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: graphURL, SubscriptionURL: subscriptionURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plug, err := NewProxyPlugin(testRules)
+	if err != nil {
+		t.Fatalf("NewProxyPlugin() returned error: %s", err)
+	}
+	p, err := proxy.New(&proxy.Config{Graph: g, Plugins: []*proxy.Plugin{plug}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client connects from 192.0.2.1
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "192.0.2.1:4321"
+		p.ServeHTTP(w, r)
+	}))
+	defer proxySrv.Close()
+
+	dialer := &websocket.Dialer{Subprotocols: []string{proxy.SubprotocolGraphQLTransportWS}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(proxySrv.URL, "http"), testClientHeader)
+	if err != nil {
+		t.Fatalf("failed to connect to the proxy: %s", err)
+	}
+	defer conn.Close()
+
+	select {
+	case header := <-got:
+		checkRulesApplied(t, header)
+	case <-time.After(time.Second):
+		t.Fatal("graph received no subscriptions handshake")
+	}
+}
+
+func TestProxyPlugin_shadow(t *testing.T) {
+	var servers []*httptest.Server
+	defer func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}()
+	newGraph := func(received chan<- http.Header) graph.Graph {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if received != nil {
+				received <- r.Header.Clone()
+			}
+			io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+		}))
+		servers = append(servers, srv)
+		u, _ := url.Parse(srv.URL)
+		g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	got := make(chan http.Header, 1)
+
+	plug, err := NewProxyPlugin(testRules)
+	if err != nil {
+		t.Fatalf("NewProxyPlugin() returned error: %s", err)
+	}
+	shadowPlug, err := shadow.NewProxyPlugin(shadow.ProxyPluginConfig{
+		Graph:      newGraph(got),
+		Transport:  plug.SendGraphRequest(http.DefaultTransport),
+		SampleRate: 1,
+		Registerer: prometheus.NewRegistry(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := proxy.New(&proxy.Config{Graph: newGraph(nil), Plugins: []*proxy.Plugin{shadowPlug, plug}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hero }"}`))
+	r.RemoteAddr = "192.0.2.1:4321"
+	for name, values := range testClientHeader {
+		r.Header[name] = values
+	}
+	r.Header.Set("Content-Type", "application/json")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+
+	select {
+	case header := <-got:
+		checkRulesApplied(t, header)
+	case <-time.After(time.Second):
+		t.Fatal("shadow graph received no request")
+	}
+}
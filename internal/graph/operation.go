@@ -0,0 +1,232 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	OperationTypeQuery        = ast.OperationTypeQuery
+	OperationTypeMutation     = ast.OperationTypeMutation
+	OperationTypeSubscription = ast.OperationTypeSubscription
+)
+
+// ParseQuery parses the query document of the request.
+func (r *Request) ParseQuery() (*ast.Document, error) {
+	if r.Query == "" {
+		return nil, errors.New("missing query")
+	}
+	return parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(r.Query),
+			Name: "GraphQL request",
+		}),
+	})
+}
+
+// Operation returns the definition of the operation the request selects
+// using its operation name.
+func (r *Request) Operation() (*ast.OperationDefinition, error) {
+	doc, err := r.ParseQuery()
+	if err != nil {
+		return nil, err
+	}
+	return SelectOperation(doc, r.OperationName)
+}
+
+// OperationType returns the type of the operation the request selects, one
+// of OperationTypeQuery, OperationTypeMutation or OperationTypeSubscription.
+func (r *Request) OperationType() (string, error) {
+	op, err := r.Operation()
+	if err != nil {
+		return "", err
+	}
+	if op.Operation == "" {
+		return OperationTypeQuery, nil
+	}
+	return op.Operation, nil
+}
+
+// SelectOperation returns the operation of the document with the given name.
+// When name is empty, the document must contain exactly one operation.
+func SelectOperation(doc *ast.Document, name string) (*ast.OperationDefinition, error) {
+	var selected *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if selected != nil {
+				return nil, errors.New("must provide operation name if query contains multiple operations")
+			}
+			selected = op
+			continue
+		}
+		if op.Name != nil && op.Name.Value == name {
+			return op, nil
+		}
+	}
+	if selected == nil {
+		if name != "" {
+			return nil, fmt.Errorf("unknown operation named \"%s\"", name)
+		}
+		return nil, errors.New("must provide an operation")
+	}
+	return selected, nil
+}
+
+// PrintDocument prints the query document.
+func PrintDocument(doc *ast.Document) string {
+	s, _ := printer.Print(doc).(string)
+	return s
+}
+
+// ResponseKey returns the key of the field in the response: its alias, or
+// its name when it has none.
+func ResponseKey(field *ast.Field) string {
+	if field.Alias != nil {
+		return field.Alias.Value
+	}
+	return field.Name.Value
+}
+
+// WithConditions returns the conditions followed by the @skip and @include
+// directives among the given ones. Gateways use them to keep the conditions
+// of the selections they move to the documents sent to their graphs.
+func WithConditions(conditions [][]*ast.Directive, dirs []*ast.Directive) [][]*ast.Directive {
+	level := make([]*ast.Directive, 0)
+	for _, d := range dirs {
+		if d.Name.Value == "skip" || d.Name.Value == "include" {
+			level = append(level, d)
+		}
+	}
+	// the conditions are shared by sibling selections, they are copied
+	return append(append([][]*ast.Directive{}, conditions...), level)
+}
+
+// RootField is a field of the root selection set of an operation, along with
+// the @skip and @include directives of the fragments it was selected in.
+type RootField struct {
+	Field      *ast.Field
+	Conditions [][]*ast.Directive
+}
+
+// RootFields returns the fields of the selection set, including the ones of
+// its inline fragments and of the given fragments it spreads.
+func RootFields(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition) []*RootField {
+	return rootFields(set, fragments, nil)
+}
+
+func rootFields(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, conditions [][]*ast.Directive) []*RootField {
+	fields := make([]*RootField, 0)
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			fields = append(fields, &RootField{Field: sel, Conditions: conditions})
+		case *ast.InlineFragment:
+			fields = append(fields, rootFields(sel.SelectionSet, fragments, WithConditions(conditions, sel.Directives))...)
+		case *ast.FragmentSpread:
+			if frag, ok := fragments[sel.Name.Value]; ok {
+				fields = append(fields, rootFields(frag.SelectionSet, fragments, WithConditions(conditions, sel.Directives))...)
+			}
+		}
+	}
+	return fields
+}
+
+// NestInConditions returns the selection nested in inline fragments holding
+// the conditions it was selected with, as returned by WithConditions.
+func NestInConditions(sel ast.Selection, conditions [][]*ast.Directive) ast.Selection {
+	for i := len(conditions) - 1; i >= 0; i-- {
+		if len(conditions[i]) == 0 {
+			continue
+		}
+		sel = ast.NewInlineFragment(&ast.InlineFragment{
+			Directives:   conditions[i],
+			SelectionSet: ast.NewSelectionSet(&ast.SelectionSet{Selections: []ast.Selection{sel}}),
+		})
+	}
+	return sel
+}
+
+// VariablesAndFragmentsUsed returns the names of the variables and, sorted,
+// of the given fragments the selection set uses. Gateways use them to build
+// the documents sent to their graphs out of parts of an operation.
+func VariablesAndFragmentsUsed(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition) (map[string]bool, []string) {
+	u := &usage{
+		fragments: make(map[string]bool),
+		variables: make(map[string]bool),
+		all:       fragments,
+	}
+	u.selectionSet(set)
+	names := make([]string, 0, len(u.fragments))
+	for name := range u.fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return u.variables, names
+}
+
+// usage collects the fragments and variables used by a selection set.
+type usage struct {
+	fragments map[string]bool
+	variables map[string]bool
+	all       map[string]*ast.FragmentDefinition
+}
+
+func (u *usage) selectionSet(set *ast.SelectionSet) {
+	if set == nil {
+		return
+	}
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			for _, arg := range sel.Arguments {
+				u.value(arg.Value)
+			}
+			u.directives(sel.Directives)
+			u.selectionSet(sel.SelectionSet)
+		case *ast.InlineFragment:
+			u.directives(sel.Directives)
+			u.selectionSet(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			u.directives(sel.Directives)
+			name := sel.Name.Value
+			if frag, ok := u.all[name]; ok && !u.fragments[name] {
+				u.fragments[name] = true
+				u.directives(frag.Directives)
+				u.selectionSet(frag.SelectionSet)
+			}
+		}
+	}
+}
+
+func (u *usage) directives(dirs []*ast.Directive) {
+	for _, d := range dirs {
+		for _, arg := range d.Arguments {
+			u.value(arg.Value)
+		}
+	}
+}
+
+func (u *usage) value(v ast.Value) {
+	switch v := v.(type) {
+	case *ast.Variable:
+		u.variables[v.Name.Value] = true
+	case *ast.ListValue:
+		for _, item := range v.Values {
+			u.value(item)
+		}
+	case *ast.ObjectValue:
+		for _, field := range v.Fields {
+			u.value(field.Value)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	"github.com/herzult/porte/internal/graph"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CircuitBreakerConfig defines the configuration of the circuit breaker
+// metrics.
+type CircuitBreakerConfig struct {
+	Namespace string
+	Subsystem string
+	// Registerer registers the metrics, it is the default prometheus
+	// registerer by default.
+	Registerer prometheus.Registerer
+}
+
+// RegisterCircuitBreaker registers the metrics of the circuit breaker of a
+// graph: a gauge per state, set when the breaker is in it, and the number of
+// requests it rejected.
+func RegisterCircuitBreaker(b *graph.CircuitBreaker, cfg CircuitBreakerConfig) error {
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+
+	collectors := []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: cfg.Subsystem,
+			Name:      "graph_circuit_breaker_rejections_total",
+			Help:      "Number of graph requests rejected by the open circuit breaker.",
+		}, func() float64 {
+			return float64(b.Rejections())
+		}),
+	}
+	for _, state := range []graph.CircuitState{graph.CircuitClosed, graph.CircuitHalfOpen, graph.CircuitOpen} {
+		state := state
+		collectors = append(collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "graph_circuit_breaker_state",
+			Help:        "Whether the circuit breaker of the graph is in the labeled state: closed, half-open or open.",
+			ConstLabels: prometheus.Labels{"state": state.String()},
+		}, func() float64 {
+			if b.State() == state {
+				return 1
+			}
+			return 0
+		}))
+	}
+
+	for _, c := range collectors {
+		if err := cfg.Registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,20 +9,27 @@ import (
 	"github.com/herzult/porte/internal/graph"
 	"github.com/herzult/porte/internal/graph/proxy"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // ProxyPluginConfig defines the configuration of the proxy plugin
 type ProxyPluginConfig struct {
 	Namespace string
 	Subsystem string
+	// Registerer registers the metrics, it is the default prometheus
+	// registerer by default. Plugins of several graphs register their
+	// metrics with registerers adding a label identifying the graph, see
+	// prometheus.WrapRegistererWith.
+	Registerer prometheus.Registerer
 }
 
 // NewProxyPlugin returns a new proxy instance that records metrics using
 // prometheus.
 func NewProxyPlugin(cfg ProxyPluginConfig) (*proxy.Plugin, error) {
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
 
-	graphGraphqlErrorsTotal := promauto.NewCounter(prometheus.CounterOpts{
+	graphGraphqlErrorsTotal := prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: cfg.Namespace,
 		Subsystem: cfg.Subsystem,
 		Name:      "graphql_errors_total",
@@ -54,11 +61,16 @@ func NewProxyPlugin(cfg ProxyPluginConfig) (*proxy.Plugin, error) {
 		},
 	)
 
-	prometheus.MustRegister(
+	for _, c := range []prometheus.Collector{
+		graphGraphqlErrorsTotal,
 		graphHTTPRequestsTotal,
 		graphHTTPRequestDuration,
 		graphHTTPRequestsInFlight,
-	)
+	} {
+		if err := cfg.Registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
 
 	return &proxy.Plugin{
 		InitContext: func(ctx context.Context) context.Context {
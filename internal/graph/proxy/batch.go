@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// BatchMode defines how the operations of a batched graph request are sent
+// to the graph.
+type BatchMode string
+
+const (
+	// BatchModeFanOut executes every operation of the batch as a separate
+	// graph request, concurrently.
+	BatchModeFanOut BatchMode = "fanout"
+	// BatchModeUpstream forwards the whole batch to the graph in a single
+	// graph request. It requires the graph to implement graph.BatchGraph and
+	// falls back to BatchModeFanOut otherwise.
+	BatchModeUpstream BatchMode = "upstream"
+)
+
+// BatchConfig defines how the proxy handles batched graph requests, that is
+// requests whose body is a JSON array of operations.
+type BatchConfig struct {
+	Mode BatchMode
+	// Concurrency limits the number of operations of a batch executed at the
+	// same time in BatchModeFanOut. Zero means no limit.
+	Concurrency int
+}
+
+type execContextsKey struct{}
+
+// GetExecContexts returns the contexts of all the executions sent to the
+// graph as part of the graph request made with the given context. It only
+// returns more than the given context when the proxy forwards a batch of
+// operations to the graph in a single request.
+func GetExecContexts(ctx context.Context) []context.Context {
+	if ctxs, ok := ctx.Value(execContextsKey{}).([]context.Context); ok {
+		return ctxs
+	}
+	return []context.Context{ctx}
+}
+
+// readBatch returns the operations of the HTTP request when its body is a
+// batch of graph requests. It returns nil when the HTTP request does not
+// contain a batch, leaving its body untouched.
+func readBatch(r *http.Request) ([]json.RawMessage, error) {
+	if r.Method != http.MethodPost {
+		return nil, nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || mediaType != "application/json" {
+			return nil, nil
+		}
+	}
+
+	bdy, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read graph request from HTTP request's body: %s", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(bdy))
+
+	if trimmed := bytes.TrimLeft(bdy, " \t\r\n"); len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, nil
+	}
+	var ops []json.RawMessage
+	if err := json.Unmarshal(bdy, &ops); err != nil {
+		return nil, fmt.Errorf("Failed to decode graph requests batch from HTTP request's body: %s", err)
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("Graph requests batch can not be empty")
+	}
+
+	return ops, nil
+}
+
+// batchOperation holds the state of one operation of a batch.
+type batchOperation struct {
+	req      *http.Request
+	graphReq *graph.Request
+	graphRes *graph.Response
+	graphErr error
+	readErr  error
+}
+
+func (p *proxy) serveBatch(w http.ResponseWriter, r *http.Request, rawOps []json.RawMessage) {
+	ops := make([]*batchOperation, len(rawOps))
+	for i, rawOp := range rawOps {
+		// every operation gets its own execution context and goes through
+		// the plugins as if it was sent alone.
+		req := r.WithContext(p.initContext(r.Context()))
+		req.Header = r.Header.Clone()
+		req.Header.Set("Content-Type", "application/json")
+		req.Body = ioutil.NopCloser(bytes.NewReader(rawOp))
+		req.ContentLength = int64(len(rawOp))
+
+		op := &batchOperation{req: req}
+		op.graphReq, op.readErr = p.readProxyRequest(req)
+		ops[i] = op
+	}
+
+	pending := make([]*batchOperation, 0, len(ops))
+	for _, op := range ops {
+		if op.readErr == nil && op.graphReq != nil {
+			pending = append(pending, op)
+		}
+	}
+
+	if bg, ok := p.graph.(graph.BatchGraph); ok && p.batch.Mode == BatchModeUpstream {
+		p.executeBatchUpstream(r, bg, pending)
+	} else {
+		p.executeBatchFanOut(pending)
+	}
+
+	results := make([]json.RawMessage, len(ops))
+	for i, op := range ops {
+		results[i] = p.writeBatchOperation(op, w.Header())
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Println("Failed to write back graph responses batch:", err.Error())
+	}
+}
+
+func (p *proxy) executeBatchFanOut(ops []*batchOperation) {
+	var sem chan struct{}
+	if p.batch.Concurrency > 0 {
+		sem = make(chan struct{}, p.batch.Concurrency)
+	}
+
+	var wg sync.WaitGroup
+	for _, op := range ops {
+		wg.Add(1)
+		go func(op *batchOperation) {
+			defer wg.Done()
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+			op.graphRes, op.graphErr = p.graph.Execute(
+				op.req.Context(),
+				op.graphReq,
+				ForwardHeadersToGraph(p.graphTransport, op.req.Header),
+			)
+		}(op)
+	}
+	wg.Wait()
+}
+
+func (p *proxy) executeBatchUpstream(r *http.Request, bg graph.BatchGraph, ops []*batchOperation) {
+	if len(ops) == 0 {
+		return
+	}
+
+	graphReqs := make([]*graph.Request, len(ops))
+	execCtxs := make([]context.Context, len(ops))
+	for i, op := range ops {
+		graphReqs[i] = op.graphReq
+		execCtxs[i] = op.req.Context()
+	}
+
+	// the single graph request is sent with the context of the first
+	// operation, plugins use GetExecContexts to reach the others.
+	ctx := context.WithValue(execCtxs[0], execContextsKey{}, execCtxs)
+	graphRess, graphErr := bg.ExecuteBatch(
+		ctx,
+		graphReqs,
+		ForwardHeadersToGraph(p.graphTransport, r.Header),
+	)
+	for i, op := range ops {
+		if graphErr != nil {
+			op.graphErr = graphErr
+			continue
+		}
+		op.graphRes = graphRess[i]
+	}
+}
+
+// writeBatchOperation writes the response of the operation through the
+// plugins and returns its JSON encoded result, adding the allowed headers of
+// the graph response to header.
+func (p *proxy) writeBatchOperation(op *batchOperation, header http.Header) json.RawMessage {
+	ctx := op.req.Context()
+	graphRes, graphErr := op.graphRes, op.graphErr
+	var reqErr *graph.RequestError
+	switch {
+	case errors.As(op.readErr, &reqErr):
+		graphRes, graphErr = &graph.Response{Errors: reqErr.Errors}, op.readErr
+	case op.readErr != nil:
+		graphRes, graphErr = &graph.Response{
+			Errors: []*graph.Error{
+				&graph.Error{
+					Message: fmt.Sprintf("Bad request: %s", op.readErr),
+				},
+			},
+		}, op.readErr
+	case op.graphReq != nil && graphRes == nil && graphErr != nil:
+		graphRes = newExecutionFailedResponse(graphErr)
+	}
+
+	buf := newResponseBuffer()
+	p.writeProxyResponse(ctx, buf, graphRes, graphErr)
+	p.responseHeaders.copy(header, buf.header)
+
+	bdy := bytes.TrimSpace(buf.body.Bytes())
+	if len(bdy) == 0 {
+		return json.RawMessage("null")
+	}
+	if !json.Valid(bdy) {
+		return mustMarshalResponse(&graph.Response{
+			Errors: []*graph.Error{
+				&graph.Error{
+					Message: string(bdy),
+				},
+			},
+		})
+	}
+	return json.RawMessage(bdy)
+}
+
+func mustMarshalResponse(graphRes *graph.Response) json.RawMessage {
+	bdy, err := json.Marshal(graphRes)
+	if err != nil {
+		panic(err)
+	}
+	return bdy
+}
+
+// responseBuffer is an http.ResponseWriter keeping the response in memory.
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{
+		header: make(http.Header),
+		code:   http.StatusOK,
+	}
+}
+
+func (b *responseBuffer) Header() http.Header         { return b.header }
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *responseBuffer) WriteHeader(code int)        { b.code = code }
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+type testBatchGraph struct {
+	mu      sync.Mutex
+	calls   int
+	batches int
+}
+
+func (g *testBatchGraph) ID() string { return "test" }
+
+func (g *testBatchGraph) Execute(_ context.Context, req *graph.Request, _ http.RoundTripper) (*graph.Response, error) {
+	g.mu.Lock()
+	g.calls++
+	g.mu.Unlock()
+	return &graph.Response{Data: map[string]interface{}{"query": req.Query}}, nil
+}
+
+func (g *testBatchGraph) ExecuteBatch(ctx context.Context, reqs []*graph.Request, t http.RoundTripper) ([]*graph.Response, error) {
+	g.mu.Lock()
+	g.batches++
+	g.mu.Unlock()
+	ress := make([]*graph.Response, len(reqs))
+	for i, req := range reqs {
+		ress[i] = &graph.Response{Data: map[string]interface{}{"query": req.Query}}
+	}
+	return ress, nil
+}
+
+func TestProxy_batch(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        BatchMode
+		wantCalls   int
+		wantBatches int
+	}{
+		{name: "fan out", mode: BatchModeFanOut, wantCalls: 3},
+		{name: "upstream", mode: BatchModeUpstream, wantBatches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &testBatchGraph{}
+			execIDs := make(chan string, 3)
+			p, err := New(&Config{
+				Graph: g,
+				Plugins: []*Plugin{
+					&Plugin{
+						WriteProxyResponse: func(next WriteProxyResponse) WriteProxyResponse {
+							return func(ctx context.Context, w http.ResponseWriter, res *graph.Response, err error) {
+								execIDs <- GetExecID(ctx)
+								next(ctx, w, res, err)
+							}
+						},
+					},
+				},
+				Batch: BatchConfig{Mode: tt.mode, Concurrency: 2},
+			})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(
+				`[{"query":"{ a }"}, {"query":"{ b }"}, {"query":"{ c }"}]`,
+			))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() responded with status %d", w.Code)
+			}
+			var ress []*graph.Response
+			if err := json.NewDecoder(w.Body).Decode(&ress); err != nil {
+				t.Fatalf("failed to decode batch response: %s", err)
+			}
+			for i, query := range []string{"{ a }", "{ b }", "{ c }"} {
+				data, _ := ress[i].Data.(map[string]interface{})
+				if data["query"] != query {
+					t.Errorf("response %d has data %v, expected query %q", i, ress[i].Data, query)
+				}
+			}
+			if g.calls != tt.wantCalls || g.batches != tt.wantBatches {
+				t.Errorf(
+					"graph executed %d requests and %d batches, expected %d and %d",
+					g.calls, g.batches, tt.wantCalls, tt.wantBatches,
+				)
+			}
+			close(execIDs)
+			seen := map[string]bool{}
+			for id := range execIDs {
+				seen[id] = true
+			}
+			if len(seen) != 3 {
+				t.Errorf("operations shared exec IDs: %v", seen)
+			}
+		})
+	}
+}
+
+func TestProxy_batch_invalid_operation(t *testing.T) {
+	var mu sync.Mutex
+	var errs []error
+	p, err := New(&Config{
+		Graph: &testBatchGraph{},
+		Plugins: []*Plugin{
+			&Plugin{
+				WriteProxyResponse: func(next WriteProxyResponse) WriteProxyResponse {
+					return func(ctx context.Context, w http.ResponseWriter, res *graph.Response, err error) {
+						mu.Lock()
+						errs = append(errs, err)
+						mu.Unlock()
+						next(ctx, w, res, err)
+					}
+				},
+			},
+		},
+		Batch: BatchConfig{Mode: BatchModeFanOut},
+	})
+	if err != nil {
+		t.Fatalf("New() returned error: %s", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`[{"query":"{ a }"}, 42]`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+
+	var ress []*graph.Response
+	if err := json.NewDecoder(w.Body).Decode(&ress); err != nil {
+		t.Fatalf("failed to decode batch response: %s", err)
+	}
+	if len(ress) != 2 {
+		t.Fatalf("ServeHTTP() responded with %d responses, expected 2", len(ress))
+	}
+	if len(ress[1].Errors) != 1 || !strings.HasPrefix(ress[1].Errors[0].Message, "Bad request: ") {
+		t.Errorf("ServeHTTP() responded with %s, expected a bad request error", mustMarshalResponse(ress[1]))
+	}
+	// the invalid operation goes through the plugins like the others
+	if len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+		t.Errorf("plugins wrote responses with errors %v, expected [<nil> <error>]", errs)
+	}
+}
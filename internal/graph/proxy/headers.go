@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// ResponseHeaderPolicy defines which headers of the graph responses are
+// written back to the client. Header names are case insensitive, a name
+// ending with * matches the names starting with what precedes it, and * alone
+// matches all of them. No header is written back by default.
+//
+// The hop-by-hop headers, the ones describing the response body, which the
+// proxy encodes itself, and the Date header are never written back.
+type ResponseHeaderPolicy struct {
+	// Allow lists the headers written back, like Set-Cookie or
+	// X-RateLimit-*.
+	Allow []string
+	// Deny lists the headers not written back, even when allowed.
+	Deny []string
+}
+
+// ownHeaders describe the graph responses themselves, they do not apply to
+// the responses of the proxy.
+var ownHeaders = []string{
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"Date",
+	"Etag",
+	"Last-Modified",
+}
+
+// allows returns whether the header with the given canonical name is written
+// back to the client.
+func (p *ResponseHeaderPolicy) allows(name string) bool {
+	for _, h := range hopHeaders {
+		if name == h {
+			return false
+		}
+	}
+	for _, h := range ownHeaders {
+		if name == h {
+			return false
+		}
+	}
+	return MatchHeader(p.Allow, name) && !MatchHeader(p.Deny, name)
+}
+
+// MatchHeader returns whether the header with the given canonical name
+// matches one of the patterns, a pattern ending with * matching the names
+// starting with what precedes it.
+func MatchHeader(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+				return true
+			}
+		} else if http.CanonicalHeaderKey(pattern) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// copy adds the allowed headers of src to dst, except the values dst already
+// has.
+func (p *ResponseHeaderPolicy) copy(dst, src http.Header) {
+	if len(p.Allow) == 0 {
+		return
+	}
+	for name, values := range src {
+		if !p.allows(name) {
+			continue
+		}
+		for _, v := range values {
+			if !hasHeaderValue(dst[name], v) {
+				dst[name] = append(dst[name], v)
+			}
+		}
+	}
+}
+
+func hasHeaderValue(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResponseHeaders returns a writer of the proxy responses writing back
+// the headers of the graph responses allowed by the policy. The headers of
+// the responses the graph failed with, like Retry-After, are written back
+// too.
+func writeResponseHeaders(policy *ResponseHeaderPolicy, next WriteProxyResponse) WriteProxyResponse {
+	return func(ctx context.Context, w http.ResponseWriter, graphRes *graph.Response, graphErr error) {
+		if graphRes != nil {
+			policy.copy(w.Header(), graphRes.Header)
+		}
+		var serviceErr *graph.ServiceError
+		if errors.As(graphErr, &serviceErr) {
+			policy.copy(w.Header(), serviceErr.Header)
+		}
+		next(ctx, w, graphRes, graphErr)
+	}
+}
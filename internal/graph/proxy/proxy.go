@@ -3,12 +3,17 @@ package proxy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/herzult/porte/internal/graph"
 )
 
@@ -19,6 +24,19 @@ type Proxy interface {
 type Config struct {
 	Graph   graph.Graph
 	Plugins []*Plugin
+	Batch   BatchConfig
+	// ResponseHeaders is the policy of the headers of the graph responses
+	// written back to the client.
+	ResponseHeaders ResponseHeaderPolicy
+	// SubscriptionOrigins lists the origins of the web pages allowed to open
+	// subscriptions WebSockets, like https://app.example.org, besides the
+	// origin of the proxy itself. * allows every origin. The browsers send
+	// their cookies along with the handshakes of any page, which the proxy
+	// forwards to the graph.
+	SubscriptionOrigins []string
+	// Transport sends the requests to the graph, wrapped by the plugins. It
+	// is http.DefaultTransport by default.
+	Transport http.RoundTripper
 }
 
 type InitContext func(context.Context) context.Context
@@ -26,19 +44,33 @@ type ReadProxyRequest func(*http.Request) (*graph.Request, error)
 type SendGraphRequest func(*http.Request) (*http.Response, error)
 type WriteProxyResponse func(context.Context, http.ResponseWriter, *graph.Response, error)
 
+// ReadConnectionInit reads the payload of the connection_init message a
+// client sends when opening a subscriptions WebSocket, and returns the
+// payload to send to the graph in its place.
+type ReadConnectionInit func(*http.Request, map[string]interface{}) (map[string]interface{}, error)
+
+// SendSubscriptionHeader returns the header sent to the graph when opening
+// its subscriptions WebSocket for a client, given the client handshake
+// request and its header without the hop-by-hop and WebSocket ones. The
+// handshake request has an execution context of its own.
+type SendSubscriptionHeader func(*http.Request, http.Header) http.Header
+
 func (f SendGraphRequest) RoundTrip(r *http.Request) (*http.Response, error) {
 	return f(r)
 }
 
 type Plugin struct {
-	InitContext        InitContext
-	ReadProxyRequest   func(ReadProxyRequest) ReadProxyRequest
-	SendGraphRequest   func(http.RoundTripper) http.RoundTripper
-	WriteProxyResponse func(WriteProxyResponse) WriteProxyResponse
+	InitContext            InitContext
+	ReadProxyRequest       func(ReadProxyRequest) ReadProxyRequest
+	SendGraphRequest       func(http.RoundTripper) http.RoundTripper
+	WriteProxyResponse     func(WriteProxyResponse) WriteProxyResponse
+	ReadConnectionInit     func(ReadConnectionInit) ReadConnectionInit
+	SendSubscriptionHeader func(SendSubscriptionHeader) SendSubscriptionHeader
 }
 
 type graphKey struct{}
 type execIDKey struct{}
+type responseMediaTypeKey struct{}
 
 func GetGraph(ctx context.Context) graph.Graph {
 	return ctx.Value(graphKey{}).(graph.Graph)
@@ -48,6 +80,16 @@ func GetExecID(ctx context.Context) string {
 	return ctx.Value(execIDKey{}).(string)
 }
 
+// GetResponseMediaType returns the media type of the response to the client,
+// negotiated from its Accept header: graph.MediaTypeGraphQLResponse or
+// graph.MediaTypeJSON.
+func GetResponseMediaType(ctx context.Context) string {
+	if mediaType, ok := ctx.Value(responseMediaTypeKey{}).(string); ok {
+		return mediaType
+	}
+	return graph.MediaTypeJSON
+}
+
 func New(cfg *Config) (Proxy, error) {
 	initContext := func(ctx context.Context) context.Context {
 		ctx = context.WithValue(ctx, graphKey{}, cfg.Graph)
@@ -60,8 +102,16 @@ func New(cfg *Config) (Proxy, error) {
 		return ctx
 	}
 	readProxyRequest := graph.NewRequestFromHTTP
-	sendGraphRequest := http.DefaultTransport
+	sendGraphRequest := cfg.Transport
+	if sendGraphRequest == nil {
+		sendGraphRequest = http.DefaultTransport
+	}
 	writeProxyResponse := defaultWriteProxyResponse
+	if len(cfg.ResponseHeaders.Allow) > 0 {
+		writeProxyResponse = writeResponseHeaders(&cfg.ResponseHeaders, writeProxyResponse)
+	}
+	readConnectionInit := defaultReadConnectionInit
+	sendSubscriptionHeader := defaultSendSubscriptionHeader
 
 	for _, plugin := range cfg.Plugins {
 		if plugin.ReadProxyRequest != nil {
@@ -73,37 +123,68 @@ func New(cfg *Config) (Proxy, error) {
 		if plugin.WriteProxyResponse != nil {
 			writeProxyResponse = plugin.WriteProxyResponse(writeProxyResponse)
 		}
+		if plugin.ReadConnectionInit != nil {
+			readConnectionInit = plugin.ReadConnectionInit(readConnectionInit)
+		}
+		if plugin.SendSubscriptionHeader != nil {
+			sendSubscriptionHeader = plugin.SendSubscriptionHeader(sendSubscriptionHeader)
+		}
 	}
 
 	return &proxy{
-		initContext:        initContext,
-		readProxyRequest:   readProxyRequest,
-		writeProxyResponse: writeProxyResponse,
-		graph:              cfg.Graph,
-		graphTransport:     sendGraphRequest,
+		initContext:            initContext,
+		readProxyRequest:       readProxyRequest,
+		writeProxyResponse:     writeProxyResponse,
+		readConnectionInit:     readConnectionInit,
+		sendSubscriptionHeader: sendSubscriptionHeader,
+		graph:                  cfg.Graph,
+		graphTransport:         sendGraphRequest,
+		batch:                  cfg.Batch,
+		responseHeaders:        cfg.ResponseHeaders,
+		wsUpgrader:             newWebSocketUpgrader(cfg.SubscriptionOrigins),
 	}, nil
 }
 
 type proxy struct {
-	initContext        InitContext
-	readProxyRequest   ReadProxyRequest
-	writeProxyResponse WriteProxyResponse
-	graph              graph.Graph
-	graphTransport     http.RoundTripper
+	initContext            InitContext
+	readProxyRequest       ReadProxyRequest
+	writeProxyResponse     WriteProxyResponse
+	readConnectionInit     ReadConnectionInit
+	sendSubscriptionHeader SendSubscriptionHeader
+	graph                  graph.Graph
+	graphTransport         http.RoundTripper
+	batch                  BatchConfig
+	responseHeaders        ResponseHeaderPolicy
+	wsUpgrader             *websocket.Upgrader
 }
 
 func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	r = r.WithContext(p.initContext(r.Context()))
+	if websocket.IsWebSocketUpgrade(r) {
+		p.serveSubscriptions(w, r)
+		return
+	}
+
+	ops, err := readBatch(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if ops != nil {
+		p.serveBatch(w, r, ops)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), responseMediaTypeKey{}, negotiateMediaType(r.Header.Get("Accept")))
+	r = r.WithContext(p.initContext(ctx))
 
 	graphReq, err := p.readProxyRequest(r)
-	if err == graph.ErrHTTPMethodNotAllowed {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		fmt.Fprintf(w, "Method not allowed: %s", err)
+	var reqErr *graph.RequestError
+	if errors.As(err, &reqErr) {
+		p.writeProxyResponse(r.Context(), w, &graph.Response{Errors: reqErr.Errors}, err)
 		return
 	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Bad request: %s", err)
+		writeRequestError(w, err)
 		return
 	}
 
@@ -112,52 +193,135 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	graphRes, graphErr := p.graph.Execute(
-		r.Context(),
-		graphReq,
-		forwardHeadersToGraph(p.graphTransport, r.Header),
-	)
+	transport := ForwardHeadersToGraph(p.graphTransport, r.Header)
+	var graphRes *graph.Response
+	var graphErr error
+	if sg, ok := p.graph.(graph.StreamGraph); ok {
+		var stream graph.ResponseStream
+		graphRes, stream, graphErr = sg.ExecuteStream(r.Context(), graphReq, transport)
+		if stream != nil {
+			p.serveStream(w, r, stream)
+			return
+		}
+	} else {
+		graphRes, graphErr = p.graph.Execute(r.Context(), graphReq, transport)
+	}
 
 	if graphRes == nil && graphErr != nil {
-		graphRes = &graph.Response{
-			Errors: []*graph.Error{
-				&graph.Error{
-					Message: "Failed to execute graph request.",
-				},
-			},
-		}
+		graphRes = newExecutionFailedResponse(graphErr)
 	}
 
 	p.writeProxyResponse(r.Context(), w, graphRes, graphErr)
 }
 
-func forwardHeadersToGraph(next http.RoundTripper, head http.Header) http.RoundTripper {
+// executionFailedMessages are the messages of the errors of the graph
+// requests that failed to execute, by error code.
+var executionFailedMessages = map[string]string{
+	graph.ErrorCodeServiceUnreachable:     "Failed to reach graph.",
+	graph.ErrorCodeServiceUnavailable:     "Graph is unavailable.",
+	graph.ErrorCodeServiceTimeout:         "Graph did not respond in time.",
+	graph.ErrorCodeRequestCanceled:        "Graph request was canceled.",
+	graph.ErrorCodeServiceHTTPError:       "Graph responded with an HTTP error.",
+	graph.ErrorCodeServiceInvalidResponse: "Graph responded with an invalid response.",
+	graph.ErrorCodeCircuitOpen:            "Graph is unavailable, its requests are suspended after repeated failures.",
+}
+
+func newExecutionFailedResponse(err error) *graph.Response {
+	message, ok := executionFailedMessages[graph.ErrorCode(err)]
+	if !ok {
+		message = "Failed to execute graph request."
+	}
+	return &graph.Response{
+		Errors: []*graph.Error{
+			&graph.Error{
+				Message:    message,
+				Extensions: graph.ErrorExtensions(err),
+			},
+		},
+	}
+}
+
+// writeRequestError writes the HTTP error matching the given error returned
+// while reading the graph request.
+func writeRequestError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, graph.ErrHTTPMethodNotAllowed):
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Method not allowed: %s", err)
+	case errors.Is(err, graph.ErrOperationNotAllowed):
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Method not allowed: %s", err)
+	case errors.Is(err, graph.ErrUnsupportedMediaType):
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "Unsupported media type: %s", err)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Bad request: %s", err)
+	}
+}
+
+// ForwardHeadersToGraph returns a transport sending the graph requests with
+// the given client request headers, except the hop-by-hop ones.
+func ForwardHeadersToGraph(next http.RoundTripper, head http.Header) http.RoundTripper {
 	return SendGraphRequest(func(req *http.Request) (*http.Response, error) {
+		// the graph request has its own body and negotiates its own response,
+		// its content type and accepted media types are kept
+		contentType := req.Header.Get("Content-Type")
+		accept := req.Header.Get("Accept")
 		req.Header = head.Clone()
-		// Remove hop-by-hop headers to the backend. Especially
-		// important is "Connection" because we want a persistent
-		// connection, regardless of what the client sent to us.
-		for _, h := range hopHeaders {
-			hv := req.Header.Get(h)
-			if h == "Te" && hv == "trailers" {
-				continue
-			}
-			req.Header.Del(h)
-		}
-		// removes hop-by-hop headers listed in the "Connection" header of h.
-		// See RFC 7230, section 6.1
-		for _, f := range req.Header["Connection"] {
-			for _, sf := range strings.Split(f, ",") {
-				if sf = strings.TrimSpace(sf); sf != "" {
-					req.Header.Del(sf)
-				}
-			}
+		removeHopHeaders(req.Header)
+		req.Header.Del("Content-Length")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", graphAccept(accept, head.Get("Accept")))
 		}
 
 		return next.RoundTrip(req)
 	})
 }
 
+// graphAccept returns the media types accepted by the graph request, to
+// which the streamed media types accepted by the client are added so that
+// the graph may deliver its response incrementally.
+func graphAccept(accept, clientAccept string) string {
+	for _, value := range strings.Split(clientAccept, ",") {
+		mediaType, _, err := mime.ParseMediaType(value)
+		if err != nil {
+			continue
+		}
+		if mediaType == graph.MediaTypeMultipart || mediaType == graph.MediaTypeEventStream {
+			accept += ", " + strings.TrimSpace(value)
+		}
+	}
+	return accept
+}
+
+// removeHopHeaders removes the hop-by-hop headers from h. Especially
+// important is "Connection" because we want a persistent connection to the
+// backend, regardless of what the client sent to us.
+func removeHopHeaders(h http.Header) {
+	// removes hop-by-hop headers listed in the "Connection" header of h.
+	// See RFC 7230, section 6.1
+	for _, f := range h["Connection"] {
+		for _, sf := range strings.Split(f, ",") {
+			if sf = strings.TrimSpace(sf); sf != "" {
+				h.Del(sf)
+			}
+		}
+	}
+	for _, hh := range hopHeaders {
+		hv := h.Get(hh)
+		if hh == "Te" && hv == "trailers" {
+			continue
+		}
+		h.Del(hh)
+	}
+}
+
 var hopHeaders = []string{
 	"Connection",
 	"Proxy-Connection",
@@ -170,16 +334,89 @@ var hopHeaders = []string{
 	"Upgrade",
 }
 
-func defaultWriteProxyResponse(_ context.Context, w http.ResponseWriter, graphRes *graph.Response, graphErr error) {
+func defaultSendSubscriptionHeader(_ *http.Request, head http.Header) http.Header {
+	return head
+}
+
+func defaultReadConnectionInit(_ *http.Request, payload map[string]interface{}) (map[string]interface{}, error) {
+	return payload, nil
+}
+
+func defaultWriteProxyResponse(ctx context.Context, w http.ResponseWriter, graphRes *graph.Response, graphErr error) {
 	if graphRes == nil && graphErr == nil {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if graphRes == nil {
+		graphRes = newExecutionFailedResponse(graphErr)
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	mediaType := GetResponseMediaType(ctx)
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(responseStatus(mediaType, graphRes, graphErr))
 	err := json.NewEncoder(w).Encode(graphRes)
 	if err != nil {
 		log.Println("Failed to write back graph response:", err.Error())
 	}
 }
+
+// negotiateMediaType returns the media type of the response to a client
+// accepting the given media types. Following the GraphQL over HTTP
+// specification, application/json is preferred unless the client explicitly
+// accepts application/graphql-response+json at least as much.
+func negotiateMediaType(accept string) string {
+	graphqlQ, jsonQ := -1.0, -1.0
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case graph.MediaTypeGraphQLResponse:
+			graphqlQ = math.Max(graphqlQ, q)
+		case graph.MediaTypeJSON, "application/*", "*/*":
+			jsonQ = math.Max(jsonQ, q)
+		}
+	}
+	if graphqlQ > 0 && graphqlQ >= jsonQ {
+		return graph.MediaTypeGraphQLResponse
+	}
+	return graph.MediaTypeJSON
+}
+
+// responseStatus returns the HTTP status of the graph response sent with the
+// given media type. Requests failing to execute have the status matching
+// their error. Following the GraphQL over HTTP specification, the other
+// responses are 200 OK with application/json. With
+// application/graphql-response+json, responses with data are 200 OK, rejected
+// requests are 400 Bad Request and the responses of the service without data
+// are 400 Bad Request unless their status already is an error.
+func responseStatus(mediaType string, graphRes *graph.Response, graphErr error) int {
+	var reqErr *graph.RequestError
+	switch {
+	case errors.As(graphErr, &reqErr):
+		if mediaType == graph.MediaTypeGraphQLResponse {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	case graphRes == nil || graphErr != nil && graphRes.Data == nil:
+		switch graph.ErrorCode(graphErr) {
+		case graph.ErrorCodeCircuitOpen:
+			return http.StatusServiceUnavailable
+		case graph.ErrorCodeServiceTimeout:
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	case mediaType != graph.MediaTypeGraphQLResponse || graphRes.Data != nil:
+		return http.StatusOK
+	case graphRes.StatusCode >= http.StatusBadRequest:
+		return graphRes.StatusCode
+	}
+	return http.StatusBadRequest
+}
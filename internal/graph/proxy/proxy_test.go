@@ -0,0 +1,479 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// failingGraph fails to execute every request with its error.
+type failingGraph struct {
+	err error
+}
+
+func (g *failingGraph) ID() string { return "failing" }
+
+func (g *failingGraph) Execute(context.Context, *graph.Request, http.RoundTripper) (*graph.Response, error) {
+	return nil, g.err
+}
+
+func TestProxy_execution_errors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "overloaded")
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	upstreamGraph, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		graph graph.Graph
+		want  string
+	}{
+		{
+			name:  "upstream status",
+			graph: upstreamGraph,
+			want:  `{"errors":[{"message":"Graph responded with an HTTP error.","extensions":{"code":"SERVICE_HTTP_ERROR","status":503}}]}`,
+		},
+		{
+			name:  "open circuit",
+			graph: &failingGraph{err: graph.ErrCircuitOpen},
+			want:  `{"errors":[{"message":"Graph is unavailable, its requests are suspended after repeated failures.","extensions":{"code":"CIRCUIT_OPEN"}}]}`,
+		},
+		{
+			name:  "unknown error",
+			graph: &failingGraph{err: errors.New("boom")},
+			want:  `{"errors":[{"message":"Failed to execute graph request."}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(&Config{Graph: tt.graph})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hero }"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if body := strings.TrimSpace(w.Body.String()); body != tt.want {
+				t.Errorf("ServeHTTP() responded with\n%s\nexpected\n%s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_response_status(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		upstreamStatus  int
+		upstreamBody    string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "data",
+			upstreamStatus:  http.StatusOK,
+			upstreamBody:    `{"data":{"hero":"R2-D2"}}`,
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"data":{"hero":"R2-D2"}}`,
+		},
+		{
+			name:            "upstream errors",
+			upstreamStatus:  http.StatusBadRequest,
+			upstreamBody:    `{"errors":[{"message":"Cannot query field \"droid\" on type \"Query\"."}]}`,
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"errors":[{"message":"Cannot query field \"droid\" on type \"Query\"."}]}`,
+		},
+		{
+			name:            "upstream errors with data",
+			accept:          "application/graphql-response+json",
+			upstreamStatus:  http.StatusInternalServerError,
+			upstreamBody:    `{"data":{"hero":null},"errors":[{"message":"database is down","path":["hero"]}]}`,
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/graphql-response+json; charset=utf-8",
+			wantBody:        `{"data":{"hero":null},"errors":[{"message":"database is down","path":["hero"]}]}`,
+		},
+		{
+			name:            "upstream errors without data",
+			accept:          "application/graphql-response+json",
+			upstreamStatus:  http.StatusOK,
+			upstreamBody:    `{"errors":[{"message":"Cannot query field \"droid\" on type \"Query\"."}]}`,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "application/graphql-response+json; charset=utf-8",
+			wantBody:        `{"errors":[{"message":"Cannot query field \"droid\" on type \"Query\"."}]}`,
+		},
+		{
+			name:            "upstream error status without data",
+			accept:          "application/graphql-response+json",
+			upstreamStatus:  http.StatusUnauthorized,
+			upstreamBody:    `{"errors":[{"message":"not authenticated"}]}`,
+			wantStatus:      http.StatusUnauthorized,
+			wantContentType: "application/graphql-response+json; charset=utf-8",
+			wantBody:        `{"errors":[{"message":"not authenticated"}]}`,
+		},
+		{
+			name:            "upstream error status without data in legacy response",
+			accept:          "application/json",
+			upstreamStatus:  http.StatusUnauthorized,
+			upstreamBody:    `{"errors":[{"message":"not authenticated"}]}`,
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"errors":[{"message":"not authenticated"}]}`,
+		},
+		{
+			name:            "upstream failure",
+			accept:          "application/graphql-response+json, application/json;q=0.9",
+			upstreamStatus:  http.StatusServiceUnavailable,
+			upstreamBody:    `<html>Service Unavailable</html>`,
+			wantStatus:      http.StatusBadGateway,
+			wantContentType: "application/graphql-response+json; charset=utf-8",
+			wantBody:        `{"errors":[{"message":"Graph responded with an HTTP error.","extensions":{"code":"SERVICE_HTTP_ERROR","status":503}}]}`,
+		},
+		{
+			name:            "invalid request",
+			accept:          "application/graphql-response+json",
+			query:           "{",
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "application/graphql-response+json; charset=utf-8",
+		},
+		{
+			name:            "invalid legacy request",
+			accept:          "application/json",
+			query:           "{",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.upstreamStatus)
+				io.WriteString(w, tt.upstreamBody)
+			}))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+			g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// rejects the requests whose query does not parse
+			validation := &Plugin{
+				ReadProxyRequest: func(next ReadProxyRequest) ReadProxyRequest {
+					return func(r *http.Request) (*graph.Request, error) {
+						req, err := next(r)
+						if err == nil {
+							if _, parseErr := req.ParseQuery(); parseErr != nil {
+								return nil, &graph.RequestError{Errors: []*graph.Error{{Message: parseErr.Error()}}}
+							}
+						}
+						return req, err
+					}
+				},
+			}
+			p, err := New(&Config{Graph: g, Plugins: []*Plugin{validation}})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+
+			query := tt.query
+			if query == "" {
+				query = "{ hero }"
+			}
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"`+query+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() responded with status %d, expected %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantContentType {
+				t.Errorf("ServeHTTP() responded with content type %s, expected %s", ct, tt.wantContentType)
+			}
+			if body := strings.TrimSpace(w.Body.String()); tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("ServeHTTP() responded with\n%s\nexpected\n%s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestProxy_plugin_execution_failure(t *testing.T) {
+	tests := []struct {
+		name       string
+		accept     string
+		err        error
+		wantStatus int
+	}{
+		{
+			name:       "legacy response",
+			accept:     "application/json",
+			err:        graph.ErrCircuitOpen,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "graphql response",
+			accept:     "application/graphql-response+json",
+			err:        errors.New("boom"),
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// drops the graph response and writes the error alone
+			failing := &Plugin{
+				WriteProxyResponse: func(next WriteProxyResponse) WriteProxyResponse {
+					return func(ctx context.Context, w http.ResponseWriter, _ *graph.Response, _ error) {
+						next(ctx, w, nil, tt.err)
+					}
+				},
+			}
+			g := &failingGraph{err: errors.New("unused")}
+			p, err := New(&Config{Graph: g, Plugins: []*Plugin{failing}})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hero }"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() responded with status %d, expected %d", w.Code, tt.wantStatus)
+			}
+			want, _ := json.Marshal(newExecutionFailedResponse(tt.err))
+			if body := strings.TrimSpace(w.Body.String()); body != string(want) {
+				t.Errorf("ServeHTTP() responded with\n%s\nexpected\n%s", body, want)
+			}
+		})
+	}
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: graph.MediaTypeJSON},
+		{accept: "*/*", want: graph.MediaTypeJSON},
+		{accept: "application/json", want: graph.MediaTypeJSON},
+		{accept: "application/graphql-response+json", want: graph.MediaTypeGraphQLResponse},
+		{accept: "application/graphql-response+json, application/json", want: graph.MediaTypeGraphQLResponse},
+		{accept: "application/graphql-response+json;q=0.8, application/json", want: graph.MediaTypeJSON},
+		{accept: "application/json;q=0.9, application/graphql-response+json", want: graph.MediaTypeGraphQLResponse},
+		{accept: "application/graphql-response+json;q=0, */*", want: graph.MediaTypeJSON},
+		{accept: "text/html", want: graph.MediaTypeJSON},
+	}
+	for _, tt := range tests {
+		if got := negotiateMediaType(tt.accept); got != tt.want {
+			t.Errorf("negotiateMediaType(%q) = %s, expected %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestProxy_graph_request_headers(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(&Config{Graph: g})
+	if err != nil {
+		t.Fatalf("New() returned error: %s", err)
+	}
+
+	graphAccept := graph.MediaTypeGraphQLResponse + ", " + graph.MediaTypeJSON + ";q=0.9"
+	tests := []struct {
+		name   string
+		accept string
+		want   map[string]string
+	}{
+		{
+			name:   "client headers",
+			accept: "application/json",
+			want: map[string]string{
+				"Content-Type":  "application/json; charset=utf-8",
+				"Accept":        graphAccept,
+				"Authorization": "Bearer token",
+				"Keep-Alive":    "",
+			},
+		},
+		{
+			name:   "streamed media types",
+			accept: `multipart/mixed;deferSpec=20220824, text/event-stream, application/json;q=0.9`,
+			want: map[string]string{
+				"Accept": graphAccept + `, multipart/mixed;deferSpec=20220824, text/event-stream`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hero }"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Accept", tt.accept)
+			r.Header.Set("Authorization", "Bearer token")
+			r.Header.Set("Keep-Alive", "timeout=5")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			for name, value := range tt.want {
+				if v := got.Get(name); v != value {
+					t.Errorf("graph request has header %s %q, expected %q", name, v, value)
+				}
+			}
+		})
+	}
+}
+
+func TestProxy_response_headers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=abc; HttpOnly")
+		w.Header().Set("Cache-Control", "private")
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		w.Header().Set("X-Internal-Trace", "7f3a")
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("Connection", "keep-alive")
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", `multipart/mixed; boundary="-"`)
+			io.WriteString(w, "\r\n---\r\nContent-Type: application/json\r\n\r\n"+`{"data":{"hero":"R2-D2"},"hasNext":false}`+"\r\n-----\r\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name   string
+		policy ResponseHeaderPolicy
+		batch  BatchMode
+		stream bool
+		want   http.Header
+	}{
+		{
+			name: "nothing by default",
+			want: http.Header{},
+		},
+		{
+			name:   "allowed headers",
+			policy: ResponseHeaderPolicy{Allow: []string{"set-cookie", "Cache-Control", "X-RateLimit-*"}},
+			want: http.Header{
+				"Set-Cookie":            {"session=abc; HttpOnly"},
+				"Cache-Control":         {"private"},
+				"X-Ratelimit-Remaining": {"42"},
+			},
+		},
+		{
+			name:   "denied headers",
+			policy: ResponseHeaderPolicy{Allow: []string{"*"}, Deny: []string{"Server", "X-Internal-*"}},
+			want: http.Header{
+				"Set-Cookie":            {"session=abc; HttpOnly"},
+				"Cache-Control":         {"private"},
+				"X-Ratelimit-Remaining": {"42"},
+			},
+		},
+		{
+			name:   "fanned out batch",
+			policy: ResponseHeaderPolicy{Allow: []string{"Set-Cookie"}},
+			batch:  BatchModeFanOut,
+			want:   http.Header{"Set-Cookie": {"session=abc; HttpOnly"}},
+		},
+		{
+			name:   "streamed response",
+			policy: ResponseHeaderPolicy{Allow: []string{"Set-Cookie"}},
+			stream: true,
+			want:   http.Header{"Set-Cookie": {"session=abc; HttpOnly"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(upstream.URL)
+			if tt.stream {
+				u.Path = "/stream"
+			}
+			g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := New(&Config{Graph: g, ResponseHeaders: tt.policy, Batch: BatchConfig{Mode: tt.batch}})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+			body := `{"query":"{ hero }"}`
+			if tt.batch != "" {
+				body = `[{"query":"{ hero }"},{"query":"{ hero }"}]`
+			}
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			got := w.Header().Clone()
+			got.Del("Content-Type")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServeHTTP() responded with headers %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_response_headers_service_error(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.Header().Set("Server", "upstream")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "service unavailable")
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(&Config{Graph: g, ResponseHeaders: ResponseHeaderPolicy{Allow: []string{"Retry-After"}}})
+	if err != nil {
+		t.Fatalf("New() returned error: %s", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hero }"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("ServeHTTP() responded with Retry-After %q, expected %q", got, "120")
+	}
+	if got := w.Header().Get("Server"); got != "" {
+		t.Errorf("ServeHTTP() responded with Server %q, expected none", got)
+	}
+}
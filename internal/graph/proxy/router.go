@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Route selects the proxy serving the requests matching all of its
+// conditions. A condition left empty matches every request.
+type Route struct {
+	// Paths are the URL paths of the requests.
+	Paths []string
+	// Host is the host of the requests, without port.
+	Host string
+	// Header and HeaderValue select the requests by the value of a header.
+	Header      string
+	HeaderValue string
+
+	Proxy Proxy
+}
+
+func (r *Route) match(req *http.Request) bool {
+	if len(r.Paths) > 0 {
+		matched := false
+		for _, path := range r.Paths {
+			if req.URL.Path == path {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.Host != "" {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, r.Host) {
+			return false
+		}
+	}
+	return r.Header == "" || req.Header.Get(r.Header) == r.HeaderValue
+}
+
+// NewRouter returns a proxy routing each request to the proxy of the first
+// route it matches. Requests matching no route are answered with a 404 Not
+// Found.
+func NewRouter(routes []*Route) (Proxy, error) {
+	for _, route := range routes {
+		if route.Proxy == nil {
+			return nil, errors.New("route must have a proxy")
+		}
+	}
+	return &router{routes: routes}, nil
+}
+
+type router struct {
+	routes []*Route
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.routes {
+		if route.match(r) {
+			route.Proxy.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type namedProxy string
+
+func (p namedProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(p))
+}
+
+func TestRouter(t *testing.T) {
+	rt, err := NewRouter([]*Route{
+		{Paths: []string{"/users/graphql", "/users/subscriptions"}, Proxy: namedProxy("users")},
+		{Paths: []string{"/graphql"}, Host: "products.example.com", Proxy: namedProxy("products")},
+		{Paths: []string{"/graphql"}, Header: "X-Graph", HeaderValue: "reviews", Proxy: namedProxy("reviews")},
+		{Paths: []string{"/graphql"}, Proxy: namedProxy("default")},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter() returned error: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   string
+	}{
+		{name: "path", url: "http://porte/users/graphql", want: "users"},
+		{name: "other path of the route", url: "http://porte/users/subscriptions", want: "users"},
+		{name: "host", url: "http://products.example.com:8080/graphql", want: "products"},
+		{name: "header", url: "http://porte/graphql", header: "reviews", want: "reviews"},
+		{name: "first matching route", url: "http://porte/graphql", header: "other", want: "default"},
+		{name: "no route", url: "http://porte/products/graphql", want: "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Graph", tt.header)
+			}
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, r)
+			if w.Body.String() != tt.want {
+				t.Errorf("request routed to %q, expected %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
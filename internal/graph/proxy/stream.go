@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// multipartBoundary is the boundary the proxy uses for multipart/mixed
+// responses, as recommended by the incremental delivery specification.
+const multipartBoundary = "-"
+
+// serveStream writes every part of the response stream to the client as soon
+// as the graph delivers it, using the media type of the stream. Each part
+// goes through the plugins as a response of its own.
+func (p *proxy) serveStream(w http.ResponseWriter, r *http.Request, stream graph.ResponseStream) {
+	defer stream.Close()
+
+	ctx := r.Context()
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	p.responseHeaders.copy(w.Header(), stream.Header())
+	mediaType := stream.MediaType()
+	switch mediaType {
+	case graph.MediaTypeEventStream:
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		w.Header().Set("Content-Type", fmt.Sprintf("%s; boundary=\"%s\"", graph.MediaTypeMultipart, multipartBoundary))
+	}
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	for {
+		part, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println("Failed to read graph response part:", err.Error())
+			part = newExecutionFailedResponse(err)
+		}
+
+		buf := newResponseBuffer()
+		p.writeProxyResponse(ctx, buf, part, err)
+		if bdy := bytes.TrimSpace(buf.body.Bytes()); len(bdy) > 0 {
+			if writeErr := writeStreamPart(w, mediaType, bdy); writeErr != nil {
+				log.Println("Failed to write back graph response part:", writeErr.Error())
+				return
+			}
+			flush()
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if mediaType == graph.MediaTypeEventStream {
+		fmt.Fprint(w, "event: complete\ndata:\n\n")
+	} else {
+		fmt.Fprintf(w, "\r\n--%s--\r\n", multipartBoundary)
+	}
+	flush()
+}
+
+func writeStreamPart(w io.Writer, mediaType string, bdy []byte) error {
+	if mediaType == graph.MediaTypeEventStream {
+		_, err := fmt.Fprintf(w, "event: next\ndata: %s\n\n", bdy)
+		return err
+	}
+	_, err := fmt.Fprintf(
+		w,
+		"\r\n--%s\r\nContent-Type: application/json; charset=utf-8\r\n\r\n%s",
+		multipartBoundary,
+		bdy,
+	)
+	return err
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+func TestProxy_stream(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		// first and rest are the frames the graph delivers, the rest once
+		// the client received the part of the first one: a multipart part
+		// ends with the boundary following it
+		first, rest     string
+		wantContentType string
+		wantFirst       string
+		wantRest        string
+	}{
+		{
+			name:        "multipart",
+			contentType: `multipart/mixed; boundary="graphql"`,
+			first:       "\r\n--graphql\r\nContent-Type: application/json\r\n\r\n" + `{"data":{"hero":"R2-D2"},"hasNext":true}` + "\r\n--graphql\r\n",
+			rest: "Content-Type: application/json\r\n\r\n" + `{"incremental":[{"data":{"name":"R2-D2"},"path":["hero"]}],"hasNext":false}` +
+				"\r\n--graphql--\r\n",
+			wantContentType: `multipart/mixed; boundary="-"`,
+			wantFirst:       "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" + `{"data":{"hero":"R2-D2"},"hasNext":true}`,
+			wantRest: "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" + `{"hasNext":false,"incremental":[{"data":{"name":"R2-D2"},"path":["hero"]}]}` +
+				"\r\n-----\r\n",
+		},
+		{
+			name:            "server-sent events",
+			contentType:     "text/event-stream",
+			first:           "event: next\ndata: " + `{"data":{"hero":"R2-D2"}}` + "\n\n",
+			rest:            ": keep-alive\n\nevent: next\ndata: " + `{"data":{"hero":"C-3PO"}}` + "\n\nevent: complete\n\n",
+			wantContentType: "text/event-stream; charset=utf-8",
+			wantFirst:       "event: next\ndata: " + `{"data":{"hero":"R2-D2"}}` + "\n\n",
+			wantRest:        "event: next\ndata: " + `{"data":{"hero":"C-3PO"}}` + "\n\nevent: complete\ndata:\n\n",
+		},
+		{
+			name:            "multipart error part",
+			contentType:     `multipart/mixed; boundary="graphql"`,
+			first:           "\r\n--graphql\r\nContent-Type: application/json\r\n\r\n" + `{"data":{"hero":"R2-D2"},"hasNext":true}` + "\r\n--graphql\r\n",
+			rest:            "Content-Type: application/json\r\n\r\n" + `{"data":` + "\r\n--graphql--\r\n",
+			wantContentType: `multipart/mixed; boundary="-"`,
+			wantFirst:       "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" + `{"data":{"hero":"R2-D2"},"hasNext":true}`,
+			wantRest: "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" + `{"errors":[{"message":"Failed to execute graph request."}]}` +
+				"\r\n-----\r\n",
+		},
+		{
+			name:            "server-sent events error part",
+			contentType:     "text/event-stream",
+			first:           "event: next\ndata: " + `{"data":{"hero":"R2-D2"}}` + "\n\n",
+			rest:            "event: next\ndata: {\"data\":\n\n",
+			wantContentType: "text/event-stream; charset=utf-8",
+			wantFirst:       "event: next\ndata: " + `{"data":{"hero":"R2-D2"}}` + "\n\n",
+			wantRest:        "event: next\ndata: " + `{"errors":[{"message":"Failed to execute graph request."}]}` + "\n\nevent: complete\ndata:\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, tt.first)
+				w.(http.Flusher).Flush()
+				select {
+				case <-received:
+				case <-r.Context().Done():
+					return
+				}
+				io.WriteString(w, tt.rest)
+			}))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+			g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := New(&Config{Graph: g})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+			srv := httptest.NewServer(p)
+			defer srv.Close()
+
+			// the headers and the first part are flushed before the graph
+			// delivers the rest
+			client := &http.Client{Timeout: time.Second}
+			res, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"query":"{ hero }"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if ct := res.Header.Get("Content-Type"); ct != tt.wantContentType {
+				t.Errorf("proxy responded with content type %s, expected %s", ct, tt.wantContentType)
+			}
+
+			body := bufio.NewReader(res.Body)
+			first := make([]byte, len(tt.wantFirst))
+			if _, err := io.ReadFull(body, first); err != nil {
+				t.Fatal(err)
+			}
+			if string(first) != tt.wantFirst {
+				t.Errorf("proxy responded with first part %q, expected %q", first, tt.wantFirst)
+			}
+			close(received)
+
+			rest, err := ioutil.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(rest) != tt.wantRest {
+				t.Errorf("proxy responded with %q, expected %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
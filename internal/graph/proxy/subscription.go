@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/herzult/porte/internal/graph"
+)
+
+// WebSocket sub-protocols supported for subscriptions.
+const (
+	// SubprotocolGraphQLWS is the legacy subscriptions-transport-ws protocol.
+	SubprotocolGraphQLWS = "graphql-ws"
+	// SubprotocolGraphQLTransportWS is the graphql-ws library protocol.
+	SubprotocolGraphQLTransportWS = "graphql-transport-ws"
+)
+
+// Message types of both sub-protocols. The protocols share most of them,
+// the others are specific to one of them.
+const (
+	wsConnectionInit      = "connection_init"
+	wsConnectionAck       = "connection_ack"
+	wsConnectionError     = "connection_error"     // graphql-ws only
+	wsConnectionTerminate = "connection_terminate" // graphql-ws only
+	wsStart               = "start"                // graphql-ws only
+	wsStop                = "stop"                 // graphql-ws only
+	wsData                = "data"                 // graphql-ws only
+	wsSubscribe           = "subscribe"            // graphql-transport-ws only
+	wsNext                = "next"                 // graphql-transport-ws only
+	wsError               = "error"
+	wsComplete            = "complete"
+)
+
+// wsCloseForbidden is the close code graphql-transport-ws uses to reject a
+// connection_init message.
+const wsCloseForbidden = 4403
+
+// newWebSocketUpgrader returns the upgrader of the subscriptions WebSockets
+// of the clients, accepting the handshakes of the given origins and of the
+// origin of the proxy.
+func newWebSocketUpgrader(origins []string) *websocket.Upgrader {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return &websocket.Upgrader{
+		Subprotocols: []string{SubprotocolGraphQLTransportWS, SubprotocolGraphQLWS},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			// clients other than browsers send no origin
+			if origin == "" || allowed["*"] || allowed[strings.ToLower(origin)] {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+}
+
+// wsDialHeaders are the headers set by the WebSocket dialer itself which must
+// not be forwarded from the client handshake.
+var wsDialHeaders = []string{
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// serveSubscriptions upgrades the client connection to a WebSocket and
+// relays its messages to the subscription endpoint of the graph, using the
+// sub-protocol negotiated with the client on both sides.
+func (p *proxy) serveSubscriptions(w http.ResponseWriter, r *http.Request) {
+	sg, ok := p.graph.(graph.SubscriptionGraph)
+	if !ok || sg.SubscriptionURL() == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprint(w, "Not implemented: graph does not support subscriptions")
+		return
+	}
+
+	clientConn, err := p.wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an HTTP error
+		return
+	}
+	defer clientConn.Close()
+
+	subprotocol := clientConn.Subprotocol()
+	if subprotocol == "" {
+		closeWebSocket(clientConn, websocket.CloseProtocolError, "Subprotocol not acceptable")
+		return
+	}
+
+	head := r.Header.Clone()
+	removeHopHeaders(head)
+	for _, h := range wsDialHeaders {
+		head.Del(h)
+	}
+	// the handshake and the connection_init message share the context of the
+	// session, each operation has its own.
+	sessionReq := r.WithContext(p.initContext(r.Context()))
+	head = p.sendSubscriptionHeader(sessionReq, head)
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{subprotocol},
+	}
+	graphConn, _, err := dialer.DialContext(r.Context(), sg.SubscriptionURL().String(), head)
+	if err != nil {
+		log.Println("Failed to connect to graph subscriptions endpoint:", err.Error())
+		closeWebSocket(clientConn, websocket.CloseInternalServerErr, "Graph not available")
+		return
+	}
+	defer graphConn.Close()
+
+	s := &subscriptionSession{
+		proxy:       p,
+		req:         r,
+		sessionReq:  sessionReq,
+		subprotocol: subprotocol,
+		clientConn:  clientConn,
+		graphConn:   graphConn,
+		operations:  map[string]context.Context{},
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		s.relayClientMessages()
+		done <- struct{}{}
+	}()
+	go func() {
+		s.relayGraphMessages()
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// subscriptionSession relays the messages of a client WebSocket connection to
+// a graph WebSocket connection and back.
+type subscriptionSession struct {
+	proxy       *proxy
+	req         *http.Request
+	sessionReq  *http.Request
+	subprotocol string
+	clientConn  *websocket.Conn
+	graphConn   *websocket.Conn
+
+	clientMu sync.Mutex
+	graphMu  sync.Mutex
+
+	opMu       sync.Mutex
+	operations map[string]context.Context
+}
+
+func (s *subscriptionSession) relayClientMessages() {
+	for {
+		msg := new(wsMessage)
+		if err := s.clientConn.ReadJSON(msg); err != nil {
+			s.closeGraph(err)
+			return
+		}
+
+		switch msg.Type {
+		case wsConnectionInit:
+			var payload map[string]interface{}
+			if len(msg.Payload) > 0 {
+				if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+					s.rejectConnection(fmt.Sprintf("Invalid connection_init payload: %s", err))
+					return
+				}
+			}
+			// the plugins may add to the payload the client did not send
+			if payload == nil {
+				payload = make(map[string]interface{})
+			}
+			payload, err := s.proxy.readConnectionInit(s.sessionReq, payload)
+			if err != nil {
+				s.rejectConnection(err.Error())
+				return
+			}
+			msg.Payload = nil
+			if payload != nil {
+				msg.Payload, _ = json.Marshal(payload)
+			}
+		case wsStart, wsSubscribe:
+			graphReq, err := s.startOperation(msg.ID, msg.Payload)
+			if err != nil {
+				s.writeOperationError(msg.ID, err)
+				continue
+			}
+			if graphReq == nil {
+				continue
+			}
+			msg.Payload, _ = json.Marshal(graphReq)
+		case wsStop, wsComplete:
+			s.endOperation(msg.ID)
+		}
+
+		if err := s.writeGraph(msg); err != nil {
+			log.Println("Failed to relay subscription message to graph:", err.Error())
+			return
+		}
+		if msg.Type == wsConnectionTerminate {
+			return
+		}
+	}
+}
+
+func (s *subscriptionSession) relayGraphMessages() {
+	for {
+		msg := new(wsMessage)
+		if err := s.graphConn.ReadJSON(msg); err != nil {
+			s.closeClient(err)
+			return
+		}
+
+		switch msg.Type {
+		case wsData, wsNext:
+			payload, ok := s.writeOperationResponse(msg.ID, msg.Payload)
+			if !ok {
+				continue
+			}
+			msg.Payload = payload
+		case wsComplete:
+			s.endOperation(msg.ID)
+		}
+
+		if err := s.writeClient(msg); err != nil {
+			log.Println("Failed to relay subscription message to client:", err.Error())
+			return
+		}
+	}
+}
+
+// startOperation reads the graph request of a subscription operation through
+// the plugins, as if it was sent in the body of a POST request.
+func (s *subscriptionSession) startOperation(id string, payload json.RawMessage) (*graph.Request, error) {
+	ctx := s.proxy.initContext(s.req.Context())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.req.URL.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.req.Header.Clone()
+	removeHopHeaders(req.Header)
+	for _, h := range wsDialHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Host = s.req.Host
+	req.RemoteAddr = s.req.RemoteAddr
+
+	graphReq, err := s.proxy.readProxyRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if graphReq != nil {
+		s.opMu.Lock()
+		s.operations[id] = ctx
+		s.opMu.Unlock()
+	}
+	return graphReq, nil
+}
+
+func (s *subscriptionSession) endOperation(id string) {
+	s.opMu.Lock()
+	delete(s.operations, id)
+	s.opMu.Unlock()
+}
+
+// writeOperationResponse writes a subscription event through the plugins and
+// returns the payload to send to the client in its place. It returns false
+// when the event must not be sent to the client.
+func (s *subscriptionSession) writeOperationResponse(id string, payload json.RawMessage) (json.RawMessage, bool) {
+	s.opMu.Lock()
+	ctx, ok := s.operations[id]
+	s.opMu.Unlock()
+	if !ok {
+		return payload, true
+	}
+
+	graphRes := new(graph.Response)
+	if err := json.Unmarshal(payload, graphRes); err != nil {
+		log.Println("Failed to decode subscription event from graph:", err.Error())
+		return payload, true
+	}
+
+	buf := newResponseBuffer()
+	s.proxy.writeProxyResponse(ctx, buf, graphRes, nil)
+	bdy := bytes.TrimSpace(buf.body.Bytes())
+	if len(bdy) == 0 || !json.Valid(bdy) {
+		return nil, false
+	}
+	return json.RawMessage(bdy), true
+}
+
+func (s *subscriptionSession) writeOperationError(id string, err error) {
+	msg := &wsMessage{ID: id, Type: wsError}
+	gqlErrs := []*graph.Error{&graph.Error{Message: fmt.Sprintf("Bad request: %s", err)}}
+	var reqErr *graph.RequestError
+	if errors.As(err, &reqErr) && len(reqErr.Errors) > 0 {
+		gqlErrs = reqErr.Errors
+	}
+	if s.subprotocol == SubprotocolGraphQLTransportWS {
+		msg.Payload, _ = json.Marshal(gqlErrs)
+	} else {
+		// the legacy protocol only carries a single error
+		msg.Payload, _ = json.Marshal(gqlErrs[0])
+	}
+	if err := s.writeClient(msg); err != nil {
+		log.Println("Failed to write subscription error to client:", err.Error())
+	}
+}
+
+func (s *subscriptionSession) rejectConnection(reason string) {
+	if s.subprotocol == SubprotocolGraphQLWS {
+		payload, _ := json.Marshal(map[string]string{"message": reason})
+		s.writeClient(&wsMessage{Type: wsConnectionError, Payload: payload})
+	}
+	s.clientMu.Lock()
+	closeWebSocket(s.clientConn, wsCloseForbidden, "Forbidden")
+	s.clientMu.Unlock()
+	s.closeGraph(nil)
+}
+
+func (s *subscriptionSession) writeClient(msg *wsMessage) error {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	return s.clientConn.WriteJSON(msg)
+}
+
+func (s *subscriptionSession) writeGraph(msg *wsMessage) error {
+	s.graphMu.Lock()
+	defer s.graphMu.Unlock()
+	return s.graphConn.WriteJSON(msg)
+}
+
+// closeClient closes the client connection, forwarding the close error
+// received from the graph if any.
+func (s *subscriptionSession) closeClient(err error) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	closeWebSocketWithError(s.clientConn, err)
+}
+
+// closeGraph closes the graph connection, forwarding the close error
+// received from the client if any.
+func (s *subscriptionSession) closeGraph(err error) {
+	s.graphMu.Lock()
+	defer s.graphMu.Unlock()
+	closeWebSocketWithError(s.graphConn, err)
+}
+
+func closeWebSocketWithError(conn *websocket.Conn, err error) {
+	if ce, ok := err.(*websocket.CloseError); ok && ce.Code != websocket.CloseNoStatusReceived {
+		closeWebSocket(conn, ce.Code, ce.Text)
+		return
+	}
+	closeWebSocket(conn, websocket.CloseNormalClosure, "")
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, text string) {
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(time.Second),
+	)
+	conn.Close()
+}
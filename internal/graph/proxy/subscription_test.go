@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/herzult/porte/internal/graph"
+)
+
+type testSubscriptionGraph struct {
+	testBatchGraph
+	subscriptionURL *url.URL
+}
+
+func (g *testSubscriptionGraph) SubscriptionURL() *url.URL { return g.subscriptionURL }
+
+// newTestSubscriptionServer returns a graphql-transport-ws server sending
+// two events for every subscription, each echoing the connection_init
+// payload it received. It accepts the handshakes of any origin the proxy
+// forwards.
+func newTestSubscriptionServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{SubprotocolGraphQLTransportWS},
+		CheckOrigin:  func(*http.Request) bool { return true },
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade graph connection: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		var initPayload json.RawMessage
+		for {
+			msg := new(wsMessage)
+			if err := conn.ReadJSON(msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case wsConnectionInit:
+				initPayload = msg.Payload
+				conn.WriteJSON(&wsMessage{Type: wsConnectionAck})
+			case wsSubscribe:
+				for i := 0; i < 2; i++ {
+					payload, _ := json.Marshal(&graph.Response{
+						Data: map[string]interface{}{"init": initPayload},
+					})
+					conn.WriteJSON(&wsMessage{ID: msg.ID, Type: wsNext, Payload: payload})
+				}
+				conn.WriteJSON(&wsMessage{ID: msg.ID, Type: wsComplete})
+			}
+		}
+	}))
+}
+
+func TestProxy_subscriptions_connection_init_context(t *testing.T) {
+	graphSrv := newTestSubscriptionServer(t)
+	defer graphSrv.Close()
+	graphURL, _ := url.Parse("ws" + strings.TrimPrefix(graphSrv.URL, "http"))
+
+	var handshakeExecID string
+	p, err := New(&Config{
+		Graph: &testSubscriptionGraph{subscriptionURL: graphURL},
+		Plugins: []*Plugin{
+			&Plugin{
+				SendSubscriptionHeader: func(next SendSubscriptionHeader) SendSubscriptionHeader {
+					return func(r *http.Request, head http.Header) http.Header {
+						handshakeExecID = GetExecID(r.Context())
+						return next(r, head)
+					}
+				},
+				ReadConnectionInit: func(next ReadConnectionInit) ReadConnectionInit {
+					return func(r *http.Request, payload map[string]interface{}) (map[string]interface{}, error) {
+						payload["execID"] = GetExecID(r.Context())
+						return next(r, payload)
+					}
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() returned error: %s", err)
+	}
+	proxySrv := httptest.NewServer(p)
+	defer proxySrv.Close()
+
+	dialer := &websocket.Dialer{Subprotocols: []string{SubprotocolGraphQLTransportWS}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(proxySrv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %s", err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(&wsMessage{Type: wsConnectionInit})
+	conn.WriteJSON(&wsMessage{
+		ID:      "1",
+		Type:    wsSubscribe,
+		Payload: json.RawMessage(`{"query":"subscription { newReview { stars } }"}`),
+	})
+
+	for _, msgType := range []string{wsConnectionAck, wsNext} {
+		msg := new(wsMessage)
+		if err := conn.ReadJSON(msg); err != nil {
+			t.Fatalf("failed to read message from proxy: %s", err)
+		}
+		if msg.Type != msgType {
+			t.Fatalf("proxy sent message of type %s, expected %s", msg.Type, msgType)
+		}
+		if msgType != wsNext {
+			continue
+		}
+		var res struct {
+			Data struct {
+				Init struct {
+					ExecID string `json:"execID"`
+				} `json:"init"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(msg.Payload, &res); err != nil {
+			t.Fatalf("failed to decode event: %s", err)
+		}
+		if execID := res.Data.Init.ExecID; execID == "" || execID != handshakeExecID {
+			t.Errorf("connection_init was read with execution ID %q, expected the handshake one %q", execID, handshakeExecID)
+		}
+	}
+}
+
+func TestProxy_subscriptions(t *testing.T) {
+	graphSrv := newTestSubscriptionServer(t)
+	defer graphSrv.Close()
+	graphURL, _ := url.Parse("ws" + strings.TrimPrefix(graphSrv.URL, "http"))
+
+	tests := []struct {
+		name        string
+		initPayload json.RawMessage
+	}{
+		{name: "payload", initPayload: json.RawMessage(`{}`)},
+		{name: "no payload"},
+		{name: "null payload", initPayload: json.RawMessage(`null`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := 0
+			p, err := New(&Config{
+				Graph: &testSubscriptionGraph{subscriptionURL: graphURL},
+				Plugins: []*Plugin{
+					&Plugin{
+						ReadConnectionInit: func(next ReadConnectionInit) ReadConnectionInit {
+							return func(r *http.Request, payload map[string]interface{}) (map[string]interface{}, error) {
+								payload["token"] = r.Header.Get("Authorization")
+								return next(r, payload)
+							}
+						},
+						WriteProxyResponse: func(next WriteProxyResponse) WriteProxyResponse {
+							return func(ctx context.Context, w http.ResponseWriter, res *graph.Response, err error) {
+								events++
+								res.SetExtension("event", events)
+								next(ctx, w, res, err)
+							}
+						},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("New() returned error: %s", err)
+			}
+			proxySrv := httptest.NewServer(p)
+			defer proxySrv.Close()
+
+			dialer := &websocket.Dialer{Subprotocols: []string{SubprotocolGraphQLTransportWS}}
+			conn, _, err := dialer.Dial(
+				"ws"+strings.TrimPrefix(proxySrv.URL, "http"),
+				http.Header{"Authorization": {"Bearer secret"}},
+			)
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %s", err)
+			}
+			defer conn.Close()
+
+			conn.WriteJSON(&wsMessage{Type: wsConnectionInit, Payload: tt.initPayload})
+			conn.WriteJSON(&wsMessage{
+				ID:      "1",
+				Type:    wsSubscribe,
+				Payload: json.RawMessage(`{"query":"subscription { newReview { stars } }"}`),
+			})
+
+			expected := []string{
+				`{"type":"connection_ack"}`,
+				`{"id":"1","type":"next","payload":{"data":{"init":{"token":"Bearer secret"}},"extensions":{"event":1}}}`,
+				`{"id":"1","type":"next","payload":{"data":{"init":{"token":"Bearer secret"}},"extensions":{"event":2}}}`,
+				`{"id":"1","type":"complete"}`,
+			}
+			for _, want := range expected {
+				_, got, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatalf("failed to read message from proxy: %s", err)
+				}
+				if strings.TrimSpace(string(got)) != want {
+					t.Errorf("proxy sent message %s, expected %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxy_subscriptions_origin(t *testing.T) {
+	graphSrv := newTestSubscriptionServer(t)
+	defer graphSrv.Close()
+	graphURL, _ := url.Parse("ws" + strings.TrimPrefix(graphSrv.URL, "http"))
+
+	p, err := New(&Config{
+		Graph:               &testSubscriptionGraph{subscriptionURL: graphURL},
+		SubscriptionOrigins: []string{"https://app.example.org/"},
+	})
+	if err != nil {
+		t.Fatalf("New() returned error: %s", err)
+	}
+	proxySrv := httptest.NewServer(p)
+	defer proxySrv.Close()
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "", want: true},
+		{origin: proxySrv.URL, want: true},
+		{origin: "https://APP.example.org", want: true},
+		{origin: "https://evil.example.org", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			dialer := &websocket.Dialer{Subprotocols: []string{SubprotocolGraphQLTransportWS}}
+			conn, res, err := dialer.Dial("ws"+strings.TrimPrefix(proxySrv.URL, "http"), header)
+			if err == nil {
+				conn.Close()
+			}
+			if accepted := err == nil; accepted != tt.want {
+				t.Errorf("handshake from origin %q accepted: %v, expected %v", tt.origin, accepted, tt.want)
+			}
+			if !tt.want && res != nil && res.StatusCode != http.StatusForbidden {
+				t.Errorf("handshake from origin %q rejected with status %d, expected %d", tt.origin, res.StatusCode, http.StatusForbidden)
+			}
+		})
+	}
+}
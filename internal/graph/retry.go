@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy defines how the requests failing to reach the GraphQL service
+// are retried. Only the requests the service could not have executed twice
+// are retried: queries, and mutations when allowed. A request is retried on
+// transport errors, read timeouts and 502, 503 or 504 responses.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent, it is not
+	// retried when it is 0 or 1.
+	MaxAttempts int
+	// Backoff is the maximum wait before the first retry, doubling on each
+	// retry up to MaxBackoff. The actual wait is random below the maximum.
+	// They are 100ms and 2s by default.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Mutations also retries mutations, which the service may execute more
+	// than once.
+	Mutations bool
+}
+
+// backoff returns the wait before the given retry, starting at 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retries returns whether the requests are retried.
+func (p *RetryPolicy) retries(graphReqs ...*Request) bool {
+	if p.MaxAttempts <= 1 {
+		return false
+	}
+	for _, r := range graphReqs {
+		opType, err := r.OperationType()
+		if err != nil {
+			return false
+		}
+		switch opType {
+		case OperationTypeQuery:
+		case OperationTypeMutation:
+			if !p.Mutations {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// sleep waits for the given duration, it returns false when the context is
+// done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// cancelingBody cancels the context of the request it is the response body
+// of once closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,172 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGraph_Execute_retries(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses are the statuses of the successive responses, the
+		// following ones are 200
+		statuses []int
+		// delays are the delays of the successive responses
+		delays       []time.Duration
+		query        string
+		cfg          GraphConfig
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{
+			name:         "no retries",
+			statuses:     []int{503},
+			query:        "{ hero }",
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "query retried until it succeeds",
+			statuses:     []int{503, 502},
+			query:        "{ hero }",
+			cfg:          GraphConfig{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}},
+			wantAttempts: 3,
+		},
+		{
+			name:         "query retried up to the max attempts",
+			statuses:     []int{503, 503, 503, 503},
+			query:        "{ hero }",
+			cfg:          GraphConfig{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}},
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "query not retried on errors other than unavailability",
+			statuses:     []int{500},
+			query:        "{ hero }",
+			cfg:          GraphConfig{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "mutation not retried",
+			statuses:     []int{503},
+			query:        "mutation { like }",
+			cfg:          GraphConfig{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "mutation retried when allowed",
+			statuses:     []int{503},
+			query:        "mutation { like }",
+			cfg:          GraphConfig{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Mutations: true}},
+			wantAttempts: 2,
+		},
+		{
+			name:   "query retried after a read timeout",
+			delays: []time.Duration{300 * time.Millisecond},
+			query:  "{ hero }",
+			cfg: GraphConfig{
+				ReadTimeout: 50 * time.Millisecond,
+				Retry:       RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond},
+			},
+			wantAttempts: 2,
+		},
+		{
+			name:   "query not retried after the timeout",
+			delays: []time.Duration{300 * time.Millisecond, 300 * time.Millisecond},
+			query:  "{ hero }",
+			cfg: GraphConfig{
+				Timeout: 50 * time.Millisecond,
+				Retry:   RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond},
+			},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&attempts, 1))
+				if n <= len(tt.delays) {
+					ioutil.ReadAll(r.Body)
+					select {
+					case <-time.After(tt.delays[n-1]):
+					case <-r.Context().Done():
+						return
+					}
+				}
+				if n <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[n-1])
+					return
+				}
+				io.WriteString(w, `{"data":{"hero":"R2-D2"}}`)
+			}))
+			defer srv.Close()
+
+			cfg := tt.cfg
+			cfg.ServiceURL, _ = url.Parse(srv.URL)
+			g, err := NewGraph(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := g.Execute(context.Background(), &Request{Query: tt.query}, nil)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, expected one: %v", err, tt.wantErr)
+			}
+			if err == nil && res.Data == nil {
+				t.Errorf("got no data")
+			}
+			if n := atomic.LoadInt32(&attempts); n != tt.wantAttempts {
+				t.Errorf("got %d attempts, expected %d", n, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestGraph_Execute_circuit_breaker(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute})
+	g, err := NewGraph(&GraphConfig{
+		ServiceURL:     u,
+		Retry:          RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond},
+		CircuitBreaker: breaker,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := g.Execute(context.Background(), &Request{Query: "{ hero }"}, nil); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v, expected the service error", err)
+	}
+	// the breaker opens on the third attempt, rejecting the retry and the
+	// following requests
+	for i := 0; i < 2; i++ {
+		if _, err := g.Execute(context.Background(), &Request{Query: "{ hero }"}, nil); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("got error %v, expected %v", err, ErrCircuitOpen)
+		}
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("got state %s, expected open", state)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("got %d attempts, expected 3", n)
+	}
+}
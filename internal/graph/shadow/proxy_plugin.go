@@ -0,0 +1,266 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/execlog"
+	"github.com/herzult/porte/internal/graph/proxy"
+)
+
+// ProxyPluginConfig defines the configuration of the proxy plugin
+type ProxyPluginConfig struct {
+	// Graph is the graph the requests are mirrored to.
+	Graph graph.Graph
+	// Transport sends the mirrored requests, http.DefaultTransport by
+	// default. Their contexts have the values of the primary executions.
+	Transport http.RoundTripper
+	// SampleRate is the fraction of the requests mirrored, between 0 and 1.
+	SampleRate float64
+	// Mutations enables the mirroring of mutations, only queries are
+	// mirrored by default.
+	Mutations bool
+	// Ignore lists the paths of the response values not compared, as
+	// described by graph.CompareResponses. Only the data and errors of the
+	// responses are compared, their extensions never are.
+	Ignore []string
+	// Concurrency is the maximum number of mirrored requests in flight, above
+	// which requests are not mirrored. It is 10 by default.
+	Concurrency int
+	// Timeout is the timeout of the mirrored requests, 10s by default.
+	Timeout time.Duration
+	// EntryWriter, when set, is given an entry for each mirrored request
+	// whose response differs from the primary one or that failed.
+	EntryWriter execlog.EntryWriter
+	// Redact lists the keys whose values are redacted from the entries, as
+	// described by execlog.ProxyPluginConfig. The values of the differences
+	// are redacted too.
+	Redact []string
+
+	// Namespace and Subsystem prefix the names of the metrics, registered
+	// with Registerer or the default prometheus registerer.
+	Namespace  string
+	Subsystem  string
+	Registerer prometheus.Registerer
+}
+
+// NewProxyPlugin returns a new proxy plugin instance mirroring the requests
+// to the configured graph once the primary response is written, and
+// comparing the responses.
+func NewProxyPlugin(cfg ProxyPluginConfig) (*proxy.Plugin, error) {
+	if cfg.Graph == nil {
+		return nil, errors.New("missing shadow graph")
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %v, expected a value between 0 and 1", cfg.SampleRate)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 10
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+	if cfg.EntryWriter != nil && len(cfg.Redact) > 0 {
+		cfg.EntryWriter = execlog.NewRedactingEntryWriter(cfg.EntryWriter, cfg.Redact)
+	}
+
+	requestsTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: cfg.Subsystem,
+			Name:      "shadow_requests_total",
+			Help:      "Number of requests mirrored to the shadow graph by result: match, mismatch, error or dropped.",
+		},
+		[]string{"result"},
+	)
+	requestDuration := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: cfg.Namespace,
+			Subsystem: cfg.Subsystem,
+			Name:      "shadow_request_duration_seconds",
+			Help:      "Duration of the requests mirrored to the shadow graph.",
+		},
+	)
+	for _, c := range []prometheus.Collector{requestsTotal, requestDuration} {
+		if err := cfg.Registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &shadower{
+		cfg:             cfg,
+		sem:             make(chan struct{}, cfg.Concurrency),
+		requestsTotal:   requestsTotal,
+		requestDuration: requestDuration,
+	}
+
+	return &proxy.Plugin{
+		InitContext: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, stateKey{}, &state{})
+		},
+		ReadProxyRequest: func(next proxy.ReadProxyRequest) proxy.ReadProxyRequest {
+			return func(req *http.Request) (*graph.Request, error) {
+				nextReq, nextErr := next(req)
+				if st, ok := req.Context().Value(stateKey{}).(*state); ok && nextErr == nil {
+					st.req = nextReq
+					st.header = req.Header.Clone()
+				}
+				return nextReq, nextErr
+			}
+		},
+		WriteProxyResponse: func(next proxy.WriteProxyResponse) proxy.WriteProxyResponse {
+			return func(ctx context.Context, w http.ResponseWriter, graphRes *graph.Response, graphErr error) {
+				next(ctx, w, graphRes, graphErr)
+
+				st, _ := ctx.Value(stateKey{}).(*state)
+				// the responses of incremental deliveries and subscriptions
+				// are written in several parts, they are not mirrored
+				if st == nil || st.req == nil || st.written || graphErr != nil || graphRes == nil || graphRes.HasNext != nil {
+					return
+				}
+				st.written = true
+				if !s.mirrored(st.req) {
+					return
+				}
+				// the outer plugins may still change the response once
+				// written, the mirrored request compares a copy of it
+				primary, err := comparedResponse(graphRes)
+				if err != nil {
+					s.requestsTotal.WithLabelValues("error").Inc()
+					return
+				}
+				select {
+				case s.sem <- struct{}{}:
+				default:
+					s.requestsTotal.WithLabelValues("dropped").Inc()
+					return
+				}
+				go func() {
+					defer func() { <-s.sem }()
+					s.shadow(ctx, st, primary)
+				}()
+			}
+		},
+	}, nil
+}
+
+type stateKey struct{}
+
+// detachedContext has the values of its context, without its deadline and
+// cancellation: the mirrored requests outlive the primary ones.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+type state struct {
+	req     *graph.Request
+	header  http.Header
+	written bool
+}
+
+type shadower struct {
+	cfg             ProxyPluginConfig
+	sem             chan struct{}
+	requestsTotal   *prometheus.CounterVec
+	requestDuration prometheus.Histogram
+}
+
+// mirrored reports whether the request is sampled and of a mirrored
+// operation type.
+func (s *shadower) mirrored(req *graph.Request) bool {
+	if rand.Float64() >= s.cfg.SampleRate {
+		return false
+	}
+	opType, err := req.OperationType()
+	if err != nil {
+		return false
+	}
+	return opType == graph.OperationTypeQuery || s.cfg.Mutations && opType == graph.OperationTypeMutation
+}
+
+// shadow sends the request to the shadow graph and compares its response
+// with the primary one. The request is sent with the values of the execution
+// context, but not its cancellation.
+func (s *shadower) shadow(execCtx context.Context, st *state, primary *graph.Response) {
+	execID := proxy.GetExecID(execCtx)
+	ctx, cancel := context.WithTimeout(detachedContext{execCtx}, s.cfg.Timeout)
+	defer cancel()
+
+	transport := s.cfg.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	startTime := time.Now()
+	res, err := s.cfg.Graph.Execute(ctx, st.req, proxy.ForwardHeadersToGraph(transport, st.header))
+	duration := time.Since(startTime)
+	s.requestDuration.Observe(duration.Seconds())
+
+	var diffs []*graph.Difference
+	if err == nil {
+		var shadowed *graph.Response
+		if shadowed, err = comparedResponse(res); err == nil {
+			diffs, err = graph.CompareResponses(primary, shadowed, s.cfg.Ignore)
+		}
+	}
+	switch {
+	case err != nil:
+		s.requestsTotal.WithLabelValues("error").Inc()
+	case len(diffs) > 0:
+		s.requestsTotal.WithLabelValues("mismatch").Inc()
+	default:
+		s.requestsTotal.WithLabelValues("match").Inc()
+		return
+	}
+
+	if s.cfg.EntryWriter == nil {
+		return
+	}
+	entry := &execlog.Entry{
+		ID:            execID,
+		GraphID:       s.cfg.Graph.ID(),
+		ClientName:    st.header.Get("Client-Name"),
+		ClientVersion: st.header.Get("Client-Version"),
+		StartTime:     startTime,
+		Duration:      duration,
+		Request:       st.req,
+		Response:      res,
+		Differences:   diffs,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := s.cfg.EntryWriter.Write(entry); err != nil {
+		log.Println("Failed to write shadow execution log entry:", err.Error())
+	}
+}
+
+// comparedResponse returns a copy of the compared parts of the response: its
+// data and errors. The extensions hold values specific to each execution,
+// like the debug information of the proxy.
+func comparedResponse(res *graph.Response) (*graph.Response, error) {
+	bdy, err := json.Marshal(&graph.Response{Data: res.Data, Errors: res.Errors})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode graph response: %s", err)
+	}
+	compared := new(graph.Response)
+	if err := json.Unmarshal(bdy, compared); err != nil {
+		return nil, fmt.Errorf("failed to decode graph response: %s", err)
+	}
+	return compared, nil
+}
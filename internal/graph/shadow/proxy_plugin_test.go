@@ -0,0 +1,302 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/execlog"
+	"github.com/herzult/porte/internal/graph/proxy"
+)
+
+// testGraph answers every request with the name of its hero, after the
+// configured delay.
+type testGraph struct {
+	hero  string
+	err   error
+	delay time.Duration
+	// primary graphs do not record the headers, their transport sends
+	// actual HTTP requests
+	primary bool
+
+	mu      sync.Mutex
+	queries []string
+	headers []http.Header
+}
+
+func (g *testGraph) ID() string { return "test-" + g.hero }
+
+func (g *testGraph) Execute(ctx context.Context, req *graph.Request, transport http.RoundTripper) (*graph.Response, error) {
+	// the transport is called to record the forwarded headers
+	httpReq, _ := http.NewRequest(http.MethodPost, "http://graph", nil)
+	if !g.primary {
+		transport.RoundTrip(httpReq)
+	}
+
+	time.Sleep(g.delay)
+	g.mu.Lock()
+	g.queries = append(g.queries, req.Query)
+	g.headers = append(g.headers, httpReq.Header)
+	g.mu.Unlock()
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &graph.Response{Data: map[string]interface{}{"hero": g.hero}}, nil
+}
+
+func (g *testGraph) received() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.queries)
+}
+
+type entriesWriter struct {
+	mu      sync.Mutex
+	entries []*execlog.Entry
+}
+
+func (w *entriesWriter) Write(entry *execlog.Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries = append(w.entries, entry)
+	return nil
+}
+
+func (w *entriesWriter) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.entries)
+}
+
+var noopTransport = proxy.SendGraphRequest(func(*http.Request) (*http.Response, error) {
+	return nil, errors.New("no transport")
+})
+
+func serve(p proxy.Proxy, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"`+query+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Client-Name", "ios")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	return w
+}
+
+func wait(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// resultCount returns the number of shadow requests with the given result.
+func resultCount(reg *prometheus.Registry, result string) float64 {
+	mfs, _ := reg.Gather()
+	for _, mf := range mfs {
+		if mf.GetName() != "shadow_requests_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetLabel()[0].GetValue() == result {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestProxyPlugin(t *testing.T) {
+	primary := &testGraph{hero: "R2-D2", primary: true}
+	tests := []struct {
+		name       string
+		shadow     *testGraph
+		query      string
+		wantResult string
+		wantEntry  bool
+	}{
+		{name: "match", shadow: &testGraph{hero: "R2-D2"}, query: "{ hero }", wantResult: "match"},
+		{name: "mismatch", shadow: &testGraph{hero: "C-3PO"}, query: "{ hero }", wantResult: "mismatch", wantEntry: true},
+		{name: "error", shadow: &testGraph{err: errors.New("unavailable")}, query: "{ hero }", wantResult: "error", wantEntry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			entries := &entriesWriter{}
+			plug, err := NewProxyPlugin(ProxyPluginConfig{
+				Graph:       tt.shadow,
+				Transport:   noopTransport,
+				SampleRate:  1,
+				EntryWriter: entries,
+				Registerer:  reg,
+			})
+			if err != nil {
+				t.Fatalf("NewProxyPlugin() returned error: %s", err)
+			}
+			p, _ := proxy.New(&proxy.Config{Graph: primary, Plugins: []*proxy.Plugin{plug}})
+
+			w := serve(p, tt.query)
+			if !strings.Contains(w.Body.String(), "R2-D2") {
+				t.Errorf("client got %s, expected the primary response", w.Body.String())
+			}
+			wait(t, func() bool { return tt.shadow.received() == 1 })
+			if tt.shadow.headers[0].Get("Client-Name") != "ios" {
+				t.Errorf("shadow request headers are %v, expected the client headers", tt.shadow.headers[0])
+			}
+
+			wait(t, func() bool { return resultCount(reg, tt.wantResult) == 1 })
+
+			if tt.wantEntry {
+				wait(t, func() bool { return entries.len() == 1 })
+				entry := entries.entries[0]
+				if entry.GraphID != tt.shadow.ID() || entry.ClientName != "ios" || entry.Request.Query != tt.query {
+					t.Errorf("shadow entry is %+v", entry)
+				}
+				if tt.wantResult == "mismatch" && (len(entry.Differences) != 1 || entry.Differences[0].Path != "data.hero") {
+					t.Errorf("shadow entry differences are %v", entry.Differences)
+				}
+				if tt.wantResult == "error" && entry.Error != "unavailable" {
+					t.Errorf("shadow entry error is %q", entry.Error)
+				}
+			} else if entries.len() != 0 {
+				t.Errorf("%d shadow entries written for a matching response", entries.len())
+			}
+		})
+	}
+}
+
+func TestProxyPlugin_redact(t *testing.T) {
+	primary := &testGraph{hero: "R2-D2", primary: true}
+	shadow := &testGraph{hero: "C-3PO"}
+	entries := &entriesWriter{}
+	plug, err := NewProxyPlugin(ProxyPluginConfig{
+		Graph:       shadow,
+		Transport:   noopTransport,
+		SampleRate:  1,
+		EntryWriter: entries,
+		Redact:      []string{"hero"},
+		Registerer:  prometheus.NewRegistry(),
+	})
+	if err != nil {
+		t.Fatalf("NewProxyPlugin() returned error: %s", err)
+	}
+	p, _ := proxy.New(&proxy.Config{Graph: primary, Plugins: []*proxy.Plugin{plug}})
+
+	serve(p, "{ hero }")
+	wait(t, func() bool { return entries.len() == 1 })
+	entry := entries.entries[0]
+	if hero := entry.Response.Data.(map[string]interface{})["hero"]; hero != execlog.RedactedValue {
+		t.Errorf("shadow entry response hero is %v, expected it redacted", hero)
+	}
+	if d := entry.Differences; len(d) != 1 || d[0].Expected != execlog.RedactedValue || d[0].Actual != execlog.RedactedValue {
+		t.Errorf("shadow entry differences are %v, expected their values redacted", d)
+	}
+}
+
+func TestProxyPlugin_outer_plugins(t *testing.T) {
+	primary := &testGraph{hero: "R2-D2", primary: true}
+	shadow := &testGraph{hero: "R2-D2"}
+	reg := prometheus.NewRegistry()
+	plug, err := NewProxyPlugin(ProxyPluginConfig{
+		Graph:      shadow,
+		Transport:  noopTransport,
+		SampleRate: 1,
+		Registerer: reg,
+	})
+	if err != nil {
+		t.Fatalf("NewProxyPlugin() returned error: %s", err)
+	}
+	// adds extensions to the response before it is written, and changes it
+	// once written
+	outer := &proxy.Plugin{
+		WriteProxyResponse: func(next proxy.WriteProxyResponse) proxy.WriteProxyResponse {
+			return func(ctx context.Context, w http.ResponseWriter, res *graph.Response, err error) {
+				res.SetExtension("debug", map[string]interface{}{"execID": proxy.GetExecID(ctx)})
+				next(ctx, w, res, err)
+				res.Data = nil
+			}
+		},
+	}
+	p, _ := proxy.New(&proxy.Config{Graph: primary, Plugins: []*proxy.Plugin{plug, outer}})
+
+	serve(p, "{ hero }")
+	wait(t, func() bool { return resultCount(reg, "match")+resultCount(reg, "mismatch") == 1 })
+	if resultCount(reg, "match") != 1 {
+		t.Error("shadow response mismatched the primary one, expected their data and errors to be compared alone")
+	}
+}
+
+func TestProxyPlugin_skipped(t *testing.T) {
+	primary := &testGraph{hero: "R2-D2", primary: true}
+	shadow := &testGraph{hero: "R2-D2", delay: 50 * time.Millisecond}
+	reg := prometheus.NewRegistry()
+	plug, err := NewProxyPlugin(ProxyPluginConfig{
+		Graph:       shadow,
+		Transport:   noopTransport,
+		SampleRate:  1,
+		Concurrency: 1,
+		Registerer:  reg,
+	})
+	if err != nil {
+		t.Fatalf("NewProxyPlugin() returned error: %s", err)
+	}
+	p, _ := proxy.New(&proxy.Config{Graph: primary, Plugins: []*proxy.Plugin{plug}})
+
+	start := time.Now()
+	serve(p, "{ hero }")
+	// the shadow request in flight is not waited for, the next one is dropped
+	serve(p, "{ hero }")
+	serve(p, "mutation { hero }")
+	if d := time.Since(start); d >= 50*time.Millisecond {
+		t.Errorf("client waited %s for the shadow graph", d)
+	}
+	wait(t, func() bool { return shadow.received() == 1 })
+
+	wait(t, func() bool { return resultCount(reg, "match") == 1 })
+	if n := resultCount(reg, "dropped"); n != 1 {
+		t.Errorf("%v shadow requests dropped, expected 1", n)
+	}
+	if shadow.queries[0] != "{ hero }" {
+		t.Errorf("shadow graph received %v, expected no mutation", shadow.queries)
+	}
+}
+
+func TestProxyPlugin_sample_rate(t *testing.T) {
+	for _, tt := range []struct {
+		rate float64
+		want int
+	}{
+		{rate: 0, want: 0},
+		{rate: 1, want: 10},
+	} {
+		shadow := &testGraph{hero: "R2-D2"}
+		plug, err := NewProxyPlugin(ProxyPluginConfig{
+			Graph:      shadow,
+			Transport:  noopTransport,
+			SampleRate: tt.rate,
+			Registerer: prometheus.NewRegistry(),
+		})
+		if err != nil {
+			t.Fatalf("NewProxyPlugin() returned error: %s", err)
+		}
+		p, _ := proxy.New(&proxy.Config{Graph: &testGraph{hero: "R2-D2", primary: true}, Plugins: []*proxy.Plugin{plug}})
+
+		for i := 0; i < 10; i++ {
+			serve(p, "{ hero }")
+		}
+		wait(t, func() bool { return shadow.received() >= tt.want })
+		time.Sleep(10 * time.Millisecond)
+		if n := shadow.received(); n != tt.want {
+			t.Errorf("sample rate %v: %d requests mirrored, expected %d", tt.rate, n, tt.want)
+		}
+	}
+}
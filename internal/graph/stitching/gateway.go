@@ -0,0 +1,268 @@
+package stitching
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/validation"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// GatewayConfig defines the configuration of a stitching gateway.
+type GatewayConfig struct {
+	// Name is the ID of the gateway graph.
+	Name       string
+	Subschemas []*Subschema
+}
+
+// Gateway is a graph serving the merged schema of several graphs. The root
+// fields of the operations it executes are sent to the graphs defining them,
+// concurrently, and their responses are merged.
+//
+// Types defined by several graphs must be the same, the subschemas prefixes
+// and namespaces avoid the conflicts. Subscriptions are not supported.
+type Gateway struct {
+	name       string
+	schema     schema.Schema
+	executable *graphql.Schema
+	validator  *validation.Validator
+	rootFields map[string]map[string]*rootField
+}
+
+var _ graph.Graph = (*Gateway)(nil)
+
+// NewGateway merges the schemas of the subschemas and returns the gateway
+// serving it.
+func NewGateway(cfg *GatewayConfig) (*Gateway, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("missing gateway name")
+	}
+	m, err := merge(cfg.Subschemas)
+	if err != nil {
+		return nil, err
+	}
+	s, err := schema.NewSchema(m.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merged schema: %s", err)
+	}
+	executable, err := validation.NewExecutableSchema(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merged schema: %s", err)
+	}
+	validator, err := validation.NewValidator(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Gateway{
+		name:       cfg.Name,
+		schema:     s,
+		executable: executable,
+		validator:  validator,
+		rootFields: m.rootFields,
+	}, nil
+}
+
+func (g *Gateway) ID() string { return g.name }
+
+// Schema returns the merged schema served by the gateway.
+func (g *Gateway) Schema() schema.Schema { return g.schema }
+
+// Execute validates the operation of the request against the merged schema
+// and executes it. The response holds the data and errors of every graph,
+// the returned error reports the graphs that could not be reached.
+func (g *Gateway) Execute(ctx context.Context, req *graph.Request, transport http.RoundTripper) (*graph.Response, error) {
+	doc, err := req.ParseQuery()
+	if err != nil {
+		return &graph.Response{Errors: []*graph.Error{{Message: err.Error()}}}, nil
+	}
+	if errs := g.validator.ValidateDocument(doc); len(errs) > 0 {
+		return &graph.Response{Errors: errs}, nil
+	}
+	op, err := graph.SelectOperation(doc, req.OperationName)
+	if err != nil {
+		return &graph.Response{Errors: []*graph.Error{{Message: err.Error()}}}, nil
+	}
+	opType := op.Operation
+	if opType == "" {
+		opType = graph.OperationTypeQuery
+	}
+	if opType == graph.OperationTypeSubscription {
+		return &graph.Response{Errors: []*graph.Error{{Message: "subscriptions are not supported by the gateway"}}}, nil
+	}
+
+	p := &planner{
+		rootFields: g.rootFields[opType],
+		op:         op,
+		fragments:  make(map[string]*ast.FragmentDefinition),
+	}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			p.fragments[frag.Name.Value] = frag
+		}
+	}
+	fetches := p.plan(opType == graph.OperationTypeMutation)
+
+	results := make([]*graph.Response, len(fetches))
+	errs := make([]error, len(fetches))
+	execute := func(i int) {
+		results[i], errs[i] = g.fetch(ctx, p, fetches[i], req.Variables, transport)
+	}
+	// mutation fields are executed serially
+	if opType == graph.OperationTypeMutation {
+		for i := range fetches {
+			execute(i)
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i := range fetches {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				execute(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	return g.merge(fetches, results, errs)
+}
+
+// fetch executes the fetch operation on its graph, or on the gateway schema
+// when it has no subschema.
+func (g *Gateway) fetch(ctx context.Context, p *planner, f *fetch, variables map[string]interface{}, transport http.RoundTripper) (*graph.Response, error) {
+	rename := func(name string) string { return name }
+	if f.sub != nil {
+		rename = f.sub.typeName
+	}
+	doc, vars := p.document(f, variables, rename)
+
+	if f.sub == nil {
+		return validation.Execute(ctx, g.executable, doc, vars)
+	}
+
+	req := &graph.Request{Query: graph.PrintDocument(doc), Variables: vars}
+	if name := p.op.Name; name != nil {
+		req.OperationName = name.Value
+	}
+	res, err := f.sub.Graph.Execute(ctx, req, transport)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.sub.gatewayTypeNames) > 0 {
+		fragments := make(map[string]*ast.FragmentDefinition)
+		for _, def := range doc.Definitions {
+			if frag, ok := def.(*ast.FragmentDefinition); ok {
+				fragments[frag.Name.Value] = frag
+			}
+		}
+		renameTypenames(res.Data, doc.Definitions[0].(*ast.OperationDefinition).SelectionSet, fragments, f.sub.gatewayTypeName)
+	}
+	return res, nil
+}
+
+// merge merges the responses of the fetches into the response of the
+// operation.
+func (g *Gateway) merge(fetches []*fetch, results []*graph.Response, errs []error) (*graph.Response, error) {
+	merged := &graph.Response{}
+	data := make(map[string]interface{})
+	failures := make([]string, 0)
+	for i, f := range fetches {
+		if err := errs[i]; err != nil {
+			graphID := g.name
+			if f.sub != nil {
+				graphID = f.sub.Graph.ID()
+			}
+			failures = append(failures, fmt.Sprintf("%s: %s", graphID, err))
+			for _, key := range f.keys {
+				data[key] = nil
+				merged.Errors = append(merged.Errors, &graph.Error{
+					Message:    fmt.Sprintf("Failed to execute graph %s request.", graphID),
+					Path:       []interface{}{key},
+					Extensions: graph.ErrorExtensions(err),
+				})
+			}
+			continue
+		}
+
+		res := results[i]
+		fetchData, _ := res.Data.(map[string]interface{})
+		if f.namespace != "" {
+			if fetchData != nil {
+				data[f.namespace] = fetchData
+			} else {
+				data[f.namespace] = nil
+			}
+		} else {
+			for _, key := range f.keys {
+				if v, ok := fetchData[key]; ok || fetchData == nil {
+					data[key] = v
+				}
+			}
+		}
+		for _, e := range res.Errors {
+			// the locations point into the fetch document, not the client one
+			if f.sub != nil {
+				e.Locations = nil
+			}
+			if f.namespace != "" && len(e.Path) > 0 {
+				e.Path = append([]interface{}{f.namespace}, e.Path...)
+			}
+			merged.Errors = append(merged.Errors, e)
+		}
+	}
+	merged.Data = data
+
+	// a null non-null root field nulls the whole data
+	for _, f := range fetches {
+		for _, key := range f.nonNullKeys {
+			if v, ok := data[key]; ok && v == nil {
+				merged.Data = nil
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return merged, fmt.Errorf("failed to execute graph requests: %s", strings.Join(failures, "; "))
+	}
+	return merged, nil
+}
+
+// renameTypenames replaces the graph type names of the __typename fields of
+// the response data selected by the selection set with the gateway ones.
+func renameTypenames(data interface{}, set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, rename func(string) string) {
+	if set == nil {
+		return
+	}
+	switch data := data.(type) {
+	case []interface{}:
+		for _, item := range data {
+			renameTypenames(item, set, fragments, rename)
+		}
+	case map[string]interface{}:
+		for _, sel := range set.Selections {
+			switch sel := sel.(type) {
+			case *ast.Field:
+				key := graph.ResponseKey(sel)
+				if sel.Name.Value != "__typename" {
+					renameTypenames(data[key], sel.SelectionSet, fragments, rename)
+				} else if name, ok := data[key].(string); ok {
+					data[key] = rename(name)
+				}
+			case *ast.InlineFragment:
+				renameTypenames(data, sel.SelectionSet, fragments, rename)
+			case *ast.FragmentSpread:
+				if frag, ok := fragments[sel.Name.Value]; ok {
+					renameTypenames(data, frag.SelectionSet, fragments, rename)
+				}
+			}
+		}
+	}
+}
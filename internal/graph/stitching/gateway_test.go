@@ -0,0 +1,289 @@
+package stitching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/testutil"
+	"github.com/graphql-go/handler"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/schema"
+)
+
+var usersSchema = func() graphql.Schema {
+	user := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name": &graphql.Field{Type: graphql.String},
+		},
+	})
+	s, _ := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"me": &graphql.Field{
+					Type: graphql.NewNonNull(user),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{"id": "1", "name": "Luke"}, nil
+					},
+				},
+				"user": &graphql.Field{
+					Type: user,
+					Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return nil, errors.New("user not found")
+					},
+				},
+			},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"rename": &graphql.Field{
+					Type: user,
+					Args: graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.String}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{"id": "1", "name": p.Args["name"]}, nil
+					},
+				},
+			},
+		}),
+	})
+	return s
+}()
+
+// productsSchema defines a User type of its own.
+var productsSchema = func() graphql.Schema {
+	user := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		},
+	})
+	product := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"upc":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"seller": &graphql.Field{Type: user},
+		},
+	})
+	s, _ := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"me": &graphql.Field{Type: user},
+				"top": &graphql.Field{
+					Type: graphql.NewList(product),
+					Args: graphql.FieldConfigArgument{"first": &graphql.ArgumentConfig{Type: graphql.Int}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						products := []interface{}{
+							map[string]interface{}{"upc": "1", "seller": map[string]interface{}{"id": "1"}},
+							map[string]interface{}{"upc": "2", "seller": map[string]interface{}{"id": "2"}},
+						}
+						return products[:p.Args["first"].(int)], nil
+					},
+				},
+			},
+		}),
+	})
+	return s
+}()
+
+// newTestSubschema serves the schema and returns its subschema.
+func newTestSubschema(t *testing.T, name string, s *graphql.Schema) (*Subschema, func()) {
+	server := httptest.NewServer(handler.New(&handler.Config{Schema: s}))
+	u, _ := url.Parse(server.URL)
+	g, _ := graph.NewGraph(&graph.GraphConfig{Name: name, ServiceURL: u})
+	gs, err := schema.Introspect(context.Background(), g, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("Introspect() returned error: %s", err)
+	}
+	return &Subschema{Graph: g, Schema: gs}, server.Close
+}
+
+func TestNewGateway_conflicts(t *testing.T) {
+	users, closeUsers := newTestSubschema(t, "users", &usersSchema)
+	defer closeUsers()
+	products, closeProducts := newTestSubschema(t, "products", &productsSchema)
+	defer closeProducts()
+
+	tests := []struct {
+		name       string
+		configure  func(products *Subschema)
+		wantErr    string
+		wantFields []string
+	}{
+		{
+			name:    "type conflict",
+			wantErr: `type "User" of graph products conflicts with the one of graph users`,
+		},
+		{
+			name:      "root field conflict",
+			configure: func(products *Subschema) { products.TypePrefix = "Products" },
+			wantErr:   `query root field "me" of graph products conflicts with the one of graph users`,
+		},
+		{
+			name: "prefixes",
+			configure: func(products *Subschema) {
+				products.TypePrefix = "Products"
+				products.FieldPrefix = "products_"
+			},
+			wantFields: []string{"me", "user", "products_me", "products_top"},
+		},
+		{
+			name:       "namespace",
+			configure:  func(products *Subschema) { products.Namespace = "products"; products.TypePrefix = "Products" },
+			wantFields: []string{"me", "user", "products"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := *products
+			if tt.configure != nil {
+				tt.configure(&sub)
+			}
+			gw, err := NewGateway(&GatewayConfig{Name: "gateway", Subschemas: []*Subschema{users, &sub}})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("NewGateway() returned error %v, expected %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewGateway() returned error: %s", err)
+			}
+			fields := make([]string, 0)
+			for _, f := range gw.Schema().QueryType().Fields() {
+				fields = append(fields, f.Name())
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("gateway query fields are %v, expected %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestGateway_Execute(t *testing.T) {
+	users, closeUsers := newTestSubschema(t, "users", &usersSchema)
+	defer closeUsers()
+	products, closeProducts := newTestSubschema(t, "products", &productsSchema)
+	defer closeProducts()
+	products.TypePrefix = "Products"
+	products.FieldPrefix = "products_"
+	starWars, closeStarWars := newTestSubschema(t, "starwars", &testutil.StarWarsSchema)
+	defer closeStarWars()
+	starWars.Namespace = "starwars"
+
+	gw, err := NewGateway(&GatewayConfig{Name: "gateway", Subschemas: []*Subschema{users, products, starWars}})
+	if err != nil {
+		t.Fatalf("NewGateway() returned error: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			name: "root fields of several graphs",
+			query: `query Home($first: Int) {
+				__typename
+				me { name }
+				products: products_top(first: $first) { upc seller { ... on ProductsUser { __typename id } } }
+			}`,
+			variables: map[string]interface{}{"first": 1},
+			want:      `{"data":{"__typename":"Query","me":{"name":"Luke"},"products":[{"seller":{"__typename":"ProductsUser","id":"1"},"upc":"1"}]}}`,
+		},
+		{
+			name: "fragments and conditions",
+			query: `query($withMe: Boolean!) { ...Root @include(if: $withMe) }
+				fragment Root on Query { me { id } products_me { ...Seller } }
+				fragment Seller on ProductsUser { id }`,
+			variables: map[string]interface{}{"withMe": false},
+			want:      `{"data":{}}`,
+		},
+		{
+			name: "included fragments",
+			query: `query($withMe: Boolean!) { ...Root @include(if: $withMe) }
+				fragment Root on Query { me { id } products_me { ...Seller } }
+				fragment Seller on ProductsUser { id }`,
+			variables: map[string]interface{}{"withMe": true},
+			want:      `{"data":{"me":{"id":"1"},"products_me":null}}`,
+		},
+		{
+			name:  "namespace",
+			query: `{ sw: starwars { __typename hero { name ... on Droid { primaryFunction } } } }`,
+			want:  `{"data":{"sw":{"__typename":"StarwarsQuery","hero":{"name":"R2-D2","primaryFunction":"Astromech"}}}}`,
+		},
+		{
+			name:  "errors",
+			query: `{ user(id: "2") { name } starwars { human(id: "1000") { name } } }`,
+			want:  `{"data":{"starwars":{"human":{"name":"Luke Skywalker"}},"user":null},"errors":[{"message":"user not found","path":["user"]}]}`,
+		},
+		{
+			name:  "invalid operation",
+			query: `{ hero { name } }`,
+			want:  `{"errors":[{"message":"Cannot query field \"hero\" on type \"Query\".","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "introspection",
+			query: `{ __type(name: "StarwarsQuery") { fields { name } } }`,
+			want:  `{"data":{"__type":{"fields":[{"name":"droid"},{"name":"hero"},{"name":"human"}]}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := gw.Execute(context.Background(), &graph.Request{Query: tt.query, Variables: tt.variables}, http.DefaultTransport)
+			if err != nil {
+				t.Fatalf("Execute() returned error: %s", err)
+			}
+			got, _ := json.Marshal(res)
+			if string(got) != tt.want {
+				t.Errorf("Execute() returned\n%s\nexpected\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGateway_Execute_unavailable_graph(t *testing.T) {
+	users, closeUsers := newTestSubschema(t, "users", &usersSchema)
+	defer closeUsers()
+	starWars, closeStarWars := newTestSubschema(t, "starwars", &testutil.StarWarsSchema)
+	starWars.Namespace = "starwars"
+	gw, err := NewGateway(&GatewayConfig{Name: "gateway", Subschemas: []*Subschema{users, starWars}})
+	if err != nil {
+		t.Fatalf("NewGateway() returned error: %s", err)
+	}
+	closeStarWars()
+
+	res, err := gw.Execute(context.Background(), &graph.Request{
+		Query: `mutation { rename(name: "Leia") { name } }`,
+	}, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("Execute() returned error: %s", err)
+	}
+	if got, _ := json.Marshal(res); string(got) != `{"data":{"rename":{"name":"Leia"}}}` {
+		t.Errorf("Execute() returned %s", got)
+	}
+
+	res, err = gw.Execute(context.Background(), &graph.Request{
+		Query: `{ me { name } starwars { hero { name } } }`,
+	}, http.DefaultTransport)
+	if err == nil || !strings.Contains(err.Error(), "starwars") {
+		t.Errorf("Execute() returned error %v, expected a starwars failure", err)
+	}
+	want := `{"data":{"me":{"name":"Luke"},"starwars":null},"errors":[{"message":"Failed to execute graph starwars request.","path":["starwars"],"extensions":{"code":"SERVICE_UNREACHABLE"}}]}`
+	if got, _ := json.Marshal(res); string(got) != want {
+		t.Errorf("Execute() returned\n%s\nexpected\n%s", got, want)
+	}
+}
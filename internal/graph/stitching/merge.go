@@ -0,0 +1,323 @@
+package stitching
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// Subschema is the schema of a graph merged into the gateway schema.
+type Subschema struct {
+	Graph  graph.Graph
+	Schema schema.Schema
+	// FieldPrefix prefixes the names of the root fields of the graph in the
+	// gateway schema.
+	FieldPrefix string
+	// TypePrefix prefixes the names of the types of the graph in the gateway
+	// schema, except the built-in scalars.
+	TypePrefix string
+	// Namespace, when set, is the name of the gateway root fields the root
+	// fields of the graph are nested under. Their types are named after the
+	// namespace, like UsersQuery and UsersMutation for users.
+	Namespace string
+}
+
+// subschema is a merged Subschema.
+type subschema struct {
+	*Subschema
+	// typeNames maps the gateway type names to the graph ones, and
+	// gatewayTypeNames the graph type names to the gateway ones. They only
+	// hold the names that differ.
+	typeNames        map[string]string
+	gatewayTypeNames map[string]string
+}
+
+// rootField is a root field of the gateway schema.
+type rootField struct {
+	sub *subschema
+	// name is the name of the field in the graph schema
+	name string
+	// namespace fields nest the root fields of the graph
+	namespace bool
+	nonNull   bool
+}
+
+type rootType struct {
+	op string
+	// name is the name of the gateway root type
+	name string
+	cfg  *schema.TypeRefConfig
+}
+
+// merger merges the subschemas into the gateway schema config.
+type merger struct {
+	cfg        *schema.SchemaConfig
+	types      map[string]*schema.TypeConfig
+	directives map[string]*schema.DirectiveConfig
+	// owners are the IDs of the graphs defining the types, directives and
+	// root fields first, to report conflicts
+	owners     map[string]string
+	subs       []*subschema
+	rootFields map[string]map[string]*rootField
+}
+
+func merge(subs []*Subschema) (*merger, error) {
+	if len(subs) == 0 {
+		return nil, errors.New("missing subschemas")
+	}
+	m := &merger{
+		cfg:        &schema.SchemaConfig{},
+		types:      make(map[string]*schema.TypeConfig),
+		directives: make(map[string]*schema.DirectiveConfig),
+		owners:     make(map[string]string),
+		rootFields: map[string]map[string]*rootField{
+			graph.OperationTypeQuery:    make(map[string]*rootField),
+			graph.OperationTypeMutation: make(map[string]*rootField),
+		},
+	}
+	for _, sub := range subs {
+		if sub.Graph == nil || sub.Schema == nil {
+			return nil, errors.New("subschema must have a graph and a schema")
+		}
+		if err := m.add(sub); err != nil {
+			return nil, err
+		}
+	}
+
+	m.cfg.QueryType = &schema.TypeRefConfig{Kind: schema.TypeKindObject, Name: "Query"}
+	if _, ok := m.types["Mutation"]; ok {
+		m.cfg.MutationType = &schema.TypeRefConfig{Kind: schema.TypeKindObject, Name: "Mutation"}
+	}
+	return m, nil
+}
+
+func (m *merger) add(s *Subschema) error {
+	cfg := schema.SchemaConfigOf(s.Schema)
+	sub := &subschema{
+		Subschema:        s,
+		typeNames:        make(map[string]string),
+		gatewayTypeNames: make(map[string]string),
+	}
+	m.subs = append(m.subs, sub)
+
+	// the root types are merged into the gateway ones, or into the types of
+	// the namespace fields
+	roots := []*rootType{{op: graph.OperationTypeQuery, name: "Query", cfg: cfg.QueryType}}
+	if cfg.MutationType != nil {
+		roots = append(roots, &rootType{op: graph.OperationTypeMutation, name: "Mutation", cfg: cfg.MutationType})
+	}
+	isRoot := func(name string) bool {
+		for _, root := range roots {
+			if root.cfg.Name == name {
+				return true
+			}
+		}
+		return cfg.SubscriptionType != nil && cfg.SubscriptionType.Name == name
+	}
+	for _, root := range roots {
+		if s.Namespace != "" {
+			sub.rename(root.cfg.Name, strings.Title(s.Namespace)+root.name)
+		} else {
+			sub.rename(root.cfg.Name, root.name)
+		}
+	}
+	for _, t := range cfg.Types {
+		if !isRoot(t.Name) && !isBuiltInType(t.Name) {
+			sub.rename(t.Name, s.TypePrefix+t.Name)
+		}
+	}
+
+	// subscriptions are not served by the gateway
+	rootCfgs := make(map[string]*schema.TypeConfig)
+	for _, t := range cfg.Types {
+		if isRoot(t.Name) {
+			rootCfgs[t.Name] = t
+			continue
+		}
+		if strings.HasPrefix(t.Name, "__") {
+			continue
+		}
+		if err := m.addType(sub, sub.renameType(t)); err != nil {
+			return err
+		}
+	}
+	for _, root := range roots {
+		if err := m.addRootFields(sub, root.op, root.name, rootCfgs[root.cfg.Name]); err != nil {
+			return err
+		}
+	}
+	for _, d := range cfg.Directives {
+		if err := m.addDirective(sub, sub.renameDirective(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *merger) addType(sub *subschema, t *schema.TypeConfig) error {
+	existing, ok := m.types[t.Name]
+	if !ok {
+		m.types[t.Name] = t
+		m.owners["type "+t.Name] = sub.Graph.ID()
+		m.cfg.Types = append(m.cfg.Types, t)
+		return nil
+	}
+	if schema.TypeSignature(existing) != schema.TypeSignature(t) {
+		return fmt.Errorf("type \"%s\" of graph %s conflicts with the one of graph %s", t.Name, sub.Graph.ID(), m.owners["type "+t.Name])
+	}
+	return nil
+}
+
+// addRootFields adds the fields of the root type of the graph to the gateway
+// root type of the given name, or to the type of its namespace field.
+func (m *merger) addRootFields(sub *subschema, op, rootName string, root *schema.TypeConfig) error {
+	if _, ok := m.types[rootName]; !ok {
+		if err := m.addType(sub, &schema.TypeConfig{Kind: schema.TypeKindObject, Name: rootName}); err != nil {
+			return err
+		}
+	}
+	if sub.Namespace != "" {
+		nsType := sub.renameType(root)
+		if err := m.addType(sub, nsType); err != nil {
+			return err
+		}
+		// the namespace field is nullable, so that the failures of the graph
+		// do not null the fields of the other graphs
+		return m.addRootField(sub, op, rootName, &schema.FieldConfig{
+			Name: sub.Namespace,
+			Type: &schema.TypeRefConfig{Kind: schema.TypeKindObject, Name: nsType.Name},
+		}, &rootField{sub: sub, name: sub.Namespace, namespace: true})
+	}
+	for _, f := range sub.renameType(root).Fields {
+		name := f.Name
+		f.Name = sub.FieldPrefix + f.Name
+		err := m.addRootField(sub, op, rootName, f, &rootField{
+			sub:     sub,
+			name:    name,
+			nonNull: f.Type.Kind == schema.TypeKindNonNull,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *merger) addRootField(sub *subschema, op, rootName string, f *schema.FieldConfig, rf *rootField) error {
+	owner := op + " field " + f.Name
+	if _, ok := m.rootFields[op][f.Name]; ok {
+		return fmt.Errorf("%s root field \"%s\" of graph %s conflicts with the one of graph %s", op, f.Name, sub.Graph.ID(), m.owners[owner])
+	}
+	m.rootFields[op][f.Name] = rf
+	m.owners[owner] = sub.Graph.ID()
+	m.types[rootName].Fields = append(m.types[rootName].Fields, f)
+	return nil
+}
+
+func (m *merger) addDirective(sub *subschema, d *schema.DirectiveConfig) error {
+	existing, ok := m.directives[d.Name]
+	if !ok {
+		m.directives[d.Name] = d
+		m.owners["directive "+d.Name] = sub.Graph.ID()
+		m.cfg.Directives = append(m.cfg.Directives, d)
+		return nil
+	}
+	if schema.DirectiveSignature(existing) != schema.DirectiveSignature(d) {
+		return fmt.Errorf("directive \"%s\" of graph %s conflicts with the one of graph %s", d.Name, sub.Graph.ID(), m.owners["directive "+d.Name])
+	}
+	return nil
+}
+
+func (sub *subschema) rename(name, gatewayName string) {
+	if name == gatewayName {
+		return
+	}
+	sub.typeNames[gatewayName] = name
+	sub.gatewayTypeNames[name] = gatewayName
+}
+
+func (sub *subschema) gatewayTypeName(name string) string {
+	if n, ok := sub.gatewayTypeNames[name]; ok {
+		return n
+	}
+	return name
+}
+
+func (sub *subschema) typeName(gatewayName string) string {
+	if n, ok := sub.typeNames[gatewayName]; ok {
+		return n
+	}
+	return gatewayName
+}
+
+// renameType returns a copy of the type config with the gateway type names.
+func (sub *subschema) renameType(t *schema.TypeConfig) *schema.TypeConfig {
+	renamed := &schema.TypeConfig{
+		Kind:        t.Kind,
+		Name:        sub.gatewayTypeName(t.Name),
+		Description: t.Description,
+		EnumValues:  t.EnumValues,
+		InputFields: sub.renameInputValues(t.InputFields),
+	}
+	for _, f := range t.Fields {
+		renamed.Fields = append(renamed.Fields, &schema.FieldConfig{
+			Name:              f.Name,
+			Description:       f.Description,
+			Args:              sub.renameInputValues(f.Args),
+			Type:              sub.renameTypeRef(f.Type),
+			IsDeprecated:      f.IsDeprecated,
+			DeprecationReason: f.DeprecationReason,
+		})
+	}
+	for _, i := range t.Interfaces {
+		renamed.Interfaces = append(renamed.Interfaces, sub.renameTypeRef(i))
+	}
+	for _, pt := range t.PossibleTypes {
+		renamed.PossibleTypes = append(renamed.PossibleTypes, sub.renameTypeRef(pt))
+	}
+	return renamed
+}
+
+func (sub *subschema) renameDirective(d *schema.DirectiveConfig) *schema.DirectiveConfig {
+	return &schema.DirectiveConfig{
+		Name:        d.Name,
+		Description: d.Description,
+		Locations:   d.Locations,
+		Args:        sub.renameInputValues(d.Args),
+	}
+}
+
+func (sub *subschema) renameInputValues(values []*schema.InputValueConfig) []*schema.InputValueConfig {
+	renamed := make([]*schema.InputValueConfig, 0, len(values))
+	for _, v := range values {
+		renamed = append(renamed, &schema.InputValueConfig{
+			Name:         v.Name,
+			Description:  v.Description,
+			Type:         sub.renameTypeRef(v.Type),
+			DefaultValue: v.DefaultValue,
+		})
+	}
+	return renamed
+}
+
+func (sub *subschema) renameTypeRef(ref *schema.TypeRefConfig) *schema.TypeRefConfig {
+	if ref == nil {
+		return nil
+	}
+	return &schema.TypeRefConfig{
+		Kind:   ref.Kind,
+		Name:   sub.gatewayTypeName(ref.Name),
+		OfType: sub.renameTypeRef(ref.OfType),
+	}
+}
+
+func isBuiltInType(name string) bool {
+	switch name {
+	case "Int", "Float", "String", "Boolean", "ID":
+		return true
+	}
+	return strings.HasPrefix(name, "__")
+}
@@ -0,0 +1,184 @@
+package stitching
+
+import (
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// fetch is the part of an operation sent to one graph, or executed by the
+// gateway when it has no subschema: the introspection fields.
+type fetch struct {
+	sub *subschema
+	// namespace is the response key of the namespace field the fetch
+	// executes the selections of
+	namespace  string
+	selections []ast.Selection
+	// keys are the response keys of the root fields of the fetch, the
+	// nonNullKeys ones are non-null
+	keys        []string
+	nonNullKeys []string
+}
+
+// planner splits an operation into fetches.
+type planner struct {
+	rootFields map[string]*rootField
+	op         *ast.OperationDefinition
+	fragments  map[string]*ast.FragmentDefinition
+}
+
+// plan returns the fetches of the operation in the order of their first root
+// field. The root fields of a graph share a fetch, unless serial: then only
+// the consecutive ones do.
+func (p *planner) plan(serial bool) []*fetch {
+	type owner struct {
+		sub       *subschema
+		namespace string
+	}
+	fetches := make([]*fetch, 0)
+	byOwner := make(map[owner]*fetch)
+	var last owner
+	for _, sel := range graph.RootFields(p.op.SelectionSet, p.fragments) {
+		key := graph.ResponseKey(sel.Field)
+		rf := p.rootFields[sel.Field.Name.Value]
+		o := owner{}
+		if rf != nil {
+			o.sub = rf.sub
+			if rf.namespace {
+				o.namespace = key
+			}
+		}
+		f, ok := byOwner[o]
+		if !ok || serial && o != last {
+			f = &fetch{sub: o.sub, namespace: o.namespace}
+			fetches = append(fetches, f)
+			byOwner[o] = f
+		}
+		last = o
+
+		switch {
+		case rf == nil:
+			// introspection fields are executed as they are by the gateway
+			f.addSelection(sel.Field, sel.Conditions)
+			f.addKey(key, false)
+		case rf.namespace:
+			// the namespace conditions apply to all its selections
+			conditions := graph.WithConditions(sel.Conditions, sel.Field.Directives)
+			for _, nsSel := range sel.Field.SelectionSet.Selections {
+				f.addSelection(nsSel, conditions)
+			}
+			f.keys = []string{key}
+		default:
+			field := *sel.Field
+			field.Name = ast.NewName(&ast.Name{Value: rf.name})
+			if key != rf.name {
+				field.Alias = ast.NewName(&ast.Name{Value: key})
+			}
+			f.addSelection(&field, sel.Conditions)
+			f.addKey(key, rf.nonNull)
+		}
+	}
+	return fetches
+}
+
+// addSelection adds the selection to the fetch, with the conditions it was
+// selected with.
+func (f *fetch) addSelection(sel ast.Selection, conditions [][]*ast.Directive) {
+	f.selections = append(f.selections, graph.NestInConditions(sel, conditions))
+}
+
+func (f *fetch) addKey(key string, nonNull bool) {
+	for _, k := range f.keys {
+		if k == key {
+			return
+		}
+	}
+	f.keys = append(f.keys, key)
+	if nonNull {
+		f.nonNullKeys = append(f.nonNullKeys, key)
+	}
+}
+
+// document returns the document of the fetch operation, with the fragments
+// and variables it uses, and the values of the variables. The type names of
+// the gateway schema are renamed to the graph ones.
+func (p *planner) document(f *fetch, variables map[string]interface{}, rename func(string) string) (*ast.Document, map[string]interface{}) {
+	op := ast.NewOperationDefinition(&ast.OperationDefinition{
+		Operation:    p.op.Operation,
+		Name:         p.op.Name,
+		SelectionSet: renameSelectionSet(ast.NewSelectionSet(&ast.SelectionSet{Selections: f.selections}), rename),
+	})
+	defs := []ast.Node{op}
+
+	usedVariables, usedFragments := graph.VariablesAndFragmentsUsed(op.SelectionSet, p.fragments)
+	for _, name := range usedFragments {
+		frag := p.fragments[name]
+		defs = append(defs, ast.NewFragmentDefinition(&ast.FragmentDefinition{
+			Name:          frag.Name,
+			TypeCondition: renameNamed(frag.TypeCondition, rename),
+			Directives:    frag.Directives,
+			SelectionSet:  renameSelectionSet(frag.SelectionSet, rename),
+		}))
+	}
+
+	vars := make(map[string]interface{})
+	for _, def := range p.op.VariableDefinitions {
+		name := def.Variable.Name.Value
+		if !usedVariables[name] {
+			continue
+		}
+		op.VariableDefinitions = append(op.VariableDefinitions, ast.NewVariableDefinition(&ast.VariableDefinition{
+			Variable:     def.Variable,
+			Type:         renameType(def.Type, rename),
+			DefaultValue: def.DefaultValue,
+		}))
+		if v, ok := variables[name]; ok {
+			vars[name] = v
+		}
+	}
+	return ast.NewDocument(&ast.Document{Definitions: defs}), vars
+}
+
+// renameSelectionSet returns a copy of the selection set with its type
+// conditions renamed.
+func renameSelectionSet(set *ast.SelectionSet, rename func(string) string) *ast.SelectionSet {
+	if set == nil {
+		return nil
+	}
+	renamed := ast.NewSelectionSet(&ast.SelectionSet{})
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			field := *sel
+			field.SelectionSet = renameSelectionSet(sel.SelectionSet, rename)
+			renamed.Selections = append(renamed.Selections, &field)
+		case *ast.InlineFragment:
+			frag := *sel
+			frag.TypeCondition = renameNamed(sel.TypeCondition, rename)
+			frag.SelectionSet = renameSelectionSet(sel.SelectionSet, rename)
+			renamed.Selections = append(renamed.Selections, &frag)
+		default:
+			renamed.Selections = append(renamed.Selections, sel)
+		}
+	}
+	return renamed
+}
+
+func renameNamed(t *ast.Named, rename func(string) string) *ast.Named {
+	if t == nil {
+		return nil
+	}
+	return ast.NewNamed(&ast.Named{Name: ast.NewName(&ast.Name{Value: rename(t.Name.Value)})})
+}
+
+func renameType(t ast.Type, rename func(string) string) ast.Type {
+	switch t := t.(type) {
+	case *ast.NonNull:
+		return ast.NewNonNull(&ast.NonNull{Type: renameType(t.Type, rename)})
+	case *ast.List:
+		return ast.NewList(&ast.List{Type: renameType(t.Type, rename)})
+	case *ast.Named:
+		return renameNamed(t, rename)
+	}
+	return t
+}
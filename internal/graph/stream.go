@@ -0,0 +1,168 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Media types of the incremental deliveries a graph can respond with.
+const (
+	MediaTypeEventStream = "text/event-stream"
+	MediaTypeMultipart   = "multipart/mixed"
+)
+
+// ResponseStream is a response a graph delivers incrementally, as a sequence
+// of response parts. It is used for @defer and @stream payloads as well as
+// for subscriptions over server-sent events.
+type ResponseStream interface {
+	// MediaType returns the media type the graph delivers the parts with,
+	// either MediaTypeEventStream or MediaTypeMultipart.
+	MediaType() string
+	// Header returns the header of the graph response.
+	Header() http.Header
+	// Next returns the next part of the response, or io.EOF once all the
+	// parts were delivered.
+	Next() (*Response, error)
+	Close() error
+}
+
+// StreamGraph is implemented by graphs able to deliver responses
+// incrementally.
+type StreamGraph interface {
+	Graph
+	// ExecuteStream executes the request and returns either the response or
+	// the response stream, depending on how the graph delivers it.
+	ExecuteStream(context.Context, *Request, http.RoundTripper) (*Response, ResponseStream, error)
+}
+
+func isResponseStream(httpRes *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(httpRes.Header.Get("Content-Type"))
+	return mediaType == MediaTypeEventStream || mediaType == MediaTypeMultipart
+}
+
+// newResponseStream returns the response stream of the HTTP response, or nil
+// when the graph did not deliver the response incrementally.
+func newResponseStream(httpRes *http.Response) (ResponseStream, error) {
+	mediaType, params, _ := mime.ParseMediaType(httpRes.Header.Get("Content-Type"))
+	switch mediaType {
+	case MediaTypeEventStream:
+		return &eventStream{
+			header: httpRes.Header,
+			body:   httpRes.Body,
+			reader: bufio.NewReader(httpRes.Body),
+		}, nil
+	case MediaTypeMultipart:
+		boundary := params["boundary"]
+		if boundary == "" {
+			httpRes.Body.Close()
+			return nil, fmt.Errorf("graphql service responded with %s without boundary", mediaType)
+		}
+		return &multipartStream{
+			header: httpRes.Header,
+			body:   httpRes.Body,
+			reader: multipart.NewReader(httpRes.Body, boundary),
+		}, nil
+	}
+	return nil, nil
+}
+
+// eventStream reads the response parts from server-sent events, following
+// the GraphQL over SSE protocol: every "next" (or unnamed) event holds a
+// part, and a "complete" event ends the stream.
+type eventStream struct {
+	header http.Header
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+func (s *eventStream) MediaType() string   { return MediaTypeEventStream }
+func (s *eventStream) Header() http.Header { return s.header }
+func (s *eventStream) Close() error        { return s.body.Close() }
+
+func (s *eventStream) Next() (*Response, error) {
+	event := ""
+	data := new(bytes.Buffer)
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line != "" {
+			field, value := line, ""
+			if i := strings.Index(line, ":"); i >= 0 {
+				field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+			}
+			continue
+		}
+
+		// an empty line dispatches the event
+		switch event {
+		case "complete":
+			return nil, io.EOF
+		case "", "next":
+			if data.Len() > 0 {
+				part := new(Response)
+				if err := json.Unmarshal(data.Bytes(), part); err != nil {
+					return nil, fmt.Errorf("failed to decode graphql service response part: %s", err)
+				}
+				return part, nil
+			}
+		}
+		event = ""
+		data.Reset()
+	}
+}
+
+// multipartStream reads the response parts from the parts of a
+// multipart/mixed body, following the GraphQL incremental delivery over HTTP
+// specification.
+type multipartStream struct {
+	header http.Header
+	body   io.ReadCloser
+	reader *multipart.Reader
+}
+
+func (s *multipartStream) MediaType() string   { return MediaTypeMultipart }
+func (s *multipartStream) Header() http.Header { return s.header }
+func (s *multipartStream) Close() error        { return s.body.Close() }
+
+func (s *multipartStream) Next() (*Response, error) {
+	for {
+		p, err := s.reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		bdy, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(bdy)) == 0 {
+			continue
+		}
+		part := new(Response)
+		if err := json.Unmarshal(bdy, part); err != nil {
+			return nil, fmt.Errorf("failed to decode graphql service response part: %s", err)
+		}
+		return part, nil
+	}
+}
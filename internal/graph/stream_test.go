@@ -0,0 +1,90 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGraph_ExecuteStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantType    string
+		wantParts   []string
+	}{
+		{
+			name:        "server-sent events",
+			contentType: "text/event-stream",
+			body: "event: next\ndata: {\"data\":{\"a\":1}}\n\n" +
+				": keep alive\n\n" +
+				"event: next\ndata: {\"data\":\ndata: {\"a\":2}}\n\n" +
+				"event: complete\ndata:\n\n",
+			wantType:  MediaTypeEventStream,
+			wantParts: []string{`{"data":{"a":1}}`, `{"data":{"a":2}}`},
+		},
+		{
+			name:        "multipart incremental delivery",
+			contentType: `multipart/mixed; boundary="-"`,
+			body: "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" +
+				`{"data":{"hero":{"name":"R2-D2"}},"hasNext":true}` +
+				"\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" +
+				`{"incremental":[{"data":{"friends":[]},"path":["hero"]}],"hasNext":false}` +
+				"\r\n-----\r\n",
+			wantType: MediaTypeMultipart,
+			wantParts: []string{
+				`{"data":{"hero":{"name":"R2-D2"}},"hasNext":true}`,
+				`{"hasNext":false,"incremental":[{"data":{"friends":[]},"path":["hero"]}]}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			serviceURL, _ := url.Parse(srv.URL)
+			g, _ := NewGraph(&GraphConfig{ServiceURL: serviceURL})
+			res, stream, err := g.(StreamGraph).ExecuteStream(context.Background(), &Request{Query: "{ a }"}, nil)
+			if err != nil {
+				t.Fatalf("ExecuteStream() returned error: %s", err)
+			}
+			if res != nil || stream == nil {
+				t.Fatalf("ExecuteStream() returned response %v and stream %v, expected a stream only", res, stream)
+			}
+			defer stream.Close()
+			if stream.MediaType() != tt.wantType {
+				t.Errorf("stream media type is %s, expected %s", stream.MediaType(), tt.wantType)
+			}
+
+			var parts []string
+			for {
+				part, err := stream.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next() returned error: %s", err)
+				}
+				bdy, _ := json.Marshal(part)
+				parts = append(parts, string(bdy))
+			}
+			if len(parts) != len(tt.wantParts) {
+				t.Fatalf("stream returned parts %v, expected %v", parts, tt.wantParts)
+			}
+			for i := range parts {
+				if parts[i] != tt.wantParts[i] {
+					t.Errorf("part %d is %s, expected %s", i, parts[i], tt.wantParts[i])
+				}
+			}
+		})
+	}
+}
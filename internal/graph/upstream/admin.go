@@ -0,0 +1,70 @@
+package upstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// EndpointStatus is the state of an endpoint of a pool.
+type EndpointStatus struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	// Available is whether the endpoint takes requests: it is healthy and
+	// not ejected.
+	Available bool `json:"available"`
+	Healthy   bool `json:"healthy"`
+	InFlight  int  `json:"inFlight"`
+	// EjectedUntil is the time an ejected endpoint is back in the pool.
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	// SlowStartFactor is the share of its requests the endpoint gets, below
+	// 1 while it slowly starts.
+	SlowStartFactor float64 `json:"slowStartFactor"`
+}
+
+// Status returns the state of the endpoints of the pool.
+func (p *Pool) Status() []*EndpointStatus {
+	statuses := make([]*EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		status := p.status(e)
+		statuses = append(statuses, &status)
+	}
+	return statuses
+}
+
+func (p *Pool) status(e *endpoint) EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	status := EndpointStatus{
+		URL:             e.label,
+		Weight:          e.Weight,
+		Available:       e.available(now),
+		Healthy:         e.healthy,
+		InFlight:        e.inFlight,
+		SlowStartFactor: p.slowStartFactor(now, e),
+	}
+	if now.Before(e.ejectedUntil) {
+		ejectedUntil := e.ejectedUntil
+		status.EjectedUntil = &ejectedUntil
+	}
+	return status
+}
+
+// NewAdminHandler returns a handler responding with the state of the
+// endpoints of the pools, by graph name, in JSON.
+func NewAdminHandler(pools map[string]*Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		statuses := make(map[string][]*EndpointStatus, len(pools))
+		for name, p := range pools {
+			statuses[name] = p.Status()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
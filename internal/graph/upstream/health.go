@@ -0,0 +1,159 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minSlowStartFactor is the share of its requests an endpoint gets as soon as
+// it is back in the pool.
+const minSlowStartFactor = 0.1
+
+// available returns whether the endpoint takes requests.
+func (e *endpoint) available(now time.Time) bool {
+	return e.healthy && !now.Before(e.ejectedUntil)
+}
+
+// slowStartFactor returns the share of its requests the endpoint gets,
+// growing from minSlowStartFactor to 1 over the slow start once it is back in
+// the pool.
+func (p *Pool) slowStartFactor(now time.Time, e *endpoint) float64 {
+	if p.cfg.SlowStart <= 0 || e.availableSince.IsZero() {
+		return 1
+	}
+	factor := float64(now.Sub(e.availableSince)) / float64(p.cfg.SlowStart)
+	if factor < minSlowStartFactor {
+		return minSlowStartFactor
+	}
+	if factor > 1 {
+		return 1
+	}
+	return factor
+}
+
+// observe records the outcome of a request to the endpoint, and ejects it
+// when too many consecutive requests failed.
+func (p *Pool) observe(e *endpoint, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		e.requestFailures = 0
+		return
+	}
+	e.requestFailures++
+	if p.cfg.MaxFailures <= 0 || e.requestFailures < p.cfg.MaxFailures {
+		return
+	}
+	now := p.now()
+	if now.Before(e.ejectedUntil) {
+		return
+	}
+	e.requestFailures = 0
+	e.ejectedUntil = now.Add(p.cfg.EjectionTime)
+	e.availableSince = e.ejectedUntil
+	if p.ejections != nil {
+		p.ejections.WithLabelValues(e.label).Inc()
+	}
+}
+
+// checkHealth checks the health of the endpoints at every interval until the
+// pool is closed.
+func (p *Pool) checkHealth() {
+	defer p.stopped.Done()
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		p.checkAll()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll checks the health of all the endpoints at once.
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			p.recordCheck(e, p.check(e) == nil)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// check posts the probe query to the endpoint.
+func (p *Pool) check(e *endpoint) error {
+	ctx := context.Background()
+	if p.cfg.HealthCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.HealthCheckTimeout)
+		defer cancel()
+	}
+	body, err := json.Marshal(map[string]string{"query": p.cfg.HealthCheckQuery})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.cfg.HealthCheckTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check responded with status %d", res.StatusCode)
+	}
+	var result struct {
+		Data   json.RawMessage   `json:"data"`
+		Errors []json.RawMessage `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Errors) != 0 {
+		return fmt.Errorf("health check responded with %d errors", len(result.Errors))
+	}
+	if len(result.Data) == 0 || string(result.Data) == "null" {
+		return fmt.Errorf("health check responded without data")
+	}
+	return nil
+}
+
+// recordCheck records the outcome of a health check of the endpoint, which
+// changes its health after enough consecutive identical outcomes.
+func (p *Pool) recordCheck(e *endpoint, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !ok {
+		e.successes = 0
+		e.failures++
+		if e.healthy && e.failures >= p.cfg.UnhealthyThreshold {
+			e.healthy = false
+		}
+		return
+	}
+	e.failures = 0
+	e.successes++
+	if !e.healthy && e.successes >= p.cfg.HealthyThreshold {
+		e.healthy = true
+		now := p.now()
+		if now.After(e.availableSince) {
+			e.availableSince = now
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// Strategy is the way a pool picks the endpoint of a request.
+type Strategy string
+
+const (
+	// StrategyRoundRobin sends the requests to the endpoints in turn.
+	StrategyRoundRobin Strategy = "round-robin"
+	// StrategyLeastInFlight sends the requests to the endpoint with the
+	// fewest requests in flight.
+	StrategyLeastInFlight Strategy = "least-in-flight"
+	// StrategyWeighted sends the requests to the endpoints in turn, in
+	// proportion to their weights.
+	StrategyWeighted Strategy = "weighted"
+	// StrategyConsistentHash sends the requests with the same value of the
+	// hash header to the same endpoint, the others in turn.
+	StrategyConsistentHash Strategy = "consistent-hash"
+)
+
+// DefaultHealthCheckQuery is the probe query of the active health checks when
+// none is given.
+const DefaultHealthCheckQuery = "{ __typename }"
+
+// Endpoint is an instance of a GraphQL service.
+type Endpoint struct {
+	URL *url.URL
+	// Weight is the share of the requests the endpoint gets with the
+	// weighted and consistent hash strategies, it is 1 by default.
+	Weight int
+}
+
+// ParseEndpoint parses the URL of an endpoint, its weight may be given by a
+// weight=N fragment, like http://users-1:8080/graphql#weight=2.
+func ParseEndpoint(rawurl string) (*Endpoint, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	e := &Endpoint{URL: u, Weight: 1}
+	if u.Fragment != "" {
+		values, err := url.ParseQuery(u.Fragment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %s", rawurl, err)
+		}
+		if w := values.Get("weight"); w != "" {
+			if e.Weight, err = strconv.Atoi(w); err != nil || e.Weight < 1 {
+				return nil, fmt.Errorf("invalid endpoint %s: weight must be a positive integer", rawurl)
+			}
+		}
+		u.Fragment = ""
+	}
+	return e, nil
+}
+
+// Config defines the configuration of a pool.
+type Config struct {
+	Endpoints []*Endpoint
+	// Strategy is StrategyRoundRobin by default.
+	Strategy Strategy
+	// HashHeader is the request header hashed by StrategyConsistentHash.
+	HashHeader string
+
+	// HealthCheckInterval is the time between the active health checks of
+	// the endpoints, they are disabled when it is zero. A check posts the
+	// HealthCheckQuery to the endpoint, and succeeds when the endpoint
+	// responds with data and no errors within the HealthCheckTimeout.
+	HealthCheckInterval time.Duration
+	HealthCheckQuery    string
+	HealthCheckTimeout  time.Duration
+	// UnhealthyThreshold is the number of consecutive failed checks after
+	// which an endpoint is unhealthy, HealthyThreshold the number of
+	// consecutive successful ones after which it is healthy again. They are
+	// 1 by default.
+	UnhealthyThreshold int
+	HealthyThreshold   int
+	// HealthCheckTransport sends the checks, it is http.DefaultTransport by
+	// default.
+	HealthCheckTransport http.RoundTripper
+
+	// MaxFailures is the number of consecutive failed requests, transport
+	// errors or 5xx responses, after which an endpoint is ejected from the
+	// pool for the EjectionTime. Endpoints are never ejected when it is
+	// zero.
+	MaxFailures  int
+	EjectionTime time.Duration
+	// SlowStart is the time an endpoint back in the pool takes to get its
+	// full share of the requests, growing linearly from a tenth of it. It
+	// does not apply to StrategyConsistentHash.
+	SlowStart time.Duration
+
+	Namespace string
+	Subsystem string
+	// Registerer registers the metrics of the endpoints, they are not
+	// recorded when it is nil.
+	Registerer prometheus.Registerer
+}
+
+// Pool balances the requests of a graph over the endpoints of its GraphQL
+// service. The requests are spread over the available endpoints, the healthy
+// ones that are not ejected, or over all of them when none is available.
+type Pool struct {
+	cfg       Config
+	endpoints []*endpoint
+	ring      []ringPoint
+	ejections *prometheus.CounterVec
+	now       func() time.Time
+
+	mu sync.Mutex
+	// next is the index the least-in-flight strategy starts looking from
+	next int
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+var _ graph.Balancer = (*Pool)(nil)
+
+// endpoint is an endpoint along with its state, guarded by the pool mutex.
+type endpoint struct {
+	*Endpoint
+	label string
+
+	inFlight int
+	// healthy is the state of the endpoint according to the active checks,
+	// successes and failures count the consecutive checks.
+	healthy   bool
+	successes int
+	failures  int
+	// requestFailures counts the consecutive failed requests
+	requestFailures int
+	ejectedUntil    time.Time
+	// availableSince is the time the endpoint came back in the pool, zero
+	// when it was always in
+	availableSince time.Time
+	// current is the smooth weighted round-robin state
+	current float64
+}
+
+// ringPoint is a point of the consistent hash ring.
+type ringPoint struct {
+	hash     uint32
+	endpoint *endpoint
+}
+
+// ringPointsPerWeight is the number of points of an endpoint of weight 1 on
+// the consistent hash ring.
+const ringPointsPerWeight = 100
+
+// NewPool returns a pool balancing the requests over the endpoints, and
+// starts the active health checks when they are enabled.
+func NewPool(cfg *Config) (*Pool, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("pool must have at least one endpoint")
+	}
+	p := &Pool{
+		cfg:  *cfg,
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	switch p.cfg.Strategy {
+	case "":
+		p.cfg.Strategy = StrategyRoundRobin
+	case StrategyRoundRobin, StrategyLeastInFlight, StrategyWeighted:
+	case StrategyConsistentHash:
+		if p.cfg.HashHeader == "" {
+			return nil, errors.New("consistent hash strategy requires a hash header")
+		}
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy %s", p.cfg.Strategy)
+	}
+	if p.cfg.HealthCheckQuery == "" {
+		p.cfg.HealthCheckQuery = DefaultHealthCheckQuery
+	}
+	if p.cfg.HealthCheckTransport == nil {
+		p.cfg.HealthCheckTransport = http.DefaultTransport
+	}
+	if p.cfg.UnhealthyThreshold < 1 {
+		p.cfg.UnhealthyThreshold = 1
+	}
+	if p.cfg.HealthyThreshold < 1 {
+		p.cfg.HealthyThreshold = 1
+	}
+
+	for _, e := range cfg.Endpoints {
+		weight := e.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		// credentials do not belong in metrics
+		u := *e.URL
+		u.User = nil
+		ep := &endpoint{
+			Endpoint: &Endpoint{URL: e.URL, Weight: weight},
+			label:    u.String(),
+			healthy:  true,
+		}
+		p.endpoints = append(p.endpoints, ep)
+		for i := 0; i < weight*ringPointsPerWeight; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hash(ep.label + "#" + strconv.Itoa(i)), endpoint: ep})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+
+	if err := p.registerMetrics(); err != nil {
+		return nil, err
+	}
+	if p.cfg.HealthCheckInterval > 0 {
+		p.stopped.Add(1)
+		go p.checkHealth()
+	}
+	return p, nil
+}
+
+func (p *Pool) registerMetrics() error {
+	if p.cfg.Registerer == nil {
+		return nil
+	}
+	p.ejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: p.cfg.Namespace,
+			Subsystem: p.cfg.Subsystem,
+			Name:      "upstream_endpoint_ejections_total",
+			Help:      "Number of times the endpoint was ejected from the pool.",
+		},
+		[]string{"endpoint"},
+	)
+	collectors := []prometheus.Collector{p.ejections}
+	for _, e := range p.endpoints {
+		e := e
+		p.ejections.WithLabelValues(e.label)
+		labels := prometheus.Labels{"endpoint": e.label}
+		collectors = append(collectors,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace:   p.cfg.Namespace,
+				Subsystem:   p.cfg.Subsystem,
+				Name:        "upstream_endpoint_available",
+				Help:        "Whether the endpoint is available to receive requests.",
+				ConstLabels: labels,
+			}, func() float64 {
+				if p.status(e).Available {
+					return 1
+				}
+				return 0
+			}),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace:   p.cfg.Namespace,
+				Subsystem:   p.cfg.Subsystem,
+				Name:        "upstream_endpoint_healthy",
+				Help:        "Whether the endpoint passes its health checks.",
+				ConstLabels: labels,
+			}, func() float64 {
+				if p.status(e).Healthy {
+					return 1
+				}
+				return 0
+			}),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace:   p.cfg.Namespace,
+				Subsystem:   p.cfg.Subsystem,
+				Name:        "upstream_endpoint_requests_in_flight",
+				Help:        "Number of requests sent to the endpoint waiting for a response.",
+				ConstLabels: labels,
+			}, func() float64 {
+				return float64(p.status(e).InFlight)
+			}),
+		)
+	}
+	for _, c := range collectors {
+		if err := p.cfg.Registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the active health checks.
+func (p *Pool) Close() {
+	close(p.stop)
+	p.stopped.Wait()
+}
+
+// RoundTrip sends the request to the endpoint picked by the strategy. The
+// endpoint is ejected once too many consecutive requests failed.
+func (p *Pool) RoundTrip(req *http.Request, transport http.RoundTripper) (*http.Response, error) {
+	e := p.pick(req)
+
+	endpointReq := req.Clone(req.Context())
+	u := *e.URL
+	endpointReq.URL = &u
+	endpointReq.Host = ""
+	res, err := transport.RoundTrip(endpointReq)
+
+	// requests canceled by their client say nothing of the endpoint, unlike
+	// the ones it did not respond to in time
+	if !errors.Is(req.Context().Err(), context.Canceled) {
+		p.observe(e, err == nil && res.StatusCode < http.StatusInternalServerError)
+	}
+	if err != nil {
+		p.release(e)
+		return nil, err
+	}
+	// the request is in flight until its response is read
+	res.Body = &releasingBody{ReadCloser: res.Body, release: func() { p.release(e) }}
+	return res, nil
+}
+
+func (p *Pool) release(e *endpoint) {
+	p.mu.Lock()
+	e.inFlight--
+	p.mu.Unlock()
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+// pick picks the endpoint of the request and counts it in flight.
+func (p *Pool) pick(req *http.Request) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if e.available(now) {
+			candidates = append(candidates, e)
+		}
+	}
+	// with no endpoint available, the requests are spread evenly over all
+	// of them rather than failing
+	slowStart := true
+	if len(candidates) == 0 {
+		candidates = p.endpoints
+		slowStart = false
+	}
+	factor := func(e *endpoint) float64 {
+		if !slowStart {
+			return 1
+		}
+		return p.slowStartFactor(now, e)
+	}
+
+	var picked *endpoint
+	switch p.cfg.Strategy {
+	case StrategyConsistentHash:
+		if value := req.Header.Get(p.cfg.HashHeader); value != "" {
+			picked = p.hashed(value, candidates)
+		}
+	case StrategyLeastInFlight:
+		picked = p.leastInFlight(candidates, factor)
+	}
+	if picked == nil {
+		picked = p.smoothWeighted(candidates, factor)
+	}
+	picked.inFlight++
+	return picked
+}
+
+// smoothWeighted picks the endpoints in turn in proportion to their weights,
+// spreading the picks of an endpoint over the turn. The weights are all 1 but
+// with the weighted strategy.
+func (p *Pool) smoothWeighted(candidates []*endpoint, factor func(*endpoint) float64) *endpoint {
+	var picked *endpoint
+	total := 0.0
+	for _, e := range candidates {
+		weight := 1.0
+		if p.cfg.Strategy == StrategyWeighted {
+			weight = float64(e.Weight)
+		}
+		weight *= factor(e)
+		e.current += weight
+		total += weight
+		if picked == nil || e.current > picked.current {
+			picked = e
+		}
+	}
+	picked.current -= total
+	return picked
+}
+
+// leastInFlight picks the endpoint with the fewest requests in flight
+// relative to its slow start share.
+func (p *Pool) leastInFlight(candidates []*endpoint, factor func(*endpoint) float64) *endpoint {
+	var picked *endpoint
+	least := 0.0
+	for i := range candidates {
+		e := candidates[(p.next+i)%len(candidates)]
+		load := float64(e.inFlight+1) / factor(e)
+		if picked == nil || load < least {
+			picked, least = e, load
+		}
+	}
+	p.next++
+	return picked
+}
+
+// hashed picks the endpoint following the hash of the value on the ring.
+func (p *Pool) hashed(value string, candidates []*endpoint) *endpoint {
+	isCandidate := make(map[*endpoint]bool, len(candidates))
+	for _, e := range candidates {
+		isCandidate[e] = true
+	}
+	h := hash(value)
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	for i := range p.ring {
+		if point := p.ring[(start+i)%len(p.ring)]; isCandidate[point.endpoint] {
+			return point.endpoint
+		}
+	}
+	return nil
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,443 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// testTransport responds to the requests with the status of their host, and
+// fails the ones to hosts without status.
+type testTransport struct {
+	mu       sync.Mutex
+	statuses map[string]int
+	hosts    []string
+}
+
+func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hosts = append(t.hosts, req.URL.Host)
+	status, ok := t.statuses[req.URL.Host]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(`{"data":{}}`)),
+	}, nil
+}
+
+// counts returns the number of requests received by each host.
+func (t *testTransport) counts() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int)
+	for _, host := range t.hosts {
+		counts[host]++
+	}
+	t.hosts = nil
+	return counts
+}
+
+func testEndpoints(t *testing.T, rawurls ...string) []*Endpoint {
+	endpoints := make([]*Endpoint, 0, len(rawurls))
+	for _, rawurl := range rawurls {
+		e, err := ParseEndpoint(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
+
+// send sends n requests through the pool, closing their responses.
+func send(t *testing.T, p *Pool, transport http.RoundTripper, n int, header http.Header) {
+	for i := 0; i < n; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "http://graph/graphql", nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		res, err := p.RoundTrip(req, transport)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		rawurl string
+		url    string
+		weight int
+		err    bool
+	}{
+		{rawurl: "http://a:8080/graphql", url: "http://a:8080/graphql", weight: 1},
+		{rawurl: "http://a:8080/graphql#weight=3", url: "http://a:8080/graphql", weight: 3},
+		{rawurl: "http://a:8080/graphql#weight=0", err: true},
+		{rawurl: "http://a:8080/graphql#weight=heavy", err: true},
+	}
+	for _, test := range tests {
+		e, err := ParseEndpoint(test.rawurl)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.rawurl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.rawurl, err)
+			continue
+		}
+		if e.URL.String() != test.url || e.Weight != test.weight {
+			t.Errorf("%s: got %s with weight %d, expected %s with weight %d", test.rawurl, e.URL, e.Weight, test.url, test.weight)
+		}
+	}
+}
+
+func TestNewPool_invalid(t *testing.T) {
+	endpoints := testEndpoints(t, "http://a/graphql")
+	for name, cfg := range map[string]*Config{
+		"no endpoints":     {},
+		"unknown strategy": {Endpoints: endpoints, Strategy: "random"},
+		"no hash header":   {Endpoints: endpoints, Strategy: StrategyConsistentHash},
+	} {
+		if _, err := NewPool(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPool_strategies(t *testing.T) {
+	transport := &testTransport{statuses: map[string]int{"a": 200, "b": 200, "c": 200}}
+
+	t.Run("round-robin", func(t *testing.T) {
+		p, err := NewPool(&Config{Endpoints: testEndpoints(t, "http://a/graphql#weight=4", "http://b/graphql", "http://c/graphql")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		send(t, p, transport, 30, nil)
+		if counts := transport.counts(); counts["a"] != 10 || counts["b"] != 10 || counts["c"] != 10 {
+			t.Errorf("unexpected spread %v", counts)
+		}
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		p, err := NewPool(&Config{
+			Endpoints: testEndpoints(t, "http://a/graphql#weight=4", "http://b/graphql", "http://c/graphql"),
+			Strategy:  StrategyWeighted,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		send(t, p, transport, 30, nil)
+		if counts := transport.counts(); counts["a"] != 20 || counts["b"] != 5 || counts["c"] != 5 {
+			t.Errorf("unexpected spread %v", counts)
+		}
+	})
+
+	t.Run("least-in-flight", func(t *testing.T) {
+		p, err := NewPool(&Config{
+			Endpoints: testEndpoints(t, "http://a/graphql", "http://b/graphql"),
+			Strategy:  StrategyLeastInFlight,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a keeps a request in flight
+		var responses []*http.Response
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest(http.MethodPost, "http://graph/graphql", nil)
+			res, err := p.RoundTrip(req, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			responses = append(responses, res)
+		}
+		transport.counts()
+		responses[1].Body.Close()
+		responses[1].Body.Close()
+		send(t, p, transport, 4, nil)
+		if counts := transport.counts(); counts["b"] != 4 {
+			t.Errorf("unexpected spread %v", counts)
+		}
+		responses[0].Body.Close()
+		for _, status := range p.Status() {
+			if status.InFlight != 0 {
+				t.Errorf("unexpected requests in flight %+v", status)
+			}
+		}
+	})
+
+	t.Run("consistent-hash", func(t *testing.T) {
+		p, err := NewPool(&Config{
+			Endpoints:  testEndpoints(t, "http://a/graphql", "http://b/graphql", "http://c/graphql"),
+			Strategy:   StrategyConsistentHash,
+			HashHeader: "X-User-Id",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts := make(map[string]string)
+		for _, user := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+			send(t, p, transport, 5, http.Header{"X-User-Id": {user}})
+			counts := transport.counts()
+			if len(counts) != 1 {
+				t.Fatalf("user %s: requests spread over %v", user, counts)
+			}
+			for host := range counts {
+				hosts[user] = host
+			}
+		}
+
+		// only the users of an ejected endpoint move
+		p.endpoints[0].ejectedUntil = time.Now().Add(time.Minute)
+		for user, host := range hosts {
+			send(t, p, transport, 1, http.Header{"X-User-Id": {user}})
+			for moved := range transport.counts() {
+				if host == "a" && moved == "a" {
+					t.Errorf("user %s: sent to the ejected endpoint", user)
+				}
+				if host != "a" && moved != host {
+					t.Errorf("user %s: moved from %s to %s", user, host, moved)
+				}
+			}
+		}
+
+		// requests without the header are sent in turn
+		send(t, p, transport, 4, nil)
+		if counts := transport.counts(); counts["b"] != 2 || counts["c"] != 2 {
+			t.Errorf("unexpected spread %v", counts)
+		}
+	})
+}
+
+func TestPool_ejection(t *testing.T) {
+	transport := &testTransport{statuses: map[string]int{"a": 200, "b": 502}}
+	registry := prometheus.NewPedanticRegistry()
+	p, err := NewPool(&Config{
+		Endpoints:    testEndpoints(t, "http://a/graphql", "http://b/graphql", "http://c/graphql"),
+		MaxFailures:  2,
+		EjectionTime: time.Minute,
+		SlowStart:    time.Minute,
+		Namespace:    "porte",
+		Subsystem:    "proxy",
+		Registerer:   registry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	now := start
+	p.now = func() time.Time { return now }
+
+	// b responds with errors and c is unreachable
+	send(t, p, transport, 6, nil)
+	if counts := transport.counts(); counts["a"] != 2 || counts["b"] != 2 || counts["c"] != 2 {
+		t.Errorf("unexpected spread %v", counts)
+	}
+	send(t, p, transport, 6, nil)
+	if counts := transport.counts(); counts["a"] != 6 {
+		t.Errorf("unexpected spread after ejection %v", counts)
+	}
+	status := p.Status()
+	if status[1].Available || status[1].EjectedUntil == nil || !status[1].EjectedUntil.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected b to be ejected for a minute, got %+v", status[1])
+	}
+	for name, expected := range map[string]float64{"http://a/graphql": 0, "http://b/graphql": 1, "http://c/graphql": 1} {
+		if n := testutil.ToFloat64(p.ejections.WithLabelValues(name)); n != expected {
+			t.Errorf("expected %s to be ejected %v times, got %v", name, expected, n)
+		}
+	}
+	if families, err := registry.Gather(); err != nil || len(families) != 4 {
+		t.Errorf("expected 4 metric families, got %d (%v)", len(families), err)
+	}
+
+	// ejected endpoints slowly start once back
+	transport.statuses["b"] = 200
+	transport.statuses["c"] = 200
+	now = start.Add(90 * time.Second)
+	if factor := p.Status()[1].SlowStartFactor; factor != 0.5 {
+		t.Errorf("expected b to get half of its requests, got %v", factor)
+	}
+	send(t, p, transport, 40, nil)
+	if counts := transport.counts(); counts["a"] != 20 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Errorf("unexpected spread during slow start %v", counts)
+	}
+
+	// all the endpoints are tried when none is available
+	now = start.Add(5 * time.Minute)
+	transport.statuses["a"] = 500
+	transport.statuses["b"] = 500
+	delete(transport.statuses, "c")
+	send(t, p, transport, 10, nil)
+	transport.counts()
+	for _, status := range p.Status() {
+		if status.Available {
+			t.Errorf("expected %s to be ejected", status.URL)
+		}
+	}
+	send(t, p, transport, 30, nil)
+	if counts := transport.counts(); counts["a"] == 0 || counts["b"] == 0 || counts["c"] == 0 {
+		t.Errorf("unexpected spread without available endpoints %v", counts)
+	}
+}
+
+func TestPool_canceled_requests(t *testing.T) {
+	transport := &testTransport{statuses: map[string]int{}}
+	p, err := NewPool(&Config{
+		Endpoints:    testEndpoints(t, "http://a/graphql", "http://b/graphql"),
+		MaxFailures:  1,
+		EjectionTime: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest(http.MethodPost, "http://graph/graphql", nil)
+	p.RoundTrip(req.WithContext(ctx), transport)
+	for _, status := range p.Status() {
+		if !status.Available || status.InFlight != 0 {
+			t.Errorf("unexpected status %+v", status)
+		}
+	}
+}
+
+// slowTransport responds to the requests once their context is done.
+type slowTransport struct{}
+
+func (slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestPool_timed_out_requests(t *testing.T) {
+	// the endpoint never responds within the deadline of the request
+	transport := slowTransport{}
+	p, err := NewPool(&Config{
+		Endpoints:    testEndpoints(t, "http://a/graphql"),
+		MaxFailures:  1,
+		EjectionTime: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodPost, "http://graph/graphql", nil)
+	p.RoundTrip(req.WithContext(ctx), transport)
+	if status := p.Status()[0]; status.Available || status.InFlight != 0 {
+		t.Errorf("expected a to be ejected, got %+v", status)
+	}
+}
+
+// testService is a GraphQL service whose health can be toggled, counting the
+// requests other than the health checks.
+type testService struct {
+	*httptest.Server
+	healthy  int32
+	requests int32
+}
+
+func newTestService() *testService {
+	s := &testService{healthy: 1}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Query string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Query != DefaultHealthCheckQuery {
+			atomic.AddInt32(&s.requests, 1)
+		}
+		if atomic.LoadInt32(&s.healthy) == 0 {
+			w.Write([]byte(`{"data":null,"errors":[{"message":"database is down"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"__typename":"Query"}}`))
+	}))
+	return s
+}
+
+func TestPool_health_checks(t *testing.T) {
+	a, b := newTestService(), newTestService()
+	defer a.Close()
+	defer b.Close()
+	p, err := NewPool(&Config{
+		Endpoints:           testEndpoints(t, a.URL, b.URL),
+		HealthCheckInterval: 10 * time.Millisecond,
+		HealthCheckTimeout:  time.Second,
+		UnhealthyThreshold:  2,
+		HealthyThreshold:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	waitFor := func(healthy bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for p.Status()[1].Healthy != healthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("endpoint never became healthy=%v", healthy)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	atomic.StoreInt32(&b.healthy, 0)
+	waitFor(false)
+	g, err := graph.NewGraph(&graph.GraphConfig{ServiceURL: p.endpoints[0].URL, Balancer: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		res, err := g.Execute(context.Background(), &graph.Request{Query: "{ hero }"}, http.DefaultTransport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Errors) != 0 {
+			t.Fatalf("unexpected errors %v", res.Errors)
+		}
+	}
+	if n := atomic.LoadInt32(&b.requests); n != 0 {
+		t.Errorf("unhealthy endpoint received %d requests", n)
+	}
+	if n := atomic.LoadInt32(&a.requests); n != 4 {
+		t.Errorf("healthy endpoint received %d requests, expected 4", n)
+	}
+
+	atomic.StoreInt32(&b.healthy, 1)
+	waitFor(true)
+}
+
+func TestNewAdminHandler(t *testing.T) {
+	u, _ := url.Parse("http://user:secret@a/graphql")
+	p, err := NewPool(&Config{Endpoints: []*Endpoint{{URL: u, Weight: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	NewAdminHandler(map[string]*Pool{"users": p}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/upstreams", nil))
+	expected := `{"users":[{"url":"http://a/graphql","weight":2,"available":true,"healthy":true,"inFlight":0,"slowStartFactor":1}]}`
+	if body := strings.TrimSpace(rec.Body.String()); body != expected {
+		t.Errorf("got %s, expected %s", body, expected)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+}
@@ -0,0 +1,33 @@
+package validation
+
+import (
+	"net/http"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/proxy"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// NewProxyPlugin returns a new proxy plugin validating the graph requests
+// against the given schema. Invalid requests are rejected with the validation
+// errors, without being sent to the graph.
+func NewProxyPlugin(s schema.Schema) (*proxy.Plugin, error) {
+	v, err := NewValidator(s)
+	if err != nil {
+		return nil, err
+	}
+	return &proxy.Plugin{
+		ReadProxyRequest: func(next proxy.ReadProxyRequest) proxy.ReadProxyRequest {
+			return func(r *http.Request) (*graph.Request, error) {
+				graphReq, err := next(r)
+				if err != nil || graphReq == nil {
+					return graphReq, err
+				}
+				if errs := v.Validate(graphReq); len(errs) > 0 {
+					return nil, &graph.RequestError{Errors: errs}
+				}
+				return graphReq, nil
+			}
+		},
+	}, nil
+}
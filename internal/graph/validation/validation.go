@@ -0,0 +1,311 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/schema"
+)
+
+// Validator validates the query documents of graph requests against a schema
+// using the validation rules of the GraphQL specification.
+type Validator struct {
+	schema *graphql.Schema
+}
+
+// NewValidator returns a new Validator for the given schema.
+func NewValidator(s schema.Schema) (*Validator, error) {
+	gs, err := NewExecutableSchema(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build validation schema: %s", err)
+	}
+	return &Validator{schema: gs}, nil
+}
+
+// Validate parses the query document of the graph request and validates it.
+// It returns the errors to send back to the client, none when the document is
+// valid.
+func (v *Validator) Validate(req *graph.Request) []*graph.Error {
+	doc, err := req.ParseQuery()
+	if err != nil {
+		return []*graph.Error{newError(gqlerrors.FormatError(err))}
+	}
+	return v.ValidateDocument(doc)
+}
+
+// ValidateDocument validates the given query document.
+func (v *Validator) ValidateDocument(doc *ast.Document) []*graph.Error {
+	res := graphql.ValidateDocument(v.schema, doc, graphql.SpecifiedRules)
+	if res.IsValid {
+		return nil
+	}
+	errs := make([]*graph.Error, len(res.Errors))
+	for i, e := range res.Errors {
+		errs[i] = newError(e)
+	}
+	return errs
+}
+
+func newError(e gqlerrors.FormattedError) *graph.Error {
+	gqlErr := &graph.Error{Message: e.Message}
+	for _, l := range e.Locations {
+		gqlErr.Locations = append(gqlErr.Locations, &graph.Location{
+			Line:   int64(l.Line),
+			Column: int64(l.Column),
+		})
+	}
+	return gqlErr
+}
+
+// NewExecutableSchema builds the graphql-go schema matching the given
+// schema. The types have no resolvers: it is only meant to validate documents
+// and to execute introspection queries.
+func NewExecutableSchema(s schema.Schema) (*graphql.Schema, error) {
+	b := &schemaBuilder{
+		schema: s,
+		types:  map[string]graphql.Type{},
+	}
+	return b.build()
+}
+
+// Execute executes the document on the executable schema, returning the
+// result encoded the way the graph responses are. Its fields having no
+// resolvers, it only resolves the introspection fields: gateways use it to
+// execute them locally.
+func Execute(ctx context.Context, s *graphql.Schema, doc *ast.Document, variables map[string]interface{}) (*graph.Response, error) {
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:  *s,
+		AST:     doc,
+		Args:    variables,
+		Context: ctx,
+	})
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	res := new(graph.Response)
+	return res, json.Unmarshal(data, res)
+}
+
+var builtInScalars = map[string]*graphql.Scalar{
+	"Int":     graphql.Int,
+	"Float":   graphql.Float,
+	"String":  graphql.String,
+	"Boolean": graphql.Boolean,
+	"ID":      graphql.ID,
+}
+
+var builtInDirectives = map[string]*graphql.Directive{
+	"include":    graphql.IncludeDirective,
+	"skip":       graphql.SkipDirective,
+	"deprecated": graphql.DeprecatedDirective,
+}
+
+type schemaBuilder struct {
+	schema schema.Schema
+	types  map[string]graphql.Type
+}
+
+func (b *schemaBuilder) build() (*graphql.Schema, error) {
+	// named types are created in dependency order, fields are given as
+	// thunks so they can reference any other type
+	for _, kind := range []schema.TypeKind{
+		schema.TypeKindScalar,
+		schema.TypeKindEnum,
+		schema.TypeKindInputObject,
+		schema.TypeKindInterface,
+		schema.TypeKindObject,
+		schema.TypeKindUnion,
+	} {
+		for _, t := range b.schema.Types() {
+			if t.Kind() == kind {
+				b.types[t.Name()] = b.newType(t)
+			}
+		}
+	}
+
+	cfg := graphql.SchemaConfig{
+		Query:        b.rootType(b.schema.QueryType()),
+		Mutation:     b.rootType(b.schema.MutationType()),
+		Subscription: b.rootType(b.schema.SubscriptionType()),
+	}
+	for _, t := range b.types {
+		cfg.Types = append(cfg.Types, t)
+	}
+	for _, d := range b.schema.Directives() {
+		cfg.Directives = append(cfg.Directives, b.newDirective(d))
+	}
+	// the specified directives are always available
+	for name, d := range builtInDirectives {
+		if b.schema.Directive(name) == nil {
+			cfg.Directives = append(cfg.Directives, d)
+		}
+	}
+
+	gs, err := graphql.NewSchema(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &gs, nil
+}
+
+func (b *schemaBuilder) rootType(t schema.Type) *graphql.Object {
+	if t == nil {
+		return nil
+	}
+	obj, _ := b.types[t.Name()].(*graphql.Object)
+	return obj
+}
+
+func (b *schemaBuilder) newType(t schema.Type) graphql.Type {
+	switch t.Kind() {
+	case schema.TypeKindScalar:
+		if scalar, ok := builtInScalars[t.Name()]; ok {
+			return scalar
+		}
+		return graphql.NewScalar(graphql.ScalarConfig{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Serialize:   func(v interface{}) interface{} { return v },
+			ParseValue:  func(v interface{}) interface{} { return v },
+			// the proxy does not know how custom scalars are coerced,
+			// any literal is accepted
+			ParseLiteral: func(v ast.Value) interface{} { return v },
+		})
+	case schema.TypeKindEnum:
+		values := graphql.EnumValueConfigMap{}
+		for _, ev := range t.EnumValues() {
+			values[ev.Name()] = &graphql.EnumValueConfig{
+				Value:             ev.Name(),
+				Description:       ev.Description(),
+				DeprecationReason: ev.DeprecationReason(),
+			}
+		}
+		return graphql.NewEnum(graphql.EnumConfig{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Values:      values,
+		})
+	case schema.TypeKindInputObject:
+		return graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+				fields := graphql.InputObjectConfigFieldMap{}
+				for _, f := range t.InputFields() {
+					fields[f.Name()] = &graphql.InputObjectFieldConfig{
+						Type:        b.inputType(f),
+						Description: f.Description(),
+					}
+				}
+				return fields
+			}),
+		})
+	case schema.TypeKindInterface:
+		return graphql.NewInterface(graphql.InterfaceConfig{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Fields:      b.fieldsThunk(t),
+			ResolveType: func(graphql.ResolveTypeParams) *graphql.Object { return nil },
+		})
+	case schema.TypeKindObject:
+		return graphql.NewObject(graphql.ObjectConfig{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Fields:      b.fieldsThunk(t),
+			Interfaces: graphql.InterfacesThunk(func() []*graphql.Interface {
+				ifaces := make([]*graphql.Interface, 0, len(t.Interfaces()))
+				for _, i := range t.Interfaces() {
+					if iface, ok := b.types[i.Name()].(*graphql.Interface); ok {
+						ifaces = append(ifaces, iface)
+					}
+				}
+				return ifaces
+			}),
+		})
+	case schema.TypeKindUnion:
+		objs := make([]*graphql.Object, 0, len(t.PossibleTypes()))
+		for _, p := range t.PossibleTypes() {
+			if obj, ok := b.types[p.Name()].(*graphql.Object); ok {
+				objs = append(objs, obj)
+			}
+		}
+		return graphql.NewUnion(graphql.UnionConfig{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Types:       objs,
+			ResolveType: func(graphql.ResolveTypeParams) *graphql.Object { return nil },
+		})
+	}
+	return nil
+}
+
+func (b *schemaBuilder) fieldsThunk(t schema.Type) graphql.FieldsThunk {
+	return func() graphql.Fields {
+		fields := graphql.Fields{}
+		for _, f := range t.Fields() {
+			fields[f.Name()] = &graphql.Field{
+				Type:              b.typeRef(f.Type()),
+				Args:              b.args(f.Args()),
+				Description:       f.Description(),
+				DeprecationReason: f.DeprecationReason(),
+			}
+		}
+		return fields
+	}
+}
+
+func (b *schemaBuilder) args(args []schema.InputValue) graphql.FieldConfigArgument {
+	cfg := graphql.FieldConfigArgument{}
+	for _, arg := range args {
+		cfg[arg.Name()] = &graphql.ArgumentConfig{
+			Type:        b.inputType(arg),
+			Description: arg.Description(),
+		}
+	}
+	return cfg
+}
+
+// inputType returns the type of the input value. A NON_NULL input value with
+// a default value may be omitted, it is given the nullable type as graphql-go
+// would require it otherwise.
+func (b *schemaBuilder) inputType(v schema.InputValue) graphql.Input {
+	t := v.Type()
+	if t.Kind() == schema.TypeKindNonNull && v.DefaultValue() != "" {
+		t = t.OfType()
+	}
+	return b.typeRef(t)
+}
+
+func (b *schemaBuilder) typeRef(t schema.Type) graphql.Type {
+	switch t.Kind() {
+	case schema.TypeKindNonNull:
+		return graphql.NewNonNull(b.typeRef(t.OfType()))
+	case schema.TypeKindList:
+		return graphql.NewList(b.typeRef(t.OfType()))
+	default:
+		return b.types[t.Name()]
+	}
+}
+
+func (b *schemaBuilder) newDirective(d schema.Directive) *graphql.Directive {
+	if dir, ok := builtInDirectives[d.Name()]; ok {
+		return dir
+	}
+	locs := make([]string, len(d.Locations()))
+	for i, l := range d.Locations() {
+		locs[i] = string(l)
+	}
+	return graphql.NewDirective(graphql.DirectiveConfig{
+		Name:        d.Name(),
+		Description: d.Description(),
+		Locations:   locs,
+		Args:        b.args(d.Args()),
+	})
+}
@@ -0,0 +1,201 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/herzult/porte/internal/graph"
+	"github.com/herzult/porte/internal/graph/proxy"
+	"github.com/herzult/porte/internal/schema"
+)
+
+const testSDL = `
+directive @cached(ttl: Int) on FIELD
+
+type Query {
+  hero(episode: Episode = JEDI): Character
+  human(id: ID!): Human
+  search(text: String!, first: Int! = 10): [SearchResult!]!
+}
+
+interface Character {
+  id: ID!
+  name: String!
+}
+
+type Human implements Character {
+  id: ID!
+  name: String!
+  height(unit: Unit = METER): Float
+}
+
+type Droid implements Character {
+  id: ID!
+  name: String!
+}
+
+union SearchResult = Human | Droid
+
+enum Episode {
+  NEWHOPE
+  JEDI
+}
+
+enum Unit {
+  METER
+  FOOT
+}
+
+scalar DateTime
+`
+
+func newTestValidator(t *testing.T) *Validator {
+	cfg, err := schema.ParseSDL(testSDL)
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	s, err := schema.NewSchema(cfg)
+	if err != nil {
+		t.Fatalf("NewSchema() returned error: %s", err)
+	}
+	v, err := NewValidator(s)
+	if err != nil {
+		t.Fatalf("NewValidator() returned error: %s", err)
+	}
+	return v
+}
+
+func TestValidator_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		msgs  []string
+	}{
+		{
+			name:  "valid query",
+			query: `query ($id: ID!) { human(id: $id) { name height(unit: FOOT) @cached(ttl: 60) } search(text: "r2") { ... on Droid { name } } }`,
+		},
+		{
+			name:  "syntax error",
+			query: `{ hero {`,
+			msgs:  []string{"Syntax Error GraphQL request (1:9) Expected Name, found EOF\n\n1: { hero {\n           ^\n"},
+		},
+		{
+			name:  "unknown field",
+			query: `{ hero { height } }`,
+			msgs:  []string{`Cannot query field "height" on type "Character". Did you mean to use an inline fragment on "Human"?`},
+		},
+		{
+			name:  "argument of the wrong type",
+			query: `{ human(id: 1) { name } hero(episode: EMPIRE) { name } }`,
+			msgs:  []string{`Argument "episode" has invalid value EMPIRE.` + "\n" + `Expected type "Episode", found EMPIRE.`},
+		},
+		{
+			name:  "missing required argument",
+			query: `{ human { name } }`,
+			msgs:  []string{`Field "human" argument "id" of type "ID!" is required but not provided.`},
+		},
+		{
+			name:  "undefined and unused variables",
+			query: `query ($text: String!) { human(id: $id) { name } }`,
+			msgs: []string{
+				`Variable "$id" is not defined.`,
+				`Variable "$text" is never used.`,
+			},
+		},
+		{
+			name:  "fragment on a type it can never apply to",
+			query: `{ human(id: "1") { ... on Droid { name } } }`,
+			msgs:  []string{`Fragment cannot be spread here as objects of type "Human" can never be of type "Droid".`},
+		},
+		{
+			name:  "unknown directive",
+			query: `{ hero @plop { name } }`,
+			msgs:  []string{`Unknown directive "plop".`},
+		},
+	}
+	v := newTestValidator(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := v.Validate(&graph.Request{Query: tt.query})
+			msgs := make([]string, len(errs))
+			for i, e := range errs {
+				msgs[i] = e.Message
+			}
+			if len(msgs) == 0 {
+				msgs = nil
+			}
+			if !reflect.DeepEqual(msgs, tt.msgs) {
+				t.Errorf("Validate() errors = %q, expected %q", msgs, tt.msgs)
+			}
+		})
+	}
+}
+
+func TestValidator_Validate_locations(t *testing.T) {
+	v := newTestValidator(t)
+	errs := v.Validate(&graph.Request{Query: "{\n  hero {\n    height\n  }\n}"})
+	if len(errs) != 1 {
+		t.Fatalf("Validate() returned %d errors, expected 1", len(errs))
+	}
+	expected := []*graph.Location{&graph.Location{Line: 3, Column: 5}}
+	if !reflect.DeepEqual(errs[0].Locations, expected) {
+		t.Errorf("Validate() error locations = %+v, expected %+v", errs[0].Locations[0], expected[0])
+	}
+}
+
+type testGraph struct {
+	calls int
+}
+
+func (g *testGraph) ID() string { return "test" }
+
+func (g *testGraph) Execute(context.Context, *graph.Request, http.RoundTripper) (*graph.Response, error) {
+	g.calls++
+	return &graph.Response{Data: map[string]interface{}{}}, nil
+}
+
+func TestNewProxyPlugin(t *testing.T) {
+	cfg, _ := schema.ParseSDL(testSDL)
+	s, _ := schema.NewSchema(cfg)
+	plug, err := NewProxyPlugin(s)
+	if err != nil {
+		t.Fatalf("NewProxyPlugin() returned error: %s", err)
+	}
+	g := &testGraph{}
+	p, _ := proxy.New(&proxy.Config{Graph: g, Plugins: []*proxy.Plugin{plug}})
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ plop }"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+
+	if g.calls != 0 {
+		t.Errorf("graph was called %d times, expected no call", g.calls)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("response status is %d, expected %d", w.Code, http.StatusOK)
+	}
+	res := new(graph.Response)
+	if err := json.NewDecoder(w.Body).Decode(res); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Message != `Cannot query field "plop" on type "Query".` {
+		t.Fatalf("response errors are %+v", res.Errors)
+	}
+	if len(res.Errors[0].Locations) != 1 || *res.Errors[0].Locations[0] != (graph.Location{Line: 1, Column: 3}) {
+		t.Errorf("response error locations are %+v", res.Errors[0].Locations)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hero { name } }"}`))
+	r.Header.Set("Content-Type", "application/json")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if g.calls != 1 {
+		t.Errorf("graph was called %d times for a valid request, expected 1", g.calls)
+	}
+}
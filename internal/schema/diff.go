@@ -0,0 +1,442 @@
+package schema
+
+import (
+	"fmt"
+)
+
+// Criticality tells how a schema change affects the clients of the schema.
+type Criticality string
+
+const (
+	// CriticalityBreaking changes break existing operations.
+	CriticalityBreaking Criticality = "BREAKING"
+	// CriticalityDangerous changes do not break existing operations but may
+	// change their results or announce a future breaking change.
+	CriticalityDangerous Criticality = "DANGEROUS"
+	// CriticalitySafe changes have no effect on existing operations.
+	CriticalitySafe Criticality = "SAFE"
+)
+
+// ChangeType identifies the kind of a schema change.
+type ChangeType string
+
+const (
+	ChangeTypeRemoved                   ChangeType = "TYPE_REMOVED"
+	ChangeTypeAdded                     ChangeType = "TYPE_ADDED"
+	ChangeTypeKindChanged               ChangeType = "TYPE_KIND_CHANGED"
+	ChangeRootTypeChanged               ChangeType = "ROOT_TYPE_CHANGED"
+	ChangeFieldRemoved                  ChangeType = "FIELD_REMOVED"
+	ChangeFieldAdded                    ChangeType = "FIELD_ADDED"
+	ChangeFieldTypeChanged              ChangeType = "FIELD_TYPE_CHANGED"
+	ChangeFieldDeprecated               ChangeType = "FIELD_DEPRECATED"
+	ChangeFieldDeprecationRemoved       ChangeType = "FIELD_DEPRECATION_REMOVED"
+	ChangeArgRemoved                    ChangeType = "ARG_REMOVED"
+	ChangeArgAdded                      ChangeType = "ARG_ADDED"
+	ChangeArgTypeChanged                ChangeType = "ARG_TYPE_CHANGED"
+	ChangeArgDefaultValueChanged        ChangeType = "ARG_DEFAULT_VALUE_CHANGED"
+	ChangeInputFieldRemoved             ChangeType = "INPUT_FIELD_REMOVED"
+	ChangeInputFieldAdded               ChangeType = "INPUT_FIELD_ADDED"
+	ChangeInputFieldTypeChanged         ChangeType = "INPUT_FIELD_TYPE_CHANGED"
+	ChangeInputFieldDefaultValueChanged ChangeType = "INPUT_FIELD_DEFAULT_VALUE_CHANGED"
+	ChangeInterfaceRemoved              ChangeType = "IMPLEMENTED_INTERFACE_REMOVED"
+	ChangeInterfaceAdded                ChangeType = "IMPLEMENTED_INTERFACE_ADDED"
+	ChangeUnionMemberRemoved            ChangeType = "UNION_MEMBER_REMOVED"
+	ChangeUnionMemberAdded              ChangeType = "UNION_MEMBER_ADDED"
+	ChangeEnumValueRemoved              ChangeType = "ENUM_VALUE_REMOVED"
+	ChangeEnumValueAdded                ChangeType = "ENUM_VALUE_ADDED"
+	ChangeEnumValueDeprecated           ChangeType = "ENUM_VALUE_DEPRECATED"
+	ChangeDirectiveRemoved              ChangeType = "DIRECTIVE_REMOVED"
+	ChangeDirectiveAdded                ChangeType = "DIRECTIVE_ADDED"
+	ChangeDirectiveLocationRemoved      ChangeType = "DIRECTIVE_LOCATION_REMOVED"
+	ChangeDirectiveLocationAdded        ChangeType = "DIRECTIVE_LOCATION_ADDED"
+)
+
+// Change is a difference between two schemas.
+type Change struct {
+	Type        ChangeType  `json:"type"`
+	Criticality Criticality `json:"criticality"`
+	// Path is the coordinate of the changed schema element, e.g. "Query",
+	// "Query.hero", "Query.hero.episode", "Episode.JEDI" or "@include.if".
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Diff returns the changes from the old schema to the new one.
+func Diff(old, new Schema) []*Change {
+	d := &differ{}
+	d.diffRootType("query", old.QueryType(), new.QueryType())
+	d.diffRootType("mutation", old.MutationType(), new.MutationType())
+	d.diffRootType("subscription", old.SubscriptionType(), new.SubscriptionType())
+
+	for _, ot := range old.Types() {
+		nt := new.Type(ot.Name())
+		if nt == nil {
+			d.add(ChangeTypeRemoved, CriticalityBreaking, ot.Name(),
+				"Type \"%s\" was removed.", ot.Name())
+			continue
+		}
+		d.diffType(ot, nt)
+	}
+	for _, nt := range new.Types() {
+		if old.Type(nt.Name()) == nil {
+			d.add(ChangeTypeAdded, CriticalitySafe, nt.Name(),
+				"Type \"%s\" was added.", nt.Name())
+		}
+	}
+
+	for _, od := range old.Directives() {
+		nd := new.Directive(od.Name())
+		if nd == nil {
+			d.add(ChangeDirectiveRemoved, CriticalityBreaking, "@"+od.Name(),
+				"Directive \"%s\" was removed.", od.Name())
+			continue
+		}
+		d.diffDirective(od, nd)
+	}
+	for _, nd := range new.Directives() {
+		if old.Directive(nd.Name()) == nil {
+			d.add(ChangeDirectiveAdded, CriticalitySafe, "@"+nd.Name(),
+				"Directive \"%s\" was added.", nd.Name())
+		}
+	}
+
+	return d.changes
+}
+
+// HasBreakingChanges tells whether some of the changes are breaking.
+func HasBreakingChanges(changes []*Change) bool {
+	for _, c := range changes {
+		if c.Criticality == CriticalityBreaking {
+			return true
+		}
+	}
+	return false
+}
+
+type differ struct {
+	changes []*Change
+}
+
+func (d *differ) add(typ ChangeType, crit Criticality, path string, format string, args ...interface{}) {
+	d.changes = append(d.changes, &Change{
+		Type:        typ,
+		Criticality: crit,
+		Path:        path,
+		Message:     fmt.Sprintf(format, args...),
+	})
+}
+
+func (d *differ) diffRootType(op string, old, new Type) {
+	oldName, newName := "", ""
+	if old != nil {
+		oldName = old.Name()
+	}
+	if new != nil {
+		newName = new.Name()
+	}
+	switch {
+	case oldName == newName:
+	case oldName == "":
+		d.add(ChangeRootTypeChanged, CriticalitySafe, newName,
+			"Schema %s root type \"%s\" was added.", op, newName)
+	case newName == "":
+		d.add(ChangeRootTypeChanged, CriticalityBreaking, oldName,
+			"Schema %s root type \"%s\" was removed.", op, oldName)
+	default:
+		d.add(ChangeRootTypeChanged, CriticalityBreaking, oldName,
+			"Schema %s root type changed from \"%s\" to \"%s\".", op, oldName, newName)
+	}
+}
+
+func (d *differ) diffType(old, new Type) {
+	if old.Kind() != new.Kind() {
+		d.add(ChangeTypeKindChanged, CriticalityBreaking, old.Name(),
+			"Type \"%s\" changed from %s to %s.", old.Name(), old.Kind(), new.Kind())
+		return
+	}
+
+	switch old.Kind() {
+	case TypeKindObject, TypeKindInterface:
+		d.diffFields(old, new)
+		if old.Kind() == TypeKindObject {
+			d.diffInterfaces(old, new)
+		}
+	case TypeKindUnion:
+		d.diffUnion(old, new)
+	case TypeKindEnum:
+		d.diffEnum(old, new)
+	case TypeKindInputObject:
+		d.diffInputFields(old, new)
+	}
+}
+
+func (d *differ) diffFields(old, new Type) {
+	for _, of := range old.Fields() {
+		path := old.Name() + "." + of.Name()
+		nf := new.Field(of.Name())
+		if nf == nil {
+			d.add(ChangeFieldRemoved, CriticalityBreaking, path,
+				"Field \"%s\" was removed from %s type \"%s\".", of.Name(), old.Kind(), old.Name())
+			continue
+		}
+		if !isSafeOutputTypeChange(of.Type(), nf.Type()) {
+			d.add(ChangeFieldTypeChanged, CriticalityBreaking, path,
+				"Field \"%s\" changed type from \"%s\" to \"%s\".", path, printTypeRef(of.Type()), printTypeRef(nf.Type()))
+		} else if printTypeRef(of.Type()) != printTypeRef(nf.Type()) {
+			d.add(ChangeFieldTypeChanged, CriticalitySafe, path,
+				"Field \"%s\" changed type from \"%s\" to \"%s\".", path, printTypeRef(of.Type()), printTypeRef(nf.Type()))
+		}
+		switch {
+		case !of.IsDeprecated() && nf.IsDeprecated():
+			// a deprecation announces the removal of the field
+			d.add(ChangeFieldDeprecated, CriticalityDangerous, path,
+				"Field \"%s\" was deprecated: %s", path, nf.DeprecationReason())
+		case of.IsDeprecated() && !nf.IsDeprecated():
+			d.add(ChangeFieldDeprecationRemoved, CriticalitySafe, path,
+				"Field \"%s\" is no longer deprecated.", path)
+		}
+		d.diffArgs(path, fmt.Sprintf("field \"%s\"", path), of.Args(), nf.Args(), nf.Arg)
+	}
+	for _, nf := range new.Fields() {
+		if old.Field(nf.Name()) == nil {
+			d.add(ChangeFieldAdded, CriticalitySafe, new.Name()+"."+nf.Name(),
+				"Field \"%s\" was added to %s type \"%s\".", nf.Name(), new.Kind(), new.Name())
+		}
+	}
+}
+
+func (d *differ) diffArgs(path, owner string, old, new []InputValue, newArg func(string) InputValue) {
+	oldArgs := map[string]bool{}
+	for _, oa := range old {
+		oldArgs[oa.Name()] = true
+		argPath := path + "." + oa.Name()
+		na := newArg(oa.Name())
+		if na == nil {
+			d.add(ChangeArgRemoved, CriticalityBreaking, argPath,
+				"Argument \"%s\" was removed from %s.", oa.Name(), owner)
+			continue
+		}
+		if !isSafeInputTypeChange(oa.Type(), na.Type()) {
+			d.add(ChangeArgTypeChanged, CriticalityBreaking, argPath,
+				"Argument \"%s\" of %s changed type from \"%s\" to \"%s\".", oa.Name(), owner, printTypeRef(oa.Type()), printTypeRef(na.Type()))
+		} else if printTypeRef(oa.Type()) != printTypeRef(na.Type()) {
+			d.add(ChangeArgTypeChanged, CriticalitySafe, argPath,
+				"Argument \"%s\" of %s changed type from \"%s\" to \"%s\".", oa.Name(), owner, printTypeRef(oa.Type()), printTypeRef(na.Type()))
+		}
+		switch {
+		case oa.DefaultValue() != "" && isRequiredInputValue(na):
+			// the operations relying on the default value now fail
+			d.add(ChangeArgDefaultValueChanged, CriticalityBreaking, argPath,
+				"Argument \"%s\" of %s is now required, its default value \"%s\" was removed.", oa.Name(), owner, oa.DefaultValue())
+		case oa.DefaultValue() != na.DefaultValue():
+			d.add(ChangeArgDefaultValueChanged, CriticalityDangerous, argPath,
+				"Argument \"%s\" of %s changed default value from \"%s\" to \"%s\".", oa.Name(), owner, oa.DefaultValue(), na.DefaultValue())
+		}
+	}
+	for _, na := range new {
+		if oldArgs[na.Name()] {
+			continue
+		}
+		if isRequiredInputValue(na) {
+			d.add(ChangeArgAdded, CriticalityBreaking, path+"."+na.Name(),
+				"Required argument \"%s\" was added to %s.", na.Name(), owner)
+		} else {
+			d.add(ChangeArgAdded, CriticalitySafe, path+"."+na.Name(),
+				"Optional argument \"%s\" was added to %s.", na.Name(), owner)
+		}
+	}
+}
+
+func (d *differ) diffInterfaces(old, new Type) {
+	has := func(t Type, name string) bool {
+		for _, i := range t.Interfaces() {
+			if i.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, oi := range old.Interfaces() {
+		if !has(new, oi.Name()) {
+			d.add(ChangeInterfaceRemoved, CriticalityBreaking, old.Name(),
+				"Type \"%s\" no longer implements interface \"%s\".", old.Name(), oi.Name())
+		}
+	}
+	for _, ni := range new.Interfaces() {
+		if !has(old, ni.Name()) {
+			// existing operations on the interface may get objects of a type
+			// they do not expect
+			d.add(ChangeInterfaceAdded, CriticalityDangerous, new.Name(),
+				"Type \"%s\" now implements interface \"%s\".", new.Name(), ni.Name())
+		}
+	}
+}
+
+func (d *differ) diffUnion(old, new Type) {
+	has := func(t Type, name string) bool {
+		for _, p := range t.PossibleTypes() {
+			if p.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, op := range old.PossibleTypes() {
+		if !has(new, op.Name()) {
+			d.add(ChangeUnionMemberRemoved, CriticalityBreaking, old.Name(),
+				"Member \"%s\" was removed from union type \"%s\".", op.Name(), old.Name())
+		}
+	}
+	for _, np := range new.PossibleTypes() {
+		if !has(old, np.Name()) {
+			d.add(ChangeUnionMemberAdded, CriticalityDangerous, new.Name(),
+				"Member \"%s\" was added to union type \"%s\".", np.Name(), new.Name())
+		}
+	}
+}
+
+func (d *differ) diffEnum(old, new Type) {
+	values := func(t Type) map[string]EnumValue {
+		m := map[string]EnumValue{}
+		for _, ev := range t.EnumValues() {
+			m[ev.Name()] = ev
+		}
+		return m
+	}
+	oldValues, newValues := values(old), values(new)
+	for _, ov := range old.EnumValues() {
+		path := old.Name() + "." + ov.Name()
+		nv, ok := newValues[ov.Name()]
+		if !ok {
+			d.add(ChangeEnumValueRemoved, CriticalityBreaking, path,
+				"Value \"%s\" was removed from enum type \"%s\".", ov.Name(), old.Name())
+			continue
+		}
+		if !ov.IsDeprecated() && nv.IsDeprecated() {
+			d.add(ChangeEnumValueDeprecated, CriticalityDangerous, path,
+				"Value \"%s\" of enum type \"%s\" was deprecated: %s", ov.Name(), old.Name(), nv.DeprecationReason())
+		}
+	}
+	for _, nv := range new.EnumValues() {
+		if _, ok := oldValues[nv.Name()]; !ok {
+			// clients may not handle the new value in results
+			d.add(ChangeEnumValueAdded, CriticalityDangerous, new.Name()+"."+nv.Name(),
+				"Value \"%s\" was added to enum type \"%s\".", nv.Name(), new.Name())
+		}
+	}
+}
+
+func (d *differ) diffInputFields(old, new Type) {
+	for _, of := range old.InputFields() {
+		path := old.Name() + "." + of.Name()
+		nf := new.InputField(of.Name())
+		if nf == nil {
+			d.add(ChangeInputFieldRemoved, CriticalityBreaking, path,
+				"Input field \"%s\" was removed from input object type \"%s\".", of.Name(), old.Name())
+			continue
+		}
+		if !isSafeInputTypeChange(of.Type(), nf.Type()) {
+			d.add(ChangeInputFieldTypeChanged, CriticalityBreaking, path,
+				"Input field \"%s\" changed type from \"%s\" to \"%s\".", path, printTypeRef(of.Type()), printTypeRef(nf.Type()))
+		} else if printTypeRef(of.Type()) != printTypeRef(nf.Type()) {
+			d.add(ChangeInputFieldTypeChanged, CriticalitySafe, path,
+				"Input field \"%s\" changed type from \"%s\" to \"%s\".", path, printTypeRef(of.Type()), printTypeRef(nf.Type()))
+		}
+		switch {
+		case of.DefaultValue() != "" && isRequiredInputValue(nf):
+			d.add(ChangeInputFieldDefaultValueChanged, CriticalityBreaking, path,
+				"Input field \"%s\" is now required, its default value \"%s\" was removed.", path, of.DefaultValue())
+		case of.DefaultValue() != nf.DefaultValue():
+			d.add(ChangeInputFieldDefaultValueChanged, CriticalityDangerous, path,
+				"Input field \"%s\" changed default value from \"%s\" to \"%s\".", path, of.DefaultValue(), nf.DefaultValue())
+		}
+	}
+	for _, nf := range new.InputFields() {
+		if old.InputField(nf.Name()) != nil {
+			continue
+		}
+		path := new.Name() + "." + nf.Name()
+		if isRequiredInputValue(nf) {
+			d.add(ChangeInputFieldAdded, CriticalityBreaking, path,
+				"Required input field \"%s\" was added to input object type \"%s\".", nf.Name(), new.Name())
+		} else {
+			d.add(ChangeInputFieldAdded, CriticalitySafe, path,
+				"Optional input field \"%s\" was added to input object type \"%s\".", nf.Name(), new.Name())
+		}
+	}
+}
+
+func (d *differ) diffDirective(old, new Directive) {
+	path := "@" + old.Name()
+	newLocs := map[DirectiveLocation]bool{}
+	for _, l := range new.Locations() {
+		newLocs[l] = true
+	}
+	oldLocs := map[DirectiveLocation]bool{}
+	for _, l := range old.Locations() {
+		oldLocs[l] = true
+		if !newLocs[l] {
+			d.add(ChangeDirectiveLocationRemoved, CriticalityBreaking, path,
+				"Location %s was removed from directive \"%s\".", l, old.Name())
+		}
+	}
+	for _, l := range new.Locations() {
+		if !oldLocs[l] {
+			d.add(ChangeDirectiveLocationAdded, CriticalitySafe, path,
+				"Location %s was added to directive \"%s\".", l, new.Name())
+		}
+	}
+
+	newArg := func(name string) InputValue {
+		for _, arg := range new.Args() {
+			if arg.Name() == name {
+				return arg
+			}
+		}
+		return nil
+	}
+	d.diffArgs(path, fmt.Sprintf("directive \"%s\"", old.Name()), old.Args(), new.Args(), newArg)
+}
+
+// isRequiredInputValue tells whether a value must be provided for the input
+// value.
+func isRequiredInputValue(v InputValue) bool {
+	return v.Type().Kind() == TypeKindNonNull && v.DefaultValue() == ""
+}
+
+// isSafeOutputTypeChange tells whether the type of a field can change from
+// old to new without breaking existing operations: the new type must be the
+// same or stricter.
+func isSafeOutputTypeChange(old, new Type) bool {
+	switch old.Kind() {
+	case TypeKindList:
+		if new.Kind() == TypeKindNonNull {
+			return isSafeOutputTypeChange(old, new.OfType())
+		}
+		return new.Kind() == TypeKindList && isSafeOutputTypeChange(old.OfType(), new.OfType())
+	case TypeKindNonNull:
+		return new.Kind() == TypeKindNonNull && isSafeOutputTypeChange(old.OfType(), new.OfType())
+	default:
+		if new.Kind() == TypeKindNonNull {
+			return isSafeOutputTypeChange(old, new.OfType())
+		}
+		return new.Kind() != TypeKindList && old.Name() == new.Name()
+	}
+}
+
+// isSafeInputTypeChange tells whether the type of an argument or input field
+// can change from old to new without breaking existing operations: the new
+// type must be the same or looser.
+func isSafeInputTypeChange(old, new Type) bool {
+	switch old.Kind() {
+	case TypeKindList:
+		return new.Kind() == TypeKindList && isSafeInputTypeChange(old.OfType(), new.OfType())
+	case TypeKindNonNull:
+		if new.Kind() == TypeKindNonNull {
+			return isSafeInputTypeChange(old.OfType(), new.OfType())
+		}
+		return isSafeInputTypeChange(old.OfType(), new)
+	default:
+		return new.Kind() != TypeKindList && new.Kind() != TypeKindNonNull && old.Name() == new.Name()
+	}
+}
@@ -0,0 +1,207 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	oldSDL := `
+directive @cached(ttl: Int) on FIELD | FIELD_DEFINITION
+
+type Query {
+  hero(episode: Episode): Character
+  human(id: ID!): Human
+  search(text: String!, first: Int = 10): [SearchResult]
+  droids: [Droid]
+  droid(id: ID! = "R2-D2"): Droid
+  starship(id: Int): String
+}
+
+type Mutation {
+  createReview(review: ReviewInput): String
+}
+
+interface Character {
+  id: ID!
+  name: String
+}
+
+interface Node {
+  id: ID!
+}
+
+type Human implements Character & Node {
+  id: ID!
+  name: String
+  height: Float
+}
+
+type Droid implements Character {
+  id: ID!
+  name: String
+  primaryFunction: String
+}
+
+union SearchResult = Human | Droid
+
+enum Episode {
+  NEWHOPE
+  EMPIRE
+  JEDI
+}
+
+input ReviewInput {
+  stars: Int! = 5
+  commentary: String = "great"
+  rating: Int
+}
+
+scalar DateTime
+`
+	newSDL := `
+directive @cached(ttl: Int) on FIELD
+
+type Query {
+  hero(episode: Episode, first: Int!): Character
+  human(id: ID): Human
+  search(text: String!, first: Int = 20): [SearchResult]
+  droids: [Droid!]! @deprecated
+  droid(id: ID!): Droid
+  review(review: ReviewInput): String
+  starship(id: Int!): String
+}
+
+interface Character {
+  id: ID!
+  name: String
+}
+
+type Human implements Character {
+  id: ID!
+  name: String!
+  height: Int
+}
+
+type Droid implements Character {
+  id: ID!
+  name: String
+}
+
+union SearchResult = Human
+
+enum Episode {
+  NEWHOPE
+  JEDI
+  CLONES
+}
+
+input ReviewInput {
+  stars: Int!
+  commentary: String = "good"
+  author: String!
+  rating: Int!
+}
+
+type DateTime {
+  value: String
+}
+`
+	oldCfg, err := ParseSDL(oldSDL)
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	newCfg, err := ParseSDL(newSDL)
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	oldSchema, err := NewSchema(oldCfg)
+	if err != nil {
+		t.Fatalf("NewSchema() returned error: %s", err)
+	}
+	newSchema, err := NewSchema(newCfg)
+	if err != nil {
+		t.Fatalf("NewSchema() returned error: %s", err)
+	}
+
+	changes := Diff(oldSchema, newSchema)
+
+	actual := make([]string, len(changes))
+	for i, c := range changes {
+		actual[i] = string(c.Criticality) + " " + string(c.Type) + " " + c.Path
+	}
+	checkSameElements(t, actual, []string{
+		"BREAKING ARG_ADDED Query.hero.first",
+		"SAFE ARG_TYPE_CHANGED Query.human.id",
+		"DANGEROUS ARG_DEFAULT_VALUE_CHANGED Query.search.first",
+		"SAFE FIELD_TYPE_CHANGED Query.droids",
+		"BREAKING ARG_DEFAULT_VALUE_CHANGED Query.droid.id",
+		"DANGEROUS FIELD_DEPRECATED Query.droids",
+		"SAFE FIELD_ADDED Query.review",
+		"BREAKING TYPE_REMOVED Node",
+		"SAFE FIELD_TYPE_CHANGED Human.name",
+		"BREAKING FIELD_TYPE_CHANGED Human.height",
+		"BREAKING IMPLEMENTED_INTERFACE_REMOVED Human",
+		"BREAKING FIELD_REMOVED Droid.primaryFunction",
+		"BREAKING UNION_MEMBER_REMOVED SearchResult",
+		"BREAKING ENUM_VALUE_REMOVED Episode.EMPIRE",
+		"DANGEROUS ENUM_VALUE_ADDED Episode.CLONES",
+		"BREAKING INPUT_FIELD_ADDED ReviewInput.author",
+		"BREAKING INPUT_FIELD_DEFAULT_VALUE_CHANGED ReviewInput.stars",
+		"DANGEROUS INPUT_FIELD_DEFAULT_VALUE_CHANGED ReviewInput.commentary",
+		"BREAKING INPUT_FIELD_TYPE_CHANGED ReviewInput.rating",
+		"BREAKING ARG_TYPE_CHANGED Query.starship.id",
+		"BREAKING ROOT_TYPE_CHANGED Mutation",
+		"BREAKING TYPE_REMOVED Mutation",
+		"BREAKING TYPE_KIND_CHANGED DateTime",
+		"BREAKING DIRECTIVE_LOCATION_REMOVED @cached",
+	})
+	for _, c := range changes {
+		if c.Type == ChangeRootTypeChanged && c.Message != `Schema mutation root type "Mutation" was removed.` {
+			t.Errorf("root type removal reported as %q", c.Message)
+		}
+	}
+	if !HasBreakingChanges(changes) {
+		t.Error("HasBreakingChanges() = false, expected true")
+	}
+	if changes := Diff(oldSchema, oldSchema); len(changes) != 0 {
+		t.Errorf("Diff() of a schema with itself returned %d changes", len(changes))
+	}
+}
+
+func TestIsSafeTypeChange(t *testing.T) {
+	ref := func(sdl string) Type {
+		cfg, err := ParseSDL("type Query { a(b: " + sdl + "): " + sdl + " }")
+		if err != nil {
+			t.Fatalf("ParseSDL() returned error: %s", err)
+		}
+		s, err := NewSchema(cfg)
+		if err != nil {
+			t.Fatalf("NewSchema() returned error: %s", err)
+		}
+		return s.QueryType().Field("a").Type()
+	}
+	tests := []struct {
+		old, new   string
+		safeOutput bool
+		safeInputs bool
+	}{
+		{old: "Int", new: "Int", safeOutput: true, safeInputs: true},
+		{old: "Int", new: "Int!", safeOutput: true, safeInputs: false},
+		{old: "Int!", new: "Int", safeOutput: false, safeInputs: true},
+		{old: "[Int]", new: "[Int!]!", safeOutput: true, safeInputs: false},
+		{old: "[Int!]!", new: "[Int]", safeOutput: false, safeInputs: true},
+		{old: "Int", new: "[Int]", safeOutput: false, safeInputs: false},
+		{old: "Int", new: "Float", safeOutput: false, safeInputs: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.old+" to "+tt.new, func(t *testing.T) {
+			old, new := ref(tt.old), ref(tt.new)
+			if got := isSafeOutputTypeChange(old, new); got != tt.safeOutput {
+				t.Errorf("isSafeOutputTypeChange() = %v, expected %v", got, tt.safeOutput)
+			}
+			if got := isSafeInputTypeChange(old, new); got != tt.safeInputs {
+				t.Errorf("isSafeInputTypeChange() = %v, expected %v", got, tt.safeInputs)
+			}
+		})
+	}
+}
@@ -24,6 +24,7 @@ const (
 	DirectiveLocationFragmentDefinitionn                    = "FRAGMENT_DEFINITION"
 	DirectiveLocationFragmentSpread                         = "FRAGMENT_SPREAD"
 	DirectiveLocationInlineFragment                         = "INLINE_FRAGMENT"
+	DirectiveLocationVariableDefinition                     = "VARIABLE_DEFINITION"
 	DirectiveLocationSchema                                 = "SCHEMA"
 	DirectiveLocationScalar                                 = "SCALAR"
 	DirectiveLocationObject                                 = "OBJECT"
@@ -72,6 +73,7 @@ func newDirective(schema *schema, cfg *DirectiveConfig) (*directive, error) {
 				arg.name,
 			)
 		}
+		idx[arg.name] = true
 		directive.args = append(directive.args, arg)
 	}
 	return directive, nil
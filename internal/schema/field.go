@@ -18,12 +18,12 @@ type Field interface {
 var _ Field = (*field)(nil)
 
 type FieldConfig struct {
-	Name              string
-	Description       string
-	Args              []*InputValueConfig
-	Type              *TypeRefConfig
-	IsDeprecated      bool
-	DeprecationReason string
+	Name              string              `json:"name"`
+	Description       string              `json:"description,omitempty"`
+	Args              []*InputValueConfig `json:"args"`
+	Type              *TypeRefConfig      `json:"type"`
+	IsDeprecated      bool                `json:"isDeprecated,omitempty"`
+	DeprecationReason string              `json:"deprecationReason,omitempty"`
 }
 
 func newField(schema *schema, cfg *FieldConfig) (*field, error) {
@@ -46,7 +46,7 @@ func newField(schema *schema, cfg *FieldConfig) (*field, error) {
 		isDeprecated:      cfg.IsDeprecated,
 		deprecationReason: cfg.DeprecationReason,
 		typ:               t,
-		argsMap:           map[string]*inputValue{},
+		argsMap:           map[string]InputValue{},
 	}
 	for _, argCfg := range cfg.Args {
 		arg, err := newInputValue(schema, argCfg)
@@ -66,7 +66,7 @@ type field struct {
 	name              string
 	description       string
 	args              []InputValue
-	argsMap           map[string]*inputValue
+	argsMap           map[string]InputValue
 	typ               Type
 	isDeprecated      bool
 	deprecationReason string
@@ -15,10 +15,10 @@ type InputValue interface {
 var _ InputValue = (*inputValue)(nil)
 
 type InputValueConfig struct {
-	Name         string
-	Description  string
-	Type         *TypeRefConfig
-	DefaultValue string
+	Name         string         `json:"name"`
+	Description  string         `json:"description,omitempty"`
+	Type         *TypeRefConfig `json:"type"`
+	DefaultValue string         `json:"defaultValue,omitempty"`
 }
 
 func newInputValue(schema *schema, cfg *InputValueConfig) (*inputValue, error) {
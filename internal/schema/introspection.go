@@ -0,0 +1,179 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// IntrospectionQuery is the standard query used to fetch the schema of a
+// graph through introspection.
+const IntrospectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types {
+      ...FullType
+    }
+    directives {
+      name
+      description
+      locations
+      args {
+        ...InputValue
+      }
+    }
+  }
+}
+
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) {
+    name
+    description
+    args {
+      ...InputValue
+    }
+    type {
+      ...TypeRef
+    }
+    isDeprecated
+    deprecationReason
+  }
+  inputFields {
+    ...InputValue
+  }
+  interfaces {
+    ...TypeRef
+  }
+  enumValues(includeDeprecated: true) {
+    name
+    description
+    isDeprecated
+    deprecationReason
+  }
+  possibleTypes {
+    ...TypeRef
+  }
+}
+
+fragment InputValue on __InputValue {
+  name
+  description
+  type { ...TypeRef }
+  defaultValue
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType {
+    kind
+    name
+    ofType {
+      kind
+      name
+      ofType {
+        kind
+        name
+        ofType {
+          kind
+          name
+          ofType {
+            kind
+            name
+            ofType {
+              kind
+              name
+              ofType {
+                kind
+                name
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// IntrospectionResult is the data of the response to the introspection query.
+type IntrospectionResult struct {
+	Schema *SchemaConfig `json:"__schema"`
+}
+
+// Introspect fetches the schema of the graph using the introspection query.
+func Introspect(ctx context.Context, g graph.Graph, transport http.RoundTripper) (Schema, error) {
+	cfg, err := IntrospectConfig(ctx, g, transport)
+	if err != nil {
+		return nil, err
+	}
+	return NewSchema(cfg)
+}
+
+// IntrospectConfig fetches the config of the graph schema using the
+// introspection query. The introspection types are left out of the config.
+func IntrospectConfig(ctx context.Context, g graph.Graph, transport http.RoundTripper) (*SchemaConfig, error) {
+	res, err := g.Execute(ctx, &graph.Request{
+		Query:         IntrospectionQuery,
+		OperationName: "IntrospectionQuery",
+	}, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute introspection query: %s", err)
+	}
+	if len(res.Errors) > 0 {
+		msgs := make([]string, len(res.Errors))
+		for i, e := range res.Errors {
+			msgs[i] = e.Message
+		}
+		return nil, fmt.Errorf("introspection query failed: %s", strings.Join(msgs, "; "))
+	}
+
+	// the response data is decoded generically, encode it back to decode it
+	// as an introspection result
+	data, err := json.Marshal(res.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read introspection result: %s", err)
+	}
+	return DecodeIntrospection(data)
+}
+
+// DecodeIntrospection decodes the JSON encoded result of the introspection
+// query into a schema config. It accepts the data of the result as well as
+// the whole response, with the data under a "data" key. The introspection
+// types are left out of the config.
+func DecodeIntrospection(data []byte) (*SchemaConfig, error) {
+	var result struct {
+		IntrospectionResult
+		Data *IntrospectionResult `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection result: %s", err)
+	}
+	cfg := result.Schema
+	if result.Data != nil {
+		cfg = result.Data.Schema
+	}
+	if cfg == nil {
+		return nil, errors.New("introspection result has no schema")
+	}
+
+	types := make([]*TypeConfig, 0, len(cfg.Types))
+	for _, t := range cfg.Types {
+		if !strings.HasPrefix(t.Name, "__") {
+			types = append(types, t)
+		}
+	}
+	cfg.Types = types
+
+	return cfg, nil
+}
@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/graphql-go/graphql/testutil"
+	"github.com/graphql-go/handler"
+	"github.com/herzult/porte/internal/graph"
+)
+
+func TestIntrospect(t *testing.T) {
+	srv := httptest.NewServer(handler.New(&handler.Config{
+		Schema: &testutil.StarWarsSchema,
+	}))
+	defer srv.Close()
+
+	serviceURL, _ := url.Parse(srv.URL)
+	g, _ := graph.NewGraph(&graph.GraphConfig{ServiceURL: serviceURL})
+
+	schema, err := Introspect(context.Background(), g, nil)
+	if err != nil {
+		t.Fatalf("Introspect() returned error: %s", err)
+	}
+
+	if schema.QueryType().Name() != "Query" {
+		t.Errorf("query type is %s, expected Query", schema.QueryType().Name())
+	}
+	if schema.Type("__Schema") != nil {
+		t.Error("introspection types were not left out")
+	}
+	actual := []string{}
+	for _, p := range schema.Type("Character").PossibleTypes() {
+		actual = append(actual, p.Name())
+	}
+	checkSameElements(t, actual, []string{"Human", "Droid"})
+
+	friends := schema.Type("Human").Field("friends")
+	if friends == nil {
+		t.Fatal("field Human.friends is missing")
+	}
+	if friends.Type().Kind() != TypeKindList || friends.Type().OfType().Name() != "Character" {
+		t.Errorf("field Human.friends has type %s of %s", friends.Type().Kind(), friends.Type().OfType().Name())
+	}
+	if schema.Directive("include") == nil {
+		t.Error("directive include is missing")
+	}
+}
@@ -1,10 +1,5 @@
 package schema
 
-import (
-	"errors"
-	"fmt"
-)
-
 type Schema interface {
 	QueryType() Type
 	MutationType() Type
@@ -34,119 +29,72 @@ func NewSchema(cfg *SchemaConfig) (Schema, error) {
 		typesByInterface: map[string][]Type{},
 	}
 
-	// index fields
+	// index the types and directives, reporting the invalid ones along with
+	// the violations found by the validation of the whole schema
+	v := &validator{schema: schema}
 	for _, typCfg := range cfg.Types {
 		typ, err := newType(schema, typCfg)
 		if err != nil {
-			return nil, fmt.Errorf("invalid config: %s", err)
+			var path []string
+			if typCfg != nil && typCfg.Name != "" {
+				path = []string{typCfg.Name}
+			}
+			v.report(path, "%s", err)
+			continue
 		}
 		if _, ok := schema.typesMap[typ.Name()]; ok {
-			return nil, fmt.Errorf("type \"%s\" defined more than once", typ.Name())
+			v.report([]string{typ.Name()}, "type \"%s\" defined more than once", typ.Name())
+			continue
 		}
 		schema.types = append(schema.types, typ)
 		schema.typesMap[typ.Name()] = typ
 	}
-
-	// index directives
 	for _, dirCfg := range cfg.Directives {
 		dir, err := newDirective(schema, dirCfg)
 		if err != nil {
-			return nil, fmt.Errorf("found invalid directive: %s", err)
+			var path []string
+			if dirCfg != nil && dirCfg.Name != "" {
+				path = []string{"@" + dirCfg.Name}
+			}
+			v.report(path, "%s", err)
+			continue
 		}
 		if _, ok := schema.directivesMap[dir.Name()]; ok {
-			return nil, fmt.Errorf("directive \"%s\" defined more than once", dir.Name())
+			v.report([]string{"@" + dir.Name()}, "directive \"%s\" defined more than once", dir.Name())
+			continue
 		}
 		schema.directivesMap[dir.Name()] = dir
 		schema.directives = append(schema.directives, dir)
 	}
 
-	// assign root types
-	if cfg.QueryType != nil {
-		typ, err := newTypeRef(schema, cfg.QueryType)
-		if err != nil {
-			return nil, fmt.Errorf("invalid config: query type: %s", err)
-		}
-		if _, ok := schema.typesMap[typ.name]; !ok && typ.name != "" {
-			return nil, fmt.Errorf("invalid config: query type references non-existing type \"%s\"", typ.name)
-		}
-		if typ.Kind() != TypeKindObject {
-			return nil, fmt.Errorf(
-				"invalid config: query type must reference an OBJECT type, found %s type",
-				typ.Kind(),
-			)
-		}
-		schema.queryType = typ
-	} else {
-		return nil, errors.New("invalid config: no query type specified")
-	}
-	if cfg.MutationType != nil {
-		typ, err := newTypeRef(schema, cfg.MutationType)
-		if err != nil {
-			return nil, fmt.Errorf("invalid config: mutation type: %s", err)
-		}
-		if _, ok := schema.typesMap[typ.name]; !ok && typ.name != "" {
-			return nil, fmt.Errorf("invalid config: mutation type references non-existing type \"%s\"", typ.name)
-		}
-		if typ.Kind() != TypeKindObject {
-			return nil, fmt.Errorf(
-				"invalid config: mutation type must reference an OBJECT type, found %s type",
-				typ.Kind(),
-			)
-		}
-		schema.mutationType = typ
-	}
-	if cfg.SubscriptionType != nil {
-		typ, err := newTypeRef(schema, cfg.SubscriptionType)
-		if err != nil {
-			return nil, fmt.Errorf("invalid config: subscriptino type: %s", err)
-		}
-		if _, ok := schema.typesMap[typ.name]; !ok && typ.name != "" {
-			return nil, fmt.Errorf("invalid config: subscription type references non-existing type \"%s\"", typ.name)
-		}
-		if typ.Kind() != TypeKindObject {
-			return nil, fmt.Errorf(
-				"invalid config: subscription type must reference an OBJECT type, found %s type",
-				typ.Kind(),
-			)
-		}
-		schema.subscriptionType = typ
-	}
-
+	// index object types by interfaces
 	for _, t := range schema.types {
-		// index object types by interfaces
 		if t.Kind() == TypeKindObject {
 			for _, i := range t.Interfaces() {
-				schema.typesByInterface[i.Name()] = append(schema.typesByInterface[i.Name()], t)
-			}
-		}
-
-		// check possible types in union types
-		if t.Kind() == TypeKindUnion {
-			for _, p := range t.PossibleTypes() {
-				if p.Kind() == "" {
-					return nil, fmt.Errorf(
-						"invalid config: in UNION type \"%s\": possible type references non-existing type \"%s\"",
-						t.Name(),
-						p.Name(),
-					)
-				} else if p.Kind() != TypeKindObject {
-					return nil, fmt.Errorf(
-						"invalid config: in UNION type \"%s\": all possible types must reference OBJECT types, found %s type \"%s\"",
-						t.Name(),
-						p.Kind(),
-						p.Name(),
-					)
+				if i.Kind() == TypeKindInterface {
+					schema.typesByInterface[i.Name()] = append(schema.typesByInterface[i.Name()], t)
 				}
 			}
 		}
 	}
 
-	/**
-	TODO: add some validation
-		- check all type refs reference existing types
-		- check all input values are of input types
-		- check all interfaces types are of interface kind
-	*/
+	// assign root types and validate the whole schema, reporting all the
+	// violations together
+	if cfg.QueryType != nil {
+		schema.queryType = v.rootType("query", cfg.QueryType)
+	} else {
+		v.report(nil, "no query type specified")
+	}
+	if cfg.MutationType != nil {
+		schema.mutationType = v.rootType("mutation", cfg.MutationType)
+	}
+	if cfg.SubscriptionType != nil {
+		schema.subscriptionType = v.rootType("subscription", cfg.SubscriptionType)
+	}
+	v.validate()
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
 
 	return schema, nil
 }
@@ -169,3 +117,86 @@ func (s *schema) Types() []Type                   { return s.types }
 func (s *schema) Type(name string) Type           { return s.typesMap[name] }
 func (s *schema) Directives() []Directive         { return s.directives }
 func (s *schema) Directive(name string) Directive { return s.directivesMap[name] }
+
+// SchemaConfigOf returns the config of the given schema, from which NewSchema
+// builds the schema back.
+func SchemaConfigOf(s Schema) *SchemaConfig {
+	cfg := &SchemaConfig{
+		QueryType: typeRefConfigOf(s.QueryType()),
+		Types:     make([]*TypeConfig, 0, len(s.Types())),
+	}
+	if t := s.MutationType(); t != nil {
+		cfg.MutationType = typeRefConfigOf(t)
+	}
+	if t := s.SubscriptionType(); t != nil {
+		cfg.SubscriptionType = typeRefConfigOf(t)
+	}
+	for _, t := range s.Types() {
+		typCfg := &TypeConfig{
+			Kind:        t.Kind(),
+			Name:        t.Name(),
+			Description: t.Description(),
+		}
+		switch t.Kind() {
+		case TypeKindObject, TypeKindInterface:
+			for _, f := range t.Fields() {
+				typCfg.Fields = append(typCfg.Fields, &FieldConfig{
+					Name:              f.Name(),
+					Description:       f.Description(),
+					Args:              inputValueConfigsOf(f.Args()),
+					Type:              typeRefConfigOf(f.Type()),
+					IsDeprecated:      f.IsDeprecated(),
+					DeprecationReason: f.DeprecationReason(),
+				})
+			}
+			for _, i := range t.Interfaces() {
+				typCfg.Interfaces = append(typCfg.Interfaces, typeRefConfigOf(i))
+			}
+		case TypeKindUnion:
+			for _, pt := range t.PossibleTypes() {
+				typCfg.PossibleTypes = append(typCfg.PossibleTypes, typeRefConfigOf(pt))
+			}
+		case TypeKindEnum:
+			for _, ev := range t.EnumValues() {
+				typCfg.EnumValues = append(typCfg.EnumValues, &EnumValueConfig{
+					Name:              ev.Name(),
+					Description:       ev.Description(),
+					IsDeprecated:      ev.IsDeprecated(),
+					DeprecationReason: ev.DeprecationReason(),
+				})
+			}
+		case TypeKindInputObject:
+			typCfg.InputFields = inputValueConfigsOf(t.InputFields())
+		}
+		cfg.Types = append(cfg.Types, typCfg)
+	}
+	for _, d := range s.Directives() {
+		cfg.Directives = append(cfg.Directives, &DirectiveConfig{
+			Name:        d.Name(),
+			Description: d.Description(),
+			Locations:   d.Locations(),
+			Args:        inputValueConfigsOf(d.Args()),
+		})
+	}
+	return cfg
+}
+
+func typeRefConfigOf(t Type) *TypeRefConfig {
+	if t.Kind() == TypeKindNonNull || t.Kind() == TypeKindList {
+		return &TypeRefConfig{Kind: t.Kind(), OfType: typeRefConfigOf(t.OfType())}
+	}
+	return &TypeRefConfig{Kind: t.Kind(), Name: t.Name()}
+}
+
+func inputValueConfigsOf(values []InputValue) []*InputValueConfig {
+	cfgs := make([]*InputValueConfig, 0, len(values))
+	for _, v := range values {
+		cfgs = append(cfgs, &InputValueConfig{
+			Name:         v.Name(),
+			Description:  v.Description(),
+			Type:         typeRefConfigOf(v.Type()),
+			DefaultValue: v.DefaultValue(),
+		})
+	}
+	return cfgs
+}
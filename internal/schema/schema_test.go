@@ -1,6 +1,7 @@
 package schema
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -11,6 +12,20 @@ func TestNewSchema_test_schema(t *testing.T) {
 	}
 }
 
+func TestSchemaConfigOf(t *testing.T) {
+	s, err := NewSchema(newTestSchemaConfig())
+	if err != nil {
+		t.Fatalf("NewSchema() returned error: %s", err)
+	}
+	rebuilt, err := NewSchema(SchemaConfigOf(s))
+	if err != nil {
+		t.Fatalf("NewSchema() returned error for the schema config: %s", err)
+	}
+	if PrintSDL(rebuilt) != PrintSDL(s) {
+		t.Errorf("schema rebuilt from its config is\n%s\nexpected\n%s", PrintSDL(rebuilt), PrintSDL(s))
+	}
+}
+
 func TestNewSchema_possibleTypes_on_interface(t *testing.T) {
 	cfg := newTestSchemaConfig()
 	for _, t := range cfg.Types {
@@ -536,3 +551,144 @@ func newTestSchemaConfig() *SchemaConfig {
 		},
 	}
 }
+
+func TestNewSchema_validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		sdl    string
+		errMsg string
+	}{
+		{
+			name:   "field referencing a non-existing type",
+			sdl:    "type Query { a: [Plop!] }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"a\": references non-existing type \"Plop\"",
+		},
+		{
+			name:   "field of an input type",
+			sdl:    "type Query { a: Filter }\ninput Filter { b: Int }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"a\": must be of an output type, found INPUT_OBJECT type \"Filter\"",
+		},
+		{
+			name:   "argument of an output type",
+			sdl:    "type Query { a(b: Query): Int }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"a\": argument \"b\": must be of an input type, found OBJECT type \"Query\"",
+		},
+		{
+			name:   "input field of an output type",
+			sdl:    "type Query { a(b: Filter): Int }\ninput Filter { c: Query }",
+			errMsg: "invalid config: in INPUT_OBJECT type \"Filter\": input field \"c\": must be of an input type, found OBJECT type \"Query\"",
+		},
+		{
+			name:   "implementation of a non-interface type",
+			sdl:    "type Query implements Other { a: Int }\ntype Other { a: Int }",
+			errMsg: "invalid config: in OBJECT type \"Query\": can only implement INTERFACE types, found OBJECT type \"Other\"",
+		},
+		{
+			name:   "implementation missing an interface field",
+			sdl:    "type Query implements Node { a: Int }\ninterface Node { id: ID! }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"id\" of interface \"Node\": is not implemented",
+		},
+		{
+			name:   "implementation with a field of a non-covariant type",
+			sdl:    "type Query implements Node { id: ID }\ninterface Node { id: ID! }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"id\" of interface \"Node\": expects type \"ID!\" but is implemented with type \"ID\"",
+		},
+		{
+			name:   "implementation with an argument of a different type",
+			sdl:    "type Query implements Node { id(a: Int!): ID! }\ninterface Node { id(a: Int): ID! }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"id\" of interface \"Node\": argument \"a\" expects type \"Int\" but is implemented with type \"Int!\"",
+		},
+		{
+			name:   "implementation with an additional required argument",
+			sdl:    "type Query implements Node { id(a: Int!): ID! }\ninterface Node { id: ID! }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"id\" of interface \"Node\": additional argument \"a\" must not be required",
+		},
+		{
+			name:   "name reserved by introspection",
+			sdl:    "type Query { __a: Int }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"__a\": name \"__a\" must not begin with \"__\", which is reserved by GraphQL introspection",
+		},
+		{
+			name:   "enum value with a reserved name",
+			sdl:    "type Query { a: Flag }\nenum Flag { true }",
+			errMsg: "invalid config: in ENUM type \"Flag\": enum value \"true\": name is reserved",
+		},
+		{
+			name:   "union declaring a member twice",
+			sdl:    "type Query { a: Result }\nunion Result = Query | Query",
+			errMsg: "invalid config: in UNION type \"Result\": possible type \"Query\" declared more than once",
+		},
+		{
+			name:   "input object referencing itself through non-null fields",
+			sdl:    "type Query { a(b: A): Int }\ninput A { b: B! }\ninput B { a: A! }",
+			errMsg: "invalid config: in INPUT_OBJECT type \"A\": can not reference itself through non-null input fields, found cycle A.b.a; in INPUT_OBJECT type \"B\": can not reference itself through non-null input fields, found cycle B.a.b",
+		},
+		{
+			name:   "directive with an unknown location",
+			sdl:    "type Query { a: Int }\ndirective @plop(a: Query) on FIELD | PLOP",
+			errMsg: "invalid config: in directive \"plop\": unknown location \"PLOP\"; in directive \"plop\": argument \"a\": must be of an input type, found OBJECT type \"Query\"",
+		},
+		{
+			name:   "several violations",
+			sdl:    "type Query implements Node { a: Plop }\ninterface Node { id: ID! }\ninput Filter { __b: Int }",
+			errMsg: "invalid config: in OBJECT type \"Query\": field \"a\": references non-existing type \"Plop\"; in OBJECT type \"Query\": field \"id\" of interface \"Node\": is not implemented; in INPUT_OBJECT type \"Filter\": input field \"__b\": name \"__b\" must not begin with \"__\", which is reserved by GraphQL introspection",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseSDL(tt.sdl)
+			if err != nil {
+				t.Fatalf("ParseSDL() returned error: %s", err)
+			}
+			got, err := NewSchema(cfg)
+			checkError(t, err, tt.errMsg)
+			if got != nil {
+				t.Errorf("NewSchema() was not expected to return a Schema, but it did")
+			}
+		})
+	}
+}
+
+func TestNewSchema_validation_error_paths(t *testing.T) {
+	cfg, err := ParseSDL("type Query { a(b: Query): Int }\ndirective @plop on PLOP")
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	_, err = NewSchema(cfg)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("NewSchema() returned %T error, expected ValidationErrors", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("NewSchema() returned %d errors, expected 2: %s", len(errs), err)
+	}
+	checkSameElements(t, errs[0].Path, []string{"Query", "a", "b"})
+	checkSameElements(t, errs[1].Path, []string{"@plop"})
+}
+
+func TestNewSchema_config_errors(t *testing.T) {
+	cfg, err := ParseSDL("type Query { a: Plop }\nenum Flag { ON }\ndirective @plop on FIELD")
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	flag := cfg.Types[1]
+	flag.EnumValues = append(flag.EnumValues, &EnumValueConfig{Name: "ON"})
+	cfg.Types = append(cfg.Types, &TypeConfig{Kind: TypeKindScalar, Name: "Query"})
+	cfg.Directives = append(cfg.Directives, &DirectiveConfig{Name: "plop", Locations: []DirectiveLocation{DirectiveLocationField}})
+
+	_, err = NewSchema(cfg)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("NewSchema() returned %T error, expected ValidationErrors", err)
+	}
+	actual := make([]string, len(errs))
+	for i, e := range errs {
+		actual[i] = strings.Join(e.Path, ".") + ": " + e.Message
+	}
+	checkSameElements(t, actual, []string{
+		"Flag: in type \"Flag\": enum value \"ON\" declared more than once",
+		"Query: type \"Query\" defined more than once",
+		"@plop: directive \"plop\" defined more than once",
+		"Query.a: in OBJECT type \"Query\": field \"a\": references non-existing type \"Plop\"",
+	})
+}
@@ -0,0 +1,579 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// DefaultDeprecationReason is the deprecation reason of the @deprecated
+// directive when none is given.
+const DefaultDeprecationReason = "No longer supported"
+
+// builtInScalars are the scalar types every schema has, they do not need to
+// be declared in SDL.
+var builtInScalars = []string{"Int", "Float", "String", "Boolean", "ID"}
+
+// builtInDirectives returns the configs of the directives every schema has,
+// they do not need to be declared in SDL.
+func builtInDirectives() []*DirectiveConfig {
+	nonNullBoolean := &TypeRefConfig{
+		Kind:   TypeKindNonNull,
+		OfType: &TypeRefConfig{Name: "Boolean"},
+	}
+	return []*DirectiveConfig{
+		&DirectiveConfig{
+			Name:        "include",
+			Description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+			Locations: []DirectiveLocation{
+				DirectiveLocationField,
+				DirectiveLocationFragmentSpread,
+				DirectiveLocationInlineFragment,
+			},
+			Args: []*InputValueConfig{
+				&InputValueConfig{Name: "if", Description: "Included when true.", Type: nonNullBoolean},
+			},
+		},
+		&DirectiveConfig{
+			Name:        "skip",
+			Description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+			Locations: []DirectiveLocation{
+				DirectiveLocationField,
+				DirectiveLocationFragmentSpread,
+				DirectiveLocationInlineFragment,
+			},
+			Args: []*InputValueConfig{
+				&InputValueConfig{Name: "if", Description: "Skipped when true.", Type: nonNullBoolean},
+			},
+		},
+		&DirectiveConfig{
+			Name:        "deprecated",
+			Description: "Marks an element of a GraphQL schema as no longer supported.",
+			Locations: []DirectiveLocation{
+				DirectiveLocationFieldDefinition,
+				DirectiveLocationEnumValue,
+			},
+			Args: []*InputValueConfig{
+				&InputValueConfig{
+					Name:         "reason",
+					Description:  "Explains why this element was deprecated.",
+					Type:         &TypeRefConfig{Name: "String"},
+					DefaultValue: printString(DefaultDeprecationReason),
+				},
+			},
+		},
+	}
+}
+
+func isBuiltInScalar(name string) bool {
+	for _, s := range builtInScalars {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isBuiltInDirective(name string) bool {
+	for _, d := range builtInDirectives() {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSDL parses a schema written in the GraphQL schema definition language
+// into a schema config. The built-in scalars and directives are added to the
+// config when the SDL does not declare them.
+func ParseSDL(sdl string) (*SchemaConfig, error) {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(sdl),
+			Name: "GraphQL SDL",
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ParseSDLDocument(doc)
+}
+
+// ParseSDLDocument builds the schema config of a parsed SDL document, like
+// ParseSDL.
+func ParseSDLDocument(doc *ast.Document) (*SchemaConfig, error) {
+	cfg := &SchemaConfig{}
+	typesMap := map[string]*TypeConfig{}
+	var schemaDef *ast.SchemaDefinition
+	var extensions []*ast.ObjectDefinition
+
+	for _, def := range doc.Definitions {
+		var typCfg *TypeConfig
+		switch def := def.(type) {
+		case *ast.SchemaDefinition:
+			if schemaDef != nil {
+				return nil, errors.New("schema definition declared more than once")
+			}
+			schemaDef = def
+		case *ast.TypeExtensionDefinition:
+			extensions = append(extensions, def.Definition)
+		case *ast.DirectiveDefinition:
+			dirCfg := &DirectiveConfig{
+				Name:        def.Name.Value,
+				Description: descriptionValue(def.Description),
+				Args:        inputValueConfigs(def.Arguments),
+			}
+			for _, loc := range def.Locations {
+				dirCfg.Locations = append(dirCfg.Locations, DirectiveLocation(loc.Value))
+			}
+			cfg.Directives = append(cfg.Directives, dirCfg)
+		case *ast.ScalarDefinition:
+			typCfg = &TypeConfig{
+				Kind:        TypeKindScalar,
+				Name:        def.Name.Value,
+				Description: descriptionValue(def.Description),
+			}
+		case *ast.ObjectDefinition:
+			typCfg = &TypeConfig{
+				Kind:        TypeKindObject,
+				Name:        def.Name.Value,
+				Description: descriptionValue(def.Description),
+				Fields:      fieldConfigs(def.Fields),
+				Interfaces:  namedTypeRefConfigs(def.Interfaces),
+			}
+		case *ast.InterfaceDefinition:
+			typCfg = &TypeConfig{
+				Kind:        TypeKindInterface,
+				Name:        def.Name.Value,
+				Description: descriptionValue(def.Description),
+				Fields:      fieldConfigs(def.Fields),
+			}
+		case *ast.UnionDefinition:
+			typCfg = &TypeConfig{
+				Kind:          TypeKindUnion,
+				Name:          def.Name.Value,
+				Description:   descriptionValue(def.Description),
+				PossibleTypes: namedTypeRefConfigs(def.Types),
+			}
+		case *ast.EnumDefinition:
+			typCfg = &TypeConfig{
+				Kind:        TypeKindEnum,
+				Name:        def.Name.Value,
+				Description: descriptionValue(def.Description),
+			}
+			for _, v := range def.Values {
+				isDeprecated, reason := deprecation(v.Directives)
+				typCfg.EnumValues = append(typCfg.EnumValues, &EnumValueConfig{
+					Name:              v.Name.Value,
+					Description:       descriptionValue(v.Description),
+					IsDeprecated:      isDeprecated,
+					DeprecationReason: reason,
+				})
+			}
+		case *ast.InputObjectDefinition:
+			typCfg = &TypeConfig{
+				Kind:        TypeKindInputObject,
+				Name:        def.Name.Value,
+				Description: descriptionValue(def.Description),
+				InputFields: inputValueConfigs(def.Fields),
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %s definition in SDL", def.GetKind())
+		}
+
+		if typCfg != nil {
+			if _, ok := typesMap[typCfg.Name]; ok {
+				return nil, fmt.Errorf("type \"%s\" defined more than once", typCfg.Name)
+			}
+			typesMap[typCfg.Name] = typCfg
+			cfg.Types = append(cfg.Types, typCfg)
+		}
+	}
+
+	for _, ext := range extensions {
+		typCfg, ok := typesMap[ext.Name.Value]
+		if !ok {
+			return nil, fmt.Errorf("can not extend non-existing type \"%s\"", ext.Name.Value)
+		}
+		if typCfg.Kind != TypeKindObject {
+			return nil, fmt.Errorf("can not extend %s type \"%s\" as an OBJECT type", typCfg.Kind, typCfg.Name)
+		}
+		typCfg.Fields = append(typCfg.Fields, fieldConfigs(ext.Fields)...)
+		typCfg.Interfaces = append(typCfg.Interfaces, namedTypeRefConfigs(ext.Interfaces)...)
+	}
+
+	for _, name := range builtInScalars {
+		if _, ok := typesMap[name]; !ok {
+			cfg.Types = append(cfg.Types, &TypeConfig{Kind: TypeKindScalar, Name: name})
+		}
+	}
+	for _, dirCfg := range builtInDirectives() {
+		defined := false
+		for _, d := range cfg.Directives {
+			defined = defined || d.Name == dirCfg.Name
+		}
+		if !defined {
+			cfg.Directives = append(cfg.Directives, dirCfg)
+		}
+	}
+
+	if schemaDef != nil {
+		for _, opType := range schemaDef.OperationTypes {
+			ref := &TypeRefConfig{Name: opType.Type.Name.Value}
+			switch opType.Operation {
+			case ast.OperationTypeQuery:
+				cfg.QueryType = ref
+			case ast.OperationTypeMutation:
+				cfg.MutationType = ref
+			case ast.OperationTypeSubscription:
+				cfg.SubscriptionType = ref
+			}
+		}
+	} else {
+		if _, ok := typesMap["Query"]; ok {
+			cfg.QueryType = &TypeRefConfig{Name: "Query"}
+		}
+		if _, ok := typesMap["Mutation"]; ok {
+			cfg.MutationType = &TypeRefConfig{Name: "Mutation"}
+		}
+		if _, ok := typesMap["Subscription"]; ok {
+			cfg.SubscriptionType = &TypeRefConfig{Name: "Subscription"}
+		}
+	}
+
+	return cfg, nil
+}
+
+func descriptionValue(v *ast.StringValue) string {
+	if v == nil {
+		return ""
+	}
+	return v.Value
+}
+
+// deprecation returns the deprecation state of a schema element from its
+// directives.
+func deprecation(directives []*ast.Directive) (bool, string) {
+	for _, d := range directives {
+		if d.Name.Value != "deprecated" {
+			continue
+		}
+		for _, arg := range d.Arguments {
+			if s, ok := arg.Value.(*ast.StringValue); ok && arg.Name.Value == "reason" {
+				return true, s.Value
+			}
+		}
+		return true, DefaultDeprecationReason
+	}
+	return false, ""
+}
+
+func fieldConfigs(defs []*ast.FieldDefinition) []*FieldConfig {
+	cfgs := make([]*FieldConfig, 0, len(defs))
+	for _, def := range defs {
+		isDeprecated, reason := deprecation(def.Directives)
+		cfgs = append(cfgs, &FieldConfig{
+			Name:              def.Name.Value,
+			Description:       descriptionValue(def.Description),
+			Args:              inputValueConfigs(def.Arguments),
+			Type:              typeRefConfig(def.Type),
+			IsDeprecated:      isDeprecated,
+			DeprecationReason: reason,
+		})
+	}
+	return cfgs
+}
+
+func inputValueConfigs(defs []*ast.InputValueDefinition) []*InputValueConfig {
+	cfgs := make([]*InputValueConfig, 0, len(defs))
+	for _, def := range defs {
+		cfg := &InputValueConfig{
+			Name:        def.Name.Value,
+			Description: descriptionValue(def.Description),
+			Type:        typeRefConfig(def.Type),
+		}
+		if def.DefaultValue != nil {
+			cfg.DefaultValue = printValue(def.DefaultValue)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs
+}
+
+func namedTypeRefConfigs(nameds []*ast.Named) []*TypeRefConfig {
+	cfgs := make([]*TypeRefConfig, 0, len(nameds))
+	for _, n := range nameds {
+		cfgs = append(cfgs, &TypeRefConfig{Name: n.Name.Value})
+	}
+	return cfgs
+}
+
+func typeRefConfig(t ast.Type) *TypeRefConfig {
+	switch t := t.(type) {
+	case *ast.NonNull:
+		return &TypeRefConfig{Kind: TypeKindNonNull, OfType: typeRefConfig(t.Type)}
+	case *ast.List:
+		return &TypeRefConfig{Kind: TypeKindList, OfType: typeRefConfig(t.Type)}
+	case *ast.Named:
+		return &TypeRefConfig{Name: t.Name.Value}
+	}
+	return nil
+}
+
+// PrintSDL prints the schema in the GraphQL schema definition language. The
+// built-in scalars and directives are left out, as well as the schema
+// definition when the root types have their default names.
+func PrintSDL(s Schema) string {
+	blocks := []string{}
+
+	if def := printSchemaDefinition(s); def != "" {
+		blocks = append(blocks, def)
+	}
+	for _, d := range s.Directives() {
+		if !isBuiltInDirective(d.Name()) {
+			blocks = append(blocks, printDirective(d))
+		}
+	}
+	for _, t := range s.Types() {
+		if t.Kind() == TypeKindScalar && isBuiltInScalar(t.Name()) {
+			continue
+		}
+		if strings.HasPrefix(t.Name(), "__") {
+			continue
+		}
+		blocks = append(blocks, printType(t))
+	}
+
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+func printSchemaDefinition(s Schema) string {
+	roots := []struct {
+		op  string
+		typ Type
+		def string
+	}{
+		{"query", s.QueryType(), "Query"},
+		{"mutation", s.MutationType(), "Mutation"},
+		{"subscription", s.SubscriptionType(), "Subscription"},
+	}
+	isDefault := true
+	for _, r := range roots {
+		if r.typ != nil && r.typ.Name() != r.def {
+			isDefault = false
+		}
+	}
+	if isDefault {
+		return ""
+	}
+
+	b := new(strings.Builder)
+	b.WriteString("schema {\n")
+	for _, r := range roots {
+		if r.typ != nil {
+			fmt.Fprintf(b, "  %s: %s\n", r.op, r.typ.Name())
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func printDirective(d Directive) string {
+	b := new(strings.Builder)
+	b.WriteString(printDescription(d.Description(), ""))
+	fmt.Fprintf(b, "directive @%s%s on ", d.Name(), printArgs(d.Args(), ""))
+	locs := make([]string, len(d.Locations()))
+	for i, l := range d.Locations() {
+		locs[i] = string(l)
+	}
+	b.WriteString(strings.Join(locs, " | "))
+	return b.String()
+}
+
+func printType(t Type) string {
+	b := new(strings.Builder)
+	b.WriteString(printDescription(t.Description(), ""))
+
+	switch t.Kind() {
+	case TypeKindScalar:
+		fmt.Fprintf(b, "scalar %s", t.Name())
+	case TypeKindObject, TypeKindInterface:
+		keyword := "type"
+		if t.Kind() == TypeKindInterface {
+			keyword = "interface"
+		}
+		fmt.Fprintf(b, "%s %s", keyword, t.Name())
+		if len(t.Interfaces()) > 0 {
+			names := make([]string, len(t.Interfaces()))
+			for i, iface := range t.Interfaces() {
+				names[i] = iface.Name()
+			}
+			fmt.Fprintf(b, " implements %s", strings.Join(names, " & "))
+		}
+		b.WriteString(" {\n")
+		for i, f := range t.Fields() {
+			if i > 0 && f.Description() != "" {
+				b.WriteString("\n")
+			}
+			b.WriteString(printDescription(f.Description(), "  "))
+			fmt.Fprintf(b, "  %s%s: %s", f.Name(), printArgs(f.Args(), "  "), printTypeRef(f.Type()))
+			b.WriteString(printDeprecated(f.IsDeprecated(), f.DeprecationReason()))
+			b.WriteString("\n")
+		}
+		b.WriteString("}")
+	case TypeKindUnion:
+		names := make([]string, len(t.PossibleTypes()))
+		for i, p := range t.PossibleTypes() {
+			names[i] = p.Name()
+		}
+		fmt.Fprintf(b, "union %s = %s", t.Name(), strings.Join(names, " | "))
+	case TypeKindEnum:
+		fmt.Fprintf(b, "enum %s {\n", t.Name())
+		for i, v := range t.EnumValues() {
+			if i > 0 && v.Description() != "" {
+				b.WriteString("\n")
+			}
+			b.WriteString(printDescription(v.Description(), "  "))
+			fmt.Fprintf(b, "  %s", v.Name())
+			b.WriteString(printDeprecated(v.IsDeprecated(), v.DeprecationReason()))
+			b.WriteString("\n")
+		}
+		b.WriteString("}")
+	case TypeKindInputObject:
+		fmt.Fprintf(b, "input %s {\n", t.Name())
+		for i, f := range t.InputFields() {
+			if i > 0 && f.Description() != "" {
+				b.WriteString("\n")
+			}
+			b.WriteString(printDescription(f.Description(), "  "))
+			fmt.Fprintf(b, "  %s\n", printInputValue(f))
+		}
+		b.WriteString("}")
+	}
+
+	return b.String()
+}
+
+// printArgs prints the arguments of a field or a directive, on multiple lines
+// when any of them has a description.
+func printArgs(args []InputValue, indent string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	multiline := false
+	for _, arg := range args {
+		multiline = multiline || arg.Description() != ""
+	}
+
+	printed := make([]string, len(args))
+	for i, arg := range args {
+		if multiline {
+			printed[i] = printDescription(arg.Description(), indent+"  ") + indent + "  " + printInputValue(arg)
+		} else {
+			printed[i] = printInputValue(arg)
+		}
+	}
+	if multiline {
+		return "(\n" + strings.Join(printed, "\n") + "\n" + indent + ")"
+	}
+	return "(" + strings.Join(printed, ", ") + ")"
+}
+
+func printInputValue(v InputValue) string {
+	s := fmt.Sprintf("%s: %s", v.Name(), printTypeRef(v.Type()))
+	if v.DefaultValue() != "" {
+		s += " = " + v.DefaultValue()
+	}
+	return s
+}
+
+func printTypeRef(t Type) string {
+	switch t.Kind() {
+	case TypeKindNonNull:
+		return printTypeRef(t.OfType()) + "!"
+	case TypeKindList:
+		return "[" + printTypeRef(t.OfType()) + "]"
+	}
+	return t.Name()
+}
+
+func printDeprecated(isDeprecated bool, reason string) string {
+	if !isDeprecated {
+		return ""
+	}
+	if reason == "" || reason == DefaultDeprecationReason {
+		return " @deprecated"
+	}
+	return fmt.Sprintf(" @deprecated(reason: %s)", printString(reason))
+}
+
+// printDescription prints a description followed by a new line, as a block
+// string when it spans several lines.
+func printDescription(desc string, indent string) string {
+	if desc == "" {
+		return ""
+	}
+	if !strings.Contains(desc, "\n") {
+		return indent + printString(desc) + "\n"
+	}
+	lines := strings.Split(strings.Replace(desc, `"""`, `\"""`, -1), "\n")
+	b := new(strings.Builder)
+	b.WriteString(indent + "\"\"\"\n")
+	for _, l := range lines {
+		if l == "" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(indent + l + "\n")
+	}
+	b.WriteString(indent + "\"\"\"\n")
+	return b.String()
+}
+
+// printValue prints a GraphQL input value literal.
+func printValue(v ast.Value) string {
+	switch v := v.(type) {
+	case *ast.Variable:
+		return "$" + v.Name.Value
+	case *ast.IntValue:
+		return v.Value
+	case *ast.FloatValue:
+		return v.Value
+	case *ast.StringValue:
+		return printString(v.Value)
+	case *ast.BooleanValue:
+		return strconv.FormatBool(v.Value)
+	case *ast.EnumValue:
+		return v.Value
+	case *ast.ListValue:
+		values := make([]string, len(v.Values))
+		for i, item := range v.Values {
+			values[i] = printValue(item)
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case *ast.ObjectValue:
+		fields := make([]string, len(v.Fields))
+		for i, f := range v.Fields {
+			fields[i] = f.Name.Value + ": " + printValue(f.Value)
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return ""
+}
+
+// printString prints a GraphQL string literal, JSON string escaping is valid
+// GraphQL string escaping.
+func printString(s string) string {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,200 @@
+package schema
+
+import (
+	"testing"
+)
+
+const testSDL = `schema {
+  query: Root
+  mutation: Mutation
+}
+
+"""
+Marks a field as cacheable.
+Only applies to object fields.
+"""
+directive @cached(
+  "How long the field can be cached, in seconds."
+  ttl: Int = 60
+) on FIELD_DEFINITION | OBJECT
+
+"The root query type"
+type Root {
+  hero(episode: Episode = JEDI): Character
+  search(text: String!, filter: SearchFilter = {first: 10, kinds: ["human", "droid"]}): [SearchResult!]!
+  oldHero: Character @deprecated(reason: "Use \"hero\" instead.")
+  legacyHero: Character @deprecated
+}
+
+type Mutation {
+  createReview(episode: Episode!, review: ReviewInput!): Review
+}
+
+interface Character {
+  id: ID!
+  name: String!
+}
+
+interface Node {
+  id: ID!
+}
+
+type Human implements Character & Node {
+  id: ID!
+  name: String!
+
+  "Height in meters"
+  height: Float
+}
+
+type Droid implements Character & Node {
+  id: ID!
+  name: String!
+  primaryFunction: String
+}
+
+union SearchResult = Human | Droid
+
+enum Episode {
+  NEWHOPE
+  EMPIRE
+
+  "Episode VI"
+  JEDI
+  HOLIDAY_SPECIAL @deprecated(reason: "Never happened.")
+}
+
+input SearchFilter {
+  first: Int
+  kinds: [String!]
+}
+
+input ReviewInput {
+  stars: Int!
+  commentary: String
+}
+
+type Review {
+  stars: Int!
+  commentary: String
+}
+
+scalar DateTime
+`
+
+func TestParseSDL(t *testing.T) {
+	cfg, err := ParseSDL(testSDL + `
+extend type Review {
+  createdAt: DateTime
+}
+`)
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	schema, err := NewSchema(cfg)
+	if err != nil {
+		t.Fatalf("NewSchema() returned error: %s", err)
+	}
+
+	if schema.QueryType().Name() != "Root" {
+		t.Errorf("query type is %s, expected Root", schema.QueryType().Name())
+	}
+	if schema.SubscriptionType() != nil {
+		t.Errorf("subscription type is %s, expected none", schema.SubscriptionType().Name())
+	}
+	if schema.Type("Review").Field("createdAt") == nil {
+		t.Error("field Review.createdAt from the type extension is missing")
+	}
+	if schema.Type("String") == nil || schema.Directive("skip") == nil {
+		t.Error("built-in types and directives are missing")
+	}
+
+	oldHero := schema.Type("Root").Field("oldHero")
+	if !oldHero.IsDeprecated() || oldHero.DeprecationReason() != `Use "hero" instead.` {
+		t.Errorf("field Root.oldHero deprecation is %v %q", oldHero.IsDeprecated(), oldHero.DeprecationReason())
+	}
+	legacyHero := schema.Type("Root").Field("legacyHero")
+	if legacyHero.DeprecationReason() != DefaultDeprecationReason {
+		t.Errorf("field Root.legacyHero deprecation reason is %q", legacyHero.DeprecationReason())
+	}
+	filter := schema.Type("Root").Field("search").Arg("filter")
+	if filter.DefaultValue() != `{first: 10, kinds: ["human", "droid"]}` {
+		t.Errorf("arg Root.search(filter:) default value is %s", filter.DefaultValue())
+	}
+	if d := schema.Directive("cached").Description(); d != "Marks a field as cacheable.\nOnly applies to object fields." {
+		t.Errorf("directive @cached description is %q", d)
+	}
+	actual := []string{}
+	for _, p := range schema.Type("Node").PossibleTypes() {
+		actual = append(actual, p.Name())
+	}
+	checkSameElements(t, actual, []string{"Human", "Droid"})
+}
+
+func TestParseSDL_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		sdl    string
+		errMsg string
+	}{
+		{
+			name:   "type defined twice",
+			sdl:    "type Query { a: Int }\ntype Query { b: Int }",
+			errMsg: "type \"Query\" defined more than once",
+		},
+		{
+			name:   "extension of a non-existing type",
+			sdl:    "type Query { a: Int }\nextend type Plop { b: Int }",
+			errMsg: "can not extend non-existing type \"Plop\"",
+		},
+		{
+			name:   "executable definition",
+			sdl:    "type Query { a: Int }\nquery { a }",
+			errMsg: "unexpected OperationDefinition definition in SDL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSDL(tt.sdl)
+			checkError(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestPrintSDL_round_trip(t *testing.T) {
+	tests := []struct {
+		name string
+		sdl  func(t *testing.T) string
+	}{
+		{
+			name: "test schema",
+			sdl: func(t *testing.T) string {
+				schema, err := NewSchema(newTestSchemaConfig())
+				if err != nil {
+					t.Fatalf("NewSchema() returned error: %s", err)
+				}
+				return PrintSDL(schema)
+			},
+		},
+		{
+			name: "SDL with every kind of definition",
+			sdl:  func(*testing.T) string { return testSDL },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sdl := tt.sdl(t)
+			cfg, err := ParseSDL(sdl)
+			if err != nil {
+				t.Fatalf("ParseSDL() returned error: %s", err)
+			}
+			schema, err := NewSchema(cfg)
+			if err != nil {
+				t.Fatalf("NewSchema() returned error: %s", err)
+			}
+			if printed := PrintSDL(schema); printed != sdl {
+				t.Errorf("PrintSDL() = \n%s\nexpected\n%s", printed, sdl)
+			}
+		})
+	}
+}
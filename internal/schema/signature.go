@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"sort"
+	"strings"
+)
+
+// TypeSignature describes the type config regardless of its descriptions and
+// of the order of its members: two configs with the same signature define the
+// same type.
+func TypeSignature(t *TypeConfig) string {
+	members := make([]string, 0)
+	for _, f := range t.Fields {
+		members = append(members, "field "+f.Name+"("+inputValuesSignature(f.Args)+"): "+TypeRefSignature(f.Type))
+	}
+	for _, i := range t.Interfaces {
+		members = append(members, "implements "+TypeRefSignature(i))
+	}
+	for _, pt := range t.PossibleTypes {
+		members = append(members, "possible "+TypeRefSignature(pt))
+	}
+	for _, ev := range t.EnumValues {
+		members = append(members, "value "+ev.Name)
+	}
+	for _, v := range t.InputFields {
+		members = append(members, "input "+inputValuesSignature([]*InputValueConfig{v}))
+	}
+	sort.Strings(members)
+	return string(t.Kind) + " " + t.Name + " {" + strings.Join(members, ", ") + "}"
+}
+
+// DirectiveSignature describes the directive config regardless of its
+// descriptions and of the order of its arguments and locations.
+func DirectiveSignature(d *DirectiveConfig) string {
+	locations := make([]string, len(d.Locations))
+	for i, l := range d.Locations {
+		locations[i] = string(l)
+	}
+	sort.Strings(locations)
+	return d.Name + "(" + inputValuesSignature(d.Args) + ") on " + strings.Join(locations, " | ")
+}
+
+func inputValuesSignature(values []*InputValueConfig) string {
+	args := make([]string, len(values))
+	for i, v := range values {
+		args[i] = v.Name + ": " + TypeRefSignature(v.Type)
+		if v.DefaultValue != "" {
+			args[i] += " = " + v.DefaultValue
+		}
+	}
+	sort.Strings(args)
+	return strings.Join(args, ", ")
+}
+
+// TypeRefSignature prints the type reference the way SDL does.
+func TypeRefSignature(ref *TypeRefConfig) string {
+	switch ref.Kind {
+	case TypeKindNonNull:
+		return TypeRefSignature(ref.OfType) + "!"
+	case TypeKindList:
+		return "[" + TypeRefSignature(ref.OfType) + "]"
+	}
+	return ref.Name
+}
@@ -59,8 +59,8 @@ type TypeConfig struct {
 }
 
 type TypeRefConfig struct {
-	Kind   TypeKind       `json:"kind"`
-	Name   string         `json:"name"`
+	Kind   TypeKind       `json:"kind,omitempty"`
+	Name   string         `json:"name,omitempty"`
 	OfType *TypeRefConfig `json:"ofType,omitempty"`
 }
 
@@ -125,7 +125,6 @@ func newType(schema *schema, cfg *TypeConfig) (Type, error) {
 			t.fields = append(t.fields, f)
 		}
 		if t.kind == TypeKindObject {
-			idx := map[string]bool{}
 			for _, ifaceCfg := range cfg.Interfaces {
 				i, err := newTypeRef(schema, ifaceCfg)
 				if err != nil {
@@ -137,9 +136,6 @@ func newType(schema *schema, cfg *TypeConfig) (Type, error) {
 						t.name,
 					)
 				}
-				if idx[t.name] {
-					continue
-				}
 				t.interfaces = append(t.interfaces, i)
 			}
 		}
@@ -162,7 +158,6 @@ func newType(schema *schema, cfg *TypeConfig) (Type, error) {
 		}
 	case TypeKindUnion:
 		// build possible types
-		idx := map[string]bool{}
 		for _, ptCfg := range cfg.PossibleTypes {
 			pt, err := newTypeRef(schema, ptCfg)
 			if err != nil {
@@ -175,9 +170,6 @@ func newType(schema *schema, cfg *TypeConfig) (Type, error) {
 					pt.kind,
 				)
 			}
-			if idx[pt.name] {
-				continue
-			}
 			t.possibleTypes = append(t.possibleTypes, pt)
 		}
 	case TypeKindList:
@@ -0,0 +1,367 @@
+package schema
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/herzult/porte/internal/graph"
+)
+
+// ExtractUsage returns the coordinates of the schema elements the operation
+// of the query document with the given name uses, in the format of the Change
+// paths: the types ("Human"), fields ("Human.name"), provided arguments
+// ("Query.hero.episode"), input fields ("ReviewInput.stars"), enum values
+// provided as input or returned by the selected fields ("Episode.JEDI") and
+// directives ("@include", "@include.if"). Input values are also read from the
+// given variables. Elements unknown to the schema are left out. The other
+// operations of the document are not executed, their elements are not used.
+func ExtractUsage(s Schema, doc *ast.Document, operationName string, variables map[string]interface{}) ([]string, error) {
+	op, err := graph.SelectOperation(doc, operationName)
+	if err != nil {
+		return nil, err
+	}
+	e := &usageExtractor{
+		schema:    s,
+		fragments: map[string]*ast.FragmentDefinition{},
+		visited:   map[string]bool{},
+		coords:    map[string]bool{},
+	}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			e.fragments[frag.Name.Value] = frag
+		}
+	}
+	var root Type
+	switch op.Operation {
+	case ast.OperationTypeMutation:
+		root = s.MutationType()
+	case ast.OperationTypeSubscription:
+		root = s.SubscriptionType()
+	default:
+		root = s.QueryType()
+	}
+	for _, v := range op.VariableDefinitions {
+		t := e.astType(v.Type)
+		if t == nil {
+			continue
+		}
+		e.use(namedType(t).Name())
+		if val, ok := variables[v.Variable.Name.Value]; ok {
+			e.inputJSONValue(t, val)
+		}
+	}
+	e.directives(op.Directives)
+	if root != nil {
+		e.selectionSet(root, op.SelectionSet)
+	}
+
+	coords := make([]string, 0, len(e.coords))
+	for c := range e.coords {
+		coords = append(coords, c)
+	}
+	sort.Strings(coords)
+	return coords, nil
+}
+
+type usageExtractor struct {
+	schema    Schema
+	fragments map[string]*ast.FragmentDefinition
+	visited   map[string]bool
+	coords    map[string]bool
+}
+
+func (e *usageExtractor) use(coord string) {
+	e.coords[coord] = true
+}
+
+func (e *usageExtractor) selectionSet(parent Type, set *ast.SelectionSet) {
+	if set == nil {
+		return
+	}
+	e.use(parent.Name())
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			e.directives(sel.Directives)
+			f := parent.Field(sel.Name.Value)
+			if f == nil {
+				// __typename and introspection fields
+				continue
+			}
+			coord := parent.Name() + "." + f.Name()
+			e.use(coord)
+			for _, arg := range sel.Arguments {
+				if a := f.Arg(arg.Name.Value); a != nil {
+					e.use(coord + "." + a.Name())
+					e.inputValue(a.Type(), arg.Value)
+				}
+			}
+			if sel.SelectionSet != nil {
+				e.selectionSet(namedType(f.Type()), sel.SelectionSet)
+			} else {
+				e.output(namedType(f.Type()))
+			}
+		case *ast.InlineFragment:
+			e.directives(sel.Directives)
+			t := parent
+			if sel.TypeCondition != nil {
+				t = e.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			if t != nil {
+				e.selectionSet(t, sel.SelectionSet)
+			}
+		case *ast.FragmentSpread:
+			e.directives(sel.Directives)
+			name := sel.Name.Value
+			frag, ok := e.fragments[name]
+			if !ok || e.visited[name] {
+				continue
+			}
+			e.visited[name] = true
+			e.directives(frag.Directives)
+			if t := e.schema.Type(frag.TypeCondition.Name.Value); t != nil {
+				e.selectionSet(t, frag.SelectionSet)
+			}
+		}
+	}
+}
+
+func (e *usageExtractor) directives(dirs []*ast.Directive) {
+	for _, dir := range dirs {
+		d := e.schema.Directive(dir.Name.Value)
+		if d == nil {
+			continue
+		}
+		e.use("@" + d.Name())
+		for _, arg := range dir.Arguments {
+			for _, a := range d.Args() {
+				if a.Name() == arg.Name.Value {
+					e.use("@" + d.Name() + "." + a.Name())
+					e.inputValue(a.Type(), arg.Value)
+				}
+			}
+		}
+	}
+}
+
+// output records the type of a leaf field. The client may read every value
+// of an enum.
+func (e *usageExtractor) output(t Type) {
+	e.use(t.Name())
+	if t.Kind() == TypeKindEnum {
+		for _, ev := range t.EnumValues() {
+			e.use(t.Name() + "." + ev.Name())
+		}
+	}
+}
+
+// inputValue records the input fields and enum values of a literal value.
+func (e *usageExtractor) inputValue(t Type, v ast.Value) {
+	switch t.Kind() {
+	case TypeKindNonNull:
+		e.inputValue(t.OfType(), v)
+	case TypeKindList:
+		if list, ok := v.(*ast.ListValue); ok {
+			for _, item := range list.Values {
+				e.inputValue(t.OfType(), item)
+			}
+		} else {
+			e.inputValue(t.OfType(), v)
+		}
+	case TypeKindEnum:
+		e.use(t.Name())
+		if ev, ok := v.(*ast.EnumValue); ok {
+			e.use(t.Name() + "." + ev.Value)
+		}
+	case TypeKindInputObject:
+		e.use(t.Name())
+		obj, ok := v.(*ast.ObjectValue)
+		if !ok {
+			return
+		}
+		for _, f := range obj.Fields {
+			if field := t.InputField(f.Name.Value); field != nil {
+				e.use(t.Name() + "." + field.Name())
+				e.inputValue(field.Type(), f.Value)
+			}
+		}
+	default:
+		e.use(t.Name())
+	}
+}
+
+// inputJSONValue records the input fields and enum values of a variable
+// value.
+func (e *usageExtractor) inputJSONValue(t Type, v interface{}) {
+	switch t.Kind() {
+	case TypeKindNonNull:
+		e.inputJSONValue(t.OfType(), v)
+	case TypeKindList:
+		if list, ok := v.([]interface{}); ok {
+			for _, item := range list {
+				e.inputJSONValue(t.OfType(), item)
+			}
+		} else {
+			e.inputJSONValue(t.OfType(), v)
+		}
+	case TypeKindEnum:
+		if s, ok := v.(string); ok {
+			e.use(t.Name() + "." + s)
+		}
+	case TypeKindInputObject:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for name, fv := range obj {
+			if field := t.InputField(name); field != nil {
+				e.use(t.Name() + "." + field.Name())
+				e.inputJSONValue(field.Type(), fv)
+			}
+		}
+	}
+}
+
+// astType returns the schema type ref matching the type of a variable
+// definition.
+func (e *usageExtractor) astType(t ast.Type) Type {
+	switch t := t.(type) {
+	case *ast.NonNull:
+		if of := e.astType(t.Type); of != nil {
+			return &wrappingType{kind: TypeKindNonNull, ofType: of}
+		}
+	case *ast.List:
+		if of := e.astType(t.Type); of != nil {
+			return &wrappingType{kind: TypeKindList, ofType: of}
+		}
+	case *ast.Named:
+		return e.schema.Type(t.Name.Value)
+	}
+	return nil
+}
+
+// wrappingType is a NON_NULL or LIST type built from a query document.
+type wrappingType struct {
+	Type
+	kind   TypeKind
+	ofType Type
+}
+
+func (t *wrappingType) Kind() TypeKind { return t.kind }
+func (t *wrappingType) OfType() Type   { return t.ofType }
+
+// namedType returns the named type of a type ref.
+func namedType(t Type) Type {
+	for t.Kind() == TypeKindNonNull || t.Kind() == TypeKindList {
+		t = t.OfType()
+	}
+	return t
+}
+
+// ClientUsage is the usage of a schema element by a version of a client.
+type ClientUsage struct {
+	ClientName    string    `json:"clientName"`
+	ClientVersion string    `json:"clientVersion"`
+	Count         int       `json:"count"`
+	LastSeen      time.Time `json:"lastSeen"`
+}
+
+// UsageReport aggregates the usage of schema elements by clients.
+type UsageReport struct {
+	clients map[string]map[[2]string]*ClientUsage
+}
+
+// NewUsageReport returns a new empty UsageReport.
+func NewUsageReport() *UsageReport {
+	return &UsageReport{clients: map[string]map[[2]string]*ClientUsage{}}
+}
+
+// Add records the usage of the given coordinates by a client at the given
+// time.
+func (r *UsageReport) Add(coords []string, clientName, clientVersion string, at time.Time) {
+	key := [2]string{clientName, clientVersion}
+	for _, coord := range coords {
+		clients, ok := r.clients[coord]
+		if !ok {
+			clients = map[[2]string]*ClientUsage{}
+			r.clients[coord] = clients
+		}
+		u, ok := clients[key]
+		if !ok {
+			u = &ClientUsage{ClientName: clientName, ClientVersion: clientVersion}
+			clients[key] = u
+		}
+		u.Count++
+		if at.After(u.LastSeen) {
+			u.LastSeen = at
+		}
+	}
+}
+
+// Clients returns the usage of the coordinate by client, the most used
+// first.
+func (r *UsageReport) Clients(coord string) []*ClientUsage {
+	usages := make([]*ClientUsage, 0, len(r.clients[coord]))
+	for _, u := range r.clients[coord] {
+		usages = append(usages, u)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Count != usages[j].Count {
+			return usages[i].Count > usages[j].Count
+		}
+		if usages[i].ClientName != usages[j].ClientName {
+			return usages[i].ClientName < usages[j].ClientName
+		}
+		return usages[i].ClientVersion < usages[j].ClientVersion
+	})
+	return usages
+}
+
+// CheckedChange is a schema change along with the clients affected by it.
+type CheckedChange struct {
+	*Change
+	// OriginalCriticality is the criticality of the change before it was
+	// downgraded because no client is affected.
+	OriginalCriticality Criticality    `json:"originalCriticality,omitempty"`
+	Clients             []*ClientUsage `json:"clients,omitempty"`
+}
+
+// CheckChanges finds the clients affected by each change in the usage
+// report. Breaking and dangerous changes affecting no client are downgraded
+// to safe.
+func CheckChanges(changes []*Change, report *UsageReport) []*CheckedChange {
+	checked := make([]*CheckedChange, len(changes))
+	for i, c := range changes {
+		cc := &CheckedChange{
+			Change:  c,
+			Clients: report.Clients(affectedCoordinate(c)),
+		}
+		if len(cc.Clients) == 0 && c.Criticality != CriticalitySafe {
+			downgraded := *c
+			downgraded.Criticality = CriticalitySafe
+			cc.Change = &downgraded
+			cc.OriginalCriticality = c.Criticality
+		}
+		checked[i] = cc
+	}
+	return checked
+}
+
+// affectedCoordinate returns the coordinate of the schema element whose usage
+// makes the change affect a client.
+func affectedCoordinate(c *Change) string {
+	switch c.Type {
+	case ChangeArgAdded, ChangeArgTypeChanged, ChangeArgDefaultValueChanged,
+		ChangeInputFieldAdded, ChangeInputFieldTypeChanged, ChangeInputFieldDefaultValueChanged,
+		// the clients reading an enum may receive the added values
+		ChangeEnumValueAdded:
+		// these changes also affect the operations not providing the value
+		if i := strings.LastIndex(c.Path, "."); i >= 0 {
+			return c.Path[:i]
+		}
+	}
+	return c.Path
+}
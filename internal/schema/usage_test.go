@@ -0,0 +1,192 @@
+package schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func TestExtractUsage(t *testing.T) {
+	cfg, err := ParseSDL(testSDL)
+	if err != nil {
+		t.Fatalf("ParseSDL() returned error: %s", err)
+	}
+	s, err := NewSchema(cfg)
+	if err != nil {
+		t.Fatalf("NewSchema() returned error: %s", err)
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: `
+query Search($text: String!, $filter: SearchFilter) {
+  hero(episode: EMPIRE) { ...names }
+  search(text: $text, filter: $filter) {
+    __typename
+    ... on Human { height @cached(ttl: 10) }
+  }
+}
+
+mutation CreateReview { createReview(episode: JEDI, review: {stars: 5}) { stars } }
+
+fragment names on Character { name ...names }
+`})
+	if err != nil {
+		t.Fatalf("Parse() returned error: %s", err)
+	}
+
+	variables := map[string]interface{}{
+		"text":   "r2",
+		"filter": map[string]interface{}{"kinds": []interface{}{"droid"}},
+	}
+
+	tests := []struct {
+		operationName string
+		expected      []string
+	}{
+		{
+			operationName: "Search",
+			expected: []string{
+				"@cached",
+				"@cached.ttl",
+				"Character",
+				"Character.name",
+				"Episode",
+				"Episode.EMPIRE",
+				"Float",
+				"Human",
+				"Human.height",
+				"Int",
+				"Root",
+				"Root.hero",
+				"Root.hero.episode",
+				"Root.search",
+				"Root.search.filter",
+				"Root.search.text",
+				"SearchFilter",
+				"SearchFilter.kinds",
+				"SearchResult",
+				"String",
+			},
+		},
+		{
+			operationName: "CreateReview",
+			expected: []string{
+				"Episode",
+				"Episode.JEDI",
+				"Int",
+				"Mutation",
+				"Mutation.createReview",
+				"Mutation.createReview.episode",
+				"Mutation.createReview.review",
+				"Review",
+				"Review.stars",
+				"ReviewInput",
+				"ReviewInput.stars",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operationName, func(t *testing.T) {
+			actual, err := ExtractUsage(s, doc, tt.operationName, variables)
+			if err != nil {
+				t.Fatalf("ExtractUsage() returned error: %s", err)
+			}
+			checkSameElements(t, actual, tt.expected)
+		})
+	}
+	if _, err := ExtractUsage(s, doc, "", variables); err == nil {
+		t.Error("ExtractUsage() of a document of several operations without an operation name returned no error")
+	}
+}
+
+func TestCheckChanges_output_enum(t *testing.T) {
+	parse := func(sdl string) Schema {
+		cfg, err := ParseSDL(sdl)
+		if err != nil {
+			t.Fatalf("ParseSDL() returned error: %s", err)
+		}
+		s, err := NewSchema(cfg)
+		if err != nil {
+			t.Fatalf("NewSchema() returned error: %s", err)
+		}
+		return s
+	}
+	old := parse(`type Query { hero: Hero, droids(episode: Episode): [String] }
+type Hero { name: String, episode: Episode }
+enum Episode { NEWHOPE EMPIRE JEDI }`)
+	new := parse(`type Query { hero: Hero, droids(episode: Episode): [String] }
+type Hero { name: String, episode: Episode }
+enum Episode { NEWHOPE EMPIRE CLONES }`)
+
+	report := NewUsageReport()
+	for _, client := range []struct {
+		name  string
+		query string
+	}{
+		{name: "reader", query: `{ hero { episode } }`},
+		{name: "writer", query: `{ droids(episode: EMPIRE) }`},
+	} {
+		doc, err := parser.Parse(parser.ParseParams{Source: client.query})
+		if err != nil {
+			t.Fatalf("Parse() returned error: %s", err)
+		}
+		coords, err := ExtractUsage(old, doc, "", nil)
+		if err != nil {
+			t.Fatalf("ExtractUsage() returned error: %s", err)
+		}
+		report.Add(coords, client.name, "1.0", time.Now())
+	}
+
+	checked := CheckChanges(Diff(old, new), report)
+	clients := map[string][]string{}
+	for _, c := range checked {
+		for _, u := range c.Clients {
+			clients[c.Path] = append(clients[c.Path], u.ClientName)
+		}
+	}
+	// the enum readers no longer receive the removed values, the writer
+	// does not provide them; the added values concern every client of the
+	// enum
+	checkSameElements(t, clients["Episode.JEDI"], []string{"reader"})
+	checkSameElements(t, clients["Episode.CLONES"], []string{"reader", "writer"})
+}
+
+func TestCheckChanges(t *testing.T) {
+	report := NewUsageReport()
+	now := time.Now()
+	report.Add([]string{"Query.hero", "Query.hero.episode"}, "ios", "1.2", now.Add(-time.Hour))
+	report.Add([]string{"Query.hero"}, "ios", "1.2", now)
+	report.Add([]string{"Query.hero"}, "android", "3.0", now)
+
+	changes := []*Change{
+		{Type: ChangeFieldRemoved, Criticality: CriticalityBreaking, Path: "Query.droids"},
+		{Type: ChangeArgRemoved, Criticality: CriticalityBreaking, Path: "Query.hero.episode"},
+		{Type: ChangeArgAdded, Criticality: CriticalityBreaking, Path: "Query.hero.first"},
+		{Type: ChangeFieldAdded, Criticality: CriticalitySafe, Path: "Query.review"},
+	}
+	checked := CheckChanges(changes, report)
+
+	expected := []struct {
+		criticality Criticality
+		original    Criticality
+		clients     int
+	}{
+		{criticality: CriticalitySafe, original: CriticalityBreaking},
+		{criticality: CriticalityBreaking, clients: 1},
+		{criticality: CriticalityBreaking, clients: 2},
+		{criticality: CriticalitySafe},
+	}
+	for i, e := range expected {
+		c := checked[i]
+		if c.Criticality != e.criticality || c.OriginalCriticality != e.original || len(c.Clients) != e.clients {
+			t.Errorf("change %s is %s (originally %q) with %d clients, expected %s (originally %q) with %d clients",
+				c.Path, c.Criticality, c.OriginalCriticality, len(c.Clients), e.criticality, e.original, e.clients)
+		}
+	}
+	if changes[0].Criticality != CriticalityBreaking {
+		t.Error("CheckChanges() modified the given changes")
+	}
+	ios := checked[2].Clients[0]
+	if ios.ClientName != "ios" || ios.ClientVersion != "1.2" || ios.Count != 2 || !ios.LastSeen.Equal(now) {
+		t.Errorf("most affected client is %+v", ios)
+	}
+}
@@ -0,0 +1,391 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ValidationError is a violation of the GraphQL type system rules found in a
+// schema config.
+type ValidationError struct {
+	// Path locates the violation in the schema, from the type or directive
+	// name down to the field, argument or value, e.g. ["Human", "friends"] or
+	// ["@include", "if"].
+	Path    []string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors holds all the violations found in a schema config.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+var nameRegexp = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// directiveLocations are the locations a directive can be declared on.
+var directiveLocations = map[DirectiveLocation]bool{
+	DirectiveLocationQuery:                true,
+	DirectiveLocationMutation:             true,
+	DirectiveLocationSubscripiom:          true,
+	DirectiveLocationField:                true,
+	DirectiveLocationFragmentDefinitionn:  true,
+	DirectiveLocationFragmentSpread:       true,
+	DirectiveLocationInlineFragment:       true,
+	DirectiveLocationVariableDefinition:   true,
+	DirectiveLocationSchema:               true,
+	DirectiveLocationScalar:               true,
+	DirectiveLocationObject:               true,
+	DirectiveLocationFieldDefinition:      true,
+	DirectiveLocationArgumentDefinition:   true,
+	DirectiveLocationInterface:            true,
+	DirectiveLocationUnion:                true,
+	DirectiveLocationEnum:                 true,
+	DirectiveLocationEnumValue:            true,
+	DirectiveLocationInputObject:          true,
+	DirectiveLocationInputFieldDefinition: true,
+}
+
+// validator checks a schema against the type system rules of the GraphQL
+// specification and collects the violations.
+type validator struct {
+	schema *schema
+	errs   ValidationErrors
+}
+
+func (v *validator) report(path []string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// rootType returns the root type of the given operation, reporting an
+// error when it does not reference an existing OBJECT type.
+func (v *validator) rootType(op string, cfg *TypeRefConfig) Type {
+	typ, err := newTypeRef(v.schema, cfg)
+	if err != nil {
+		v.report(nil, "%s type: %s", op, err)
+		return nil
+	}
+	if _, ok := v.schema.typesMap[typ.name]; !ok && typ.name != "" {
+		v.report(nil, "%s type references non-existing type \"%s\"", op, typ.name)
+		return nil
+	}
+	if typ.Kind() != TypeKindObject {
+		v.report(
+			nil,
+			"%s type must reference an OBJECT type, found %s type",
+			op,
+			typ.Kind(),
+		)
+		return nil
+	}
+	return typ
+}
+
+func (v *validator) validate() {
+	for _, t := range v.schema.types {
+		v.validateName([]string{t.Name()}, fmt.Sprintf("type \"%s\"", t.Name()), t.Name())
+
+		switch t.Kind() {
+		case TypeKindObject, TypeKindInterface:
+			v.validateFields(t)
+			if t.Kind() == TypeKindObject {
+				v.validateInterfaces(t)
+			}
+		case TypeKindUnion:
+			v.validateUnion(t)
+		case TypeKindEnum:
+			v.validateEnum(t)
+		case TypeKindInputObject:
+			v.validateInputObject(t)
+		}
+	}
+
+	for _, d := range v.schema.directives {
+		v.validateDirective(d)
+	}
+}
+
+func (v *validator) validateName(path []string, what string, name string) {
+	if strings.HasPrefix(name, "__") {
+		v.report(path, "%s: name \"%s\" must not begin with \"__\", which is reserved by GraphQL introspection", what, name)
+	} else if !nameRegexp.MatchString(name) {
+		v.report(path, "%s: name \"%s\" is not a valid GraphQL name", what, name)
+	}
+}
+
+// validateTypeRef checks the type ref references an existing type, of an
+// input type when input is true or of an output type otherwise.
+func (v *validator) validateTypeRef(path []string, what string, ref Type, input bool) {
+	named := ref
+	for named.Kind() == TypeKindNonNull || named.Kind() == TypeKindList {
+		named = named.OfType()
+	}
+	switch named.Kind() {
+	case "":
+		v.report(path, "%s: references non-existing type \"%s\"", what, named.Name())
+	case TypeKindScalar, TypeKindEnum:
+	case TypeKindInputObject:
+		if !input {
+			v.report(path, "%s: must be of an output type, found INPUT_OBJECT type \"%s\"", what, named.Name())
+		}
+	default:
+		if input {
+			v.report(path, "%s: must be of an input type, found %s type \"%s\"", what, named.Kind(), named.Name())
+		}
+	}
+}
+
+func (v *validator) validateArgs(path []string, what string, args []InputValue) {
+	for _, arg := range args {
+		argPath := append(append([]string{}, path...), arg.Name())
+		argWhat := fmt.Sprintf("%s: argument \"%s\"", what, arg.Name())
+		v.validateName(argPath, argWhat, arg.Name())
+		v.validateTypeRef(argPath, argWhat, arg.Type(), true)
+	}
+}
+
+func (v *validator) validateFields(t Type) {
+	what := fmt.Sprintf("in %s type \"%s\"", t.Kind(), t.Name())
+	if len(t.Fields()) == 0 {
+		v.report([]string{t.Name()}, "%s: must define one or more fields", what)
+	}
+	for _, f := range t.Fields() {
+		path := []string{t.Name(), f.Name()}
+		fieldWhat := fmt.Sprintf("%s: field \"%s\"", what, f.Name())
+		v.validateName(path, fieldWhat, f.Name())
+		v.validateTypeRef(path, fieldWhat, f.Type(), false)
+		v.validateArgs(path, fieldWhat, f.Args())
+	}
+}
+
+func (v *validator) validateInterfaces(t Type) {
+	what := fmt.Sprintf("in OBJECT type \"%s\"", t.Name())
+	seen := map[string]bool{}
+	for _, iface := range t.Interfaces() {
+		path := []string{t.Name()}
+		switch iface.Kind() {
+		case "":
+			v.report(path, "%s: implements non-existing type \"%s\"", what, iface.Name())
+			continue
+		case TypeKindInterface:
+		default:
+			v.report(path, "%s: can only implement INTERFACE types, found %s type \"%s\"", what, iface.Kind(), iface.Name())
+			continue
+		}
+		if seen[iface.Name()] {
+			v.report(path, "%s: implements interface \"%s\" more than once", what, iface.Name())
+			continue
+		}
+		seen[iface.Name()] = true
+
+		for _, ifaceField := range iface.Fields() {
+			fieldWhat := fmt.Sprintf("%s: field \"%s\" of interface \"%s\"", what, ifaceField.Name(), iface.Name())
+			path := []string{t.Name(), ifaceField.Name()}
+			f := t.Field(ifaceField.Name())
+			if f == nil {
+				v.report(path, "%s: is not implemented", fieldWhat)
+				continue
+			}
+			if !v.isSubType(f.Type(), ifaceField.Type()) {
+				v.report(
+					path,
+					"%s: expects type \"%s\" but is implemented with type \"%s\"",
+					fieldWhat,
+					printTypeRef(ifaceField.Type()),
+					printTypeRef(f.Type()),
+				)
+			}
+			for _, ifaceArg := range ifaceField.Args() {
+				argPath := append(append([]string{}, path...), ifaceArg.Name())
+				arg := f.Arg(ifaceArg.Name())
+				if arg == nil {
+					v.report(argPath, "%s: argument \"%s\" is not implemented", fieldWhat, ifaceArg.Name())
+					continue
+				}
+				if !isEqualType(arg.Type(), ifaceArg.Type()) {
+					v.report(
+						argPath,
+						"%s: argument \"%s\" expects type \"%s\" but is implemented with type \"%s\"",
+						fieldWhat,
+						ifaceArg.Name(),
+						printTypeRef(ifaceArg.Type()),
+						printTypeRef(arg.Type()),
+					)
+				}
+			}
+			for _, arg := range f.Args() {
+				if ifaceField.Arg(arg.Name()) == nil && arg.Type().Kind() == TypeKindNonNull && arg.DefaultValue() == "" {
+					v.report(
+						append(append([]string{}, path...), arg.Name()),
+						"%s: additional argument \"%s\" must not be required",
+						fieldWhat,
+						arg.Name(),
+					)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateUnion(t Type) {
+	path := []string{t.Name()}
+	if len(t.PossibleTypes()) == 0 {
+		v.report(path, "in UNION type \"%s\": must define one or more possible types", t.Name())
+	}
+	seen := map[string]bool{}
+	for _, p := range t.PossibleTypes() {
+		if p.Kind() == "" {
+			v.report(
+				path,
+				"in UNION type \"%s\": possible type references non-existing type \"%s\"",
+				t.Name(),
+				p.Name(),
+			)
+		} else if p.Kind() != TypeKindObject {
+			v.report(
+				path,
+				"in UNION type \"%s\": all possible types must reference OBJECT types, found %s type \"%s\"",
+				t.Name(),
+				p.Kind(),
+				p.Name(),
+			)
+		} else if seen[p.Name()] {
+			v.report(path, "in UNION type \"%s\": possible type \"%s\" declared more than once", t.Name(), p.Name())
+		}
+		seen[p.Name()] = true
+	}
+}
+
+func (v *validator) validateEnum(t Type) {
+	what := fmt.Sprintf("in ENUM type \"%s\"", t.Name())
+	if len(t.EnumValues()) == 0 {
+		v.report([]string{t.Name()}, "%s: must define one or more values", what)
+	}
+	for _, ev := range t.EnumValues() {
+		path := []string{t.Name(), ev.Name()}
+		valueWhat := fmt.Sprintf("%s: enum value \"%s\"", what, ev.Name())
+		v.validateName(path, valueWhat, ev.Name())
+		switch ev.Name() {
+		case "true", "false", "null":
+			v.report(path, "%s: name is reserved", valueWhat)
+		}
+	}
+}
+
+func (v *validator) validateInputObject(t Type) {
+	what := fmt.Sprintf("in INPUT_OBJECT type \"%s\"", t.Name())
+	if len(t.InputFields()) == 0 {
+		v.report([]string{t.Name()}, "%s: must define one or more input fields", what)
+	}
+	for _, f := range t.InputFields() {
+		path := []string{t.Name(), f.Name()}
+		fieldWhat := fmt.Sprintf("%s: input field \"%s\"", what, f.Name())
+		v.validateName(path, fieldWhat, f.Name())
+		v.validateTypeRef(path, fieldWhat, f.Type(), true)
+	}
+	if cycle := v.nonNullInputCycle(t, []string{t.Name()}); cycle != nil {
+		v.report(
+			[]string{t.Name()},
+			"%s: can not reference itself through non-null input fields, found cycle %s",
+			what,
+			strings.Join(cycle, "."),
+		)
+	}
+}
+
+// nonNullInputCycle returns the path of input fields referencing the input
+// object type at the start of the path through non-null singular fields, such
+// input values could never be provided.
+func (v *validator) nonNullInputCycle(t Type, path []string) []string {
+	for _, f := range t.InputFields() {
+		if f.Type().Kind() != TypeKindNonNull {
+			continue
+		}
+		of := f.Type().OfType()
+		if of.Kind() != TypeKindInputObject {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), f.Name())
+		if of.Name() == path[0] {
+			return fieldPath
+		}
+		visited := false
+		for _, p := range path {
+			visited = visited || p == of.Name()
+		}
+		if visited {
+			continue
+		}
+		if cycle := v.nonNullInputCycle(of, fieldPath); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateDirective(d Directive) {
+	path := []string{"@" + d.Name()}
+	what := fmt.Sprintf("in directive \"%s\"", d.Name())
+	v.validateName(path, what, d.Name())
+	if len(d.Locations()) == 0 {
+		v.report(path, "%s: must define one or more locations", what)
+	}
+	for _, loc := range d.Locations() {
+		if !directiveLocations[loc] {
+			v.report(path, "%s: unknown location \"%s\"", what, loc)
+		}
+	}
+	v.validateArgs(path, what, d.Args())
+}
+
+// isSubType tells whether a value of type sub can be used where a value of
+// type super is expected, as needed for covariant field types.
+func (v *validator) isSubType(sub, super Type) bool {
+	if isEqualType(sub, super) {
+		return true
+	}
+	if super.Kind() == TypeKindNonNull {
+		return sub.Kind() == TypeKindNonNull && v.isSubType(sub.OfType(), super.OfType())
+	}
+	if sub.Kind() == TypeKindNonNull {
+		return v.isSubType(sub.OfType(), super)
+	}
+	if super.Kind() == TypeKindList {
+		return sub.Kind() == TypeKindList && v.isSubType(sub.OfType(), super.OfType())
+	}
+	if sub.Kind() == TypeKindList {
+		return false
+	}
+	if sub.Kind() == TypeKindObject && (super.Kind() == TypeKindInterface || super.Kind() == TypeKindUnion) {
+		for _, p := range super.PossibleTypes() {
+			if p.Name() == sub.Name() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEqualType tells whether both type refs are the same type.
+func isEqualType(a, b Type) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	if a.Kind() == TypeKindNonNull || a.Kind() == TypeKindList {
+		return isEqualType(a.OfType(), b.OfType())
+	}
+	return a.Name() == b.Name()
+}